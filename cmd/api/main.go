@@ -6,11 +6,14 @@ import (
 	"os"
 
 	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
 
 	"datacurve-takehome/internal/db"
+	"datacurve-takehome/internal/gitmirror"
 	httpSrv "datacurve-takehome/internal/http"
 	"datacurve-takehome/internal/migrations"
 	"datacurve-takehome/internal/storage"
+	"datacurve-takehome/internal/vault"
 )
 
 func main() {
@@ -24,7 +27,12 @@ func main() {
 		log.Fatal(err)
 	}
 	asq := asynq.NewClient(asynq.RedisClientOpt{Addr: os.Getenv("REDIS_ADDR")})
-	srv := httpSrv.NewServer(dbase, s3c, asq)
+	rdb := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_ADDR")})
+	v, err := vault.FromEnv(dbase)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := httpSrv.NewServer(dbase, s3c, asq, rdb, gitmirror.FromEnv(), v)
 	if err := srv.ListenAndServe(); err != nil {
 		log.Fatal(err)
 	}
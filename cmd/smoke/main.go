@@ -1,41 +1,18 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"time"
 
 	"datacurve-takehome/internal/qa"
+	"datacurve-takehome/pkg/client"
 )
 
-type createResp struct {
-	TraceID     string `json:"trace_id"`
-	UploadToken string `json:"upload_token"`
-}
-
-type appendResp struct {
-	Accepted int   `json:"accepted"`
-	NextSeq  int64 `json:"next_seq"`
-}
-
-type getTraceResp struct {
-	TraceID     string         `json:"trace_id"`
-	Developer   map[string]any `json:"developer"`
-	Task        map[string]any `json:"task"`
-	Environment map[string]any `json:"environment"`
-	Artifacts   map[string]any `json:"artifacts,omitempty"`
-	QA          map[string]any `json:"qa,omitempty"`
-	Version     string         `json:"version"`
-	Extra       map[string]any `json:"-"`
-}
-
 func main() {
 	base := envOr("API_BASE_URL", "http://localhost:8000")
 	token := envOr("API_TOKEN", "dev-secret-token")
@@ -52,16 +29,17 @@ func main() {
 		return
 	}
 
-	httpc := &http.Client{Timeout: 12 * time.Second}
+	ctx := context.Background()
+	api := client.New(*baseFlag, *tokenFlag, client.WithHTTPClient(&http.Client{Timeout: 12 * time.Second}))
 
 	// 1) Create trace
-	createBody := map[string]any{
-		"developer": map[string]any{
+	createBody := client.CreateTraceRequest{
+		Developer: map[string]any{
 			"name":       "Smoke Tester",
 			"email":      "smoke@example.com",
 			"experience": "senior",
 		},
-		"task": map[string]any{
+		Task: map[string]any{
 			"description":  "Fix bug in calculator function",
 			"repository":   "https://github.com/tigercxx/buggy_repo",
 			"branch":       "main",
@@ -69,14 +47,14 @@ func main() {
 			"test_image":   "golang:1.24",
 			"test_command": "go test ./...",
 		},
-		"environment": map[string]any{
+		Environment: map[string]any{
 			"os":      "linux",
 			"editor":  "vscode",
 			"version": "1.0.0",
 		},
 	}
-	var created createResp
-	if err := postJSON(httpc, *baseFlag+"/traces", *tokenFlag, createBody, &created); err != nil {
+	created, err := api.CreateTrace(ctx, createBody)
+	if err != nil {
 		fatalf("create trace: %v", err)
 	}
 	fmt.Printf("✅ Created trace: id=%s upload_token=%s\n", created.TraceID, created.UploadToken)
@@ -109,20 +87,20 @@ func main() {
 			"exit_code":  0,
 		},
 	}
-	var appended appendResp
-	if err := postJSONWithUpload(httpc, fmt.Sprintf("%s/traces/%s/events", *baseFlag, created.TraceID), created.UploadToken, map[string]any{"events": events}, &appended); err != nil {
+	appended, err := api.AppendEvents(ctx, created.TraceID, created.UploadToken, events)
+	if err != nil {
 		fatalf("append events: %v", err)
 	}
 	fmt.Printf("✅ Appended events: accepted=%d next_seq=%d\n", appended.Accepted, appended.NextSeq)
 
 	// 3) Finalize
-	if err := postJSON(httpc, fmt.Sprintf("%s/traces/%s/finalize", *baseFlag, created.TraceID), *tokenFlag, nil, &map[string]any{}); err != nil {
+	if err := api.Finalize(ctx, created.TraceID); err != nil {
 		fatalf("finalize: %v", err)
 	}
 	fmt.Println("✅ Finalized trace")
 
 	// 4) Enqueue QA
-	if err := postJSON(httpc, fmt.Sprintf("%s/traces/%s/qa", *baseFlag, created.TraceID), *tokenFlag, nil, &map[string]any{}); err != nil {
+	if err := api.EnqueueQA(ctx, created.TraceID); err != nil {
 		fatalf("enqueue QA: %v", err)
 	}
 	fmt.Println("✅ Enqueued QA")
@@ -130,9 +108,9 @@ func main() {
 	time.Sleep(10 * time.Second)
 	// 5) Get trace (optionally poll for QA result)
 	deadline := time.Now().Add(*waitQA)
-	var tr getTraceResp
 	for {
-		if err := getJSON(httpc, fmt.Sprintf("%s/traces/%s", *baseFlag, created.TraceID), *tokenFlag, &tr); err != nil {
+		tr, err := api.GetTrace(ctx, created.TraceID)
+		if err != nil {
 			fatalf("get trace: %v", err)
 		}
 		if len(tr.QA) > 0 {
@@ -158,63 +136,6 @@ func envOr(k, def string) string {
 	return def
 }
 
-func postJSON(c *http.Client, url, bearer string, body any, out any) error {
-	var r io.Reader
-	if body != nil {
-		b, err := json.Marshal(body)
-		if err != nil {
-			return err
-		}
-		r = bytes.NewReader(b)
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
-	defer cancel()
-	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, r)
-	req.Header.Set("Content-Type", "application/json")
-	if bearer != "" {
-		req.Header.Set("Authorization", "Bearer "+bearer)
-	}
-	res, err := c.Do(req)
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-	if res.StatusCode != 200 {
-		b, _ := io.ReadAll(res.Body)
-		return fmt.Errorf("POST %s -> %d: %s", url, res.StatusCode, string(b))
-	}
-	if out != nil {
-		return json.NewDecoder(res.Body).Decode(out)
-	}
-	return nil
-}
-
-func postJSONWithUpload(c *http.Client, url, uploadToken string, body any, out any) error {
-	if uploadToken == "" {
-		return errors.New("upload token required")
-	}
-	return postJSON(c, url, uploadToken, body, out)
-}
-
-func getJSON(c *http.Client, url, bearer string, out any) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
-	defer cancel()
-	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if bearer != "" {
-		req.Header.Set("Authorization", "Bearer "+bearer)
-	}
-	res, err := c.Do(req)
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-	if res.StatusCode/100 != 2 {
-		b, _ := io.ReadAll(res.Body)
-		return fmt.Errorf("GET %s -> %d: %s", url, res.StatusCode, string(b))
-	}
-	return json.NewDecoder(res.Body).Decode(out)
-}
-
 func compactJSON(v any) string {
 	b, _ := json.MarshalIndent(v, "", "  ")
 	return string(b)
@@ -248,20 +169,35 @@ index 6bf3771..9d21ff9 100644
 `
 
 	// Run the tests using the QA runner
-	result, err := qa.RunTests(ctx, "https://github.com/tigercxx/buggy_repo", "9e454b2", patch, "golang:1.24", "go test -v")
+	run, err := qa.RunTests(ctx, qa.RunOptions{
+		RepoURL:     "https://github.com/tigercxx/buggy_repo",
+		StartCommit: "9e454b2",
+		Patch:       patch,
+		Image:       "golang:1.24",
+		Command:     "go test -v",
+		Baseline:    true,
+	})
 	if err != nil {
 		fatalf("QA runner failed: %v", err)
 	}
+	result := run.Patched
+	v := qa.Verify(run.Baseline.Cases, result.Cases, nil)
 
 	// Print results
 	fmt.Printf("\n📊 Test Results:\n")
 	fmt.Printf("  ✅ OK: %t\n", result.OK)
 	fmt.Printf("  🔢 Exit Code: %d\n", result.ExitCode)
+	fmt.Printf("  🧪 Parser: %s, passed %d, failed %d, skipped %d\n",
+		result.Parser, result.Summary.Passed, result.Summary.Failed, result.Summary.Skipped)
 	fmt.Printf("  📤 Stdout:\n%s\n", result.Stdout)
 	if result.Stderr != "" {
 		fmt.Printf("  📥 Stderr:\n%s\n", result.Stderr)
 	}
 
+	fmt.Printf("  🔁 FAIL_TO_PASS: %v\n", v.FailToPass)
+	fmt.Printf("  🔁 PASS_TO_PASS: %v\n", v.PassToPass)
+	fmt.Printf("  ✔️ Verified fix: %t\n", v.VerifiedFix)
+
 	if result.OK {
 		fmt.Println("🎉 All tests passed! The patch fixed the bug.")
 	} else {
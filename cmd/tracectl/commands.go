@@ -0,0 +1,741 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"datacurve-takehome/pkg/client"
+)
+
+// taskFile is the YAML accepted by `tracectl create`; it mirrors the body
+// of POST /traces.
+type taskFile struct {
+	Developer   map[string]any `yaml:"developer"`
+	Task        map[string]any `yaml:"task"`
+	Environment map[string]any `yaml:"environment"`
+}
+
+func cmdCreate(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	file := fs.String("f", "", "YAML file with developer, task and environment")
+	_ = fs.Parse(args)
+	if *file == "" {
+		return errors.New("-f is required")
+	}
+	b, err := os.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	var tf taskFile
+	if err := yaml.Unmarshal(b, &tf); err != nil {
+		return fmt.Errorf("parse %s: %w", *file, err)
+	}
+	if tf.Task == nil {
+		return fmt.Errorf("%s: missing task", *file)
+	}
+	created, err := g.api.CreateTrace(ctx, client.CreateTraceRequest{
+		Developer:   tf.Developer,
+		Task:        tf.Task,
+		Environment: tf.Environment,
+	})
+	if err != nil {
+		return err
+	}
+	if g.output == "json" {
+		return g.json(created)
+	}
+	return g.table([]string{"TRACE ID", "UPLOAD TOKEN"}, [][]string{{created.TraceID, created.UploadToken}})
+}
+
+func cmdUpload(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	traceID := fs.String("trace", "", "Trace ID")
+	upload := fs.String("upload-token", os.Getenv("UPLOAD_TOKEN"), "Upload token returned by create")
+	file := fs.String("f", "-", "NDJSON event file (- for stdin)")
+	batch := fs.Int("batch", 200, "Maximum events per uploaded batch")
+	spool := fs.String("spool", "", "Directory to spool batches to while the API is unreachable")
+	_ = fs.Parse(args)
+	if *traceID == "" || *upload == "" {
+		return errors.New("-trace and -upload-token are required")
+	}
+
+	var in io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	up, err := g.api.NewUploader(*traceID, *upload, client.UploaderOptions{MaxEvents: *batch, SpoolDir: *spool})
+	if err != nil {
+		return err
+	}
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	n, line := 0, 0
+	for sc.Scan() {
+		line++
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var ev map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			_ = up.Close(ctx)
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := up.Add(ev); err != nil {
+			_ = up.Close(ctx)
+			return err
+		}
+		n++
+	}
+	if err := sc.Err(); err != nil {
+		_ = up.Close(ctx)
+		return err
+	}
+	if err := up.Close(ctx); err != nil {
+		return fmt.Errorf("%d events not uploaded: %w", up.Pending(), err)
+	}
+	if g.output == "json" {
+		return g.json(map[string]any{"trace_id": *traceID, "uploaded": n})
+	}
+	return g.table([]string{"TRACE ID", "UPLOADED"}, [][]string{{*traceID, strconv.Itoa(n)}})
+}
+
+func cmdFinalize(ctx context.Context, g *globals, args []string) error {
+	id, err := oneArg(flag.NewFlagSet("finalize", flag.ExitOnError), args, "trace ID")
+	if err != nil {
+		return err
+	}
+	if err := g.api.Finalize(ctx, id); err != nil {
+		return err
+	}
+	if g.output == "json" {
+		return g.json(map[string]string{"trace_id": id, "status": "sealed"})
+	}
+	return g.table([]string{"TRACE ID", "STATUS"}, [][]string{{id, "sealed"}})
+}
+
+func cmdPrefetch(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("prefetch", flag.ExitOnError)
+	commits := fs.String("commits", "", "Comma-separated commits, branches or tags that must be fetched")
+	project := fs.String("project", "", "Project whose stored credential the fetch uses")
+	repo, err := oneArg(fs, args, "repository URL")
+	if err != nil {
+		return err
+	}
+	var revs []string
+	for _, c := range strings.Split(*commits, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			revs = append(revs, c)
+		}
+	}
+	if err := g.api.PrefetchRepo(ctx, repo, *project, revs...); err != nil {
+		return err
+	}
+	if g.output == "json" {
+		return g.json(map[string]string{"repository": repo, "enqueued": "ok"})
+	}
+	return g.table([]string{"REPOSITORY", "PREFETCH"}, [][]string{{repo, "enqueued"}})
+}
+
+func cmdCredentialSet(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("credential-set", flag.ExitOnError)
+	kind := fs.String("kind", "https_token", "https_token or ssh_key")
+	username := fs.String("username", "", "Username for HTTPS tokens (default x-access-token)")
+	secretFile := fs.String("secret-file", "", "File holding the token or private key; - reads stdin")
+	knownHosts := fs.String("known-hosts", "", "known_hosts file pinning the SSH host keys")
+	urlPrefix := fs.String("url-prefix", "", "Repositories the credential may clone, e.g. https://github.com/acme/")
+	project, err := oneArg(fs, args, "project")
+	if err != nil {
+		return err
+	}
+	if *secretFile == "" {
+		return errors.New("-secret-file is required")
+	}
+	if *urlPrefix == "" {
+		return errors.New("-url-prefix is required")
+	}
+	var secret []byte
+	if *secretFile == "-" {
+		secret, err = io.ReadAll(os.Stdin)
+	} else {
+		secret, err = os.ReadFile(*secretFile)
+	}
+	if err != nil {
+		return err
+	}
+	cred := client.ProjectCredential{Kind: *kind, Username: *username, Secret: strings.TrimSpace(string(secret)), URLPrefix: *urlPrefix}
+	if *kind == "ssh_key" {
+		// keys need their trailing newline
+		cred.Secret += "\n"
+	}
+	if *knownHosts != "" {
+		b, err := os.ReadFile(*knownHosts)
+		if err != nil {
+			return err
+		}
+		cred.KnownHosts = string(b)
+	}
+	info, err := g.api.PutCredential(ctx, project, cred)
+	if err != nil {
+		return err
+	}
+	return printCredential(g, info)
+}
+
+func cmdCredentialGet(ctx context.Context, g *globals, args []string) error {
+	project, err := oneArg(flag.NewFlagSet("credential-get", flag.ExitOnError), args, "project")
+	if err != nil {
+		return err
+	}
+	info, err := g.api.GetCredential(ctx, project)
+	if err != nil {
+		return err
+	}
+	return printCredential(g, info)
+}
+
+func printCredential(g *globals, info *client.CredentialInfo) error {
+	if g.output == "json" {
+		return g.json(info)
+	}
+	return g.table([]string{"PROJECT", "KIND", "USERNAME", "URL PREFIX", "UPDATED"},
+		[][]string{{info.Project, info.Kind, info.Username, info.URLPrefix, info.UpdatedAt.Format(time.RFC3339)}})
+}
+
+func cmdQA(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("qa", flag.ExitOnError)
+	wait := fs.Bool("wait", false, "Wait for QA results")
+	timeout := fs.Duration("timeout", 15*time.Minute, "How long to wait for QA results")
+	every := fs.Duration("interval", 3*time.Second, "Polling interval while waiting")
+	id, err := oneArg(fs, args, "trace ID")
+	if err != nil {
+		return err
+	}
+	if err := g.api.EnqueueQA(ctx, id); err != nil {
+		return err
+	}
+	if !*wait {
+		if g.output == "json" {
+			return g.json(map[string]string{"trace_id": id, "enqueued": "ok"})
+		}
+		return g.table([]string{"TRACE ID", "QA"}, [][]string{{id, "enqueued"}})
+	}
+
+	wctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	tr, err := g.api.WaitForQA(wctx, id, *every, func(elapsed time.Duration) {
+		fmt.Fprintf(os.Stderr, "\rwaiting for QA on %s ... %s", id, elapsed.Truncate(time.Second))
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return err
+	}
+	if g.output == "json" {
+		return g.json(tr.QA)
+	}
+	return g.table([]string{"FIELD", "VALUE"}, qaRows(tr.QA))
+}
+
+func cmdGet(ctx context.Context, g *globals, args []string) error {
+	id, err := oneArg(flag.NewFlagSet("get", flag.ExitOnError), args, "trace ID")
+	if err != nil {
+		return err
+	}
+	tr, err := g.api.GetTrace(ctx, id)
+	if err != nil {
+		return err
+	}
+	if g.output == "json" {
+		return g.json(tr)
+	}
+	rows := [][]string{
+		{"trace_id", tr.TraceID},
+		{"created_at", tr.CreatedAt.Format(time.RFC3339)},
+		{"status", tr.Status},
+		{"version", tr.Version},
+		{"developer", str(tr.Developer["name"])},
+		{"repository", str(tr.Task["repository"])},
+		{"commit", str(tr.Task["commit"])},
+		{"description", truncate(str(tr.Task["description"]), 80)},
+	}
+	if tr.IntegrityStatus != "" {
+		rows = append(rows, []string{"integrity", tr.IntegrityStatus})
+	}
+	rows = append(rows, qaRows(tr.QA)...)
+	return g.table([]string{"FIELD", "VALUE"}, rows)
+}
+
+// cmdEvents dumps a trace's events. JSON output is NDJSON, the same format
+// `tracectl upload` reads.
+func cmdEvents(ctx context.Context, g *globals, args []string) error {
+	id, err := oneArg(flag.NewFlagSet("events", flag.ExitOnError), args, "trace ID")
+	if err != nil {
+		return err
+	}
+	events, err := g.api.GetEvents(ctx, id)
+	if err != nil {
+		return err
+	}
+	if g.output == "json" {
+		enc := json.NewEncoder(g.stdout)
+		for _, ev := range events {
+			if err := enc.Encode(ev); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	rows := make([][]string, 0, len(events))
+	for i, ev := range events {
+		rows = append(rows, []string{strconv.Itoa(i), str(ev["t"]), str(ev["type"]), truncate(eventDetail(ev), 80)})
+	}
+	return g.table([]string{"#", "T", "TYPE", "DETAIL"}, rows)
+}
+
+func cmdExport(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("out", "", "Write to FILE instead of stdout")
+	unredacted := fs.Bool("unredacted", false, "Keep task.hidden_tests, for a lossless copy to import elsewhere (needs the operator token)")
+	id, err := oneArg(fs, args, "trace ID")
+	if err != nil {
+		return err
+	}
+	export := g.api.ExportTrace
+	if *unredacted {
+		export = g.api.ExportTraceUnredacted
+	}
+	doc, err := export(ctx, id)
+	if err != nil {
+		return err
+	}
+	return writeDoc(g, *out, doc)
+}
+
+// cmdFile prints a file as the session left it, or as of -at. Table output
+// is the raw content so it can be piped or redirected.
+func cmdFile(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("file", flag.ExitOnError)
+	at := fs.String("at", "", "RFC 3339 timestamp or event index (default: after the last edit)")
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errors.New("expected a trace ID and a path")
+	}
+	snap, err := g.api.GetFile(ctx, fs.Arg(0), fs.Arg(1), *at)
+	if err != nil {
+		return err
+	}
+	if g.output == "json" {
+		return g.json(snap)
+	}
+	if !snap.Exists {
+		return fmt.Errorf("%s does not exist at that point", snap.Path)
+	}
+	for _, c := range snap.Conflicts {
+		fmt.Fprintf(os.Stderr, "warning: event %d (%s) did not apply: %s\n", c.Event, c.Op, c.Reason)
+	}
+	_, err = io.WriteString(g.stdout, snap.Content)
+	return err
+}
+
+func cmdFiles(ctx context.Context, g *globals, args []string) error {
+	id, err := oneArg(flag.NewFlagSet("files", flag.ExitOnError), args, "trace ID")
+	if err != nil {
+		return err
+	}
+	res, err := g.api.TouchedFiles(ctx, id)
+	if err != nil {
+		return err
+	}
+	if g.output == "json" {
+		return g.json(res)
+	}
+	rows := make([][]string, 0, len(res.Files))
+	for _, f := range res.Files {
+		opened := "-"
+		if f.FirstOpened != nil {
+			opened = f.FirstOpened.Format(time.RFC3339)
+		}
+		status := f.Status
+		if status == "" {
+			status = "unchanged"
+		} else if f.OldPath != "" {
+			status += " from " + f.OldPath
+		}
+		rows = append(rows, []string{f.Path, status, strconv.Itoa(f.EditCount), opened})
+	}
+	return g.table([]string{"PATH", "STATUS", "EDITS", "FIRST OPENED"}, rows)
+}
+
+func cmdImport(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	file := fs.String("f", "", "Exported trace document")
+	_ = fs.Parse(args)
+	if *file == "" {
+		return errors.New("-f is required")
+	}
+	b, err := os.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	var doc client.TraceExport
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("parse %s: %w", *file, err)
+	}
+	res, err := g.api.ImportTrace(ctx, &doc)
+	if err != nil {
+		return err
+	}
+	if g.output == "json" {
+		return g.json(res)
+	}
+	return g.table([]string{"TRACE ID", "EVENTS"}, [][]string{{res.TraceID, strconv.Itoa(res.EventCount)}})
+}
+
+func cmdSnapshot(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	file := fs.String("f", "", "Tarball of the repository (optionally gzipped), paths relative to its root")
+	_ = fs.Parse(args)
+	if *file == "" {
+		return errors.New("-f is required")
+	}
+	b, err := os.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	res, err := g.api.UploadSnapshot(ctx, b)
+	if err != nil {
+		return err
+	}
+	if g.output == "json" {
+		return g.json(res)
+	}
+	return g.table([]string{"SNAPSHOT ID", "REF", "FILES"}, [][]string{{res.SnapshotID, res.Ref, strconv.Itoa(res.Files)}})
+}
+
+func writeDoc(g *globals, path string, doc any) error {
+	if path == "" {
+		return g.json(doc)
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+func cmdList(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	opts, err := listFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("list takes no arguments; use search for free text")
+	}
+	return listTraces(ctx, g, opts)
+}
+
+func cmdSearch(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	opts, err := listFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("expected exactly one search query")
+	}
+	opts.Query = fs.Arg(0)
+	return listTraces(ctx, g, opts)
+}
+
+func listFlags(fs *flag.FlagSet, args []string) (client.ListOptions, error) {
+	status := fs.String("status", "", "Only traces with this status (open, sealed)")
+	repo := fs.String("repo", "", "Only traces for this repository URL")
+	since := fs.String("since", "", "Created at or after (RFC 3339)")
+	until := fs.String("until", "", "Created before (RFC 3339)")
+	limit := fs.Int("limit", 50, "Maximum traces to list")
+	offset := fs.Int("offset", 0, "Skip this many traces")
+	if err := fs.Parse(args); err != nil {
+		return client.ListOptions{}, err
+	}
+	opts := client.ListOptions{Status: *status, Repository: *repo, Limit: *limit, Offset: *offset}
+	for _, f := range []struct {
+		v   string
+		dst *time.Time
+	}{{*since, &opts.Since}, {*until, &opts.Until}} {
+		if f.v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, f.v)
+		if err != nil {
+			return opts, err
+		}
+		*f.dst = t
+	}
+	return opts, nil
+}
+
+func listTraces(ctx context.Context, g *globals, opts client.ListOptions) error {
+	traces, err := g.api.ListTraces(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if g.output == "json" {
+		return g.json(traces)
+	}
+	rows := make([][]string, 0, len(traces))
+	for _, t := range traces {
+		qa := "-"
+		if t.QAOK != nil {
+			qa = strconv.FormatBool(*t.QAOK)
+		}
+		rows = append(rows, []string{
+			t.TraceID,
+			t.CreatedAt.Format(time.RFC3339),
+			t.Status,
+			strconv.FormatInt(t.EventCount, 10),
+			qa,
+			t.Repository,
+			truncate(t.Description, 48),
+		})
+	}
+	return g.table([]string{"TRACE ID", "CREATED", "STATUS", "EVENTS", "QA OK", "REPOSITORY", "DESCRIPTION"}, rows)
+}
+
+func cmdDatasetExport(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("dataset-export", flag.ExitOnError)
+	status := fs.String("status", "sealed", "Only traces with this status (empty for any)")
+	repo := fs.String("repo", "", "Only traces for this repository URL")
+	qaOK := fs.String("qa-ok", "", "Only traces whose QA tests passed (true) or failed (false)")
+	minJudge := fs.String("min-judge", "", "Minimum judge overall score, e.g. 3.5")
+	since := fs.String("since", "", "Created at or after (RFC 3339)")
+	until := fs.String("until", "", "Created before (RFC 3339)")
+	integrity := fs.String("integrity", "", "Only traces with this integrity status (ok, broken, unverified)")
+	verified := fs.String("verified-fix", "", "Only traces whose patch is (true) or is not (false) a verified fix")
+	minCoverage := fs.String("min-coverage", "", "Minimum diff coverage of the patch in percent, e.g. 80")
+	formats := fs.String("formats", "jsonl,parquet", "Comma-separated shard formats")
+	shardSize := fs.Int("shard-size", 0, "Traces per shard (server default when 0)")
+	prefix := fs.String("prefix", "", "Object storage prefix (datasets/<id> when empty)")
+	wait := fs.Bool("wait", false, "Wait for the export to finish")
+	_ = fs.Parse(args)
+
+	req := client.CreateDatasetExportRequest{
+		Query:     client.DatasetQuery{Status: *status, Repository: *repo, IntegrityStatus: *integrity},
+		ShardSize: *shardSize,
+		Prefix:    *prefix,
+	}
+	for _, f := range strings.Split(*formats, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			req.Formats = append(req.Formats, f)
+		}
+	}
+	if *qaOK != "" {
+		v, err := strconv.ParseBool(*qaOK)
+		if err != nil {
+			return fmt.Errorf("-qa-ok: %w", err)
+		}
+		req.Query.QAOK = &v
+	}
+	if *verified != "" {
+		v, err := strconv.ParseBool(*verified)
+		if err != nil {
+			return fmt.Errorf("-verified-fix: %w", err)
+		}
+		req.Query.VerifiedFix = &v
+	}
+	if *minCoverage != "" {
+		v, err := strconv.ParseFloat(*minCoverage, 64)
+		if err != nil {
+			return fmt.Errorf("-min-coverage: %w", err)
+		}
+		req.Query.MinDiffCoverage = &v
+	}
+	if *minJudge != "" {
+		v, err := strconv.ParseFloat(*minJudge, 64)
+		if err != nil {
+			return fmt.Errorf("-min-judge: %w", err)
+		}
+		req.Query.MinJudgeOverall = &v
+	}
+	for _, f := range []struct {
+		v   string
+		dst **time.Time
+	}{{*since, &req.Query.CreatedAfter}, {*until, &req.Query.CreatedBefore}} {
+		if f.v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, f.v)
+		if err != nil {
+			return err
+		}
+		*f.dst = &t
+	}
+
+	ex, err := g.api.CreateDatasetExport(ctx, req)
+	if err != nil {
+		return err
+	}
+	for *wait && (ex.Status == "queued" || ex.Status == "running") {
+		fmt.Fprintf(os.Stderr, "\rexport %s %s: %d rows, %d shards", ex.ID, ex.Status, ex.RowsWritten, ex.ShardsWritten)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+		if ex, err = g.api.GetDatasetExport(ctx, ex.ID); err != nil {
+			return err
+		}
+	}
+	if *wait {
+		fmt.Fprintln(os.Stderr)
+	}
+	return printDatasetExport(g, ex)
+}
+
+func cmdDatasetStatus(ctx context.Context, g *globals, args []string) error {
+	id, err := oneArg(flag.NewFlagSet("dataset-status", flag.ExitOnError), args, "export ID")
+	if err != nil {
+		return err
+	}
+	ex, err := g.api.GetDatasetExport(ctx, id)
+	if err != nil {
+		return err
+	}
+	return printDatasetExport(g, ex)
+}
+
+func printDatasetExport(g *globals, ex *client.DatasetExportOut) error {
+	if g.output == "json" {
+		return g.json(ex)
+	}
+	return g.table([]string{"EXPORT ID", "STATUS", "ROWS", "SHARDS", "PREFIX", "MANIFEST", "ERROR"}, [][]string{{
+		ex.ID, ex.Status, strconv.FormatInt(ex.RowsWritten, 10), strconv.Itoa(ex.ShardsWritten), ex.Prefix, ex.ManifestRef, truncate(ex.Error, 60),
+	}})
+}
+
+// --- formatting ---
+
+func qaRows(qa map[string]any) [][]string {
+	if len(qa) == 0 {
+		return [][]string{{"qa", "-"}}
+	}
+	var rows [][]string
+	if e, ok := qa["error"]; ok {
+		rows = append(rows, []string{"qa.error", truncate(str(e), 120)})
+	}
+	if t, ok := qa["tests"].(map[string]any); ok {
+		rows = append(rows,
+			[]string{"qa.tests.ok", str(t["ok"])},
+			[]string{"qa.tests.exit_code", str(t["exit_code"])},
+			[]string{"qa.tests.image", str(t["image"])},
+			[]string{"qa.tests.command", str(t["command"])},
+		)
+		if sm, ok := t["summary"].(map[string]any); ok {
+			rows = append(rows, []string{"qa.tests.summary", fmt.Sprintf("%s passed, %s failed, %s skipped (%s)",
+				str(sm["passed"]), str(sm["failed"]), str(sm["skipped"]), str(t["parser"]))})
+		}
+	}
+	if a, ok := qa["apply"].(map[string]any); ok {
+		if st := str(a["strategy"]); st != "" {
+			rows = append(rows, []string{"qa.apply.strategy", st})
+		}
+		files, _ := a["files"].([]any)
+		for _, f := range files {
+			f, _ := f.(map[string]any)
+			if str(f["status"]) == "applied" {
+				continue
+			}
+			var rejected []string
+			hunks, _ := f["hunks"].([]any)
+			for _, h := range hunks {
+				if h, _ := h.(map[string]any); str(h["status"]) == "rejected" {
+					rejected = append(rejected, "#"+str(h["index"]))
+				}
+			}
+			detail := str(f["status"])
+			if len(rejected) > 0 {
+				detail += ", hunks " + strings.Join(rejected, " ")
+			}
+			if e := str(f["error"]); e != "" {
+				detail += ": " + e
+			}
+			rows = append(rows, []string{"qa.apply " + str(f["path"]), truncate(detail, 120)})
+		}
+	}
+	if sb, ok := qa["sandbox"].(map[string]any); ok {
+		rows = append(rows, []string{"qa.sandbox", fmt.Sprintf("user %s, pids %s, read-only rootfs %s",
+			str(sb["user"]), str(sb["pids"]), str(sb["read_only_rootfs"]))})
+	}
+	if s, ok := qa["snapshot"].(map[string]any); ok {
+		rows = append(rows, []string{"qa.snapshot", str(s["id"])})
+	}
+	if m, ok := qa["matrix"].(map[string]any); ok {
+		rows = append(rows, []string{"qa.matrix", fmt.Sprintf("ok=%s (%s passed, %s failed)", str(m["ok"]), str(m["passed"]), str(m["failed"]))})
+	}
+	if p, ok := qa["policy"].(map[string]any); ok {
+		f, _ := p["findings"].([]any)
+		rows = append(rows, []string{"qa.policy", fmt.Sprintf("%d findings (violated=%s)", len(f), str(p["violated"]))})
+	}
+	if l, ok := qa["lint"].(map[string]any); ok {
+		in, _ := l["introduced"].([]any)
+		rows = append(rows, []string{"qa.lint.introduced", strconv.Itoa(len(in))})
+	}
+	if c, ok := qa["coverage"].(map[string]any); ok {
+		rows = append(rows, []string{"qa.coverage.percent", str(c["percent"])})
+	}
+	if m, ok := qa["mutation"].(map[string]any); ok && str(m["skipped"]) != "" {
+		rows = append(rows, []string{"qa.mutation", "skipped: " + str(m["skipped"])})
+	} else if ok {
+		rows = append(rows, []string{"qa.mutation", fmt.Sprintf("score %s (%s killed, %s survived, %s non-viable)",
+			str(m["score"]), str(m["killed"]), str(m["survived"]), str(m["non_viable"]))})
+	}
+	if v, ok := qa["verification"].(map[string]any); ok {
+		rows = append(rows,
+			[]string{"qa.verification.verified_fix", str(v["verified_fix"])},
+			[]string{"qa.verification.fail_to_pass", str(v["FAIL_TO_PASS"])},
+			[]string{"qa.verification.pass_to_fail", str(v["PASS_TO_FAIL"])},
+		)
+	}
+	if j, ok := qa["judge"].(map[string]any); ok {
+		rows = append(rows, []string{"qa.judge.overall", str(j["overall"])})
+	}
+	return rows
+}
+
+func eventDetail(ev map[string]any) string {
+	for _, k := range []string{"file_path", "cmd", "raw", "commit", "remote"} {
+		if v, ok := ev[k]; ok {
+			return str(v)
+		}
+	}
+	return ""
+}
+
+func str(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	default:
+		return fmt.Sprint(x)
+	}
+}
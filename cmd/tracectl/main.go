@@ -0,0 +1,145 @@
+// Command tracectl is the operator CLI for the trace API.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+
+	"datacurve-takehome/pkg/client"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, g *globals, args []string) error
+}
+
+var commands = []command{
+	{"create", "create -f task.yaml", cmdCreate},
+	{"upload", "upload -trace ID -upload-token TOKEN -f events.ndjson", cmdUpload},
+	{"finalize", "finalize ID", cmdFinalize},
+	{"qa", "qa [-wait] [-timeout 15m] ID", cmdQA},
+	{"prefetch", "prefetch [-project P] [-commits SHA,...] REPO", cmdPrefetch},
+	{"credential-set", "credential-set -kind https_token|ssh_key [-username U] -secret-file F [-known-hosts F] -url-prefix URL PROJECT", cmdCredentialSet},
+	{"credential-get", "credential-get PROJECT", cmdCredentialGet},
+	{"get", "get ID", cmdGet},
+	{"events", "events ID", cmdEvents},
+	{"export", "export [-out FILE] [-unredacted] ID", cmdExport},
+	{"import", "import -f trace.json", cmdImport},
+	{"snapshot", "snapshot -f repo.tar.gz", cmdSnapshot},
+	{"file", "file [-at T|N] ID PATH", cmdFile},
+	{"files", "files ID", cmdFiles},
+	{"list", "list [-status S] [-repo URL] [-since T] [-until T] [-limit N]", cmdList},
+	{"search", "search [-status S] [-limit N] QUERY", cmdSearch},
+	{"dataset-export", "dataset-export [-status S] [-qa-ok B] [-min-judge X] [-since T] [-until T] [-formats jsonl,parquet] [-wait]", cmdDatasetExport},
+	{"dataset-status", "dataset-status ID", cmdDatasetStatus},
+}
+
+type globals struct {
+	api    *client.Client
+	output string
+	stdout io.Writer
+}
+
+func main() {
+	base := envOr("API_BASE_URL", "http://localhost:8000")
+	token := envOr("API_TOKEN", "dev-secret-token")
+	operator := os.Getenv("OPERATOR_TOKEN")
+
+	fs := flag.NewFlagSet("tracectl", flag.ExitOnError)
+	baseFlag := fs.String("base", base, "API base URL (e.g., http://localhost:8000)")
+	tokenFlag := fs.String("token", token, "API token for admin endpoints")
+	operatorFlag := fs.String("operator-token", operator, "Operator token for export -unredacted")
+	outFlag := fs.String("o", "table", "Output format: table or json")
+	fs.Usage = usage
+	_ = fs.Parse(os.Args[1:])
+
+	if fs.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if *outFlag != "table" && *outFlag != "json" {
+		fatalf("unknown output format %q (want table or json)", *outFlag)
+	}
+
+	name, args := fs.Arg(0), fs.Args()[1:]
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		g := &globals{api: client.New(*baseFlag, *tokenFlag, client.WithOperatorToken(*operatorFlag)), output: *outFlag, stdout: os.Stdout}
+		if err := c.run(ctx, g, args); err != nil {
+			fatalf("%s: %v", name, err)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: tracectl [-base URL] [-token TOKEN] [-operator-token TOKEN] [-o table|json] <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %s\n", c.usage)
+	}
+}
+
+// --- helpers ---
+
+func envOr(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "tracectl: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+func (g *globals) json(v any) error {
+	enc := json.NewEncoder(g.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// table prints rows under a header, tab-aligned.
+func (g *globals) table(header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(g.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, r := range rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+	return tw.Flush()
+}
+
+// oneArg parses a subcommand's flags and returns its single positional arg.
+func oneArg(fs *flag.FlagSet, args []string, what string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("expected exactly one %s", what)
+	}
+	return fs.Arg(0), nil
+}
+
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len([]rune(s)) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
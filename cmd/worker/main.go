@@ -8,7 +8,10 @@ import (
 	_ "github.com/jackc/pgx/v5/stdlib"
 
 	"datacurve-takehome/internal/db"
+	"datacurve-takehome/internal/gitmirror"
+	"datacurve-takehome/internal/qa"
 	"datacurve-takehome/internal/storage"
+	"datacurve-takehome/internal/vault"
 	"datacurve-takehome/internal/worker"
 )
 
@@ -19,7 +22,15 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	if err := worker.Run(os.Getenv("REDIS_ADDR"), db, s3c); err != nil {
+	runner, err := qa.RunnerFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	v, err := vault.FromEnv(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := worker.Run(os.Getenv("REDIS_ADDR"), db, s3c, gitmirror.FromEnv(), v, runner); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,313 @@
+package dataset
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/parquet-go/parquet-go"
+
+	"datacurve-takehome/internal/db"
+	"datacurve-takehome/internal/schemas"
+	"datacurve-takehome/internal/storage"
+	"datacurve-takehome/internal/traces"
+)
+
+// TaskName is the asynq task type that runs an export; its payload is the
+// dataset_exports id.
+const TaskName = "dataset_export"
+
+const DefaultShardSize = 500
+
+var Formats = []string{"jsonl", "parquet"}
+
+// CheckFormats rejects unknown formats and drops repeated ones, which would
+// otherwise write each shard twice under the same key. Empty means every
+// format.
+func CheckFormats(formats []string) ([]string, error) {
+	if len(formats) == 0 {
+		return Formats, nil
+	}
+	var out []string
+	for _, f := range formats {
+		if !slices.Contains(Formats, f) {
+			return nil, fmt.Errorf("unknown format %s", f)
+		}
+		if !slices.Contains(out, f) {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+// CheckPrefix makes sure a requested prefix stays under datasets/, so an
+// export can't overwrite traces, snapshots or QA artifacts in the bucket.
+func CheckPrefix(prefix string) error {
+	p := strings.TrimSuffix(prefix, "/")
+	if p != path.Clean(p) || !strings.HasPrefix(p, "datasets/") || strings.Contains(p, "//") {
+		return fmt.Errorf("prefix %q must be a clean relative path under datasets/", prefix)
+	}
+	return nil
+}
+
+// Manifest is written to <prefix>/manifest.json once every shard is stored.
+type Manifest struct {
+	ExportID      string               `json:"export_id"`
+	CreatedAt     time.Time            `json:"created_at"`
+	SchemaVersion string               `json:"schema_version"`
+	Query         schemas.DatasetQuery `json:"query"`
+	TotalRows     int64                `json:"total_rows"`
+	Shards        []Shard              `json:"shards"`
+}
+
+type Shard struct {
+	Path   string `json:"path"`
+	Format string `json:"format"`
+	Rows   int    `json:"rows"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// Row is the flattened Parquet record for one trace. Nested documents are
+// kept as JSON strings; the JSONL shards carry them as real objects.
+type Row struct {
+	TraceID      string    `parquet:"trace_id"`
+	CreatedAt    time.Time `parquet:"created_at,timestamp"`
+	Version      string    `parquet:"version"`
+	Repository   string    `parquet:"repository"`
+	Commit       string    `parquet:"commit"`
+	EventCount   int64     `parquet:"event_count"`
+	QAOK         *bool     `parquet:"qa_ok,optional"`
+	JudgeOverall *float64  `parquet:"judge_overall,optional"`
+	Developer    string    `parquet:"developer,json"`
+	Task         string    `parquet:"task,json"`
+	Environment  string    `parquet:"environment,json"`
+	Events       string    `parquet:"events,json"`
+	Artifacts    string    `parquet:"artifacts,json"`
+	QA           string    `parquet:"qa,json"`
+	Checksum     string    `parquet:"checksum"`
+}
+
+// Where turns a query into a SQL condition over traces (aliased t) and its
+// positional arguments.
+func Where(q schemas.DatasetQuery) (string, []any) {
+	var conds []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if q.Status != "" {
+		conds = append(conds, "t.status = "+arg(q.Status))
+	}
+	if q.Repository != "" {
+		conds = append(conds, "t.task->>'repository' = "+arg(q.Repository))
+	}
+	if q.QAOK != nil {
+		conds = append(conds, "coalesce((t.qa->'tests'->>'ok')::boolean, false) = "+arg(*q.QAOK))
+	}
+	if q.MinJudgeOverall != nil {
+		conds = append(conds, "(t.qa->'judge'->>'overall')::float8 >= "+arg(*q.MinJudgeOverall))
+	}
+	if q.CreatedAfter != nil {
+		conds = append(conds, "t.created_at >= "+arg(*q.CreatedAfter))
+	}
+	if q.CreatedBefore != nil {
+		conds = append(conds, "t.created_at < "+arg(*q.CreatedBefore))
+	}
+	if q.VerifiedFix != nil {
+		conds = append(conds, "coalesce((t.qa->'verification'->>'verified_fix')::boolean, false) = "+arg(*q.VerifiedFix))
+	}
+	if q.MinDiffCoverage != nil {
+		conds = append(conds, "(t.qa->'coverage'->>'percent')::float8 >= "+arg(*q.MinDiffCoverage))
+	}
+	if q.IntegrityStatus != "" {
+		conds = append(conds, "t.integrity_status = "+arg(q.IntegrityStatus))
+	}
+	if len(conds) == 0 {
+		return "true", nil
+	}
+	return strings.Join(conds, " and "), args
+}
+
+// Run executes a queued export, recording progress on its dataset_exports
+// row. Failures are stored on the row rather than returned, so the task is
+// not retried into a half-written prefix.
+func Run(ctx context.Context, dbx *sqlx.DB, s3c *storage.Client, id string) error {
+	var ex db.DatasetExport
+	if err := dbx.GetContext(ctx, &ex, `select * from dataset_exports where id=$1`, id); err != nil {
+		return err
+	}
+	if err := run(ctx, dbx, s3c, &ex); err != nil {
+		log.Printf("dataset export %s failed: %v", id, err)
+		_, _ = dbx.ExecContext(ctx, `update dataset_exports set status='failed', error=$2, updated_at=now() where id=$1`, id, err.Error())
+	}
+	return nil
+}
+
+func run(ctx context.Context, dbx *sqlx.DB, s3c *storage.Client, ex *db.DatasetExport) error {
+	var q schemas.DatasetQuery
+	if err := json.Unmarshal(ex.Query, &q); err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	var formats []string
+	if err := json.Unmarshal(ex.Formats, &formats); err != nil {
+		return fmt.Errorf("formats: %w", err)
+	}
+	formats, err := CheckFormats(formats)
+	if err != nil {
+		return err
+	}
+	if _, err := dbx.ExecContext(ctx, `update dataset_exports set status='running', updated_at=now() where id=$1`, ex.ID); err != nil {
+		return err
+	}
+
+	where, args := Where(q)
+	var ids []string
+	if err := dbx.SelectContext(ctx, &ids, `select t.id from traces t where `+where+` order by t.created_at, t.id`, args...); err != nil {
+		return err
+	}
+	log.Printf("dataset export %s: %d traces match", ex.ID, len(ids))
+
+	manifest := Manifest{
+		ExportID:      ex.ID,
+		CreatedAt:     time.Now().UTC(),
+		SchemaVersion: traces.SchemaVersion,
+		Query:         q,
+		Shards:        []Shard{},
+	}
+	shardSize := ex.ShardSize
+	if shardSize <= 0 {
+		shardSize = DefaultShardSize
+	}
+	for n, start := 0, 0; start < len(ids); n, start = n+1, start+shardSize {
+		end := min(start+shardSize, len(ids))
+		docs := make([]*schemas.TraceExport, 0, end-start)
+		for _, tid := range ids[start:end] {
+			doc, err := traces.Export(ctx, dbx, s3c, tid, true)
+			if err != nil {
+				return fmt.Errorf("trace %s: %w", tid, err)
+			}
+			docs = append(docs, doc)
+		}
+		if err := manifest.writeShard(ctx, s3c.PutBytes, ex.Prefix, n, formats, docs); err != nil {
+			return err
+		}
+		if _, err := dbx.ExecContext(ctx, `update dataset_exports set rows_written=$2, shards_written=$3, updated_at=now() where id=$1`,
+			ex.ID, manifest.TotalRows, n+1); err != nil {
+			return err
+		}
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	ref, err := s3c.PutBytes(ctx, path.Join(ex.Prefix, "manifest.json"), "application/json", b)
+	if err != nil {
+		return fmt.Errorf("put manifest: %w", err)
+	}
+	_, err = dbx.ExecContext(ctx, `update dataset_exports set status='done', manifest_ref=$2, updated_at=now() where id=$1`, ex.ID, ref)
+	log.Printf("dataset export %s: wrote %d rows to %s", ex.ID, manifest.TotalRows, ex.Prefix)
+	return err
+}
+
+// writeShard stores docs as shard n in each format and records the files
+// in the manifest.
+func (m *Manifest) writeShard(ctx context.Context, put func(ctx context.Context, key, contentType string, data []byte) (string, error), prefix string, n int, formats []string, docs []*schemas.TraceExport) error {
+	for _, f := range formats {
+		data, err := encodeShard(f, docs)
+		if err != nil {
+			return fmt.Errorf("shard %d %s: %w", n, f, err)
+		}
+		key := path.Join(prefix, fmt.Sprintf("part-%05d.%s", n, f))
+		if _, err := put(ctx, key, contentType(f), data); err != nil {
+			return fmt.Errorf("put %s: %w", key, err)
+		}
+		sum := sha256.Sum256(data)
+		m.Shards = append(m.Shards, Shard{
+			Path:   key,
+			Format: f,
+			Rows:   len(docs),
+			Bytes:  int64(len(data)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+	m.TotalRows += int64(len(docs))
+	return nil
+}
+
+func encodeShard(format string, docs []*schemas.TraceExport) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "jsonl":
+		enc := json.NewEncoder(&buf)
+		for _, d := range docs {
+			if err := enc.Encode(d); err != nil {
+				return nil, err
+			}
+		}
+	case "parquet":
+		rows := make([]Row, 0, len(docs))
+		for _, d := range docs {
+			rows = append(rows, toRow(d))
+		}
+		if err := parquet.Write(&buf, rows); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	return buf.Bytes(), nil
+}
+
+func contentType(format string) string {
+	if format == "jsonl" {
+		return "application/x-ndjson"
+	}
+	return "application/vnd.apache.parquet"
+}
+
+func toRow(d *schemas.TraceExport) Row {
+	r := Row{
+		TraceID:     d.TraceID,
+		CreatedAt:   d.CreatedAt,
+		Version:     d.Version,
+		EventCount:  int64(len(d.Events)),
+		Developer:   jsonString(d.Developer),
+		Task:        jsonString(d.Task),
+		Environment: jsonString(d.Environment),
+		Events:      jsonString(d.Events),
+		Artifacts:   jsonString(d.Artifacts),
+		QA:          jsonString(d.QA),
+		Checksum:    d.Export.Checksum,
+	}
+	r.Repository, _ = d.Task["repository"].(string)
+	r.Commit, _ = d.Task["commit"].(string)
+	if tests, ok := d.QA["tests"].(map[string]any); ok {
+		if v, ok := tests["ok"].(bool); ok {
+			r.QAOK = &v
+		}
+	}
+	if judge, ok := d.QA["judge"].(map[string]any); ok {
+		if v, ok := judge["overall"].(float64); ok {
+			r.JudgeOverall = &v
+		}
+	}
+	return r
+}
+
+func jsonString(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
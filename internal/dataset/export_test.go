@@ -0,0 +1,138 @@
+package dataset
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"datacurve-takehome/internal/schemas"
+)
+
+func TestCheckPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		prefix string
+		ok     bool
+	}{
+		{"datasets/2024-q1", true},
+		{"datasets/team/run-7/", true},
+		{"datasets/", false},
+		{"datasets", false},
+		{"traces/x", false},
+		{"/datasets/x", false},
+		{"datasets/../traces", false},
+		{"datasets/./x", false},
+		{"datasets//x", false},
+	} {
+		if err := CheckPrefix(tc.prefix); (err == nil) != tc.ok {
+			t.Errorf("CheckPrefix(%q) = %v, want ok=%v", tc.prefix, err, tc.ok)
+		}
+	}
+}
+
+func TestCheckFormats(t *testing.T) {
+	for _, tc := range []struct {
+		formats, want []string
+		ok            bool
+	}{
+		{nil, Formats, true},
+		{[]string{"parquet"}, []string{"parquet"}, true},
+		{[]string{"jsonl", "jsonl"}, []string{"jsonl"}, true},
+		{[]string{"parquet", "jsonl", "parquet"}, []string{"parquet", "jsonl"}, true},
+		{[]string{"jsonl", "csv"}, nil, false},
+	} {
+		got, err := CheckFormats(tc.formats)
+		if (err == nil) != tc.ok || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("CheckFormats(%q) = %q, %v, want %q", tc.formats, got, err, tc.want)
+		}
+	}
+}
+
+func TestWhere(t *testing.T) {
+	yes, score := true, 0.8
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name  string
+		q     schemas.DatasetQuery
+		where string
+		args  []any
+	}{
+		{"empty", schemas.DatasetQuery{}, "true", nil},
+		{"status", schemas.DatasetQuery{Status: "sealed"}, "t.status = $1", []any{"sealed"}},
+		{"qa ok", schemas.DatasetQuery{QAOK: &yes}, "coalesce((t.qa->'tests'->>'ok')::boolean, false) = $1", []any{true}},
+		{
+			"several",
+			schemas.DatasetQuery{Repository: "https://example.com/calc.git", MinJudgeOverall: &score, CreatedAfter: &after, IntegrityStatus: "ok"},
+			"t.task->>'repository' = $1 and (t.qa->'judge'->>'overall')::float8 >= $2 and t.created_at >= $3 and t.integrity_status = $4",
+			[]any{"https://example.com/calc.git", 0.8, after, "ok"},
+		},
+		{
+			"verification and coverage",
+			schemas.DatasetQuery{VerifiedFix: &yes, MinDiffCoverage: &score},
+			"coalesce((t.qa->'verification'->>'verified_fix')::boolean, false) = $1 and (t.qa->'coverage'->>'percent')::float8 >= $2",
+			[]any{true, 0.8},
+		},
+	} {
+		where, args := Where(tc.q)
+		if where != tc.where || !reflect.DeepEqual(args, tc.args) {
+			t.Errorf("%s: Where = %q %v, want %q %v", tc.name, where, args, tc.where, tc.args)
+		}
+	}
+}
+
+func TestWriteShard(t *testing.T) {
+	stored := map[string][]byte{}
+	put := func(_ context.Context, key, _ string, data []byte) (string, error) {
+		stored[key] = data
+		return "s3://bucket/" + key, nil
+	}
+	doc := func(id string) *schemas.TraceExport {
+		return &schemas.TraceExport{TraceID: id, Task: map[string]any{"repository": "calc"}, QA: map[string]any{}}
+	}
+	m := Manifest{Shards: []Shard{}}
+	if err := m.writeShard(context.Background(), put, "datasets/x", 0, []string{"jsonl", "parquet"}, []*schemas.TraceExport{doc("a"), doc("b")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.writeShard(context.Background(), put, "datasets/x", 1, []string{"jsonl", "parquet"}, []*schemas.TraceExport{doc("c")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.TotalRows != 3 {
+		t.Errorf("total rows = %d, want 3: each trace counts once, not once per format", m.TotalRows)
+	}
+	var got []string
+	for _, sh := range m.Shards {
+		got = append(got, fmt.Sprintf("%s %s %d", sh.Path, sh.Format, sh.Rows))
+		data, ok := stored[sh.Path]
+		sum := sha256.Sum256(data)
+		if !ok || sh.Bytes != int64(len(data)) || sh.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s: bytes %d sha256 %s don't describe the stored object", sh.Path, sh.Bytes, sh.SHA256)
+		}
+	}
+	want := []string{
+		"datasets/x/part-00000.jsonl jsonl 2",
+		"datasets/x/part-00000.parquet parquet 2",
+		"datasets/x/part-00001.jsonl jsonl 1",
+		"datasets/x/part-00001.parquet parquet 1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("shards = %q, want %q", got, want)
+	}
+	if lines := bytes.Count(stored["datasets/x/part-00000.jsonl"], []byte("\n")); lines != 2 {
+		t.Errorf("part-00000.jsonl has %d lines, want 2", lines)
+	}
+}
+
+func TestWriteShardPutError(t *testing.T) {
+	put := func(context.Context, string, string, []byte) (string, error) { return "", errors.New("bucket gone") }
+	m := Manifest{}
+	err := m.writeShard(context.Background(), put, "datasets/x", 0, []string{"jsonl"}, []*schemas.TraceExport{{TraceID: "a"}})
+	if err == nil || len(m.Shards) != 0 || m.TotalRows != 0 {
+		t.Errorf("writeShard = %v with %d shards and %d rows, want an error and nothing recorded", err, len(m.Shards), m.TotalRows)
+	}
+}
@@ -1,6 +1,9 @@
 package db
 
-import "time"
+import (
+	"database/sql"
+	"time"
+)
 
 type Trace struct {
 	ID              string    `db:"id"`
@@ -13,6 +16,10 @@ type Trace struct {
 	Artifacts       []byte    `db:"artifacts"`
 	QA              []byte    `db:"qa"`
 	Version         string    `db:"version"`
+	// Integrity is the edit hash-chain report; IntegrityStatus is its
+	// status, kept in a column so exports can filter on it.
+	Integrity       []byte         `db:"integrity"`
+	IntegrityStatus sql.NullString `db:"integrity_status"`
 }
 
 type EventBatch struct {
@@ -22,4 +29,22 @@ type EventBatch struct {
 	ObjectRef  string    `db:"object_ref"`
 	CreatedAt  time.Time `db:"created_at"`
 	EventCount int64     `db:"event_count"`
+	// IdempotencyKey is the client-supplied Idempotency-Key the batch was
+	// uploaded with, so retried uploads are not stored twice.
+	IdempotencyKey sql.NullString `db:"idempotency_key"`
+}
+
+type DatasetExport struct {
+	ID            string         `db:"id"`
+	CreatedAt     time.Time      `db:"created_at"`
+	UpdatedAt     time.Time      `db:"updated_at"`
+	Status        string         `db:"status"`
+	Query         []byte         `db:"query"`
+	Formats       []byte         `db:"formats"`
+	ShardSize     int            `db:"shard_size"`
+	Prefix        string         `db:"prefix"`
+	RowsWritten   int64          `db:"rows_written"`
+	ShardsWritten int            `db:"shards_written"`
+	ManifestRef   sql.NullString `db:"manifest_ref"`
+	Error         sql.NullString `db:"error"`
 }
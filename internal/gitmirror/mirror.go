@@ -0,0 +1,247 @@
+// Package gitmirror keeps bare mirrors of task repositories on local disk so
+// the worker can read files at a commit without a full checkout.
+package gitmirror
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"datacurve-takehome/internal/vault"
+)
+
+type Mirror struct {
+	Dir string
+}
+
+// FromEnv uses GIT_MIRROR_DIR, falling back to a directory under the system
+// temp dir.
+func FromEnv() *Mirror {
+	dir := os.Getenv("GIT_MIRROR_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "git-mirrors")
+	}
+	return &Mirror{Dir: dir}
+}
+
+var locks sync.Map // mirror path -> *sync.Mutex
+
+func lock(dir string) func() {
+	mu, _ := locks.LoadOrStore(dir, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// Path is where the mirror of url fetched with cred lives. Mirrors are
+// keyed by the credential's scope as well as the URL, so a private mirror
+// one project cloned is never served to a caller without its credential:
+// callers without one get the public mirror, which only anonymous fetches
+// fill.
+func (m *Mirror) Path(url string, cred *vault.Credential) string {
+	sum := sha256.Sum256([]byte(scope(cred) + "\x00" + url))
+	name := strings.TrimSuffix(path.Base(strings.TrimRight(url, "/")), ".git")
+	return filepath.Join(m.Dir, hex.EncodeToString(sum[:8])+"-"+name+".git")
+}
+
+// scope names who may read a mirror: everyone, a project, or the holders
+// of a credential loaded outside the vault.
+func scope(cred *vault.Credential) string {
+	switch {
+	case cred == nil:
+		return "public"
+	case cred.Project != "":
+		return "project:" + cred.Project
+	}
+	sum := sha256.Sum256([]byte(cred.Kind + "\x00" + cred.Username + "\x00" + cred.Secret))
+	return "credential:" + hex.EncodeToString(sum[:])
+}
+
+// Sync clones url if there is no mirror yet and fetches it otherwise. cred,
+// if not nil, authenticates the clone or fetch.
+func (m *Mirror) Sync(ctx context.Context, url string, cred *vault.Credential) (string, error) {
+	dir := m.Path(url, cred)
+	defer lock(dir)()
+	return dir, m.sync(ctx, url, dir, cred)
+}
+
+func (m *Mirror) sync(ctx context.Context, url, dir string, cred *vault.Credential) error {
+	if _, err := os.Stat(dir); err == nil {
+		_, err := gitAuth(ctx, dir, cred, "fetch", "--prune", "--quiet", "origin")
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	// Clone next to the final path and rename, so an interrupted clone never
+	// looks like a mirror.
+	tmp, err := os.MkdirTemp(m.Dir, ".clone-")
+	if err != nil {
+		return err
+	}
+	if _, err := gitAuth(ctx, "", cred, "clone", "--mirror", "--quiet", url, tmp); err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		_ = os.RemoveAll(tmp)
+		// Another process sharing the directory got there first.
+		if _, serr := os.Stat(dir); serr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Ensure makes sure rev is present in the mirror of url, fetching if it is
+// not, and returns the mirror path and the commit rev resolves to. An empty
+// rev means the remote's HEAD.
+func (m *Mirror) Ensure(ctx context.Context, url, rev string, cred *vault.Credential) (string, string, error) {
+	if rev = strings.TrimSpace(rev); rev == "" {
+		rev = "HEAD"
+	}
+	dir := m.Path(url, cred)
+	defer lock(dir)()
+	if _, err := os.Stat(dir); err == nil {
+		if sha, err := resolve(ctx, dir, rev); err == nil {
+			return dir, sha, nil
+		}
+	}
+	if err := m.sync(ctx, url, dir, cred); err != nil {
+		return "", "", err
+	}
+	sha, err := resolve(ctx, dir, rev)
+	if err != nil {
+		return "", "", fmt.Errorf("%s not found in %s", rev, vault.Scrub(url, nil))
+	}
+	return dir, sha, nil
+}
+
+// PrefetchTask is the asynq task type that warms a mirror; its payload is
+// a JSON schemas.PrefetchRequest.
+const PrefetchTask = "git_prefetch"
+
+// Prefetch syncs the mirror of url and checks that every rev is in it.
+func (m *Mirror) Prefetch(ctx context.Context, url string, revs []string, cred *vault.Credential) error {
+	dir, err := m.Sync(ctx, url, cred)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, rev := range revs {
+		if _, err := resolve(ctx, dir, rev); err != nil {
+			missing = append(missing, rev)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("not found in %s: %s", vault.Scrub(url, nil), strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func resolve(ctx context.Context, dir, rev string) (string, error) {
+	out, err := git(ctx, dir, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Tree is the file tree of one commit in a mirror.
+type Tree struct {
+	Dir    string
+	Commit string
+}
+
+// Tree returns url at rev, syncing the mirror first if needed.
+func (m *Mirror) Tree(ctx context.Context, url, rev string, cred *vault.Credential) (*Tree, error) {
+	dir, sha, err := m.Ensure(ctx, url, rev, cred)
+	if err != nil {
+		return nil, err
+	}
+	return &Tree{Dir: dir, Commit: sha}, nil
+}
+
+// Cached returns url at rev if cred's mirror already has it, without
+// syncing, so request handlers never wait on a clone.
+func (m *Mirror) Cached(ctx context.Context, url, rev string, cred *vault.Credential) (*Tree, bool) {
+	if rev = strings.TrimSpace(rev); rev == "" {
+		rev = "HEAD"
+	}
+	dir := m.Path(url, cred)
+	if _, err := os.Stat(dir); err != nil {
+		return nil, false
+	}
+	sha, err := resolve(ctx, dir, rev)
+	if err != nil {
+		return nil, false
+	}
+	return &Tree{Dir: dir, Commit: sha}, true
+}
+
+// BlobID returns the git object id of path, or ok=false if the commit has
+// no file there.
+func (t *Tree) BlobID(ctx context.Context, p string) (string, bool, error) {
+	out, err := git(ctx, t.Dir, "ls-tree", "-z", t.Commit, "--", p)
+	if err != nil {
+		return "", false, err
+	}
+	// <mode> SP <type> SP <object> TAB <path> NUL
+	entry, _, _ := strings.Cut(string(out), "\x00")
+	meta, _, ok := strings.Cut(entry, "\t")
+	if !ok {
+		return "", false, nil
+	}
+	fields := strings.Fields(meta)
+	if len(fields) != 3 || fields[1] != "blob" {
+		return "", false, nil
+	}
+	return fields[2], true, nil
+}
+
+// ReadFile implements patch.Source.
+func (t *Tree) ReadFile(ctx context.Context, p string) (string, bool, error) {
+	id, ok, err := t.BlobID(ctx, p)
+	if err != nil || !ok {
+		return "", false, err
+	}
+	out, err := git(ctx, t.Dir, "cat-file", "blob", id)
+	if err != nil {
+		return "", false, err
+	}
+	return string(out), true, nil
+}
+
+func git(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	return gitAuth(ctx, dir, nil, args...)
+}
+
+// gitAuth runs git with cred in its environment and scrubs it from errors.
+func gitAuth(ctx context.Context, dir string, cred *vault.Credential, args ...string) ([]byte, error) {
+	sub := args[0]
+	if dir != "" {
+		args = append([]string{"--git-dir=" + dir}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), cred.Env()...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && stderr.Len() > 0 {
+			return out, fmt.Errorf("git %s: %s", sub, vault.Scrub(strings.TrimSpace(stderr.String()), cred))
+		}
+		return out, fmt.Errorf("git %s: %w", sub, err)
+	}
+	return out, nil
+}
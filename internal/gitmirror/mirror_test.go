@@ -0,0 +1,145 @@
+package gitmirror
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"datacurve-takehome/internal/vault"
+)
+
+// upstream commits files to a new repository and returns its file:// URL
+// and the commit.
+func upstream(t *testing.T, files map[string]string) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	run("init", "-q")
+	run("add", "-A")
+	run("-c", "user.name=qa", "-c", "user.email=qa@example.com", "commit", "-q", "-m", "start")
+	return "file://" + dir, run("rev-parse", "HEAD")
+}
+
+func TestMirrorScopes(t *testing.T) {
+	url, commit := upstream(t, map[string]string{"secret.txt": "private\n"})
+	m := &Mirror{Dir: t.TempDir()}
+	ctx := context.Background()
+	a := &vault.Credential{Kind: vault.HTTPSToken, Secret: "a", Project: "a"}
+	b := &vault.Credential{Kind: vault.HTTPSToken, Secret: "b", Project: "b"}
+
+	if _, _, err := m.Ensure(ctx, url, commit, a); err != nil {
+		t.Fatalf("Ensure: %v", err)
+	}
+	for _, tc := range []struct {
+		name string
+		cred *vault.Credential
+		want bool
+	}{
+		{"same project", a, true},
+		{"same project, reloaded", &vault.Credential{Kind: vault.HTTPSToken, Secret: "rotated", Project: "a"}, true},
+		{"other project", b, false},
+		{"no credential", nil, false},
+		{"credential outside the vault", &vault.Credential{Kind: vault.HTTPSToken, Secret: "a"}, false},
+	} {
+		if _, ok := m.Cached(ctx, url, commit, tc.cred); ok != tc.want {
+			t.Errorf("%s: Cached = %v, want %v", tc.name, ok, tc.want)
+		}
+	}
+	if m.Path(url, a) == m.Path(url, nil) || m.Path(url, a) == m.Path(url, b) {
+		t.Error("mirrors of different scopes share a path")
+	}
+}
+
+func TestEnsureAndCached(t *testing.T) {
+	url, first := upstream(t, map[string]string{"calc.go": "package calc\n"})
+	dir := strings.TrimPrefix(url, "file://")
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=qa", "-c", "user.email=qa@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("tag", "v1")
+	branch := git("rev-parse", "--abbrev-ref", "HEAD")
+	m := &Mirror{Dir: t.TempDir()}
+	ctx := context.Background()
+
+	if _, ok := m.Cached(ctx, url, first, nil); ok {
+		t.Fatal("Cached before any sync")
+	}
+	for _, tc := range []struct {
+		name, rev, want string
+	}{
+		{"empty is HEAD", "", first},
+		{"HEAD", "HEAD", first},
+		{"full sha", first, first},
+		{"short sha", first[:10], first},
+		{"padded", "  " + first + "\n", first},
+		{"branch", branch, first},
+		{"tag", "v1", first},
+	} {
+		_, sha, err := m.Ensure(ctx, url, tc.rev, nil)
+		if err != nil || sha != tc.want {
+			t.Errorf("%s: Ensure(%q) = %s, %v, want %s", tc.name, tc.rev, sha, err, tc.want)
+		}
+		if tree, ok := m.Cached(ctx, url, tc.rev, nil); !ok || tree.Commit != tc.want {
+			t.Errorf("%s: Cached(%q) = %+v, %v, want %s", tc.name, tc.rev, tree, ok, tc.want)
+		}
+	}
+
+	// a commit made after the clone: Cached misses it, Ensure fetches it
+	if err := os.WriteFile(filepath.Join(dir, "calc.go"), []byte("package calc\n\nfunc Add(a, b int) int { return a + b }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git("commit", "-q", "-am", "add")
+	second := git("rev-parse", "HEAD")
+	if _, ok := m.Cached(ctx, url, second, nil); ok {
+		t.Error("Cached found a commit the mirror never fetched")
+	}
+	if tree, ok := m.Cached(ctx, url, "", nil); !ok || tree.Commit != first {
+		t.Errorf("Cached HEAD before the fetch = %+v, want %s", tree, first)
+	}
+	_, sha, err := m.Ensure(ctx, url, second, nil)
+	if err != nil || sha != second {
+		t.Fatalf("Ensure(new commit) = %s, %v, want %s", sha, err, second)
+	}
+	tree, ok := m.Cached(ctx, url, second, nil)
+	if !ok {
+		t.Fatal("Cached misses the fetched commit")
+	}
+	if body, ok, err := tree.ReadFile(ctx, "calc.go"); err != nil || !ok || !strings.Contains(body, "func Add") {
+		t.Errorf("ReadFile(calc.go) = %q, %v, %v", body, ok, err)
+	}
+	if _, ok, err := tree.ReadFile(ctx, "missing.go"); err != nil || ok {
+		t.Errorf("ReadFile(missing.go) = %v, %v, want not found", ok, err)
+	}
+
+	if _, _, err := m.Ensure(ctx, url, "no-such-branch", nil); err == nil || !strings.Contains(err.Error(), "no-such-branch not found") {
+		t.Errorf("Ensure(unknown rev) = %v, want not found", err)
+	}
+	if _, ok := m.Cached(ctx, url, "no-such-branch", nil); ok {
+		t.Error("Cached resolved an unknown rev")
+	}
+}
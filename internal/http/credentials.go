@@ -0,0 +1,64 @@
+package http
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"datacurve-takehome/internal/schemas"
+	"datacurve-takehome/internal/vault"
+)
+
+// putCredential stores a project's git credential. The secret is never
+// returned by any endpoint.
+func (s *Server) putCredential(w http.ResponseWriter, r *http.Request) {
+	var req schemas.ProjectCredential
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, errResp{err.Error()})
+		return
+	}
+	c := vault.Credential{Kind: req.Kind, Username: req.Username, Secret: req.Secret, KnownHosts: req.KnownHosts, URLPrefix: req.URLPrefix}
+	if err := c.Validate(); err != nil {
+		writeJSON(w, 400, errResp{err.Error()})
+		return
+	}
+	info, err := s.Vault.Put(r.Context(), chi.URLParam(r, "project"), &c)
+	if err != nil {
+		writeJSON(w, 500, errResp{err.Error()})
+		return
+	}
+	writeJSON(w, 200, credentialInfo(info))
+}
+
+func (s *Server) getCredential(w http.ResponseWriter, r *http.Request) {
+	info, err := s.Vault.Info(r.Context(), chi.URLParam(r, "project"))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, 404, errResp{"not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 500, errResp{err.Error()})
+		return
+	}
+	writeJSON(w, 200, credentialInfo(info))
+}
+
+func (s *Server) deleteCredential(w http.ResponseWriter, r *http.Request) {
+	ok, err := s.Vault.Delete(r.Context(), chi.URLParam(r, "project"))
+	if err != nil {
+		writeJSON(w, 500, errResp{err.Error()})
+		return
+	}
+	if !ok {
+		writeJSON(w, 404, errResp{"not found"})
+		return
+	}
+	writeJSON(w, 200, map[string]string{"deleted": "ok"})
+}
+
+func credentialInfo(i *vault.Info) schemas.CredentialInfo {
+	return schemas.CredentialInfo{Project: i.Project, Kind: i.Kind, Username: i.Username, URLPrefix: i.URLPrefix, UpdatedAt: i.UpdatedAt}
+}
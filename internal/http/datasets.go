@@ -0,0 +1,81 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+
+	"datacurve-takehome/internal/dataset"
+	"datacurve-takehome/internal/db"
+	"datacurve-takehome/internal/schemas"
+)
+
+func (s *Server) createDatasetExport(w http.ResponseWriter, r *http.Request) {
+	var req schemas.CreateDatasetExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, errResp{err.Error()})
+		return
+	}
+	var err error
+	if req.Formats, err = dataset.CheckFormats(req.Formats); err != nil {
+		writeJSON(w, 400, errResp{err.Error()})
+		return
+	}
+	if req.ShardSize <= 0 {
+		req.ShardSize = dataset.DefaultShardSize
+	}
+	id := uuid.NewString()
+	if req.Prefix == "" {
+		req.Prefix = "datasets/" + id
+	}
+	if err := dataset.CheckPrefix(req.Prefix); err != nil {
+		writeJSON(w, 400, errResp{err.Error()})
+		return
+	}
+	req.Prefix = strings.TrimSuffix(req.Prefix, "/")
+	q, _ := json.Marshal(req.Query)
+	formats, _ := json.Marshal(req.Formats)
+	_, err = s.DB.Exec(`insert into dataset_exports(id, query, formats, shard_size, prefix) values($1,$2,$3,$4,$5)`,
+		id, q, formats, req.ShardSize, req.Prefix)
+	if err != nil {
+		writeJSON(w, 500, errResp{err.Error()})
+		return
+	}
+	if _, err := s.Asynq.Enqueue(asynq.NewTask(dataset.TaskName, []byte(id)), asynq.MaxRetry(0)); err != nil {
+		_, _ = s.DB.Exec(`update dataset_exports set status='failed', error=$2, updated_at=now() where id=$1`, id, err.Error())
+		writeJSON(w, 500, errResp{err.Error()})
+		return
+	}
+	s.writeDatasetExport(w, r, id)
+}
+
+func (s *Server) getDatasetExport(w http.ResponseWriter, r *http.Request) {
+	s.writeDatasetExport(w, r, chi.URLParam(r, "id"))
+}
+
+func (s *Server) writeDatasetExport(w http.ResponseWriter, r *http.Request, id string) {
+	var ex db.DatasetExport
+	if err := s.DB.GetContext(r.Context(), &ex, `select * from dataset_exports where id=$1`, id); err != nil {
+		writeJSON(w, 404, errResp{"not found"})
+		return
+	}
+	out := schemas.DatasetExportOut{
+		ID:            ex.ID,
+		CreatedAt:     ex.CreatedAt,
+		UpdatedAt:     ex.UpdatedAt,
+		Status:        ex.Status,
+		ShardSize:     ex.ShardSize,
+		Prefix:        ex.Prefix,
+		RowsWritten:   ex.RowsWritten,
+		ShardsWritten: ex.ShardsWritten,
+		ManifestRef:   ex.ManifestRef.String,
+		Error:         ex.Error.String,
+	}
+	_ = json.Unmarshal(ex.Query, &out.Query)
+	_ = json.Unmarshal(ex.Formats, &out.Formats)
+	writeJSON(w, 200, out)
+}
@@ -0,0 +1,55 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"datacurve-takehome/internal/schemas"
+	"datacurve-takehome/internal/traces"
+)
+
+// exportTrace returns the trace-1.0.0 document, with task.hidden_tests
+// redacted unless an operator asks for a lossless copy with
+// ?unredacted=true.
+func (s *Server) exportTrace(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	unredacted := r.URL.Query().Get("unredacted") == "true"
+	if unredacted && !isOperator(r) {
+		writeJSON(w, 403, errResp{"unredacted export needs the operator token (X-Operator-Token)"})
+		return
+	}
+	doc, err := traces.Export(r.Context(), s.DB, s.S3, id, !unredacted)
+	if err != nil {
+		if errors.Is(err, traces.ErrNotFound) {
+			writeJSON(w, 404, errResp{"not found"})
+			return
+		}
+		writeJSON(w, 500, errResp{err.Error()})
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="trace-`+id+`.json"`)
+	writeJSON(w, 200, doc)
+}
+
+func (s *Server) importTrace(w http.ResponseWriter, r *http.Request) {
+	var doc schemas.TraceExport
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		writeJSON(w, 400, errResp{err.Error()})
+		return
+	}
+	if err := traces.Import(r.Context(), s.DB, s.S3, &doc); err != nil {
+		switch {
+		case errors.Is(err, traces.ErrExists):
+			writeJSON(w, 409, errResp{err.Error()})
+		case errors.Is(err, traces.ErrInvalidDocument), errors.Is(err, traces.ErrChecksumMismatch):
+			writeJSON(w, 400, errResp{err.Error()})
+		default:
+			writeJSON(w, 500, errResp{err.Error()})
+		}
+		return
+	}
+	writeJSON(w, 200, schemas.ImportTraceResponse{TraceID: doc.TraceID, EventCount: len(doc.Events)})
+}
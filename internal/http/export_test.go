@@ -0,0 +1,37 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExportUnredactedNeedsOperator(t *testing.T) {
+	for _, tc := range []struct {
+		name, env, header string
+	}{
+		{"no operator token configured", "", ""},
+		{"missing header", "op-secret", ""},
+		{"wrong header", "op-secret", "op-guess"},
+	} {
+		t.Setenv("OPERATOR_TOKEN", tc.env)
+		r := httptest.NewRequest(http.MethodGet, "/traces/t1/export?unredacted=true", nil)
+		if tc.header != "" {
+			r.Header.Set("X-Operator-Token", tc.header)
+		}
+		w := httptest.NewRecorder()
+		(&Server{}).exportTrace(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d, want 403", tc.name, w.Code)
+		}
+	}
+}
+
+func TestIsOperator(t *testing.T) {
+	t.Setenv("OPERATOR_TOKEN", "op-secret")
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Operator-Token", "op-secret")
+	if !isOperator(r) {
+		t.Error("matching X-Operator-Token not accepted")
+	}
+}
@@ -0,0 +1,267 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"datacurve-takehome/internal/patch"
+	"datacurve-takehome/internal/schemas"
+	"datacurve-takehome/internal/snapshot"
+	"datacurve-takehome/internal/traces"
+	"datacurve-takehome/internal/vault"
+)
+
+// defaultSnapshotCacheMB bounds the snapshots the API keeps in memory, so
+// repeated /files requests on snapshot tasks don't download them again.
+// SNAPSHOT_CACHE_MB overrides it; 0 turns the cache off.
+const defaultSnapshotCacheMB = 256
+
+func snapshotCacheSize() int64 {
+	v := os.Getenv("SNAPSHOT_CACHE_MB")
+	if v == "" {
+		return defaultSnapshotCacheMB << 20
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		log.Printf("bad SNAPSHOT_CACHE_MB %q, using %d", v, defaultSnapshotCacheMB)
+		return defaultSnapshotCacheMB << 20
+	}
+	return n << 20
+}
+
+// mirrorWarmTimeout bounds the background clone or fetch started for a
+// commit the mirror doesn't have; failures are remembered for
+// mirrorWarmBackoff before another is tried.
+const (
+	mirrorWarmTimeout = 10 * time.Minute
+	mirrorWarmBackoff = time.Minute
+)
+
+type mirrorWarm struct {
+	mu  sync.Mutex
+	err error
+}
+
+// errMirrorWarming asks the client to come back once the mirror has the
+// task commit.
+var errMirrorWarming = errors.New("the repository is being mirrored; retry shortly")
+
+// replayInputs loads what reconstructing a trace's files needs: the task
+// commit from the mirror (or its snapshot) and the trace's events. A missing
+// trace is reported as traces.ErrNotFound. Requests never clone: a commit
+// the mirror doesn't have yet starts a bounded background sync and is
+// reported as errMirrorWarming.
+func (s *Server) replayInputs(ctx context.Context, id string) (*snapshot.Base, []map[string]any, error) {
+	var taskJSON []byte
+	if err := s.DB.GetContext(ctx, &taskJSON, `select task from traces where id=$1`, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, traces.ErrNotFound
+		}
+		return nil, nil, err
+	}
+	var task map[string]any
+	_ = json.Unmarshal(taskJSON, &task)
+	// the mirror is the one the task's project may read
+	cred, err := s.Vault.ForTask(ctx, task)
+	if err != nil {
+		return nil, nil, err
+	}
+	tree, err := snapshot.CachedBase(ctx, s.Mirror, s.snapshots, s.S3, cred, task)
+	if errors.Is(err, snapshot.ErrNotMirrored) {
+		repo, _ := task["repository"].(string)
+		commit, _ := task["commit"].(string)
+		return nil, nil, s.warmMirror(repo, commit, cred)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	events, err := traces.LoadEvents(ctx, s.DB, s.S3, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tree, events, nil
+}
+
+// warmMirror starts syncing repo in the background unless a sync is
+// running, and returns errMirrorWarming, or the error of a sync that
+// failed recently. Syncs are per mirror, that is per project and repo, so
+// each runs with its own project's credential and one project's failure
+// is never reported to another.
+func (s *Server) warmMirror(repo, commit string, cred *vault.Credential) error {
+	key := s.Mirror.Path(repo, cred)
+	w := &mirrorWarm{}
+	if cur, busy := s.warming.LoadOrStore(key, w); busy {
+		w = cur.(*mirrorWarm)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.err != nil {
+			return w.err
+		}
+		return errMirrorWarming
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mirrorWarmTimeout)
+		defer cancel()
+		_, err := s.Mirror.Tree(ctx, repo, commit, cred)
+		if err == nil {
+			s.warming.Delete(key)
+			return
+		}
+		log.Printf("mirror %s: %v", vault.Scrub(repo, nil), err)
+		w.mu.Lock()
+		w.err = fmt.Errorf("git mirror: %w", err)
+		w.mu.Unlock()
+		time.AfterFunc(mirrorWarmBackoff, func() { s.warming.Delete(key) })
+	}()
+	return errMirrorWarming
+}
+
+func writeReplayError(w http.ResponseWriter, err error) {
+	if errors.Is(err, traces.ErrNotFound) {
+		writeJSON(w, 404, errResp{"not found"})
+		return
+	}
+	if errors.Is(err, errMirrorWarming) {
+		w.Header().Set("Retry-After", "10")
+		writeJSON(w, http.StatusServiceUnavailable, errResp{err.Error()})
+		return
+	}
+	writeJSON(w, 500, errResp{err.Error()})
+}
+
+// getFile returns a file as of ?at=, which is an RFC 3339 timestamp (edits
+// at or before it) or an event index (edits at or before that position in
+// the event stream). Without at, every edit is applied.
+func (s *Server) getFile(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeJSON(w, 400, errResp{"path is required"})
+		return
+	}
+	at := r.URL.Query().Get("at")
+	include := func(patch.Edit) bool { return true }
+	if at != "" {
+		if n, err := strconv.Atoi(at); err == nil {
+			include = func(e patch.Edit) bool { return e.Index <= n }
+		} else if t, err := time.Parse(time.RFC3339Nano, at); err == nil {
+			include = func(e patch.Edit) bool { return !e.T.After(t) }
+		} else {
+			writeJSON(w, 400, errResp{"at must be an RFC 3339 timestamp or an event index"})
+			return
+		}
+	}
+
+	tree, events, err := s.replayInputs(r.Context(), id)
+	if err != nil {
+		writeReplayError(w, err)
+		return
+	}
+	ws := patch.NewWorkspace(tree)
+	out := schemas.FileSnapshot{TraceID: id, Path: path, Commit: tree.Commit, At: at}
+	if tree.Snapshot != nil {
+		out.Snapshot = tree.Snapshot.ID
+	}
+	for _, e := range patch.EditsFromEvents(events) {
+		if !include(e) {
+			continue
+		}
+		if err := ws.Apply(r.Context(), e); err != nil {
+			writeJSON(w, 500, errResp{err.Error()})
+			return
+		}
+		out.EditsApplied++
+	}
+	out.Content, out.Exists, err = ws.File(r.Context(), path)
+	if err != nil {
+		writeJSON(w, 500, errResp{err.Error()})
+		return
+	}
+	if out.Exists {
+		out.Hash = patch.BlobHash(out.Content)
+	}
+	for _, c := range ws.Conflicts() {
+		if c.FilePath == path {
+			out.Conflicts = append(out.Conflicts, schemas.EditConflict{Event: c.Event, Op: c.Op, Hunk: c.Hunk, Reason: c.Reason})
+		}
+	}
+	writeJSON(w, 200, out)
+}
+
+// touchedFiles lists every file the session opened or edited, with the
+// first time it was opened, how many edits named it and its diff against
+// the task commit after the last edit.
+func (s *Server) touchedFiles(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	tree, events, err := s.replayInputs(r.Context(), id)
+	if err != nil {
+		writeReplayError(w, err)
+		return
+	}
+
+	files := map[string]*schemas.TouchedFile{}
+	get := func(p string) *schemas.TouchedFile {
+		if files[p] == nil {
+			files[p] = &schemas.TouchedFile{Path: p}
+		}
+		return files[p]
+	}
+	for _, e := range events {
+		if e["type"] != "file_opened" {
+			continue
+		}
+		p, _ := e["file_path"].(string)
+		ts, _ := e["t"].(string)
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if p == "" || err != nil {
+			continue
+		}
+		f := get(p)
+		if f.FirstOpened == nil || t.Before(*f.FirstOpened) {
+			f.FirstOpened = &t
+		}
+	}
+
+	ws := patch.NewWorkspace(tree)
+	for _, e := range patch.EditsFromEvents(events) {
+		if err := ws.Apply(r.Context(), e); err != nil {
+			writeJSON(w, 500, errResp{err.Error()})
+			return
+		}
+		if e.FilePath != "" {
+			get(e.FilePath).EditCount++
+		}
+		if e.NewPath != "" {
+			get(e.NewPath)
+		}
+	}
+	for _, c := range ws.Changes() {
+		f := get(c.Path())
+		f.Status, f.Diff = c.Status(), patch.Unified(c)
+		if f.Status == "renamed" {
+			f.OldPath = c.OldPath
+		}
+	}
+
+	out := schemas.TouchedFilesResponse{TraceID: id, Commit: tree.Commit, Files: []schemas.TouchedFile{}}
+	if tree.Snapshot != nil {
+		out.Snapshot = tree.Snapshot.ID
+	}
+	for _, f := range files {
+		out.Files = append(out.Files, *f)
+	}
+	sort.Slice(out.Files, func(i, j int) bool { return out.Files[i].Path < out.Files[j].Path })
+	writeJSON(w, 200, out)
+}
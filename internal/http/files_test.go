@@ -0,0 +1,64 @@
+package http
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"datacurve-takehome/internal/gitmirror"
+	"datacurve-takehome/internal/vault"
+)
+
+func TestWarmMirrorPerProject(t *testing.T) {
+	s := &Server{Mirror: &gitmirror.Mirror{Dir: t.TempDir()}}
+	repo := filepath.Join(t.TempDir(), "missing.git")
+	a, b := &vault.Credential{Project: "a"}, &vault.Credential{Project: "b"}
+	// settle waits for cred's sync to fail and returns its error
+	settle := func(cred *vault.Credential) error {
+		deadline := time.Now().Add(30 * time.Second)
+		for {
+			err := s.warmMirror(repo, "HEAD", cred)
+			if !errors.Is(err, errMirrorWarming) {
+				return err
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("clone of a missing repository did not fail")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if err := s.warmMirror(repo, "HEAD", a); !errors.Is(err, errMirrorWarming) {
+		t.Fatalf("first warm = %v, want errMirrorWarming", err)
+	}
+	if err := settle(a); err == nil || !strings.HasPrefix(err.Error(), "git mirror:") {
+		t.Fatalf("project a = %v, want its sync error", err)
+	}
+	// project b and anonymous callers get syncs of their own
+	for _, cred := range []*vault.Credential{b, nil} {
+		if err := s.warmMirror(repo, "HEAD", cred); !errors.Is(err, errMirrorWarming) {
+			t.Errorf("warm for %+v = %v, want errMirrorWarming, not project a's failure", cred, err)
+		}
+		settle(cred)
+	}
+}
+
+func TestSnapshotCacheSize(t *testing.T) {
+	for _, tc := range []struct {
+		env  string
+		want int64
+	}{
+		{"", 256 << 20},
+		{"64", 64 << 20},
+		{"0", 0},
+		{"-1", 256 << 20},
+		{"lots", 256 << 20},
+	} {
+		t.Setenv("SNAPSHOT_CACHE_MB", tc.env)
+		if got := snapshotCacheSize(); got != tc.want {
+			t.Errorf("SNAPSHOT_CACHE_MB=%q: size %d, want %d", tc.env, got, tc.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"crypto/subtle"
 	"net/http"
 	"os"
 )
@@ -18,3 +19,12 @@ func RequireAPIToken(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// isOperator reports whether the request carries OPERATOR_TOKEN in
+// X-Operator-Token. The API token is shared with every client, so requests
+// that expose task secrets need this one too; unset, nobody is an operator.
+func isOperator(r *http.Request) bool {
+	want := os.Getenv("OPERATOR_TOKEN")
+	got := r.Header.Get("X-Operator-Token")
+	return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
@@ -2,8 +2,13 @@ package http
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"database/sql"
 
@@ -12,21 +17,35 @@ import (
 	"github.com/google/uuid"
 	"github.com/hibiken/asynq"
 	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
 
 	"datacurve-takehome/internal/auth"
 	"datacurve-takehome/internal/db"
+	"datacurve-takehome/internal/gitmirror"
+	"datacurve-takehome/internal/qa"
 	"datacurve-takehome/internal/schemas"
+	"datacurve-takehome/internal/snapshot"
 	"datacurve-takehome/internal/storage"
+	"datacurve-takehome/internal/traces"
+	"datacurve-takehome/internal/vault"
 )
 
 type Server struct {
-	DB    *sqlx.DB
-	S3    *storage.Client
-	Asynq *asynq.Client
+	DB     *sqlx.DB
+	S3     *storage.Client
+	Asynq  *asynq.Client
+	Redis  *redis.Client
+	Mirror *gitmirror.Mirror
+	Vault  *vault.Vault
+
+	// snapshots and warming serve the /files endpoints; see replayInputs.
+	snapshots *snapshot.Cache
+	warming   sync.Map // mirror path -> *mirrorWarm
 }
 
-func NewServer(dbx *sqlx.DB, s3c *storage.Client, asq *asynq.Client) *http.Server {
-	s := &Server{DB: dbx, S3: s3c, Asynq: asq}
+func NewServer(dbx *sqlx.DB, s3c *storage.Client, asq *asynq.Client, rdb *redis.Client, mirror *gitmirror.Mirror, v *vault.Vault) *http.Server {
+	s := &Server{DB: dbx, S3: s3c, Asynq: asq, Redis: rdb, Mirror: mirror, Vault: v,
+		snapshots: &snapshot.Cache{MaxBytes: snapshotCacheSize()}}
 	r := chi.NewRouter()
 	r.Use(m.RequestID, m.RealIP, m.Logger, m.Recoverer)
 
@@ -34,9 +53,23 @@ func NewServer(dbx *sqlx.DB, s3c *storage.Client, asq *asynq.Client) *http.Serve
 	r.Group(func(r chi.Router) {
 		r.Use(RequireAPIToken)
 		r.Post("/traces", s.createTrace)
+		r.Get("/traces", s.listTraces)
+		r.Post("/traces/import", s.importTrace)
 		r.Post("/traces/{id}/finalize", s.finalize)
 		r.Post("/traces/{id}/qa", s.runQA)
 		r.Get("/traces/{id}", s.getTrace)
+		r.Get("/traces/{id}/events", s.getEvents)
+		r.Get("/traces/{id}/export", s.exportTrace)
+		r.Get("/traces/{id}/stream", s.streamTrace)
+		r.Get("/traces/{id}/files", s.getFile)
+		r.Get("/traces/{id}/files/touched", s.touchedFiles)
+		r.Post("/repos/prefetch", s.prefetchRepo)
+		r.Put("/projects/{project}/credentials", s.putCredential)
+		r.Get("/projects/{project}/credentials", s.getCredential)
+		r.Delete("/projects/{project}/credentials", s.deleteCredential)
+		r.Post("/snapshots", s.uploadSnapshot)
+		r.Post("/datasets/exports", s.createDatasetExport)
+		r.Get("/datasets/exports/{id}", s.getDatasetExport)
 	})
 
 	// Upload token (uses Authorization: Bearer <upload>)
@@ -58,16 +91,6 @@ func NewServer(dbx *sqlx.DB, s3c *storage.Client, asq *asynq.Client) *http.Serve
 	return &http.Server{Addr: ":8000", Handler: r}
 }
 
-type createResp struct {
-	TraceID     string `json:"trace_id"`
-	UploadToken string `json:"upload_token"`
-}
-
-type acceptedResp struct {
-	Accepted int   `json:"accepted"`
-	NextSeq  int64 `json:"next_seq"`
-}
-
 type errResp struct {
 	Error string `json:"error"`
 }
@@ -97,7 +120,7 @@ func (s *Server) createTrace(w http.ResponseWriter, r *http.Request) {
 	}
 	fmt.Println("Created trace:", id)
 	fmt.Println("Upload token:", upload)
-	writeJSON(w, 200, createResp{TraceID: id, UploadToken: upload})
+	writeJSON(w, 200, schemas.CreateTraceResponse{TraceID: id, UploadToken: upload})
 }
 
 func (s *Server) appendEvents(w http.ResponseWriter, r *http.Request) {
@@ -109,8 +132,22 @@ func (s *Server) appendEvents(w http.ResponseWriter, r *http.Request) {
 	}
 	upload := got[7:]
 
-	var cnt int
-	if err := s.DB.Get(&cnt, `select count(1) from traces where id=$1 and status='open' and upload_token_hash=$2`, id, auth.HashToken(upload)); err != nil || cnt == 0 {
+	var status string
+	if err := s.DB.Get(&status, `select status from traces where id=$1 and upload_token_hash=$2`, id, auth.HashToken(upload)); err != nil {
+		writeJSON(w, 404, errResp{"trace not found or sealed"})
+		return
+	}
+	// A retried upload that already landed gets the original answer back,
+	// even if the trace was sealed in the meantime.
+	key := r.Header.Get("Idempotency-Key")
+	if key != "" {
+		var prev db.EventBatch
+		if err := s.DB.Get(&prev, `select * from event_batches where trace_id=$1 and idempotency_key=$2`, id, key); err == nil {
+			writeJSON(w, 200, schemas.AppendEventsResponse{Accepted: int(prev.EventCount), NextSeq: prev.Seq + 1})
+			return
+		}
+	}
+	if status != "open" {
 		writeJSON(w, 404, errResp{"trace not found or sealed"})
 		return
 	}
@@ -124,22 +161,41 @@ func (s *Server) appendEvents(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, 500, errResp{err.Error()})
 		return
 	}
-	var maxSeq sql.NullInt64
-	_ = s.DB.Get(&maxSeq, `select coalesce(max(seq), -1) from event_batches where trace_id=$1`, id)
-	next := maxSeq.Int64 + 1
 	events := 0
 	if evs, ok := payload["events"].([]any); ok {
 		events = len(evs)
 	}
 
-	_, err = s.DB.Exec(`insert into event_batches(id, trace_id, seq, object_ref, event_count) values($1,$2,$3,$4,$5)`, uuid.NewString(), id, next, ref, events)
+	// Locking the trace row serializes appends, so concurrent uploads get
+	// distinct, gap-free seqs and none lands after the trace is sealed.
+	var next int64
+	err = db.WithTx(r.Context(), s.DB, func(tx *sqlx.Tx) error {
+		if err := tx.Get(&status, `select status from traces where id=$1 for update`, id); err != nil {
+			return err
+		}
+		if status != "open" {
+			return errSealed
+		}
+		if err := tx.Get(&next, `select coalesce(max(seq), -1) + 1 from event_batches where trace_id=$1`, id); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`insert into event_batches(id, trace_id, seq, object_ref, event_count, idempotency_key) values($1,$2,$3,$4,$5,$6)`, uuid.NewString(), id, next, ref, events, sql.NullString{String: key, Valid: key != ""})
+		return err
+	})
+	if errors.Is(err, errSealed) {
+		writeJSON(w, 404, errResp{"trace not found or sealed"})
+		return
+	}
 	if err != nil {
 		writeJSON(w, 500, errResp{err.Error()})
 		return
 	}
-	writeJSON(w, 200, acceptedResp{Accepted: events, NextSeq: next + 1})
+	s.publishBatch(r.Context(), id, next, payload)
+	writeJSON(w, 200, schemas.AppendEventsResponse{Accepted: events, NextSeq: next + 1})
 }
 
+var errSealed = errors.New("trace sealed")
+
 func (s *Server) finalize(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if _, err := s.DB.Exec(`update traces set status='sealed' where id=$1`, id); err != nil {
@@ -149,10 +205,35 @@ func (s *Server) finalize(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, 200, map[string]string{"status": "sealed"})
 }
 
+// qaTaskTimeout replaces Asynq's 30-minute default, which a pipeline with
+// long stage timeouts or a mutation run would outlast. It covers the
+// longest pipeline ParsePipeline accepts, run twice for the baseline, plus
+// half an hour for the clone and the other stages.
+const qaTaskTimeout = 30*time.Minute + 2*qa.MaxPipelineDuration
+
 func (s *Server) runQA(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	task := asynq.NewTask("run_full_qa", []byte(id))
-	if _, err := s.Asynq.Enqueue(task, asynq.MaxRetry(0)); err != nil {
+	if _, err := s.Asynq.Enqueue(task, asynq.MaxRetry(0), asynq.Timeout(qaTaskTimeout)); err != nil {
+		writeJSON(w, 500, errResp{err.Error()})
+		return
+	}
+	writeJSON(w, 200, map[string]string{"enqueued": "ok"})
+}
+
+func (s *Server) prefetchRepo(w http.ResponseWriter, r *http.Request) {
+	var req schemas.PrefetchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, errResp{err.Error()})
+		return
+	}
+	if strings.TrimSpace(req.Repository) == "" {
+		writeJSON(w, 400, errResp{"repository is required"})
+		return
+	}
+	payload, _ := json.Marshal(req)
+	// fetches are idempotent, so a flaky upstream is worth retrying
+	if _, err := s.Asynq.Enqueue(asynq.NewTask(gitmirror.PrefetchTask, payload), asynq.MaxRetry(3)); err != nil {
 		writeJSON(w, 500, errResp{err.Error()})
 		return
 	}
@@ -169,9 +250,11 @@ func (s *Server) getTrace(w http.ResponseWriter, r *http.Request) {
 	var out schemas.TraceOut
 	out.TraceID = t.ID
 	out.CreatedAt = t.CreatedAt
+	out.Status = t.Status
 	out.Version = t.Version
 	_ = json.Unmarshal(t.Developer, &out.Developer)
 	_ = json.Unmarshal(t.Task, &out.Task)
+	traces.RedactTask(out.Task)
 	_ = json.Unmarshal(t.Environment, &out.Environment)
 	if len(t.Artifacts) > 0 {
 		_ = json.Unmarshal(t.Artifacts, &out.Artifacts)
@@ -179,5 +262,125 @@ func (s *Server) getTrace(w http.ResponseWriter, r *http.Request) {
 	if len(t.QA) > 0 {
 		_ = json.Unmarshal(t.QA, &out.QA)
 	}
+	if len(t.Integrity) > 0 {
+		_ = json.Unmarshal(t.Integrity, &out.Integrity)
+	}
+	out.IntegrityStatus = t.IntegrityStatus.String
 	writeJSON(w, 200, out)
 }
+
+type traceSummaryRow struct {
+	ID          string       `db:"id"`
+	CreatedAt   time.Time    `db:"created_at"`
+	Status      string       `db:"status"`
+	Version     string       `db:"version"`
+	Repository  string       `db:"repository"`
+	Description string       `db:"description"`
+	Developer   string       `db:"developer"`
+	EventCount  int64        `db:"event_count"`
+	QAOK        sql.NullBool `db:"qa_ok"`
+}
+
+// listTraces lists traces newest first. Supported filters: status, q (a
+// case-insensitive match on id, task description/repository and developer
+// name/email), repository, since/until (RFC 3339), limit and offset.
+func (s *Server) listTraces(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if v := q.Get("status"); v != "" {
+		where = append(where, "t.status = "+arg(v))
+	}
+	if v := q.Get("repository"); v != "" {
+		where = append(where, "t.task->>'repository' = "+arg(v))
+	}
+	if v := q.Get("q"); v != "" {
+		p := arg("%" + v + "%")
+		where = append(where, fmt.Sprintf(`(t.id ilike %[1]s or t.task->>'description' ilike %[1]s or t.task->>'repository' ilike %[1]s or t.developer->>'name' ilike %[1]s or t.developer->>'email' ilike %[1]s)`, p))
+	}
+	for _, f := range []struct{ name, op string }{{"since", ">="}, {"until", "<"}} {
+		v := q.Get(f.name)
+		if v == "" {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeJSON(w, 400, errResp{"bad " + f.name + ": " + err.Error()})
+			return
+		}
+		where = append(where, "t.created_at "+f.op+" "+arg(ts))
+	}
+	limit, offset := 50, 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 500 {
+			writeJSON(w, 400, errResp{"limit must be 1..500"})
+			return
+		}
+		limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeJSON(w, 400, errResp{"bad offset"})
+			return
+		}
+		offset = n
+	}
+
+	query := `select t.id, t.created_at, t.status, t.version,
+		coalesce(t.task->>'repository', '') as repository,
+		coalesce(t.task->>'description', '') as description,
+		coalesce(t.developer->>'name', '') as developer,
+		(select coalesce(sum(b.event_count), 0) from event_batches b where b.trace_id = t.id) as event_count,
+		(t.qa->'tests'->>'ok')::boolean as qa_ok
+		from traces t`
+	if len(where) > 0 {
+		query += " where " + strings.Join(where, " and ")
+	}
+	query += " order by t.created_at desc limit " + arg(limit) + " offset " + arg(offset)
+
+	var rows []traceSummaryRow
+	if err := s.DB.SelectContext(r.Context(), &rows, query, args...); err != nil {
+		writeJSON(w, 500, errResp{err.Error()})
+		return
+	}
+	out := schemas.ListTracesResponse{Traces: make([]schemas.TraceSummary, 0, len(rows))}
+	for _, row := range rows {
+		ts := schemas.TraceSummary{
+			TraceID:     row.ID,
+			CreatedAt:   row.CreatedAt,
+			Status:      row.Status,
+			Version:     row.Version,
+			Repository:  row.Repository,
+			Description: row.Description,
+			Developer:   row.Developer,
+			EventCount:  row.EventCount,
+		}
+		if row.QAOK.Valid {
+			ok := row.QAOK.Bool
+			ts.QAOK = &ok
+		}
+		out.Traces = append(out.Traces, ts)
+	}
+	writeJSON(w, 200, out)
+}
+
+func (s *Server) getEvents(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var cnt int
+	if err := s.DB.Get(&cnt, `select count(1) from traces where id=$1`, id); err != nil || cnt == 0 {
+		writeJSON(w, 404, errResp{"not found"})
+		return
+	}
+	events, err := traces.LoadEvents(r.Context(), s.DB, s.S3, id)
+	if err != nil {
+		writeJSON(w, 500, errResp{err.Error()})
+		return
+	}
+	writeJSON(w, 200, schemas.EventsResponse{Events: events})
+}
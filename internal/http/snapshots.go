@@ -0,0 +1,43 @@
+package http
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"datacurve-takehome/internal/schemas"
+	"datacurve-takehome/internal/snapshot"
+)
+
+// maxSnapshotSize bounds an uploaded snapshot, which is held in memory.
+const maxSnapshotSize = 512 << 20
+
+// uploadSnapshot stores the request body, a plain or gzip-compressed tar of
+// a repository, under its sha256 so identical uploads share one object.
+func (s *Server) uploadSnapshot(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSnapshotSize))
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, errResp{"snapshot larger than 512 MiB"})
+			return
+		}
+		writeJSON(w, 400, errResp{err.Error()})
+		return
+	}
+	snap, err := snapshot.Load("", b)
+	if err != nil {
+		writeJSON(w, 400, errResp{err.Error()})
+		return
+	}
+	key, ctype := "snapshots/"+snap.ID+".tar", "application/x-tar"
+	if snap.Gzipped() {
+		key, ctype = key+".gz", "application/gzip"
+	}
+	ref, err := s.S3.PutBytes(r.Context(), key, ctype, b)
+	if err != nil {
+		writeJSON(w, 500, errResp{err.Error()})
+		return
+	}
+	writeJSON(w, 200, schemas.SnapshotOut{SnapshotID: snap.ID, Ref: ref, SHA256: snap.ID, Size: len(b), Files: snap.NumFiles()})
+}
@@ -0,0 +1,193 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+
+	"datacurve-takehome/internal/db"
+)
+
+// batchMessage is what gets published on a trace's channel and written
+// out as the data of each SSE event.
+type batchMessage struct {
+	Seq    int64 `json:"seq"`
+	Events []any `json:"events"`
+}
+
+func batchChannel(traceID string) string {
+	return "trace:" + traceID + ":batches"
+}
+
+// publishBatch fans a freshly committed batch out to every API replica
+// that has a stream open for the trace.
+func (s *Server) publishBatch(ctx context.Context, traceID string, seq int64, payload map[string]any) {
+	evs, _ := payload["events"].([]any)
+	b, err := json.Marshal(batchMessage{Seq: seq, Events: evs})
+	if err != nil {
+		log.Printf("publish batch %s/%d: %v", traceID, seq, err)
+		return
+	}
+	if err := s.Redis.Publish(ctx, batchChannel(traceID), b).Err(); err != nil {
+		log.Printf("publish batch %s/%d: %v", traceID, seq, err)
+	}
+}
+
+// streamTrace tails a trace over Server-Sent Events. Each event carries the
+// batch seq as its id, so a client reconnecting with Last-Event-ID gets every
+// batch after that seq replayed before live batches resume.
+func (s *Server) streamTrace(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, 500, errResp{"streaming unsupported"})
+		return
+	}
+	var cnt int
+	if err := s.DB.Get(&cnt, `select count(1) from traces where id=$1`, id); err != nil || cnt == 0 {
+		writeJSON(w, 404, errResp{"not found"})
+		return
+	}
+
+	lastSeq := int64(-1)
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	if lastID != "" {
+		n, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil {
+			writeJSON(w, 400, errResp{"bad Last-Event-ID"})
+			return
+		}
+		lastSeq = n
+	}
+
+	ctx := r.Context()
+
+	// Subscribe before replaying so nothing committed in between is lost;
+	// anything seen twice is dropped by the seq check in tailBatches.
+	sub := s.Redis.Subscribe(ctx, batchChannel(id))
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		writeJSON(w, 500, errResp{err.Error()})
+		return
+	}
+	live := sub.Channel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	replay := func(ctx context.Context, after int64) ([]batchMessage, error) {
+		return s.batchesAfter(ctx, id, after)
+	}
+	if err := tailBatches(ctx, w, flusher.Flush, lastSeq, replay, live, 15*time.Second); err != nil {
+		log.Printf("stream %s: %v", id, err)
+	}
+}
+
+// batchesAfter loads a trace's committed batches with seq > after, in order.
+func (s *Server) batchesAfter(ctx context.Context, traceID string, after int64) ([]batchMessage, error) {
+	var batches []db.EventBatch
+	if err := s.DB.SelectContext(ctx, &batches, `select * from event_batches where trace_id=$1 and seq>$2 order by seq`, traceID, after); err != nil {
+		return nil, fmt.Errorf("list batches: %w", err)
+	}
+	out := make([]batchMessage, 0, len(batches))
+	for _, b := range batches {
+		doc, err := s.S3.GetJSON(ctx, b.ObjectRef)
+		if err != nil {
+			return nil, fmt.Errorf("fetch batch %d: %w", b.Seq, err)
+		}
+		evs, _ := doc["events"].([]any)
+		out = append(out, batchMessage{Seq: b.Seq, Events: evs})
+	}
+	return out, nil
+}
+
+// tailBatches writes the batches replay returns after lastSeq, then live
+// ones as they arrive. Seqs are assigned without gaps, so a live batch that
+// skips one means an earlier publish is late or was lost: the missing
+// batches are replayed from the database rather than skipped. It returns
+// nil when the client or the subscription goes away.
+func tailBatches(ctx context.Context, w io.Writer, flush func(), lastSeq int64,
+	replay func(context.Context, int64) ([]batchMessage, error), live <-chan *redis.Message, heartbeat time.Duration) error {
+	// write and catchUp return false once the client is gone.
+	write := func(bm batchMessage) bool {
+		if bm.Seq <= lastSeq {
+			return true
+		}
+		if err := writeSSE(w, bm); err != nil {
+			return false
+		}
+		lastSeq = bm.Seq
+		flush()
+		return true
+	}
+	catchUp := func() (bool, error) {
+		batches, err := replay(ctx, lastSeq)
+		if err != nil {
+			return false, err
+		}
+		for _, bm := range batches {
+			if !write(bm) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	if ok, err := catchUp(); !ok {
+		return err
+	}
+	tick := time.NewTicker(heartbeat)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return nil
+			}
+			flush()
+		case msg, ok := <-live:
+			if !ok {
+				return nil
+			}
+			var bm batchMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &bm); err != nil {
+				log.Printf("stream: bad message on %s: %v", msg.Channel, err)
+				continue
+			}
+			if bm.Seq > lastSeq+1 {
+				if ok, err := catchUp(); !ok {
+					return err
+				}
+			}
+			if !write(bm) {
+				return nil
+			}
+		}
+	}
+}
+
+func writeSSE(w io.Writer, bm batchMessage) error {
+	b, err := json.Marshal(bm)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: batch\ndata: %s\n\n", bm.Seq, b)
+	return err
+}
@@ -0,0 +1,202 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis speaks just enough RESP2 for PUBLISH and SUBSCRIBE.
+type fakeRedis struct {
+	mu   sync.Mutex
+	subs map[string][]net.Conn
+}
+
+func newFakeRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeRedis{subs: map[string][]net.Conn{}}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	rdb := redis.NewClient(&redis.Options{Addr: ln.Addr().String(), Protocol: 2, DisableIndentity: true})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+func (f *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "HELLO":
+			fmt.Fprint(c, "-ERR unknown command\r\n")
+		case "SUBSCRIBE":
+			for i, ch := range args[1:] {
+				f.subs[ch] = append(f.subs[ch], c)
+				fmt.Fprintf(c, "*3\r\n$9\r\nsubscribe\r\n%s:%d\r\n", bulk(ch), i+1)
+			}
+		case "PUBLISH":
+			for _, sc := range f.subs[args[1]] {
+				fmt.Fprintf(sc, "*3\r\n$7\r\nmessage\r\n%s%s", bulk(args[1]), bulk(args[2]))
+			}
+			fmt.Fprintf(c, ":%d\r\n", len(f.subs[args[1]]))
+		default:
+			fmt.Fprint(c, "+OK\r\n")
+		}
+		f.mu.Unlock()
+	}
+}
+
+func bulk(s string) string { return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s) }
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func TestPublishBatch(t *testing.T) {
+	rdb := newFakeRedis(t)
+	ctx := context.Background()
+	sub := rdb.Subscribe(ctx, batchChannel("t1"))
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{Redis: rdb}
+	s.publishBatch(ctx, "t1", 7, map[string]any{"events": []any{map[string]any{"type": "edit"}}, "other": 1})
+
+	select {
+	case msg := <-sub.Channel():
+		if msg.Channel != "trace:t1:batches" {
+			t.Errorf("channel = %q", msg.Channel)
+		}
+		var bm batchMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &bm); err != nil {
+			t.Fatal(err)
+		}
+		want := batchMessage{Seq: 7, Events: []any{map[string]any{"type": "edit"}}}
+		if !reflect.DeepEqual(bm, want) {
+			t.Errorf("message = %+v, want %+v", bm, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message published")
+	}
+}
+
+var sseID = regexp.MustCompile(`(?m)^id: (\d+)$`)
+
+func TestTailBatches(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		lastSeq int64
+		// committed is every batch in the database; visible is how many of
+		// them the first replay sees, the rest commit before live arrives.
+		committed int64
+		visible   int64
+		live      []int64
+		want      string
+	}{
+		{name: "replay all", lastSeq: -1, committed: 3, visible: 3, want: "0 1 2"},
+		{name: "Last-Event-ID", lastSeq: 1, committed: 4, visible: 4, want: "2 3"},
+		{name: "Last-Event-ID at the end", lastSeq: 3, committed: 4, visible: 4, live: []int64{4}, want: "4"},
+		{name: "live after replay", lastSeq: -1, committed: 2, visible: 2, live: []int64{2, 3}, want: "0 1 2 3"},
+		{name: "duplicates dropped", lastSeq: -1, committed: 3, visible: 3, live: []int64{1, 2, 3}, want: "0 1 2 3"},
+		// seq 2's publish overtook seq 1's: 1 is replayed, not lost
+		{name: "gap replayed", lastSeq: -1, committed: 3, visible: 1, live: []int64{2, 1}, want: "0 1 2"},
+		{name: "gap past the database", lastSeq: 0, committed: 1, visible: 1, live: []int64{3}, want: "3"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			replay := func(_ context.Context, after int64) ([]batchMessage, error) {
+				n := tc.committed
+				if calls == 0 {
+					n = tc.visible
+				}
+				calls++
+				var out []batchMessage
+				for seq := after + 1; seq < n; seq++ {
+					out = append(out, batchMessage{Seq: seq, Events: []any{}})
+				}
+				return out, nil
+			}
+			live := make(chan *redis.Message, len(tc.live))
+			for _, seq := range tc.live {
+				b, _ := json.Marshal(batchMessage{Seq: seq, Events: []any{}})
+				live <- &redis.Message{Channel: batchChannel("t1"), Payload: string(b)}
+			}
+			close(live)
+
+			var out strings.Builder
+			if err := tailBatches(context.Background(), &out, func() {}, tc.lastSeq, replay, live, time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, m := range sseID.FindAllStringSubmatch(out.String(), -1) {
+				ids = append(ids, m[1])
+			}
+			if got := strings.Join(ids, " "); got != tc.want {
+				t.Errorf("ids = %q, want %q\n%s", got, tc.want, out.String())
+			}
+		})
+	}
+}
+
+func TestTailBatchesReplayError(t *testing.T) {
+	replay := func(context.Context, int64) ([]batchMessage, error) { return nil, fmt.Errorf("db down") }
+	live := make(chan *redis.Message)
+	close(live)
+	if err := tailBatches(context.Background(), &strings.Builder{}, func() {}, -1, replay, live, time.Hour); err == nil {
+		t.Error("replay error not returned")
+	}
+}
@@ -0,0 +1,220 @@
+// Package integrity checks the file hashes a client reports in edit and
+// file_opened events against each other and against the repository.
+package integrity
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"datacurve-takehome/internal/patch"
+	"datacurve-takehome/internal/schemas"
+)
+
+const (
+	StatusOK         = "ok"
+	StatusBroken     = "broken"
+	StatusUnverified = "unverified" // no event carried a hash
+)
+
+// Issue kinds.
+const (
+	// KindBase: a file's first before_hash is not the blob at the task commit.
+	KindBase = "base"
+	// KindChain: a before_hash is not the previous edit's after_hash.
+	KindChain = "chain"
+	// KindBefore: a before_hash does not match the content replayed so far,
+	// after an edit that reported no after_hash or one of another algorithm.
+	KindBefore = "before"
+	// KindAfter: an after_hash does not match the content the edit produced.
+	KindAfter = "after"
+	// KindFileOpened: a file_opened file_hash does not match the content at
+	// that point in the session.
+	KindFileOpened = "file_opened"
+)
+
+type Issue struct {
+	Event    int    `json:"event"`
+	FilePath string `json:"file_path"`
+	Kind     string `json:"kind"`
+	Expected string `json:"expected"`
+	Got      string `json:"got"`
+}
+
+type Report struct {
+	Status string  `json:"status"`
+	Checks int     `json:"checks"`
+	Issues []Issue `json:"issues"`
+}
+
+type item struct {
+	t     time.Time
+	index int
+	edit  *patch.Edit
+	open  *schemas.FileOpened
+}
+
+// Verify replays the trace's edits over src (the task commit) and checks
+// every reported hash along the way. Hashes may be git blob ids (what
+// `git hash-object` prints, optionally "sha1:"-prefixed) or SHA-256 of the
+// raw content ("sha256:"-prefixed or 64 hex digits).
+func Verify(ctx context.Context, events []map[string]any, src patch.Source) (*Report, error) {
+	items := order(events)
+	w := patch.NewWorkspace(src)
+	rep := &Report{Issues: []Issue{}}
+	// last holds the after_hash reported by the previous edit of each file;
+	// a present but empty entry means that edit reported none.
+	last := map[string]string{}
+
+	check := func(index int, path, kind, reported string) error {
+		content, ok, err := w.File(ctx, path)
+		if err != nil {
+			return err
+		}
+		rep.Checks++
+		if !matches(reported, content, ok) {
+			rep.Issues = append(rep.Issues, Issue{Event: index, FilePath: path, Kind: kind, Expected: hashLike(reported, content, ok), Got: reported})
+		}
+		return nil
+	}
+
+	for _, it := range items {
+		if it.open != nil {
+			if err := check(it.index, it.open.FilePath, KindFileOpened, it.open.FileHash); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		e := *it.edit
+		if e.BeforeHash != "" {
+			prev, seen := last[e.FilePath]
+			switch {
+			case !seen:
+				if err := check(e.Index, e.FilePath, KindBase, e.BeforeHash); err != nil {
+					return nil, err
+				}
+			case prev != "" && sameAlgorithm(prev, e.BeforeHash):
+				rep.Checks++
+				if hashDigest(prev) != hashDigest(e.BeforeHash) {
+					rep.Issues = append(rep.Issues, Issue{Event: e.Index, FilePath: e.FilePath, Kind: KindChain, Expected: prev, Got: e.BeforeHash})
+				}
+			default:
+				// no after_hash to chain to, or one of the other algorithm:
+				// check against the content itself
+				if err := check(e.Index, e.FilePath, KindBefore, e.BeforeHash); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := w.Apply(ctx, e); err != nil {
+			return nil, err
+		}
+		target := e.FilePath
+		if e.Op == "rename" && e.NewPath != "" {
+			target = e.NewPath
+		}
+		if e.AfterHash != "" {
+			if err := check(e.Index, target, KindAfter, e.AfterHash); err != nil {
+				return nil, err
+			}
+		}
+		last[target] = e.AfterHash
+	}
+
+	switch {
+	case len(rep.Issues) > 0:
+		rep.Status = StatusBroken
+	case rep.Checks == 0:
+		rep.Status = StatusUnverified
+	default:
+		rep.Status = StatusOK
+	}
+	return rep, nil
+}
+
+// order merges edit and file_opened events (those carrying a hash) in
+// timestamp order, keeping upload order for ties.
+func order(events []map[string]any) []item {
+	edits := patch.EditsFromEvents(events)
+	items := make([]item, 0, len(edits))
+	for i := range edits {
+		items = append(items, item{t: edits[i].T, index: edits[i].Index, edit: &edits[i]})
+	}
+	for i, e := range events {
+		if e["type"] != "file_opened" {
+			continue
+		}
+		b, _ := json.Marshal(e)
+		var fo schemas.FileOpened
+		if err := json.Unmarshal(b, &fo); err != nil || fo.FileHash == "" || fo.FilePath == "" {
+			continue
+		}
+		items = append(items, item{t: fo.T, index: i, open: &fo})
+	}
+	// Same rule as for edits: an event without a timestamp keeps the time
+	// of the event before it.
+	sort.Slice(items, func(i, j int) bool { return items[i].index < items[j].index })
+	for i := 1; i < len(items); i++ {
+		if items[i].t.IsZero() {
+			items[i].t = items[i-1].t
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].t.Before(items[j].t) })
+	return items
+}
+
+func normalize(h string) string {
+	h = strings.ToLower(strings.TrimSpace(h))
+	h = strings.TrimPrefix(h, "sha1:")
+	return h
+}
+
+// sameAlgorithm reports whether a and b are both git blob ids or both
+// SHA-256 digests, so their digests can be compared directly.
+func sameAlgorithm(a, b string) bool {
+	_, sa := isSHA256(normalize(a))
+	_, sb := isSHA256(normalize(b))
+	return sa == sb
+}
+
+// hashDigest is h without its algorithm prefix.
+func hashDigest(h string) string {
+	d, _ := isSHA256(normalize(h))
+	return d
+}
+
+func isSHA256(h string) (string, bool) {
+	if rest, ok := strings.CutPrefix(h, "sha256:"); ok {
+		return rest, true
+	}
+	return h, len(h) == 64
+}
+
+func matches(reported, content string, exists bool) bool {
+	if !exists {
+		return false
+	}
+	h := normalize(reported)
+	if digest, ok := isSHA256(h); ok {
+		sum := sha256.Sum256([]byte(content))
+		return digest == hex.EncodeToString(sum[:])
+	}
+	return h == patch.BlobHash(content)
+}
+
+// hashLike renders the hash of content in the same form as reported, for
+// the Expected side of an issue.
+func hashLike(reported, content string, exists bool) string {
+	if !exists {
+		return "(missing)"
+	}
+	if _, ok := isSHA256(normalize(reported)); ok {
+		sum := sha256.Sum256([]byte(content))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return patch.BlobHash(content)
+}
@@ -0,0 +1,104 @@
+package integrity
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"datacurve-takehome/internal/patch"
+)
+
+type mapSource map[string]string
+
+func (m mapSource) ReadFile(_ context.Context, p string) (string, bool, error) {
+	c, ok := m[p]
+	return c, ok, nil
+}
+
+func TestVerify(t *testing.T) {
+	const (
+		v0 = "package calc\n\nfunc Add(a, b int) int { return a - b }\n"
+		v1 = "package calc\n\nfunc Add(a, b int) int { return a + b }\n"
+		v2 = "package calc\n\n// Add sums.\nfunc Add(a, b int) int { return a + b }\n"
+	)
+	src := mapSource{"calc.go": v0}
+	fix := "--- a/calc.go\n+++ b/calc.go\n@@ -3 +3 @@\n-func Add(a, b int) int { return a - b }\n+func Add(a, b int) int { return a + b }\n"
+	doc := "--- a/calc.go\n+++ b/calc.go\n@@ -2,0 +3 @@\n+// Add sums.\n"
+	edit := func(t, p, before, after string) map[string]any {
+		return map[string]any{"type": "edit", "t": t, "op": "modify", "file_path": "calc.go",
+			"patch_unified": p, "before_hash": before, "after_hash": after}
+	}
+	h := patch.BlobHash
+	sha := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	for _, tc := range []struct {
+		name   string
+		events []map[string]any
+		status string
+		kinds  []string
+	}{
+		{"no hashes", []map[string]any{edit("2024-01-15T10:00:00Z", fix, "", "")}, StatusUnverified, nil},
+		{"chain", []map[string]any{
+			edit("2024-01-15T10:00:00Z", fix, h(v0), h(v1)),
+			edit("2024-01-15T10:00:01Z", doc, h(v1), h(v2)),
+		}, StatusOK, nil},
+		{"sha256 and sha1: forms", []map[string]any{
+			edit("2024-01-15T10:00:00Z", fix, sha(v0), "sha1:"+h(v1)),
+		}, StatusOK, nil},
+		{"mixed algorithms in the chain", []map[string]any{
+			edit("2024-01-15T10:00:00Z", fix, h(v0), h(v1)),
+			edit("2024-01-15T10:00:01Z", doc, sha(v1), "sha1:"+h(v2)),
+		}, StatusOK, nil},
+		{"sha256 with and without prefix", []map[string]any{
+			edit("2024-01-15T10:00:00Z", fix, sha(v0), strings.TrimPrefix(sha(v1), "sha256:")),
+			edit("2024-01-15T10:00:01Z", doc, sha(v1), ""),
+		}, StatusOK, nil},
+		{"mixed algorithms, wrong before", []map[string]any{
+			edit("2024-01-15T10:00:00Z", fix, h(v0), h(v1)),
+			edit("2024-01-15T10:00:01Z", doc, sha(v0), ""),
+		}, StatusBroken, []string{KindBefore}},
+		{"base differs from the commit", []map[string]any{
+			edit("2024-01-15T10:00:00Z", fix, h(v1), ""),
+		}, StatusBroken, []string{KindBase}},
+		{"chain broken", []map[string]any{
+			edit("2024-01-15T10:00:00Z", fix, h(v0), h(v1)),
+			edit("2024-01-15T10:00:01Z", doc, h(v0), ""),
+		}, StatusBroken, []string{KindChain}},
+		{"before checked against the replay", []map[string]any{
+			edit("2024-01-15T10:00:00Z", fix, h(v0), ""),
+			edit("2024-01-15T10:00:01Z", doc, h(v0), ""),
+		}, StatusBroken, []string{KindBefore}},
+		{"after differs from the replay", []map[string]any{
+			edit("2024-01-15T10:00:00Z", fix, h(v0), h(v2)),
+		}, StatusBroken, []string{KindAfter}},
+		{"file_opened", []map[string]any{
+			{"type": "file_opened", "t": "2024-01-15T10:00:00Z", "file_path": "calc.go", "file_hash": h(v0)},
+			edit("2024-01-15T10:00:01Z", fix, "", ""),
+			{"type": "file_opened", "t": "2024-01-15T10:00:02Z", "file_path": "calc.go", "file_hash": h(v0)},
+		}, StatusBroken, []string{KindFileOpened}},
+		{"opened file missing", []map[string]any{
+			{"type": "file_opened", "t": "2024-01-15T10:00:00Z", "file_path": "gone.go", "file_hash": h("")},
+		}, StatusBroken, []string{KindFileOpened}},
+	} {
+		rep, err := Verify(context.Background(), tc.events, src)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if rep.Status != tc.status {
+			t.Errorf("%s: status %q, want %q (issues %+v)", tc.name, rep.Status, tc.status, rep.Issues)
+		}
+		if len(rep.Issues) != len(tc.kinds) {
+			t.Errorf("%s: issues %+v, want kinds %v", tc.name, rep.Issues, tc.kinds)
+			continue
+		}
+		for i, k := range tc.kinds {
+			if rep.Issues[i].Kind != k {
+				t.Errorf("%s: issue %d is %q, want %q", tc.name, i, rep.Issues[i].Kind, k)
+			}
+		}
+	}
+}
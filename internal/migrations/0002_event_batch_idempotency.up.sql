@@ -0,0 +1,5 @@
+alter table event_batches add column if not exists idempotency_key text;
+
+create unique index if not exists idx_event_batches_trace_idem
+  on event_batches(trace_id, idempotency_key)
+  where idempotency_key is not null;
@@ -0,0 +1,14 @@
+create table if not exists dataset_exports (
+  id text primary key,
+  created_at timestamptz default now(),
+  updated_at timestamptz default now(),
+  status text not null default 'queued',
+  query jsonb not null,
+  formats jsonb not null,
+  shard_size int not null,
+  prefix text not null,
+  rows_written bigint not null default 0,
+  shards_written int not null default 0,
+  manifest_ref text,
+  error text
+);
@@ -0,0 +1,4 @@
+alter table traces add column if not exists integrity jsonb;
+alter table traces add column if not exists integrity_status text;
+
+create index if not exists idx_traces_integrity_status on traces(integrity_status);
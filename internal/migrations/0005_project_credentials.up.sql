@@ -0,0 +1,10 @@
+-- git credentials per project; ciphertext is AES-256-GCM (nonce || sealed
+-- JSON) under CREDENTIALS_KEY, with the project name as associated data
+create table if not exists project_credentials (
+  project text primary key,
+  kind text not null,
+  username text not null default '',
+  ciphertext bytea not null,
+  created_at timestamptz default now(),
+  updated_at timestamptz default now()
+);
@@ -0,0 +1,3 @@
+-- the repositories a credential may be used for; credentials stored before
+-- this column existed have none and must be stored again
+alter table project_credentials add column if not exists url_prefix text not null default '';
@@ -0,0 +1,139 @@
+package patch
+
+import "strings"
+
+// HunkFailure describes a hunk that could not be placed in the file.
+type HunkFailure struct {
+	Hunk   string `json:"hunk"`
+	Reason string `json:"reason"`
+}
+
+// splitLines breaks content into lines without their "\n". noEOL reports
+// whether the last line lacks a trailing newline.
+func splitLines(s string) (lines []string, noEOL bool) {
+	if s == "" {
+		return nil, false
+	}
+	lines = strings.Split(s, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1], false
+	}
+	return lines, true
+}
+
+func joinLines(lines []string, noEOL bool) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	s := strings.Join(lines, "\n")
+	if !noEOL {
+		s += "\n"
+	}
+	return s
+}
+
+// ApplyHunks applies hunks to content in order. A hunk whose old side is not
+// found where its header says is searched for nearby, first exactly and then
+// ignoring trailing whitespace; hunks that cannot be placed are skipped and
+// reported, and the rest still apply.
+func ApplyHunks(content string, hunks []Hunk) (string, []HunkFailure) {
+	lines, noEOL := splitLines(content)
+	out := make([]string, 0, len(lines))
+	var failures []HunkFailure
+	pos, offset := 0, 0
+
+	for _, h := range hunks {
+		var old []string
+		for _, l := range h.Lines {
+			if l.Op != '+' {
+				old = append(old, l.Text)
+			}
+		}
+		want := h.OldStart - 1
+		if h.OldLines == 0 {
+			// Pure insertions name the line they follow.
+			want = h.OldStart
+		}
+		want += offset
+
+		at := find(lines, old, want, pos, equalExact)
+		if at < 0 {
+			at = find(lines, old, want, pos, equalTrimRight)
+		}
+		if at < 0 {
+			failures = append(failures, HunkFailure{Hunk: h.Header(), Reason: "context not found"})
+			continue
+		}
+		out = append(out, lines[pos:at]...)
+		// context lines keep the file's text, which may differ in trailing
+		// whitespace from the hunk's
+		k := at
+		for _, l := range h.Lines {
+			switch l.Op {
+			case '+':
+				out = append(out, l.Text)
+			case '-':
+				k++
+			default:
+				out = append(out, lines[k])
+				k++
+			}
+		}
+		pos = at + len(old)
+		offset = at - want + offset
+
+		if pos == len(lines) {
+			noEOL = lastNewSideNoEOL(h.Lines, noEOL)
+		}
+	}
+	out = append(out, lines[pos:]...)
+	return joinLines(out, noEOL), failures
+}
+
+// lastNewSideNoEOL reports whether a hunk that reaches the end of the file
+// leaves it without a trailing newline.
+func lastNewSideNoEOL(lines []Line, cur bool) bool {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if lines[i].Op != '-' {
+			return lines[i].NoEOL
+		}
+	}
+	return cur
+}
+
+// find returns the index in lines[from:] closest to want where old matches,
+// or -1.
+func find(lines, old []string, want, from int, eq func(a, b string) bool) int {
+	last := len(lines) - len(old)
+	if last < from {
+		return -1
+	}
+	want = max(from, want)
+	for d := 0; ; d++ {
+		lo, hi := want-d, want+d
+		if lo < from && hi > last {
+			return -1
+		}
+		if hi <= last && matchAt(lines, old, hi, eq) {
+			return hi
+		}
+		if d > 0 && lo >= from && lo <= last && matchAt(lines, old, lo, eq) {
+			return lo
+		}
+	}
+}
+
+func matchAt(lines, old []string, at int, eq func(a, b string) bool) bool {
+	for i, o := range old {
+		if !eq(lines[at+i], o) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalExact(a, b string) bool { return a == b }
+
+func equalTrimRight(a, b string) bool {
+	return strings.TrimRight(a, " \t\r") == strings.TrimRight(b, " \t\r")
+}
@@ -0,0 +1,92 @@
+package patch
+
+import (
+	"strings"
+	"testing"
+)
+
+func hunksOf(t *testing.T, diff string) []Hunk {
+	t.Helper()
+	diffs, err := Parse(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 {
+		t.Fatalf("got %d file diffs, want 1", len(diffs))
+	}
+	return diffs[0].Hunks
+}
+
+func TestApplyHunks(t *testing.T) {
+	const base = "a\nb\nc\nd\ne\n"
+	for _, tc := range []struct {
+		name     string
+		content  string
+		diff     string
+		want     string
+		failures int
+	}{
+		{
+			name:    "exact",
+			content: base,
+			diff:    "--- a/f\n+++ b/f\n@@ -2,3 +2,3 @@\n b\n-c\n+C\n d\n",
+			want:    "a\nb\nC\nd\ne\n",
+		},
+		{
+			name:    "drifted down",
+			content: "x\ny\n" + base,
+			diff:    "--- a/f\n+++ b/f\n@@ -2,3 +2,3 @@\n b\n-c\n+C\n d\n",
+			want:    "x\ny\na\nb\nC\nd\ne\n",
+		},
+		{
+			name:    "trailing whitespace",
+			content: "a\nb  \nc\nd\t\ne\n",
+			diff:    "--- a/f\n+++ b/f\n@@ -2,3 +2,3 @@\n b\n-c\n+C\n d\n",
+			want:    "a\nb  \nC\nd\t\ne\n",
+		},
+		{
+			name:    "pure insertion",
+			content: base,
+			diff:    "--- a/f\n+++ b/f\n@@ -2,0 +3,1 @@\n+b2\n",
+			want:    "a\nb\nb2\nc\nd\ne\n",
+		},
+		{
+			name:     "one hunk missing",
+			content:  base,
+			diff:     "--- a/f\n+++ b/f\n@@ -1,1 +1,1 @@\n-a\n+A\n@@ -3,1 +3,1 @@\n-zzz\n+Z\n@@ -5,1 +5,1 @@\n-e\n+E\n",
+			want:     "A\nb\nc\nd\nE\n",
+			failures: 1,
+		},
+		{
+			name:    "drop final newline",
+			content: base,
+			diff:    "--- a/f\n+++ b/f\n@@ -5,1 +5,1 @@\n-e\n+e\n\\ No newline at end of file\n",
+			want:    "a\nb\nc\nd\ne",
+		},
+		{
+			name:    "add final newline",
+			content: "a\nb",
+			diff:    "--- a/f\n+++ b/f\n@@ -2,1 +2,1 @@\n-b\n\\ No newline at end of file\n+b\n",
+			want:    "a\nb\n",
+		},
+		{
+			name:    "new file",
+			content: "",
+			diff:    "--- /dev/null\n+++ b/f\n@@ -0,0 +1,2 @@\n+one\n+two\n",
+			want:    "one\ntwo\n",
+		},
+	} {
+		got, failed := ApplyHunks(tc.content, hunksOf(t, tc.diff))
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+		if len(failed) != tc.failures {
+			t.Errorf("%s: %d failures %v, want %d", tc.name, len(failed), failed, tc.failures)
+		}
+		for _, f := range failed {
+			if !strings.HasPrefix(f.Hunk, "@@ ") || f.Reason == "" {
+				t.Errorf("%s: failure %+v lacks its hunk or reason", tc.name, f)
+			}
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package patch
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Line is one line of a hunk. Op is ' ' (context), '-' (removed) or '+'
+// (added). NoEOL marks a line followed by "\ No newline at end of file".
+type Line struct {
+	Op    byte
+	Text  string
+	NoEOL bool
+}
+
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Section            string
+	Lines              []Line
+}
+
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@%s", h.OldStart, h.OldLines, h.NewStart, h.NewLines, h.Section)
+}
+
+// FileDiff is the part of a unified diff that concerns one file. Paths have
+// their a/ and b/ prefixes removed; OldPath is empty for a created file and
+// NewPath is empty for a deleted one.
+type FileDiff struct {
+	OldPath string
+	NewPath string
+	Hunks   []Hunk
+}
+
+func (f *FileDiff) Created() bool { return f.OldPath == "" }
+func (f *FileDiff) Deleted() bool { return f.NewPath == "" }
+func (f *FileDiff) Renamed() bool {
+	return f.OldPath != "" && f.NewPath != "" && f.OldPath != f.NewPath
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@(.*)$`)
+
+// Parse splits a unified diff (plain or git-style) into per-file diffs.
+func Parse(text string) ([]*FileDiff, error) {
+	lines := strings.Split(text, "\n")
+	if n := len(lines); n > 0 && lines[n-1] == "" {
+		lines = lines[:n-1]
+	}
+	var out []*FileDiff
+	var cur *FileDiff
+	// sawPaths is set once a ---/+++ pair named cur's paths.
+	sawPaths := false
+	start := func() {
+		cur = &FileDiff{}
+		out = append(out, cur)
+		sawPaths = false
+	}
+
+	for i := 0; i < len(lines); i++ {
+		l := lines[i]
+		switch {
+		case strings.HasPrefix(l, "diff --git "):
+			start()
+			if a, b, ok := gitHeaderPaths(strings.TrimPrefix(l, "diff --git ")); ok {
+				cur.OldPath, cur.NewPath = a, b
+			}
+		case cur != nil && len(cur.Hunks) == 0 && strings.HasPrefix(l, "new file mode"):
+			cur.OldPath = ""
+		case cur != nil && len(cur.Hunks) == 0 && strings.HasPrefix(l, "deleted file mode"):
+			cur.NewPath = ""
+		case cur != nil && len(cur.Hunks) == 0 && strings.HasPrefix(l, "rename from "):
+			cur.OldPath = strings.TrimPrefix(l, "rename from ")
+		case cur != nil && len(cur.Hunks) == 0 && strings.HasPrefix(l, "rename to "):
+			cur.NewPath = strings.TrimPrefix(l, "rename to ")
+		case strings.HasPrefix(l, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			if cur == nil || len(cur.Hunks) > 0 || sawPaths {
+				start()
+			}
+			cur.OldPath = diffPath(strings.TrimPrefix(l, "--- "), "a/")
+			cur.NewPath = diffPath(strings.TrimPrefix(lines[i+1], "+++ "), "b/")
+			sawPaths = true
+			i++
+		case strings.HasPrefix(l, "@@ "):
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: hunk before any file header", i+1)
+			}
+			h, next, err := parseHunk(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			cur.Hunks = append(cur.Hunks, h)
+			i = next - 1
+		}
+	}
+	return out, nil
+}
+
+func parseHunk(lines []string, i int) (Hunk, int, error) {
+	m := hunkHeader.FindStringSubmatch(lines[i])
+	if m == nil {
+		return Hunk{}, 0, fmt.Errorf("line %d: bad hunk header %q", i+1, lines[i])
+	}
+	h := Hunk{Section: m[5]}
+	h.OldStart, _ = strconv.Atoi(m[1])
+	h.OldLines = 1
+	if m[2] != "" {
+		h.OldLines, _ = strconv.Atoi(m[2])
+	}
+	h.NewStart, _ = strconv.Atoi(m[3])
+	h.NewLines = 1
+	if m[4] != "" {
+		h.NewLines, _ = strconv.Atoi(m[4])
+	}
+
+	oldLeft, newLeft := h.OldLines, h.NewLines
+	j := i + 1
+	for ; j < len(lines) && (oldLeft > 0 || newLeft > 0); j++ {
+		l := lines[j]
+		if strings.HasPrefix(l, `\`) {
+			if n := len(h.Lines); n > 0 {
+				h.Lines[n-1].NoEOL = true
+			}
+			continue
+		}
+		op, text := byte(' '), ""
+		if l != "" {
+			// Some editors strip the trailing space of empty context lines.
+			op, text = l[0], l[1:]
+		}
+		switch op {
+		case ' ':
+			oldLeft--
+			newLeft--
+		case '-':
+			oldLeft--
+		case '+':
+			newLeft--
+		default:
+			return Hunk{}, 0, fmt.Errorf("line %d: unexpected %q in hunk %s", j+1, l, h.Header())
+		}
+		h.Lines = append(h.Lines, Line{Op: op, Text: text})
+	}
+	if oldLeft > 0 || newLeft > 0 {
+		return Hunk{}, 0, fmt.Errorf("hunk %s: truncated", h.Header())
+	}
+	if j < len(lines) && strings.HasPrefix(lines[j], `\`) {
+		if n := len(h.Lines); n > 0 {
+			h.Lines[n-1].NoEOL = true
+		}
+		j++
+	}
+	return h, j, nil
+}
+
+// diffPath cleans a ---/+++ path: drops a trailing timestamp, maps
+// /dev/null to "", and removes the a/ or b/ prefix.
+func diffPath(p, prefix string) string {
+	if i := strings.IndexByte(p, '\t'); i >= 0 {
+		p = p[:i]
+	}
+	if p == "/dev/null" {
+		return ""
+	}
+	return strings.TrimPrefix(p, prefix)
+}
+
+func gitHeaderPaths(s string) (string, string, bool) {
+	if !strings.HasPrefix(s, "a/") {
+		return "", "", false
+	}
+	i := strings.Index(s, " b/")
+	if i < 0 {
+		return "", "", false
+	}
+	return s[2:i], s[i+3:], true
+}
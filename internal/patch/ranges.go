@@ -0,0 +1,67 @@
+package patch
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"datacurve-takehome/internal/schemas"
+)
+
+// BlobHash is the git object id of content stored as a blob, which is what
+// `git hash-object` prints for the file.
+func BlobHash(content string) string {
+	h := sha1.New()
+	h.Write([]byte("blob " + strconv.Itoa(len(content)) + "\x00"))
+	h.Write([]byte(content))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ApplyRange replaces the text between r.Start and r.End with text. Lines and
+// columns are 1-based and columns count runes; a position one past the last
+// column of a line (or column 1 of the line after the last) is allowed.
+func ApplyRange(content string, r schemas.Range, text string) (string, error) {
+	start, err := offset(content, r.Start)
+	if err != nil {
+		return "", fmt.Errorf("range start: %w", err)
+	}
+	end, err := offset(content, r.End)
+	if err != nil {
+		return "", fmt.Errorf("range end: %w", err)
+	}
+	if end < start {
+		return "", fmt.Errorf("range end %d:%d before start %d:%d", r.End.Line, r.End.Col, r.Start.Line, r.Start.Col)
+	}
+	return content[:start] + text + content[end:], nil
+}
+
+// offset converts a position to a byte offset in content.
+func offset(content string, p schemas.Pos) (int, error) {
+	if p.Line < 1 || p.Col < 1 {
+		return 0, fmt.Errorf("position %d:%d is not 1-based", p.Line, p.Col)
+	}
+	at := 0
+	for line := 1; line < p.Line; line++ {
+		i := strings.IndexByte(content[at:], '\n')
+		if i < 0 {
+			return 0, fmt.Errorf("line %d past end of file", p.Line)
+		}
+		at += i + 1
+	}
+	rest := content[at:]
+	if i := strings.IndexByte(rest, '\n'); i >= 0 {
+		rest = rest[:i]
+	}
+	for col := 1; col < p.Col; col++ {
+		if rest == "" {
+			return 0, fmt.Errorf("column %d past end of line %d", p.Col, p.Line)
+		}
+		_, size := utf8.DecodeRuneInString(rest)
+		rest = rest[size:]
+		at += size
+	}
+	return at, nil
+}
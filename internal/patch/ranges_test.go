@@ -0,0 +1,174 @@
+package patch
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"datacurve-takehome/internal/schemas"
+)
+
+func TestApplyRange(t *testing.T) {
+	rng := func(l1, c1, l2, c2 int) schemas.Range {
+		return schemas.Range{Start: schemas.Pos{Line: l1, Col: c1}, End: schemas.Pos{Line: l2, Col: c2}}
+	}
+	const content = "func add(a, b int) int {\n\treturn a - b\n}\n"
+	for _, tc := range []struct {
+		name    string
+		content string
+		r       schemas.Range
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{"replace in a line", content, rng(2, 11, 2, 12), "+", "func add(a, b int) int {\n\treturn a + b\n}\n", false},
+		{"insert", content, rng(2, 2, 2, 2), "// sum\n\t", "func add(a, b int) int {\n\t// sum\n\treturn a - b\n}\n", false},
+		{"delete across lines", content, rng(1, 25, 3, 1), "", "func add(a, b int) int {}\n", false},
+		{"end of line", "ab\n", rng(1, 3, 1, 3), "c", "abc\n", false},
+		{"line after the last", "ab\n", rng(2, 1, 2, 1), "cd\n", "ab\ncd\n", false},
+		{"columns count runes", "héllo\n", rng(1, 3, 1, 4), "L", "héLlo\n", false},
+		{"line past end", "ab\n", rng(3, 1, 3, 1), "x", "", true},
+		{"column past end", "ab\n", rng(1, 5, 1, 5), "x", "", true},
+		{"zero-based", "ab\n", rng(0, 0, 1, 1), "x", "", true},
+		{"end before start", "abc\n", rng(1, 3, 1, 1), "x", "", true},
+	} {
+		got, err := ApplyRange(tc.content, tc.r, tc.text)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestBlobHash(t *testing.T) {
+	// git hash-object of an empty file and of "hello\n"
+	for content, want := range map[string]string{
+		"":        "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391",
+		"hello\n": "ce013625030ba8dba906f756967f9e9ca394464a",
+	} {
+		if got := BlobHash(content); got != want {
+			t.Errorf("BlobHash(%q) = %s, want %s", content, got, want)
+		}
+	}
+}
+
+func TestRangeEditReconstruction(t *testing.T) {
+	rng := func(l1, c1, l2, c2 int) *schemas.Range {
+		return &schemas.Range{Start: schemas.Pos{Line: l1, Col: c1}, End: schemas.Pos{Line: l2, Col: c2}}
+	}
+	src := mapSource{"calc.go": "package calc\n\nfunc Add(a, b int) int {\n\treturn a - b\n}\n"}
+	for _, tc := range []struct {
+		name      string
+		edits     []Edit
+		path      string
+		want      string // content after the edits; "" with exists false
+		exists    bool
+		conflicts []string // reasons
+		patches   []string // per step, a line the step's diff must contain
+	}{
+		{
+			name:    "replace",
+			edits:   []Edit{{Index: 0, Op: "replace", FilePath: "calc.go", Range: rng(4, 11, 4, 12), Text: "+"}},
+			path:    "calc.go",
+			want:    "package calc\n\nfunc Add(a, b int) int {\n\treturn a + b\n}\n",
+			exists:  true,
+			patches: []string{"+\treturn a + b"},
+		},
+		{
+			name: "insert ignores the range end",
+			edits: []Edit{{Index: 0, Op: "insert", FilePath: "calc.go", Range: rng(3, 1, 5, 2),
+				Text: "// Add sums.\n"}},
+			path:    "calc.go",
+			want:    "package calc\n\n// Add sums.\nfunc Add(a, b int) int {\n\treturn a - b\n}\n",
+			exists:  true,
+			patches: []string{"+// Add sums."},
+		},
+		{
+			name:    "delete ignores the text",
+			edits:   []Edit{{Index: 0, Op: "delete", FilePath: "calc.go", Range: rng(1, 13, 3, 1), Text: "ignored"}},
+			path:    "calc.go",
+			want:    "package calcfunc Add(a, b int) int {\n\treturn a - b\n}\n",
+			exists:  true,
+			patches: []string{"+package calcfunc Add(a, b int) int {"},
+		},
+		{
+			name: "edits build on each other",
+			edits: []Edit{
+				{Index: 0, Op: "create", FilePath: "sub.go", Text: "package calc\n"},
+				{Index: 1, Op: "insert", FilePath: "sub.go", Range: rng(2, 1, 2, 1), Text: "\nfunc Sub(a, b int) int { return a + b }\n"},
+				{Index: 2, Op: "replace", FilePath: "sub.go", Range: rng(3, 35, 3, 36), Text: "-"},
+			},
+			path:    "sub.go",
+			want:    "package calc\n\nfunc Sub(a, b int) int { return a - b }\n",
+			exists:  true,
+			patches: []string{"+package calc", "+func Sub(a, b int) int { return a + b }", "+func Sub(a, b int) int { return a - b }"},
+		},
+		{
+			name:      "range outside the file",
+			edits:     []Edit{{Index: 0, Op: "replace", FilePath: "calc.go", Range: rng(9, 1, 9, 1), Text: "x"}},
+			path:      "calc.go",
+			want:      src["calc.go"],
+			exists:    true,
+			conflicts: []string{"range start: line 9 past end of file"},
+			patches:   []string{""},
+		},
+		{
+			name:      "missing file",
+			edits:     []Edit{{Index: 0, Op: "replace", FilePath: "gone.go", Range: rng(1, 1, 1, 1), Text: "x"}},
+			path:      "gone.go",
+			conflicts: []string{"file does not exist"},
+			patches:   []string{""},
+		},
+		{
+			name:      "no range",
+			edits:     []Edit{{Index: 0, Op: "replace", FilePath: "calc.go", Text: "x"}},
+			path:      "calc.go",
+			want:      src["calc.go"],
+			exists:    true,
+			conflicts: []string{"no patch_unified or range"},
+		},
+	} {
+		ctx := context.Background()
+		w := NewWorkspace(src)
+		for _, e := range tc.edits {
+			if err := w.Apply(ctx, e); err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+		}
+		got, exists, err := w.File(ctx, tc.path)
+		if err != nil || got != tc.want || exists != tc.exists {
+			t.Errorf("%s: %s = %q (exists %v, err %v), want %q", tc.name, tc.path, got, exists, err, tc.want)
+		}
+		var reasons []string
+		for _, c := range w.Conflicts() {
+			reasons = append(reasons, c.Reason)
+		}
+		if !reflect.DeepEqual(reasons, tc.conflicts) {
+			t.Errorf("%s: conflicts %q, want %q", tc.name, reasons, tc.conflicts)
+		}
+		steps := w.Result().Steps
+		if len(steps) != len(tc.patches) {
+			t.Errorf("%s: %d steps, want %d: %+v", tc.name, len(steps), len(tc.patches), steps)
+			continue
+		}
+		for i, st := range steps {
+			if !strings.Contains(st.Patch, tc.patches[i]) {
+				t.Errorf("%s: step %d patch %q lacks %q", tc.name, i, st.Patch, tc.patches[i])
+			}
+			if reconstructed := tc.edits[i].Range != nil; st.Reconstructed != reconstructed {
+				t.Errorf("%s: step %d reconstructed = %v", tc.name, i, st.Reconstructed)
+			}
+			// each step starts where the previous one left the file
+			if i > 0 && st.BeforeHash != steps[i-1].AfterHash {
+				t.Errorf("%s: step %d before_hash %s, previous after_hash %s", tc.name, i, st.BeforeHash, steps[i-1].AfterHash)
+			}
+		}
+		if n := len(steps); n > 0 && exists && steps[n-1].AfterHash != BlobHash(got) {
+			t.Errorf("%s: last after_hash %s, want the blob hash of the result", tc.name, steps[n-1].AfterHash)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package patch
+
+import (
+	"fmt"
+	"strings"
+)
+
+const contextLines = 3
+
+// noEOLMark is appended to the last line of a file without a trailing
+// newline, so that adding or removing that newline shows up as a change.
+const noEOLMark = "\x00noeol"
+
+type diffOp struct {
+	op   byte // ' ', '-' or '+'
+	text string
+}
+
+// FileChange is one file's before and after state. A missing side is
+// represented by Exists being false.
+type FileChange struct {
+	OldPath, NewPath     string
+	Old, New             string
+	OldExists, NewExists bool
+}
+
+// Path is the file's path after the change, or before it for a deletion.
+func (c FileChange) Path() string {
+	if c.NewExists {
+		return c.NewPath
+	}
+	return c.OldPath
+}
+
+// Status is added, deleted, renamed or modified.
+func (c FileChange) Status() string {
+	switch {
+	case !c.OldExists:
+		return "added"
+	case !c.NewExists:
+		return "deleted"
+	case c.OldPath != c.NewPath:
+		return "renamed"
+	default:
+		return "modified"
+	}
+}
+
+// Unified renders a git-style diff for one file change, or "" when nothing
+// changed. Creations, deletions and renames get the matching extended
+// headers so that `git apply` reproduces them.
+func Unified(c FileChange) string {
+	if c.OldExists && c.NewExists && c.OldPath == c.NewPath && c.Old == c.New {
+		return ""
+	}
+	if !c.OldExists && !c.NewExists {
+		return ""
+	}
+	a, b := c.OldPath, c.NewPath
+	if !c.OldExists {
+		a = b
+	}
+	if !c.NewExists {
+		b = a
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "diff --git a/%s b/%s\n", a, b)
+	switch {
+	case !c.OldExists:
+		sb.WriteString("new file mode 100644\n")
+	case !c.NewExists:
+		sb.WriteString("deleted file mode 100644\n")
+	case a != b:
+		fmt.Fprintf(&sb, "rename from %s\nrename to %s\n", a, b)
+	}
+	if c.Old == c.New {
+		return sb.String()
+	}
+	oldName, newName := "a/"+a, "b/"+b
+	if !c.OldExists {
+		oldName = "/dev/null"
+	}
+	if !c.NewExists {
+		newName = "/dev/null"
+	}
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+	writeHunks(&sb, markedLines(c.Old), markedLines(c.New))
+	return sb.String()
+}
+
+func markedLines(s string) []string {
+	lines, noEOL := splitLines(s)
+	if noEOL {
+		lines[len(lines)-1] += noEOLMark
+	}
+	return lines
+}
+
+func writeHunks(sb *strings.Builder, a, b []string) {
+	ops := diffLines(a, b)
+
+	// Walk the script, opening a hunk at the first change and closing it
+	// once more than 2*contextLines unchanged lines follow.
+	i := 0
+	oldLine, newLine := 1, 1
+	for i < len(ops) {
+		if ops[i].op == ' ' {
+			i++
+			oldLine++
+			newLine++
+			continue
+		}
+		start := max(0, i-contextLines)
+		hOld := oldLine - (i - start)
+		hNew := newLine - (i - start)
+		end, run := i, 0
+		for j := i; j < len(ops); j++ {
+			if ops[j].op == ' ' {
+				run++
+				if run > 2*contextLines {
+					break
+				}
+				continue
+			}
+			run = 0
+			end = j
+		}
+		stop := min(len(ops), end+1+contextLines)
+
+		var body strings.Builder
+		oldCount, newCount := 0, 0
+		for _, o := range ops[start:stop] {
+			text, noEOL := strings.CutSuffix(o.text, noEOLMark)
+			body.WriteByte(o.op)
+			body.WriteString(text)
+			body.WriteByte('\n')
+			if noEOL {
+				body.WriteString("\\ No newline at end of file\n")
+			}
+			if o.op != '+' {
+				oldCount++
+			}
+			if o.op != '-' {
+				newCount++
+			}
+		}
+		if oldCount == 0 {
+			hOld--
+		}
+		if newCount == 0 {
+			hNew--
+		}
+		fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", hOld, oldCount, hNew, newCount)
+		sb.WriteString(body.String())
+
+		for _, o := range ops[i:stop] {
+			if o.op != '+' {
+				oldLine++
+			}
+			if o.op != '-' {
+				newLine++
+			}
+		}
+		i = stop
+	}
+}
+
+// diffLines returns an edit script turning a into b, using Myers' O(ND)
+// algorithm on what is left after stripping the common prefix and suffix.
+func diffLines(a, b []string) []diffOp {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, l := range a[:pre] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	ops = append(ops, myers(a[pre:len(a)-suf], b[pre:len(b)-suf])...)
+	for _, l := range a[len(a)-suf:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	return ops
+}
+
+func myers(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+	limit := n + m
+	off := limit + 1
+	v := make([]int, 2*limit+3)
+	var trace [][]int
+
+search:
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Backtrack through the saved frontiers, emitting the script in reverse.
+	var rev []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		vd := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && vd[off+k-1] < vd[off+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := vd[off+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			rev = append(rev, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				rev = append(rev, diffOp{'+', b[y-1]})
+			} else {
+				rev = append(rev, diffOp{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(rev)-1; i < j; i, j = i+1, j-1 {
+		rev[i], rev[j] = rev[j], rev[i]
+	}
+	return rev
+}
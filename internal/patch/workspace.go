@@ -0,0 +1,475 @@
+package patch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"time"
+
+	"datacurve-takehome/internal/schemas"
+)
+
+// Source provides file contents at the revision edits are replayed on top of
+// (the task's start commit). ok is false when the path does not exist there.
+type Source interface {
+	ReadFile(ctx context.Context, path string) (content string, ok bool, err error)
+}
+
+// Edit is one EditMade event, with Index its position in the trace's event
+// stream.
+type Edit struct {
+	Index    int            `json:"index"`
+	T        time.Time      `json:"t"`
+	Op       string         `json:"op"`
+	FilePath string         `json:"file_path"`
+	NewPath  string         `json:"new_path,omitempty"`
+	Range    *schemas.Range `json:"range,omitempty"`
+	Patch    string         `json:"-"`
+	Text     string         `json:"-"`
+	// BeforeHash and AfterHash are the hashes the client reported.
+	BeforeHash string `json:"before_hash,omitempty"`
+	AfterHash  string `json:"after_hash,omitempty"`
+}
+
+// EditsFromEvents picks the edit events out of a trace and orders them by
+// timestamp. Events without a timestamp keep the time of the event before
+// them, and ties keep upload order.
+func EditsFromEvents(events []map[string]any) []Edit {
+	var edits []Edit
+	var last time.Time
+	for i, e := range events {
+		if e["type"] != "edit" && e["type"] != "edit_made" {
+			continue
+		}
+		b, _ := json.Marshal(e)
+		var em schemas.EditMade
+		if err := json.Unmarshal(b, &em); err != nil {
+			// A malformed timestamp should not hide the edit itself.
+			e = maps.Clone(e)
+			delete(e, "t")
+			b, _ = json.Marshal(e)
+			_ = json.Unmarshal(b, &em)
+		}
+		if em.T.IsZero() {
+			em.T = last
+		}
+		last = em.T
+		edits = append(edits, Edit{
+			Index:      i,
+			T:          em.T,
+			Op:         strings.ReplaceAll(em.Op, "-", "_"),
+			FilePath:   em.FilePath,
+			NewPath:    em.NewPath,
+			Range:      em.Range,
+			Patch:      em.PatchUnified,
+			Text:       em.Text,
+			BeforeHash: em.BeforeHash,
+			AfterHash:  em.AfterHash,
+		})
+	}
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].T.Before(edits[j].T) })
+	return edits
+}
+
+// Conflict is an edit, or one hunk of it, that could not be replayed.
+type Conflict struct {
+	Event    int    `json:"event"`
+	FilePath string `json:"file_path"`
+	Op       string `json:"op"`
+	Hunk     string `json:"hunk,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// Step is what one edit did to one file, as computed by the server: the git
+// blob hashes of the file before and after and the diff between them. An
+// absent file has an empty hash.
+type Step struct {
+	Event      int    `json:"event"`
+	FilePath   string `json:"file_path"`
+	Op         string `json:"op"`
+	BeforeHash string `json:"before_hash"`
+	AfterHash  string `json:"after_hash"`
+	Patch      string `json:"patch"`
+	// Reconstructed marks edits that carried a range and text rather than
+	// a patch.
+	Reconstructed bool `json:"reconstructed,omitempty"`
+}
+
+// FileSummary describes how one file differs from the start commit.
+type FileSummary struct {
+	Path    string `json:"path"`
+	OldPath string `json:"old_path,omitempty"`
+	Status  string `json:"status"` // added, modified, deleted or renamed
+}
+
+// Result is the outcome of replaying a trace's edits.
+type Result struct {
+	Patch     string        `json:"patch"`
+	Files     []FileSummary `json:"files"`
+	Edits     int           `json:"edits"`
+	Conflicts []Conflict    `json:"conflicts"`
+	Steps     []Step        `json:"steps"`
+}
+
+type file struct {
+	content string
+	exists  bool
+}
+
+// Workspace replays edits over a Source, keeping every file it has touched
+// in memory.
+type Workspace struct {
+	src   Source
+	base  map[string]file  // contents at the start commit, as read
+	files map[string]*file // current contents
+	// origin maps a current path to the start-commit path it descends from;
+	// files created during the session have no entry.
+	origin    map[string]string
+	edits     int
+	conflicts []Conflict
+	steps     []Step
+
+	// before holds the state of each file the current edit has touched, as
+	// it was when the edit started.
+	before  map[string]file
+	touched []string
+}
+
+func NewWorkspace(src Source) *Workspace {
+	return &Workspace{
+		src:    src,
+		base:   map[string]file{},
+		files:  map[string]*file{},
+		origin: map[string]string{},
+	}
+}
+
+// Assemble replays edits in order and returns the combined diff against the
+// start commit.
+func Assemble(ctx context.Context, edits []Edit, src Source) (*Result, error) {
+	w := NewWorkspace(src)
+	for _, e := range edits {
+		if err := w.Apply(ctx, e); err != nil {
+			return nil, err
+		}
+	}
+	return w.Result(), nil
+}
+
+// Apply replays one edit. Edits that do not fit the current state are
+// recorded as conflicts; the error is only for failures reading the Source.
+func (w *Workspace) Apply(ctx context.Context, e Edit) error {
+	w.edits++
+	w.before, w.touched = map[string]file{}, nil
+	if err := w.apply(ctx, e); err != nil {
+		return err
+	}
+	for _, p := range w.touched {
+		b, a := w.before[p], *w.files[p]
+		w.steps = append(w.steps, Step{
+			Event:      e.Index,
+			FilePath:   p,
+			Op:         e.Op,
+			BeforeHash: fileHash(b),
+			AfterHash:  fileHash(a),
+			Patch: Unified(FileChange{
+				OldPath: p, Old: b.content, OldExists: b.exists,
+				NewPath: p, New: a.content, NewExists: a.exists,
+			}),
+			Reconstructed: e.Patch == "" && e.Range != nil,
+		})
+	}
+	return nil
+}
+
+func fileHash(f file) string {
+	if !f.exists {
+		return ""
+	}
+	return BlobHash(f.content)
+}
+
+func (w *Workspace) apply(ctx context.Context, e Edit) error {
+	if e.Patch != "" {
+		return w.applyPatch(ctx, e)
+	}
+	switch e.Op {
+	case "replace", "insert", "delete":
+		if e.Range == nil {
+			w.conflict(e, e.FilePath, "", "no patch_unified or range")
+			return nil
+		}
+		r := *e.Range
+		if e.Op == "insert" {
+			r.End = r.Start
+		}
+		text := e.Text
+		if e.Op == "delete" {
+			text = ""
+		}
+		return w.modifyRange(ctx, e, e.FilePath, r, text)
+	case "create":
+		return w.create(ctx, e, e.FilePath, e.Text)
+	case "delete_file":
+		return w.remove(ctx, e, e.FilePath)
+	case "rename":
+		if e.NewPath == "" {
+			w.conflict(e, e.FilePath, "", "rename without new_path")
+			return nil
+		}
+		return w.rename(ctx, e, e.FilePath, e.NewPath)
+	default:
+		w.conflict(e, e.FilePath, "", "unknown op")
+		return nil
+	}
+}
+
+func (w *Workspace) applyPatch(ctx context.Context, e Edit) error {
+	diffs, err := Parse(e.Patch)
+	if err != nil {
+		w.conflict(e, e.FilePath, "", "parse patch: "+err.Error())
+		return nil
+	}
+	if len(diffs) == 0 {
+		w.conflict(e, e.FilePath, "", "patch has no file diffs")
+		return nil
+	}
+	for _, d := range diffs {
+		oldPath, newPath := d.OldPath, d.NewPath
+		if len(diffs) == 1 && e.FilePath != "" {
+			// The event's paths are relative to the repository root; the
+			// patch headers are whatever the editor produced.
+			if !d.Created() {
+				oldPath = e.FilePath
+			}
+			switch {
+			case d.Renamed() && e.NewPath != "":
+				newPath = e.NewPath
+			case !d.Renamed() && !d.Deleted():
+				newPath = e.FilePath
+			}
+		}
+
+		var err error
+		switch {
+		case d.Created():
+			content, failed := ApplyHunks("", d.Hunks)
+			w.hunkConflicts(e, newPath, failed)
+			err = w.create(ctx, e, newPath, content)
+		case d.Deleted():
+			err = w.remove(ctx, e, oldPath)
+		case d.Renamed():
+			if err = w.rename(ctx, e, oldPath, newPath); err == nil && len(d.Hunks) > 0 {
+				err = w.modify(ctx, e, newPath, d.Hunks)
+			}
+		default:
+			err = w.modify(ctx, e, oldPath, d.Hunks)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Workspace) load(ctx context.Context, path string) (*file, error) {
+	if f, ok := w.files[path]; ok {
+		return f, nil
+	}
+	content, ok, err := w.src.ReadFile(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	w.base[path] = file{content: content, exists: ok}
+	f := &file{content: content, exists: ok}
+	w.files[path] = f
+	if ok {
+		w.origin[path] = path
+	}
+	return f, nil
+}
+
+// touch loads path and remembers its state before the current edit.
+func (w *Workspace) touch(ctx context.Context, path string) (*file, error) {
+	f, err := w.load(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := w.before[path]; !ok {
+		w.before[path] = *f
+		w.touched = append(w.touched, path)
+	}
+	return f, nil
+}
+
+func (w *Workspace) modify(ctx context.Context, e Edit, path string, hunks []Hunk) error {
+	f, err := w.touch(ctx, path)
+	if err != nil {
+		return err
+	}
+	if !f.exists {
+		w.conflict(e, path, "", "file does not exist")
+		return nil
+	}
+	content, failed := ApplyHunks(f.content, hunks)
+	w.hunkConflicts(e, path, failed)
+	f.content = content
+	return nil
+}
+
+func (w *Workspace) modifyRange(ctx context.Context, e Edit, path string, r schemas.Range, text string) error {
+	f, err := w.touch(ctx, path)
+	if err != nil {
+		return err
+	}
+	if !f.exists {
+		w.conflict(e, path, "", "file does not exist")
+		return nil
+	}
+	content, err := ApplyRange(f.content, r, text)
+	if err != nil {
+		w.conflict(e, path, "", err.Error())
+		return nil
+	}
+	f.content = content
+	return nil
+}
+
+func (w *Workspace) create(ctx context.Context, e Edit, path, content string) error {
+	f, err := w.touch(ctx, path)
+	if err != nil {
+		return err
+	}
+	if f.exists {
+		w.conflict(e, path, "", "file already exists")
+		return nil
+	}
+	f.exists, f.content = true, content
+	return nil
+}
+
+func (w *Workspace) remove(ctx context.Context, e Edit, path string) error {
+	f, err := w.touch(ctx, path)
+	if err != nil {
+		return err
+	}
+	if !f.exists {
+		w.conflict(e, path, "", "file does not exist")
+		return nil
+	}
+	f.exists, f.content = false, ""
+	return nil
+}
+
+func (w *Workspace) rename(ctx context.Context, e Edit, from, to string) error {
+	src, err := w.touch(ctx, from)
+	if err != nil {
+		return err
+	}
+	dst, err := w.touch(ctx, to)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !src.exists:
+		w.conflict(e, from, "", "file does not exist")
+		return nil
+	case dst.exists:
+		w.conflict(e, to, "", "rename target already exists")
+		return nil
+	}
+	dst.exists, dst.content = true, src.content
+	src.exists, src.content = false, ""
+	// Renaming onto a path the start commit had (and the session deleted)
+	// is a modification of that path; otherwise the origin moves along.
+	if _, ok := w.origin[to]; !ok {
+		if o, ok := w.origin[from]; ok {
+			w.origin[to] = o
+			delete(w.origin, from)
+		}
+	}
+	return nil
+}
+
+func (w *Workspace) conflict(e Edit, path, hunk, reason string) {
+	w.conflicts = append(w.conflicts, Conflict{Event: e.Index, FilePath: path, Op: e.Op, Hunk: hunk, Reason: reason})
+}
+
+func (w *Workspace) hunkConflicts(e Edit, path string, failed []HunkFailure) {
+	for _, f := range failed {
+		w.conflict(e, path, f.Hunk, f.Reason)
+	}
+}
+
+// File returns the current content of path, reading it from the Source if
+// no edit has touched it yet.
+func (w *Workspace) File(ctx context.Context, path string) (string, bool, error) {
+	f, err := w.load(ctx, path)
+	if err != nil {
+		return "", false, err
+	}
+	return f.content, f.exists, nil
+}
+
+// Changes lists every file that differs from the start commit, ordered by
+// path.
+func (w *Workspace) Changes() []FileChange {
+	var out []FileChange
+	claimed := map[string]bool{}
+	for p, f := range w.files {
+		if !f.exists {
+			continue
+		}
+		o, ok := w.origin[p]
+		if !ok {
+			out = append(out, FileChange{NewPath: p, New: f.content, NewExists: true})
+			continue
+		}
+		claimed[o] = true
+		b := w.base[o]
+		if o == p && b.content == f.content {
+			continue
+		}
+		out = append(out, FileChange{OldPath: o, Old: b.content, OldExists: true, NewPath: p, New: f.content, NewExists: true})
+	}
+	for p, b := range w.base {
+		if b.exists && !claimed[p] {
+			out = append(out, FileChange{OldPath: p, Old: b.content, OldExists: true})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path() < out[j].Path() })
+	return out
+}
+
+// Diff renders Changes as a single git-style patch.
+func (w *Workspace) Diff() string {
+	var sb strings.Builder
+	for _, c := range w.Changes() {
+		sb.WriteString(Unified(c))
+	}
+	return sb.String()
+}
+
+func (w *Workspace) Result() *Result {
+	res := &Result{Patch: w.Diff(), Files: []FileSummary{}, Edits: w.edits, Conflicts: w.conflicts, Steps: w.steps}
+	if res.Conflicts == nil {
+		res.Conflicts = []Conflict{}
+	}
+	if res.Steps == nil {
+		res.Steps = []Step{}
+	}
+	for _, c := range w.Changes() {
+		s := FileSummary{Path: c.Path(), Status: c.Status()}
+		if s.Status == "renamed" {
+			s.OldPath = c.OldPath
+		}
+		res.Files = append(res.Files, s)
+	}
+	return res
+}
+
+// Conflicts returns the edits, or hunks, that could not be replayed so far.
+func (w *Workspace) Conflicts() []Conflict { return w.conflicts }
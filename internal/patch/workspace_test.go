@@ -0,0 +1,129 @@
+package patch
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"datacurve-takehome/internal/schemas"
+)
+
+// mapSource is a start commit held in memory.
+type mapSource map[string]string
+
+func (m mapSource) ReadFile(_ context.Context, p string) (string, bool, error) {
+	c, ok := m[p]
+	return c, ok, nil
+}
+
+func TestAssemble(t *testing.T) {
+	src := mapSource{
+		"calc.go":   "package calc\n\nfunc Add(a, b int) int { return a - b }\n",
+		"old.go":    "package calc\n",
+		"README.md": "# calc\n",
+	}
+	fix := Edit{Op: "modify", FilePath: "calc.go",
+		Patch: "--- a/calc.go\n+++ b/calc.go\n@@ -3 +3 @@\n-func Add(a, b int) int { return a - b }\n+func Add(a, b int) int { return a + b }\n"}
+	for _, tc := range []struct {
+		name      string
+		edits     []Edit
+		files     map[string]string // path -> status
+		conflicts []string          // reasons
+		contains  []string          // in the combined patch
+	}{
+		{
+			name:     "patch edit",
+			edits:    []Edit{fix},
+			files:    map[string]string{"calc.go": "modified"},
+			contains: []string{"-func Add(a, b int) int { return a - b }", "+func Add(a, b int) int { return a + b }"},
+		},
+		{
+			name: "range edit",
+			edits: []Edit{{Op: "replace", FilePath: "README.md", Text: "Calc",
+				Range: &schemas.Range{Start: schemas.Pos{Line: 1, Col: 3}, End: schemas.Pos{Line: 1, Col: 7}}}},
+			files:    map[string]string{"README.md": "modified"},
+			contains: []string{"+# Calc"},
+		},
+		{
+			name: "create, rename and delete",
+			edits: []Edit{
+				{Op: "create", FilePath: "calc_test.go", Text: "package calc\n"},
+				{Op: "rename", FilePath: "old.go", NewPath: "doc.go"},
+				{Op: "delete_file", FilePath: "README.md"},
+			},
+			files:    map[string]string{"calc_test.go": "added", "doc.go": "renamed", "README.md": "deleted"},
+			contains: []string{"new file mode", "rename from old.go", "deleted file mode"},
+		},
+		{
+			name:  "edit then revert",
+			edits: []Edit{fix, {Op: "modify", FilePath: "calc.go", Patch: strings.NewReplacer("-func", "+func", "+func", "-func").Replace(fix.Patch)}},
+			files: map[string]string{},
+		},
+		{
+			name: "conflicts",
+			edits: []Edit{
+				{Op: "delete_file", FilePath: "missing.go"},
+				{Op: "create", FilePath: "old.go", Text: "x"},
+				{Op: "replace", FilePath: "calc.go"},
+				fix,
+			},
+			files:     map[string]string{"calc.go": "modified"},
+			conflicts: []string{"file does not exist", "file already exists", "no patch_unified or range"},
+		},
+	} {
+		for i := range tc.edits {
+			tc.edits[i].Index = i
+			tc.edits[i].T = time.Unix(int64(i), 0)
+		}
+		res, err := Assemble(context.Background(), tc.edits, src)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got := map[string]string{}
+		for _, f := range res.Files {
+			got[f.Path] = f.Status
+		}
+		if len(got) != len(tc.files) {
+			t.Errorf("%s: files %v, want %v", tc.name, got, tc.files)
+		}
+		for p, st := range tc.files {
+			if got[p] != st {
+				t.Errorf("%s: %s is %q, want %q", tc.name, p, got[p], st)
+			}
+		}
+		var reasons []string
+		for _, c := range res.Conflicts {
+			reasons = append(reasons, c.Reason)
+		}
+		if strings.Join(reasons, "|") != strings.Join(tc.conflicts, "|") {
+			t.Errorf("%s: conflicts %q, want %q", tc.name, reasons, tc.conflicts)
+		}
+		for _, s := range tc.contains {
+			if !strings.Contains(res.Patch, s) {
+				t.Errorf("%s: patch lacks %q:\n%s", tc.name, s, res.Patch)
+			}
+		}
+		if res.Edits != len(tc.edits) {
+			t.Errorf("%s: Edits = %d, want %d", tc.name, res.Edits, len(tc.edits))
+		}
+	}
+}
+
+func TestEditsFromEventsOrder(t *testing.T) {
+	events := []map[string]any{
+		{"type": "edit", "t": "2024-01-15T10:00:02Z", "op": "modify", "file_path": "b.go"},
+		{"type": "file_opened", "t": "2024-01-15T10:00:00Z", "file_path": "a.go"},
+		{"type": "edit_made", "t": "2024-01-15T10:00:01Z", "op": "delete-file", "file_path": "a.go"},
+		{"type": "edit", "op": "modify", "file_path": "c.go"},
+	}
+	var got []string
+	for _, e := range EditsFromEvents(events) {
+		got = append(got, e.FilePath+":"+e.Op)
+	}
+	// the untimed edit takes the time of the edit uploaded before it, a.go's
+	want := "a.go:delete_file c.go:modify b.go:modify"
+	if strings.Join(got, " ") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, " "), want)
+	}
+}
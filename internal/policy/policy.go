@@ -0,0 +1,214 @@
+// Package policy checks a final patch for changes that make QA pass without
+// fixing anything: edited tests, test runner config, CI or lockfiles, .git
+// or forbidden paths, added skip directives and removed assertions.
+package policy
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"datacurve-takehome/internal/patch"
+)
+
+// Rules.
+const (
+	// RuleTestFile: an existing test file was modified, renamed or deleted,
+	// or a new file hooks into the test run (conftest.py, a Go test file
+	// defining TestMain or init). Other new test files are allowed.
+	RuleTestFile = "test_file"
+	// RuleTestConfig: test runner config (pytest.ini, setup.cfg, ...) was
+	// created, modified or deleted.
+	RuleTestConfig = "test_config"
+	// RuleGitPath: the path is inside a .git directory.
+	RuleGitPath  = "git_path"
+	RuleCIConfig = "ci_config"
+	RuleLockfile = "lockfile"
+	// RuleForbidden: the path matches one of Policy.Forbidden.
+	RuleForbidden = "forbidden_path"
+	// RuleSkipAdded: an added line skips or expects-to-fail a test.
+	RuleSkipAdded = "skip_added"
+	// RuleAssertionRemoved: a removed line holds an assertion that no added
+	// line in the same file puts back.
+	RuleAssertionRemoved = "assertion_removed"
+)
+
+// Policy is configured per task. Forbidden globs use path.Match syntax
+// against the repository-relative path; a trailing "/**" matches everything
+// under a directory.
+type Policy struct {
+	Forbidden []string `json:"forbidden,omitempty"`
+	// Allow lists rules whose findings are not reported.
+	Allow []string `json:"allow,omitempty"`
+	// Enforce fails the QA run when there are findings.
+	Enforce bool `json:"enforce"`
+}
+
+type Finding struct {
+	Rule string `json:"rule"`
+	Path string `json:"path"`
+	// Line is the line number in the new file for added lines and in the
+	// old file for removed ones; 0 for file-level findings.
+	Line int    `json:"line,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+type Report struct {
+	Findings []Finding `json:"findings"`
+	Enforced bool      `json:"enforced"`
+	// Violated is set when Enforce is on and there are findings.
+	Violated bool `json:"violated"`
+}
+
+var (
+	testFile   = regexp.MustCompile(`(^|/)(tests?|__tests__|testdata|spec)/|_test\.(go|py)$|(^|/)test_[^/]*\.py$|\.(test|spec)\.[cm]?[jt]sx?$|(^|/)conftest\.py$`)
+	ciConfig   = regexp.MustCompile(`^(\.github/workflows/|\.circleci/|\.buildkite/)|^(\.gitlab-ci\.ya?ml|\.travis\.ya?ml|azure-pipelines\.ya?ml|Jenkinsfile|\.drone\.ya?ml|bitbucket-pipelines\.yml)$`)
+	testConfig = regexp.MustCompile(`(^|/)(pytest\.ini|setup\.cfg|tox\.ini|\.coveragerc|(jest|vitest)\.config\.[cm]?[jt]s)$`)
+	lockfile   = regexp.MustCompile(`(^|/)(go\.sum|package-lock\.json|npm-shrinkwrap\.json|yarn\.lock|pnpm-lock\.yaml|poetry\.lock|Pipfile\.lock|uv\.lock|Cargo\.lock|Gemfile\.lock|composer\.lock)$`)
+
+	skipDirective = regexp.MustCompile(`\bt\.Skip(Now|f)?\(|@pytest\.mark\.(skip|skipif|xfail)\b|\bpytest\.(skip|xfail)\(|@unittest\.(skip|skipIf|skipUnless|expectedFailure)\b|\b(it|test|describe)\.(skip|todo)\(|\b(xit|xtest|xdescribe)\(`)
+	goTestHook    = regexp.MustCompile(`^func (TestMain|init)\(`)
+	assertion     = regexp.MustCompile(`^\s*assert\b|\bt\.(Error|Errorf|Fatal|Fatalf|Fail|FailNow)\(|\b(assert|require)\.[A-Z]\w*\(|\bself\.assert\w*\(|\bexpect\(|\bpytest\.raises\(`)
+)
+
+// IsTestFile reports whether a repository path looks like test code.
+func IsTestFile(name string) bool { return testFile.MatchString(name) }
+
+// Check parses the unified diff and returns the findings for p.
+func Check(diff string, p Policy) (*Report, error) {
+	files, err := patch.Parse(diff)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{Findings: []Finding{}, Enforced: p.Enforce}
+	add := func(f Finding) {
+		for _, a := range p.Allow {
+			if a == f.Rule {
+				return
+			}
+		}
+		r.Findings = append(r.Findings, f)
+	}
+
+	for _, fd := range files {
+		name := fd.NewPath
+		if fd.Deleted() {
+			name = fd.OldPath
+		}
+		// a rename is checked under both names
+		paths := []string{name}
+		if fd.Renamed() {
+			paths = append(paths, fd.OldPath)
+		}
+		for _, pth := range paths {
+			if IsTestFile(pth) && (!fd.Created() || testHook(fd, pth)) {
+				add(Finding{Rule: RuleTestFile, Path: pth})
+			}
+			if testConfig.MatchString(pth) {
+				add(Finding{Rule: RuleTestConfig, Path: pth})
+			}
+			if inGitDir(pth) {
+				add(Finding{Rule: RuleGitPath, Path: pth})
+			}
+			if ciConfig.MatchString(pth) {
+				add(Finding{Rule: RuleCIConfig, Path: pth})
+			}
+			if lockfile.MatchString(pth) {
+				add(Finding{Rule: RuleLockfile, Path: pth})
+			}
+			if matchAny(p.Forbidden, pth) {
+				add(Finding{Rule: RuleForbidden, Path: pth})
+			}
+		}
+		for _, f := range lineFindings(fd, name) {
+			add(f)
+		}
+	}
+	sort.SliceStable(r.Findings, func(i, j int) bool { return r.Findings[i].Path < r.Findings[j].Path })
+	r.Violated = p.Enforce && len(r.Findings) > 0
+	return r, nil
+}
+
+// lineFindings reports added skip directives and removed assertions.
+// Assertions that are only moved or re-indented are not reported.
+func lineFindings(fd *patch.FileDiff, name string) []Finding {
+	var out []Finding
+	type removed struct {
+		line int
+		text string
+	}
+	var asserts []removed
+	readded := map[string]int{}
+	for _, h := range fd.Hunks {
+		oldN, newN := h.OldStart, h.NewStart
+		for _, l := range h.Lines {
+			switch l.Op {
+			case '+':
+				if skipDirective.MatchString(l.Text) {
+					out = append(out, Finding{Rule: RuleSkipAdded, Path: name, Line: newN, Text: strings.TrimSpace(l.Text)})
+				}
+				readded[strings.TrimSpace(l.Text)]++
+				newN++
+			case '-':
+				if assertion.MatchString(l.Text) {
+					asserts = append(asserts, removed{oldN, strings.TrimSpace(l.Text)})
+				}
+				oldN++
+			default:
+				oldN++
+				newN++
+			}
+		}
+	}
+	for _, a := range asserts {
+		if readded[a.text] > 0 {
+			readded[a.text]--
+			continue
+		}
+		out = append(out, Finding{Rule: RuleAssertionRemoved, Path: name, Line: a.line, Text: a.text})
+	}
+	return out
+}
+
+// testHook reports whether a new test file runs code around the other tests:
+// a conftest.py, or a Go test file defining TestMain or init.
+func testHook(fd *patch.FileDiff, name string) bool {
+	switch {
+	case path.Base(name) == "conftest.py":
+		return true
+	case strings.HasSuffix(name, "_test.go"):
+		for _, h := range fd.Hunks {
+			for _, l := range h.Lines {
+				if l.Op == '+' && goTestHook.MatchString(l.Text) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func inGitDir(name string) bool {
+	for _, c := range strings.Split(name, "/") {
+		if strings.EqualFold(c, ".git") {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(globs []string, name string) bool {
+	for _, g := range globs {
+		if dir, ok := strings.CutSuffix(g, "/**"); ok {
+			if name == dir || strings.HasPrefix(name, dir+"/") {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
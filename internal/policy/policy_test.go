@@ -0,0 +1,100 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+// created, modified and deleted build a one-file diff; body holds the hunk
+// lines.
+func created(name, body string) string {
+	return "diff --git a/" + name + " b/" + name + "\nnew file mode 100644\n--- /dev/null\n+++ b/" + name + "\n@@ -0,0 +1 @@\n" + body
+}
+
+func modified(name, body string) string {
+	return "--- a/" + name + "\n+++ b/" + name + "\n@@ -1 +1 @@\n" + body
+}
+
+func deleted(name, body string) string {
+	return "diff --git a/" + name + " b/" + name + "\ndeleted file mode 100644\n--- a/" + name + "\n+++ /dev/null\n@@ -1 +0,0 @@\n" + body
+}
+
+func TestCheckFiles(t *testing.T) {
+	for _, tc := range []struct {
+		name, diff string
+		want       []string // rule:path
+	}{
+		{"new test", created("calc_test.go", "+func TestAdd(t *testing.T) {}\n"), nil},
+		{"new python test", created("tests/test_calc.py", "+def test_add(): pass\n"), nil},
+		{"new Go test with TestMain", created("calc_test.go", "+func TestMain(m *testing.M) { os.Exit(0) }\n"), []string{"test_file:calc_test.go"}},
+		{"new Go test with init", created("pkg/x_test.go", "+func init() { os.Setenv(\"SKIP\", \"1\") }\n"), []string{"test_file:pkg/x_test.go"}},
+		{"new conftest", created("conftest.py", "+collect_ignore = ['tests']\n"), []string{"test_file:conftest.py"}},
+		{"new nested conftest", created("tests/unit/conftest.py", "+import pytest\n"), []string{"test_file:tests/unit/conftest.py"}},
+		{"modified test", modified("calc_test.go", "-x := 1\n+x := 2\n"), []string{"test_file:calc_test.go"}},
+		{"deleted test", deleted("tests/test_calc.py", "-def test_add(): pass\n"), []string{"test_file:tests/test_calc.py"}},
+		{"renamed test", "diff --git a/a_test.go b/b_test.go\nrename from a_test.go\nrename to b_test.go\n", []string{"test_file:a_test.go", "test_file:b_test.go"}},
+		{"modified source", modified("calc.go", "-return a - b\n+return a + b\n"), nil},
+		{"new pytest.ini", created("pytest.ini", "+[pytest]\n"), []string{"test_config:pytest.ini"}},
+		{"modified setup.cfg", modified("setup.cfg", "-addopts = -q\n+addopts = -q -k 'not slow'\n"), []string{"test_config:setup.cfg"}},
+		{"deleted tox.ini", deleted("tox.ini", "-[tox]\n"), []string{"test_config:tox.ini"}},
+		{"jest config", modified("web/jest.config.ts", "-a\n+b\n"), []string{"test_config:web/jest.config.ts"}},
+		{"CI", modified(".github/workflows/ci.yml", "-a\n+b\n"), []string{"ci_config:.github/workflows/ci.yml"}},
+		{"lockfile", modified("go.sum", "-a\n+b\n"), []string{"lockfile:go.sum"}},
+		{"git hook", created(".git/hooks/pre-commit", "+#!/bin/sh\n"), []string{"git_path:.git/hooks/pre-commit"}},
+		{"nested git config", modified("vendor/lib/.GIT/config", "-a\n+b\n"), []string{"git_path:vendor/lib/.GIT/config"}},
+		{"dot github", modified(".github/CODEOWNERS", "-a\n+b\n"), nil},
+	} {
+		r, err := Check(tc.diff, Policy{})
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var got []string
+		for _, f := range r.Findings {
+			got = append(got, f.Rule+":"+f.Path)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: findings = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCheckLines(t *testing.T) {
+	diff := modified("calc_test.go", "-\tassert.Equal(t, 3, Add(1, 2))\n+\tt.Skip(\"flaky\")\n") +
+		"--- a/other_test.go\n+++ b/other_test.go\n@@ -4 +4 @@\n-if got != 3 { t.Fatalf(\"got %d\", got) }\n+  if got != 3 { t.Fatalf(\"got %d\", got) }\n"
+	r, err := Check(diff, Policy{Allow: []string{RuleTestFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Finding{
+		{Rule: RuleSkipAdded, Path: "calc_test.go", Line: 1, Text: `t.Skip("flaky")`},
+		{Rule: RuleAssertionRemoved, Path: "calc_test.go", Line: 1, Text: "assert.Equal(t, 3, Add(1, 2))"},
+	}
+	if !reflect.DeepEqual(r.Findings, want) {
+		t.Errorf("findings = %+v, want %+v", r.Findings, want)
+	}
+}
+
+func TestCheckEnforce(t *testing.T) {
+	diff := modified("internal/secret/key.go", "-a\n+b\n")
+	for _, tc := range []struct {
+		name     string
+		p        Policy
+		findings int
+		violated bool
+	}{
+		{"report only", Policy{Forbidden: []string{"internal/secret/**"}}, 1, false},
+		{"enforced", Policy{Forbidden: []string{"internal/secret/**"}, Enforce: true}, 1, true},
+		{"glob", Policy{Forbidden: []string{"internal/*/key.go"}, Enforce: true}, 1, true},
+		{"allowed", Policy{Forbidden: []string{"internal/secret/**"}, Allow: []string{RuleForbidden}, Enforce: true}, 0, false},
+		{"no match", Policy{Forbidden: []string{"internal/secretive/**"}, Enforce: true}, 0, false},
+	} {
+		r, err := Check(diff, tc.p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Findings) != tc.findings || r.Violated != tc.violated {
+			t.Errorf("%s: findings %+v violated %v, want %d and %v", tc.name, r.Findings, r.Violated, tc.findings, tc.violated)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package qa
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"datacurve-takehome/internal/patch"
+)
+
+// applyStrategy is one way of applying the patch. The git strategies pass
+// args to `git apply`; the last one uses patch(1), which places hunks with
+// fuzz where git refuses to.
+type applyStrategy struct {
+	name string
+	args []string
+}
+
+var applyStrategies = []applyStrategy{
+	{"git-apply", nil},
+	{"git-apply-3way", []string{"--3way"}},
+	{"git-apply-recount", []string{"--recount", "--ignore-whitespace", "--whitespace=nowarn"}},
+	{"patch-fuzz", nil},
+}
+
+const patchFuzzStrategy = "patch-fuzz"
+
+// patchFuzzScript applies the patch file named by $1 with GNU patch; it
+// exits 127 when there is only busybox patch, which has no --fuzz.
+const patchFuzzScript = `patch --version 2>/dev/null | grep -q 'GNU patch' || exit 127; ` +
+	`patch -p1 --fuzz=3 --batch --forward --no-backup-if-mismatch --reject-file=- -i "$1"`
+
+// ApplyReport is qa.apply: which strategy applied the patch or, when none
+// did, which files and hunks were rejected.
+type ApplyReport struct {
+	Strategy string         `json:"strategy,omitempty"`
+	Attempts []ApplyAttempt `json:"attempts"`
+	Files    []FileApply    `json:"files,omitempty"`
+}
+
+// ApplyAttempt is one strategy's outcome: applied, failed or unavailable.
+type ApplyAttempt struct {
+	Strategy string `json:"strategy"`
+	Status   string `json:"status"`
+	Output   string `json:"output,omitempty"`
+}
+
+// FileApply is how one file of a rejected patch fared.
+type FileApply struct {
+	Path string `json:"path"`
+	// Status is applied, partial or rejected.
+	Status string      `json:"status"`
+	Error  string      `json:"error,omitempty"`
+	Hunks  []HunkApply `json:"hunks,omitempty"`
+}
+
+type HunkApply struct {
+	// Index counts from 1, like git's "Rejected hunk #2".
+	Index  int    `json:"index"`
+	Header string `json:"header,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Expected is the text git searched the file for and didn't find.
+	Expected string `json:"expected,omitempty"`
+}
+
+// ApplyError is returned when no strategy applies the patch; Report holds
+// the per-hunk rejections.
+type ApplyError struct {
+	Report *ApplyReport
+	// Reason, when set, is why the patch was refused without trying it.
+	Reason string
+}
+
+func (e *ApplyError) Error() string {
+	if e.Reason != "" {
+		return e.Reason
+	}
+	var rejected []string
+	for _, f := range e.Report.Files {
+		if f.Status != "applied" {
+			rejected = append(rejected, f.Path)
+		}
+	}
+	if len(rejected) == 0 {
+		return "patch does not apply"
+	}
+	return "patch does not apply to " + strings.Join(rejected, ", ")
+}
+
+// tailOutput keeps the end of an attempt's output, where the errors are.
+func tailOutput(s string) string {
+	const max = 4096
+	if len(s) > max {
+		return "..." + s[len(s)-max:]
+	}
+	return s
+}
+
+// patchApplier runs the apply steps in one backend's checkout.
+type patchApplier struct {
+	// git runs `git apply` with args plus the patch file and returns its
+	// combined output and exit code.
+	git func(ctx context.Context, args ...string) (string, int, error)
+	// patchFuzz runs patchFuzzScript the same way.
+	patchFuzz func(ctx context.Context) (string, int, error)
+	// reset restores the checkout to HEAD, keeping the patch file.
+	reset func(ctx context.Context) error
+}
+
+// applyPatch tries applyStrategies in order, resetting the checkout after
+// each failure. When all fail it runs `git apply --reject` once to learn
+// which hunks don't apply, resets again and returns an *ApplyError.
+func applyPatch(ctx context.Context, a patchApplier, diff string) (*ApplyReport, error) {
+	rep := &ApplyReport{}
+	// git apply refuses these, but the patch(1) fallback doesn't
+	if bad := unsafePaths(diff); len(bad) > 0 {
+		for _, p := range bad {
+			rep.Files = append(rep.Files, FileApply{Path: p, Status: "rejected", Error: "path is absolute, contains .. or is under .git"})
+		}
+		return rep, &ApplyError{Report: rep, Reason: "patch touches paths outside the working tree: " + strings.Join(bad, ", ")}
+	}
+	for _, s := range applyStrategies {
+		var out string
+		var code int
+		var err error
+		if s.name == patchFuzzStrategy {
+			out, code, err = a.patchFuzz(ctx)
+		} else {
+			out, code, err = a.git(ctx, s.args...)
+		}
+		if err != nil {
+			return rep, fmt.Errorf("%s: %w", s.name, err)
+		}
+		att := ApplyAttempt{Strategy: s.name, Status: "applied", Output: tailOutput(out)}
+		switch {
+		case code == 0:
+			rep.Attempts = append(rep.Attempts, att)
+			rep.Strategy = s.name
+			log.Println("QA runner: applied patch with", s.name)
+			return rep, nil
+		case s.name == patchFuzzStrategy && code == 127:
+			att.Status, att.Output = "unavailable", "GNU patch not found"
+		default:
+			att.Status = "failed"
+		}
+		rep.Attempts = append(rep.Attempts, att)
+		log.Printf("QA runner: %s did not apply the patch (exit %d)", s.name, code)
+		if err := a.reset(ctx); err != nil {
+			return rep, fmt.Errorf("reset after %s: %w", s.name, err)
+		}
+	}
+
+	out, _, err := a.git(ctx, "--reject", "--verbose")
+	if err != nil {
+		return rep, fmt.Errorf("git apply --reject: %w", err)
+	}
+	if err := a.reset(ctx); err != nil {
+		return rep, fmt.Errorf("reset after git apply --reject: %w", err)
+	}
+	rep.Files = rejections(diff, out)
+	return rep, &ApplyError{Report: rep}
+}
+
+// unsafePaths returns the paths in diff's file headers that are absolute,
+// have a .. component or a .git component at any depth. The a/ and b/
+// prefixes are stripped first, as -p1 does.
+func unsafePaths(diff string) []string {
+	var bad []string
+	seen := map[string]bool{}
+	check := func(p string) {
+		if i := strings.IndexByte(p, '\t'); i >= 0 {
+			p = p[:i] // timestamp of a traditional diff
+		}
+		if uq, err := strconv.Unquote(p); err == nil {
+			p = uq
+		}
+		if p == "" || p == "/dev/null" || seen[p] {
+			return
+		}
+		unsafe := strings.HasPrefix(p, "/")
+		rel := p
+		if strings.HasPrefix(rel, "a/") || strings.HasPrefix(rel, "b/") {
+			rel = rel[2:]
+		}
+		for _, c := range strings.Split(rel, "/") {
+			if c == ".." || strings.EqualFold(c, ".git") {
+				unsafe = true
+			}
+		}
+		if unsafe {
+			seen[p] = true
+			bad = append(bad, p)
+		}
+	}
+	for _, line := range strings.Split(diff, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		switch {
+		case strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "+++ "):
+			check(line[4:])
+		case strings.HasPrefix(line, "diff --git "):
+			// a/x b/y; names with spaces split oddly, which only errs
+			// towards refusing
+			for _, f := range strings.Fields(line[len("diff --git "):]) {
+				check(f)
+			}
+		default:
+			for _, h := range []string{"rename from ", "rename to ", "copy from ", "copy to "} {
+				if strings.HasPrefix(line, h) {
+					check(line[len(h):])
+				}
+			}
+		}
+	}
+	return bad
+}
+
+var (
+	checkingRe = regexp.MustCompile(`^Checking patch (.+)\.\.\.$`)
+	applyingRe = regexp.MustCompile(`^Applying patch (.+) with \d+ rejects?\.\.\.$`)
+	appliedRe  = regexp.MustCompile(`^Applied patch (.+) cleanly\.$`)
+	hunkRe     = regexp.MustCompile(`^(?:Hunk #(\d+) applied cleanly|Rejected hunk #(\d+))\.$`)
+	failedAtRe = regexp.MustCompile(`^error: patch failed: (.+):(\d+)$`)
+	fileErrRe  = regexp.MustCompile(`^error: (.+?): (.+)$`)
+)
+
+// rejections combines the hunks of diff with the output of
+// `git apply --reject --verbose`. Hunks git never got to, because their
+// file is missing or unreadable, count as rejected.
+func rejections(diff, out string) []FileApply {
+	var files []*FileApply
+	byPath := map[string]*FileApply{}
+	file := func(p string) *FileApply {
+		// renames are reported as "old => new"
+		if i := strings.Index(p, " => "); i >= 0 {
+			p = p[i+4:]
+		}
+		f, ok := byPath[p]
+		if !ok {
+			f = &FileApply{Path: p}
+			byPath[p] = f
+			files = append(files, f)
+		}
+		return f
+	}
+	hunk := func(f *FileApply, i int) *HunkApply {
+		for len(f.Hunks) < i {
+			f.Hunks = append(f.Hunks, HunkApply{Index: len(f.Hunks) + 1, Status: "rejected"})
+		}
+		return &f.Hunks[i-1]
+	}
+	// headers and old start lines from the diff itself; git's --recount
+	// tolerance doesn't extend to patch.Parse, so this may find nothing
+	starts := map[*FileApply][]int{}
+	if fds, err := patch.Parse(diff); err == nil {
+		for _, fd := range fds {
+			p := fd.NewPath
+			if fd.Deleted() {
+				p = fd.OldPath
+			}
+			f := file(p)
+			for i, h := range fd.Hunks {
+				hunk(f, i+1).Header = h.Header()
+				starts[f] = append(starts[f], h.OldStart)
+			}
+		}
+	}
+
+	var cur *FileApply
+	var expected []string
+	searching := false
+	for _, line := range strings.Split(out, "\n") {
+		if searching {
+			if !strings.HasPrefix(line, "error: ") {
+				expected = append(expected, line)
+				continue
+			}
+			searching = false
+		}
+		if m := checkingRe.FindStringSubmatch(line); m != nil {
+			cur = file(m[1])
+			continue
+		}
+		if m := applyingRe.FindStringSubmatch(line); m != nil {
+			cur = file(m[1])
+			continue
+		}
+		if m := appliedRe.FindStringSubmatch(line); m != nil {
+			cur = file(m[1])
+			for i := range cur.Hunks {
+				cur.Hunks[i].Status = "applied"
+			}
+			continue
+		}
+		if m := hunkRe.FindStringSubmatch(line); m != nil && cur != nil {
+			if m[1] != "" {
+				n, _ := strconv.Atoi(m[1])
+				hunk(cur, n).Status = "applied"
+			} else {
+				n, _ := strconv.Atoi(m[2])
+				hunk(cur, n).Status = "rejected"
+			}
+			continue
+		}
+		if line == "error: while searching for:" {
+			searching, expected = true, nil
+			continue
+		}
+		if m := failedAtRe.FindStringSubmatch(line); m != nil {
+			f := file(m[1])
+			at, _ := strconv.Atoi(m[2])
+			msg := fmt.Sprintf("does not match at line %d", at)
+			placed := false
+			for i, s := range starts[f] {
+				if s == at && f.Hunks[i].Error == "" {
+					f.Hunks[i].Error = msg
+					f.Hunks[i].Expected = strings.TrimRight(strings.Join(expected, "\n"), "\n")
+					placed = true
+					break
+				}
+			}
+			if !placed && f.Error == "" {
+				f.Error = msg
+			}
+			expected = nil
+			continue
+		}
+		// other errors only count when they name a file of the patch
+		if m := fileErrRe.FindStringSubmatch(line); m != nil && m[2] != "patch does not apply" {
+			if f, ok := byPath[m[1]]; ok && f.Error == "" {
+				f.Error = m[2]
+			}
+		}
+	}
+
+	result := make([]FileApply, 0, len(files))
+	for _, f := range files {
+		applied := 0
+		for _, h := range f.Hunks {
+			if h.Status == "applied" {
+				applied++
+			}
+		}
+		switch {
+		case f.Error == "" && applied == len(f.Hunks):
+			f.Status = "applied"
+		case applied > 0:
+			f.Status = "partial"
+		default:
+			f.Status = "rejected"
+		}
+		result = append(result, *f)
+	}
+	return result
+}
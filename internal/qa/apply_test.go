@@ -0,0 +1,218 @@
+package qa
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnsafePaths(t *testing.T) {
+	for _, tc := range []struct {
+		name, diff string
+		want       []string
+	}{
+		{"plain", "--- a/calc.go\n+++ b/calc.go\n", nil},
+		{"new file", "diff --git a/new.go b/new.go\nnew file mode 100644\n--- /dev/null\n+++ b/new.go\n", nil},
+		{"dotfile", "--- a/.github/ci.yml\n+++ b/.github/ci.yml\n", nil},
+		{"hook", "--- /dev/null\n+++ b/.git/hooks/post-checkout\n", []string{"b/.git/hooks/post-checkout"}},
+		{"config", "diff --git a/.git/config b/.git/config\n", []string{"a/.git/config", "b/.git/config"}},
+		{"nested repo", "--- a/vendor/lib/.git/config\n+++ b/vendor/lib/.git/config\n", []string{"a/vendor/lib/.git/config", "b/vendor/lib/.git/config"}},
+		{"case", "--- a/.GIT/config\n+++ b/.GIT/config\n", []string{"a/.GIT/config", "b/.GIT/config"}},
+		{"absolute", "--- /etc/passwd\t2024-01-01\n+++ /etc/passwd\t2024-01-02\n", []string{"/etc/passwd"}},
+		{"parent", "--- a/../outside.txt\n+++ b/../outside.txt\n", []string{"a/../outside.txt", "b/../outside.txt"}},
+		{"rename", "diff --git a/x b/y\nrename from x\nrename to .git/hooks/pre-commit\n", []string{".git/hooks/pre-commit"}},
+		{"quoted", "--- \"a/.git/hooks/t\\303\\251st\"\n+++ \"b/ok\"\n", []string{"a/.git/hooks/tést"}},
+	} {
+		if got := unsafePaths(tc.diff); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: unsafePaths = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// fakeApplier records the apply steps; exits maps a strategy to its exit
+// code, 0 when absent.
+type fakeApplier struct {
+	exits map[string]int
+	calls []string
+}
+
+func (f *fakeApplier) applier() patchApplier {
+	strategy := map[string]string{"": "git-apply", "--3way": "git-apply-3way", "--recount": "git-apply-recount", "--reject": "reject"}
+	return patchApplier{
+		git: func(_ context.Context, args ...string) (string, int, error) {
+			first := ""
+			if len(args) > 0 {
+				first = args[0]
+			}
+			s := strategy[first]
+			f.calls = append(f.calls, s)
+			if s == "reject" {
+				return rejectOutput, 1, nil
+			}
+			return "", f.exits[s], nil
+		},
+		patchFuzz: func(context.Context) (string, int, error) {
+			f.calls = append(f.calls, patchFuzzStrategy)
+			return "", f.exits[patchFuzzStrategy], nil
+		},
+		reset: func(context.Context) error {
+			f.calls = append(f.calls, "reset")
+			return nil
+		},
+	}
+}
+
+// rejectOutput is `git apply --reject --verbose` for fuzzDiff on a tree
+// where f.txt's line 9 differs and missing.txt doesn't exist.
+const rejectOutput = `Checking patch f.txt...
+error: while searching for:
+eight
+NINE
+ten
+
+error: patch failed: f.txt:8
+Checking patch ok.txt...
+Checking patch missing.txt...
+error: missing.txt: No such file or directory
+Applying patch f.txt with 1 reject...
+Hunk #1 applied cleanly.
+Rejected hunk #2.
+Applied patch ok.txt cleanly.
+`
+
+const fuzzDiff = `--- a/f.txt
++++ b/f.txt
+@@ -1,3 +1,3 @@
+ one
+-two
++2
+ three
+@@ -8,3 +8,3 @@
+ eight
+-NINE
++9
+ ten
+--- a/ok.txt
++++ b/ok.txt
+@@ -1 +1 @@
+-ok
++fine
+--- a/missing.txt
++++ b/missing.txt
+@@ -1 +1 @@
+-x
++y
+`
+
+func TestApplyPatchOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		exits        map[string]int
+		wantCalls    string
+		wantStrategy string
+		wantStatuses string
+		wantErr      bool
+	}{
+		{
+			name: "git apply", wantCalls: "git-apply", wantStrategy: "git-apply", wantStatuses: "applied",
+		},
+		{
+			name:         "3way",
+			exits:        map[string]int{"git-apply": 1},
+			wantCalls:    "git-apply reset git-apply-3way",
+			wantStrategy: "git-apply-3way", wantStatuses: "failed applied",
+		},
+		{
+			name:         "fuzz",
+			exits:        map[string]int{"git-apply": 1, "git-apply-3way": 1, "git-apply-recount": 1},
+			wantCalls:    "git-apply reset git-apply-3way reset git-apply-recount reset patch-fuzz",
+			wantStrategy: "patch-fuzz", wantStatuses: "failed failed failed applied",
+		},
+		{
+			name:         "nothing applies",
+			exits:        map[string]int{"git-apply": 1, "git-apply-3way": 1, "git-apply-recount": 1, "patch-fuzz": 127},
+			wantCalls:    "git-apply reset git-apply-3way reset git-apply-recount reset patch-fuzz reset reject reset",
+			wantStatuses: "failed failed failed unavailable",
+			wantErr:      true,
+		},
+	} {
+		f := &fakeApplier{exits: tc.exits}
+		rep, err := applyPatch(context.Background(), f.applier(), fuzzDiff)
+		var aerr *ApplyError
+		if tc.wantErr != errors.As(err, &aerr) {
+			t.Errorf("%s: err = %v, want ApplyError %v", tc.name, err, tc.wantErr)
+		}
+		if got := strings.Join(f.calls, " "); got != tc.wantCalls {
+			t.Errorf("%s: calls = %s, want %s", tc.name, got, tc.wantCalls)
+		}
+		var statuses []string
+		for _, a := range rep.Attempts {
+			statuses = append(statuses, a.Status)
+		}
+		if got := strings.Join(statuses, " "); got != tc.wantStatuses || rep.Strategy != tc.wantStrategy {
+			t.Errorf("%s: strategy %q, attempts %s; want %q, %s", tc.name, rep.Strategy, got, tc.wantStrategy, tc.wantStatuses)
+		}
+		if tc.wantErr && len(rep.Files) != 3 {
+			t.Errorf("%s: files = %+v, want the three files of the patch", tc.name, rep.Files)
+		}
+	}
+}
+
+func TestApplyPatchRefusesUnsafePaths(t *testing.T) {
+	f := &fakeApplier{}
+	diff := "--- /dev/null\n+++ b/.git/hooks/post-checkout\n@@ -0,0 +1 @@\n+#!/bin/sh\n"
+	rep, err := applyPatch(context.Background(), f.applier(), diff)
+	var aerr *ApplyError
+	if !errors.As(err, &aerr) || !strings.Contains(err.Error(), ".git/hooks/post-checkout") {
+		t.Fatalf("err = %v, want an ApplyError naming the hook", err)
+	}
+	if len(f.calls) != 0 {
+		t.Errorf("strategies ran: %v", f.calls)
+	}
+	if len(rep.Files) != 1 || rep.Files[0].Status != "rejected" {
+		t.Errorf("files = %+v", rep.Files)
+	}
+}
+
+func TestRejections(t *testing.T) {
+	for _, tc := range []struct {
+		name, diff, out string
+		want            []FileApply
+	}{
+		{
+			name: "partial, applied and missing",
+			diff: fuzzDiff,
+			out:  rejectOutput,
+			want: []FileApply{
+				{Path: "f.txt", Status: "partial", Hunks: []HunkApply{
+					{Index: 1, Header: "@@ -1,3 +1,3 @@", Status: "applied"},
+					{Index: 2, Header: "@@ -8,3 +8,3 @@", Status: "rejected", Error: "does not match at line 8", Expected: "eight\nNINE\nten"},
+				}},
+				{Path: "ok.txt", Status: "applied", Hunks: []HunkApply{{Index: 1, Header: "@@ -1,1 +1,1 @@", Status: "applied"}}},
+				{Path: "missing.txt", Status: "rejected", Error: "No such file or directory", Hunks: []HunkApply{{Index: 1, Header: "@@ -1,1 +1,1 @@", Status: "rejected"}}},
+			},
+		},
+		{
+			// hunks git never got to count as rejected
+			name: "unparsable diff",
+			diff: "not a diff",
+			out:  "Checking patch a.txt...\nerror: patch failed: a.txt:3\nApplying patch a.txt with 2 rejects...\nRejected hunk #1.\nRejected hunk #2.\n",
+			want: []FileApply{{Path: "a.txt", Status: "rejected", Error: "does not match at line 3", Hunks: []HunkApply{
+				{Index: 1, Status: "rejected"}, {Index: 2, Status: "rejected"},
+			}}},
+		},
+		{
+			name: "rename",
+			diff: "",
+			out:  "Checking patch old.txt => new.txt...\nApplied patch old.txt => new.txt cleanly.\n",
+			want: []FileApply{{Path: "new.txt", Status: "applied"}},
+		},
+	} {
+		got := rejections(tc.diff, tc.out)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: rejections =\n%+v\nwant\n%+v", tc.name, got, tc.want)
+		}
+	}
+}
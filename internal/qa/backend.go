@@ -0,0 +1,144 @@
+package qa
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Runner executes a QA run (clone, checkout, apply and test) in some
+// sandbox.
+type Runner interface {
+	// Name is recorded as qa.tests.runner.
+	Name() string
+	Run(ctx context.Context, opts RunOptions) (*RunResult, error)
+}
+
+// Limited is implemented by runners that can't honour every RunOptions
+// field. Unsupported returns one Skipped for each field set in opts that the
+// runner would reject.
+type Limited interface {
+	Unsupported(opts RunOptions) []Skipped
+}
+
+// Skipped records an option a run went without, and why.
+type Skipped struct {
+	// Option is "pipeline", "lint", "coverage", "mutation", "sandbox" or
+	// "sandbox pids".
+	Option string `json:"option"`
+	Reason string `json:"reason"`
+}
+
+// Degrade clears the options r can't honour, so a task that runs on one
+// backend runs on all of them, and returns what was dropped for the caller
+// to record. Without a pipeline the run uses the tests stage's command and
+// image.
+func Degrade(r Runner, opts RunOptions) (RunOptions, []Skipped) {
+	l, ok := r.(Limited)
+	if !ok {
+		return opts, nil
+	}
+	skipped := l.Unsupported(opts)
+	for _, s := range skipped {
+		switch s.Option {
+		case "pipeline":
+			for _, st := range opts.Pipeline.Stages {
+				if st.Tests {
+					opts.Command = st.Command
+					if st.Image != "" {
+						opts.Image = st.Image
+					}
+				}
+			}
+			opts.Pipeline = nil
+		case "lint":
+			opts.Lint = nil
+		case "coverage":
+			opts.Coverage = nil
+		case "mutation":
+			opts.Mutation = nil
+		case "sandbox":
+			opts.Sandbox = nil
+		case "sandbox pids":
+			sb := *opts.Sandbox
+			sb.Pids = nil
+			opts.Sandbox = &sb
+		}
+	}
+	return opts, skipped
+}
+
+// unsupported lists the optional stages set in opts, for runners that run
+// none of them.
+func unsupported(runner string, opts RunOptions) []Skipped {
+	var out []Skipped
+	for _, o := range []struct {
+		name string
+		set  bool
+	}{
+		{"pipeline", opts.Pipeline != nil}, {"lint", opts.Lint != nil},
+		{"coverage", opts.Coverage != nil}, {"mutation", opts.Mutation != nil},
+	} {
+		if o.set {
+			out = append(out, Skipped{Option: o.name, Reason: "not supported by the " + runner + " runner"})
+		}
+	}
+	return out
+}
+
+// rejectUnsupported is the error a Limited runner returns when it is
+// handed options Degrade would have dropped.
+func rejectUnsupported(runner string, skipped []Skipped) error {
+	names := make([]string, len(skipped))
+	for i, s := range skipped {
+		names[i] = s.Option
+	}
+	return fmt.Errorf("%s runner does not support: %s", runner, strings.Join(names, ", "))
+}
+
+// DockerRunner runs QA in containers on the daemon DOCKER_HOST points to
+// (DinD in docker-compose).
+type DockerRunner struct {
+	// Sandbox, when set, hardens the containers that run repository code
+	// and bounds the tasks' overrides.
+	Sandbox *SandboxPolicy
+}
+
+func (DockerRunner) Name() string { return "docker" }
+
+func (d DockerRunner) Run(ctx context.Context, opts RunOptions) (*RunResult, error) {
+	prof, err := d.Sandbox.Profile(opts.Sandbox)
+	if err != nil {
+		return nil, err
+	}
+	opts.profile = prof
+	return RunTests(ctx, opts)
+}
+
+// RunnerFromEnv picks the backend named by QA_RUNNER: docker (default),
+// kubernetes or local.
+func RunnerFromEnv() (Runner, error) {
+	switch name := os.Getenv("QA_RUNNER"); name {
+	case "", "docker":
+		p, err := SandboxPolicyFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return DockerRunner{Sandbox: p}, nil
+	case "kubernetes":
+		k, err := KubernetesRunnerFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return k, nil
+	case "local":
+		l, err := LocalRunnerFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return l, nil
+	default:
+		return nil, fmt.Errorf("unknown QA_RUNNER %q", name)
+	}
+}
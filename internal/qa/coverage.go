@@ -0,0 +1,221 @@
+package qa
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// Coverage formats.
+const (
+	CoverageGo        = "go"          // go test -coverprofile
+	CoveragePy        = "coverage.py" // coverage json
+	defaultGoProfile  = ".qa-coverage.out"
+	defaultPyProfile  = ".qa-coverage.json"
+	defaultGoCoverCmd = "go test -coverpkg=./... -coverprofile=" + defaultGoProfile + " ./..."
+	defaultPyCoverCmd = "coverage run -m pytest; coverage json -o " + defaultPyProfile
+)
+
+type CoverageOptions struct {
+	// Format is CoverageGo or CoveragePy.
+	Format string
+	// Command writes the profile; defaults per format.
+	Command string
+	// Profile is the profile path relative to the repository root.
+	Profile string
+}
+
+// FileCoverage is the diff coverage of one file: of the lines the patch
+// added or modified that the tool considers executable, how many ran.
+type FileCoverage struct {
+	Path      string   `json:"path"`
+	Covered   int      `json:"covered"`
+	Coverable int      `json:"coverable"`
+	Percent   *float64 `json:"percent"`
+	Uncovered []int    `json:"uncovered_lines"`
+}
+
+type CoverageReport struct {
+	Format    string `json:"format"`
+	Covered   int    `json:"covered"`
+	Coverable int    `json:"coverable"`
+	// Percent is nil when the patch changed no executable lines.
+	Percent *float64       `json:"percent"`
+	Files   []FileCoverage `json:"files"`
+	// Error is set when the stage could not produce a profile.
+	Error string `json:"error,omitempty"`
+}
+
+// lineCoverage maps a profile file name to its executable lines and whether
+// each ran.
+type lineCoverage map[string]map[int]bool
+
+// parseGoProfile reads a coverprofile. Its file names are import paths.
+func parseGoProfile(b []byte) (lineCoverage, error) {
+	out := lineCoverage{}
+	sc := bufio.NewScanner(strings.NewReader(string(b)))
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		// name.go:startLine.startCol,endLine.endCol numStmts count
+		colon := strings.LastIndex(line, ":")
+		fields := strings.Fields(line[colon+1:])
+		if colon < 0 || len(fields) != 3 {
+			return nil, fmt.Errorf("bad coverprofile line %q", line)
+		}
+		start, end, _ := strings.Cut(fields[0], ",")
+		from, _ := strconv.Atoi(strings.Split(start, ".")[0])
+		to, _ := strconv.Atoi(strings.Split(end, ".")[0])
+		count, _ := strconv.Atoi(fields[2])
+		name := line[:colon]
+		if out[name] == nil {
+			out[name] = map[int]bool{}
+		}
+		for n := from; n <= to; n++ {
+			out[name][n] = out[name][n] || count > 0
+		}
+	}
+	return out, sc.Err()
+}
+
+// parsePyCoverage reads `coverage json` output.
+func parsePyCoverage(b []byte) (lineCoverage, error) {
+	var doc struct {
+		Files map[string]struct {
+			ExecutedLines []int `json:"executed_lines"`
+			MissingLines  []int `json:"missing_lines"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	out := lineCoverage{}
+	for name, f := range doc.Files {
+		m := map[int]bool{}
+		for _, n := range f.MissingLines {
+			m[n] = false
+		}
+		for _, n := range f.ExecutedLines {
+			m[n] = true
+		}
+		out[name] = m
+	}
+	return out, nil
+}
+
+// lookup finds a repository path in the profile, whose names may be import
+// paths or absolute paths.
+func (lc lineCoverage) lookup(p string) map[int]bool {
+	if m, ok := lc[p]; ok {
+		return m
+	}
+	for name, m := range lc {
+		if strings.HasSuffix(name, "/"+p) {
+			return m
+		}
+	}
+	return nil
+}
+
+// DiffCoverage maps a coverage profile onto the lines the patch added or
+// modified.
+func DiffCoverage(format string, profile []byte, diff string) (*CoverageReport, error) {
+	var lc lineCoverage
+	var err error
+	switch format {
+	case CoverageGo:
+		lc, err = parseGoProfile(profile)
+	case CoveragePy:
+		lc, err = parsePyCoverage(profile)
+	default:
+		return nil, fmt.Errorf("unknown coverage format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	changed, err := ChangedLines(diff)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := &CoverageReport{Format: format, Files: []FileCoverage{}}
+	for p, lines := range changed {
+		fc := FileCoverage{Path: p, Uncovered: []int{}}
+		cov := lc.lookup(p)
+		for _, n := range lines {
+			ran, ok := cov[n]
+			if !ok {
+				continue // not executable
+			}
+			fc.Coverable++
+			if ran {
+				fc.Covered++
+			} else {
+				fc.Uncovered = append(fc.Uncovered, n)
+			}
+		}
+		fc.Percent = percent(fc.Covered, fc.Coverable)
+		rep.Covered += fc.Covered
+		rep.Coverable += fc.Coverable
+		rep.Files = append(rep.Files, fc)
+	}
+	sort.Slice(rep.Files, func(i, j int) bool { return rep.Files[i].Path < rep.Files[j].Path })
+	rep.Percent = percent(rep.Covered, rep.Coverable)
+	return rep, nil
+}
+
+func percent(n, of int) *float64 {
+	if of == 0 {
+		return nil
+	}
+	p := float64(n) * 100 / float64(of)
+	return &p
+}
+
+// runCoverage runs the coverage command in the same sandbox as the tests
+// and maps the profile it writes onto the patch.
+func runCoverage(ctx context.Context, cli *client.Client, volName string, opts RunOptions) (*CoverageReport, error) {
+	co := *opts.Coverage
+	if co.Format == "" {
+		co.Format = CoveragePy
+		if strings.Contains(opts.Command, "go test") {
+			co.Format = CoverageGo
+		}
+	}
+	if co.Profile == "" {
+		co.Profile = map[string]string{CoverageGo: defaultGoProfile, CoveragePy: defaultPyProfile}[co.Format]
+	}
+	if co.Command == "" {
+		co.Command = map[string]string{CoverageGo: defaultGoCoverCmd, CoveragePy: defaultPyCoverCmd}[co.Format]
+	}
+	if !reportGlob.MatchString(co.Profile) || strings.ContainsAny(co.Profile, "*?") || strings.Contains(co.Profile, "..") {
+		return nil, fmt.Errorf("invalid coverage profile path %q", co.Profile)
+	}
+
+	copts := opts
+	copts.Command = fmt.Sprintf("rm -f -- %s; %s", co.Profile, co.Command)
+	copts.Reports, copts.Format = nil, ""
+	res, err := runTestCommand(ctx, cli, volName, copts)
+	if err != nil {
+		return nil, err
+	}
+	files, err := copyFilesFromVolume(ctx, cli, volName, []string{co.Profile})
+	if err != nil {
+		return nil, err
+	}
+	profile, ok := files[co.Profile]
+	if !ok {
+		log.Printf("QA runner: coverage command exited %d without writing %s", res.ExitCode, co.Profile)
+		return nil, fmt.Errorf("coverage profile %s not written (exit %d)", co.Profile, res.ExitCode)
+	}
+	return DiffCoverage(co.Format, profile, opts.Patch)
+}
@@ -0,0 +1,78 @@
+package qa
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDiffCoverage(t *testing.T) {
+	// calc.go lines 3-4 were replaced, 9 added; util.go gained line 2
+	diff := "--- a/calc.go\n+++ b/calc.go\n@@ -3,2 +3,2 @@\n-\tif a > b {\n-\t\treturn a\n+\tif a >= b {\n+\t\treturn b\n@@ -8,0 +9 @@\n+\treturn 0\n" +
+		"--- a/util.go\n+++ b/util.go\n@@ -1 +1,2 @@\n package calc\n+var debug = true\n" +
+		"--- a/calc_test.go\n+++ b/calc_test.go\n@@ -1 +1 @@\n-x\n+y\n"
+	goProfile := "mode: set\n" +
+		"example.com/calc/calc.go:3.12,4.11 1 1\n" +
+		"example.com/calc/calc.go:9.2,9.10 1 0\n" +
+		"example.com/calc/calc_test.go:1.1,1.2 1 0\n"
+	for _, tc := range []struct {
+		name, format, profile, diff string
+		want                        []string // path covered/coverable uncovered
+		percent                     string
+	}{
+		{
+			"go import paths", CoverageGo, goProfile, diff,
+			[]string{"calc.go 2/3 [9]", "util.go 0/0 []"}, "66.7",
+		},
+		{
+			"line in a covered and an uncovered block", CoverageGo,
+			"mode: count\nexample.com/calc/calc.go:3.1,4.5 1 0\nexample.com/calc/calc.go:4.1,4.9 1 3\n", diff,
+			[]string{"calc.go 1/2 [3]", "util.go 0/0 []"}, "50.0",
+		},
+		{
+			"python", CoveragePy,
+			`{"files": {"/repo/calc.py": {"executed_lines": [2], "missing_lines": [3, 7]}}}`,
+			"--- a/calc.py\n+++ b/calc.py\n@@ -2,2 +2,3 @@\n-x = 1\n+x = 2\n+y = 3\n def f():\n",
+			[]string{"calc.py 1/2 [3]"}, "50.0",
+		},
+		{
+			"no executable lines", CoverageGo, "mode: set\n",
+			"--- a/calc.go\n+++ b/calc.go\n@@ -1 +1 @@\n-// a\n+// b\n",
+			[]string{"calc.go 0/0 []"}, "nil",
+		},
+		{
+			"deleted file", CoverageGo, goProfile,
+			"diff --git a/calc.go b/calc.go\ndeleted file mode 100644\n--- a/calc.go\n+++ /dev/null\n@@ -1 +0,0 @@\n-package calc\n",
+			nil, "nil",
+		},
+	} {
+		rep, err := DiffCoverage(tc.format, []byte(tc.profile), tc.diff)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var got []string
+		for _, f := range rep.Files {
+			got = append(got, fmt.Sprintf("%s %d/%d %v", f.Path, f.Covered, f.Coverable, f.Uncovered))
+		}
+		pct := "nil"
+		if rep.Percent != nil {
+			pct = fmt.Sprintf("%.1f", *rep.Percent)
+		}
+		if !reflect.DeepEqual(got, tc.want) || pct != tc.percent {
+			t.Errorf("%s: files %q percent %s, want %q percent %s", tc.name, got, pct, tc.want, tc.percent)
+		}
+	}
+}
+
+func TestDiffCoverageErrors(t *testing.T) {
+	for _, tc := range []struct{ name, format, profile string }{
+		{"unknown format", "lcov", "TN:\n"},
+		{"bad go profile", CoverageGo, "mode: set\ncalc.go 1 1\n"},
+		{"bad python json", CoveragePy, "{"},
+	} {
+		if _, err := DiffCoverage(tc.format, []byte(tc.profile), ""); err == nil {
+			t.Errorf("%s: no error", tc.name)
+		}
+	}
+}
@@ -0,0 +1,759 @@
+package qa
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"datacurve-takehome/internal/vault"
+)
+
+// KubernetesRunner runs QA as one Pod per run, for clusters where a
+// privileged DinD sidecar isn't allowed. The worker checks the start tree
+// out itself, like the local backend (so mirrors, snapshots and credentials
+// never leave it), and streams it into the Pod's first init container over
+// exec. Baseline, reset, apply and the tests follow as init containers
+// sharing the checkout through an emptyDir; the patch is streamed into a
+// waiting init container, which the apply fallbacks run in over exec, and
+// the test containers' logs are followed while they run. Hidden tests are
+// streamed into a volume only the step that copies them into the checkout
+// mounts. Pipelines, lint, coverage and mutation aren't supported (see
+// Unsupported).
+//
+// Since nothing in the Pod needs the network, a deny-all NetworkPolicy
+// selects it for the run; it is only enforced with a network plugin that
+// implements NetworkPolicy. Test containers get the same 1 GiB / 2 CPU
+// limits as the Docker backend, and every container runs under the sandbox
+// profile (see podSecurity).
+type KubernetesRunner struct {
+	Client kubernetes.Interface
+	// Config is Client's REST config, used to exec into the Pod.
+	Config    *rest.Config
+	Namespace string
+	// GitImage runs receive, reset and apply; default alpine/git:latest.
+	// The patch-fuzz fallback runs in it too, so it is only available when
+	// the image has GNU patch.
+	GitImage string
+	// Dir holds the worker's checkouts; default os.TempDir().
+	Dir string
+	// PollInterval is how often the Pod's phase is checked; default 2s.
+	PollInterval time.Duration
+	// Sandbox, when set, hardens every container of the Pod and bounds the
+	// tasks' overrides.
+	Sandbox *SandboxPolicy
+
+	// exec and logs replace the Pod exec and log APIs in tests.
+	exec func(ctx context.Context, pod, container string, cmd []string, stdin io.Reader) (string, int, error)
+	logs func(ctx context.Context, pod, container string) (string, error)
+}
+
+// KubernetesRunnerFromEnv uses the in-cluster config, or KUBECONFIG outside
+// a cluster. QA_K8S_NAMESPACE sets the namespace (default "default") and the
+// QA_SANDBOX_* settings the sandbox profile, except QA_SANDBOX_SECCOMP:
+// Pods use the runtime's default seccomp profile.
+func KubernetesRunnerFromEnv() (*KubernetesRunner, error) {
+	p, err := SandboxPolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if p != nil && p.Default.Seccomp != "" {
+		return nil, errors.New("QA_SANDBOX_SECCOMP is not supported by the kubernetes runner, whose Pods use the RuntimeDefault seccomp profile")
+	}
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		cfg, err = clientcmd.BuildConfigFromFlags("", os.Getenv("KUBECONFIG"))
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes config: %w", err)
+		}
+	}
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ns := os.Getenv("QA_K8S_NAMESPACE")
+	if ns == "" {
+		ns = "default"
+	}
+	return &KubernetesRunner{Client: cs, Config: cfg, Namespace: ns, Sandbox: p}, nil
+}
+
+func (k *KubernetesRunner) Name() string { return "kubernetes" }
+
+// Markers the wrapped test commands print so one log stream carries the
+// exit code and report files.
+const (
+	k8sExitMarker      = "__QA_EXIT__ "
+	k8sReportMarker    = "__QA_REPORT__ "
+	k8sReportEndMarker = "__QA_REPORT_END__"
+)
+
+// The receive and apply init containers wait for these files in /qa, which
+// the runner creates once it is done exec'ing into them. The patch is
+// streamed into /qa too, which the test containers don't mount.
+const (
+	k8sReceived = "/qa/received"
+	k8sApplied  = "/qa/applied"
+	k8sPatch    = "/qa/patch.diff"
+)
+
+const k8sResetScript = "git -C /repo reset -q --hard && git -C /repo clean -fdq"
+
+// Unsupported implements Limited: the Pod runs the tests but none of the
+// optional stages, and its spec has no PIDs limit.
+func (k *KubernetesRunner) Unsupported(opts RunOptions) []Skipped {
+	out := unsupported("kubernetes", opts)
+	if opts.Sandbox != nil && opts.Sandbox.Pids != nil {
+		out = append(out, Skipped{Option: "sandbox pids", Reason: "Pods have no PIDs limit of their own; the kubelet's podPidsLimit applies"})
+	}
+	return out
+}
+
+// Run expects options Degrade has already trimmed and rejects the rest.
+func (k *KubernetesRunner) Run(ctx context.Context, opts RunOptions) (*RunResult, error) {
+	if s := k.Unsupported(opts); len(s) > 0 {
+		return nil, rejectUnsupported("kubernetes", s)
+	}
+	for _, g := range opts.Reports {
+		if !reportGlob.MatchString(g) || strings.Contains(g, "..") {
+			return nil, fmt.Errorf("invalid report path %q", g)
+		}
+	}
+	prof, err := k.Sandbox.Profile(opts.Sandbox)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, runTimeout(opts))
+	defer cancel()
+	work, err := os.MkdirTemp(k.Dir, "qa-k8s-")
+	if err != nil {
+		return nil, fmt.Errorf("temp dir: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(work); err != nil {
+			log.Printf("warn: remove %s: %v", work, err)
+		}
+	}()
+	repo := filepath.Join(work, "repo")
+	if err := localCheckout(ctx, work, repo, opts); err != nil {
+		return nil, err
+	}
+	hidden := filepath.Join(work, "hidden")
+	if opts.Hidden != nil {
+		if err := extractLocal(ctx, work, hidden, "hidden.tar", opts.Hidden.Archive); err != nil {
+			return nil, fmt.Errorf("copy hidden tests: %w", err)
+		}
+	}
+
+	name := fmt.Sprintf("qa-run-%d", time.Now().UnixNano())
+	pod, err := k.pod(name, opts, prof)
+	if err != nil {
+		return nil, err
+	}
+	nps := k.Client.NetworkingV1().NetworkPolicies(k.Namespace)
+	pods := k.Client.CoreV1().Pods(k.Namespace)
+
+	// created before the Pod, so it never runs without it
+	if _, err := nps.Create(ctx, k8sNetworkPolicy(name), metav1.CreateOptions{}); err != nil {
+		return nil, fmt.Errorf("create network policy: %w", err)
+	}
+	defer func() {
+		if err := nps.Delete(context.Background(), name, metav1.DeleteOptions{}); err != nil {
+			log.Printf("warn: delete network policy %s: %v", name, err)
+		}
+	}()
+
+	log.Printf("QA runner: creating pod %s/%s", k.Namespace, name)
+	if _, err := pods.Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		return nil, fmt.Errorf("create pod: %w", err)
+	}
+	defer func() {
+		if err := pods.Delete(context.Background(), name, metav1.DeleteOptions{}); err != nil {
+			log.Printf("warn: delete pod %s: %v", name, err)
+		}
+	}()
+	var baselineLog, testsLog, hiddenLog func() (string, error)
+	if opts.Baseline {
+		baselineLog = k.follow(ctx, name, "baseline")
+	}
+	testsLog = k.follow(ctx, name, "tests")
+	if opts.Hidden != nil {
+		hiddenLog = k.follow(ctx, name, "hidden")
+	}
+
+	log.Println("QA runner: copying the checkout into the pod")
+	if err := k.waitRunning(ctx, name, "receive", opts); err != nil {
+		return nil, err
+	}
+	if err := k.send(ctx, name, "receive", repo, "tar -xof - -C /repo"); err != nil {
+		return nil, fmt.Errorf("copy checkout: %w", err)
+	}
+	if opts.Hidden != nil {
+		if err := k.send(ctx, name, "receive", hidden, "cat > /hidden/hidden.tar"); err != nil {
+			return nil, fmt.Errorf("copy hidden tests: %w", err)
+		}
+	}
+	if err := k.touch(ctx, name, "receive", k8sReceived); err != nil {
+		return nil, err
+	}
+
+	out := &RunResult{Sandbox: prof}
+	if strings.TrimSpace(opts.Patch) != "" {
+		if err := k.waitRunning(ctx, name, "apply", opts); err != nil {
+			return out, err
+		}
+		// over exec rather than from a ConfigMap, which caps at 1 MiB
+		if err := k.put(ctx, name, "apply", k8sPatch, opts.Patch); err != nil {
+			return out, fmt.Errorf("copy patch: %w", err)
+		}
+		log.Println("QA runner: applying patch")
+		out.Apply, err = applyPatch(ctx, k8sApplier(k, name), opts.Patch)
+		if err != nil {
+			return out, fmt.Errorf("apply patch: %w", err)
+		}
+		if err := k.touch(ctx, name, "apply", k8sApplied); err != nil {
+			return out, err
+		}
+	}
+
+	done, err := k.wait(ctx, name, podFinished)
+	if err != nil {
+		return out, fmt.Errorf("wait for pod: %w", err)
+	}
+	if err := k.initFailure(ctx, done, opts); err != nil {
+		return out, err
+	}
+
+	if opts.Baseline {
+		if out.Baseline, err = k8sTestResult(baselineLog, opts); err != nil {
+			return out, fmt.Errorf("baseline test run: %w", err)
+		}
+	}
+	if out.Patched, err = k8sTestResult(testsLog, opts); err != nil {
+		return out, fmt.Errorf("test run: %w", err)
+	}
+	if h := opts.Hidden; h != nil {
+		hopts := opts
+		if h.Command != "" {
+			hopts.Command = h.Command
+		}
+		hres, err := k8sTestResult(hiddenLog, hopts)
+		if err != nil {
+			return out, fmt.Errorf("hidden test run: %w", err)
+		}
+		out.Hidden = hiddenResult(out.Patched, hres)
+	}
+	return out, nil
+}
+
+func k8sLabels(name string) map[string]string {
+	return map[string]string{"app.kubernetes.io/name": "qa-runner", "qa-run": name}
+}
+
+// k8sNetworkPolicy denies all ingress and egress to the run's Pod, cluster
+// API and DNS included.
+func k8sNetworkPolicy(name string) *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: k8sLabels(name)},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"qa-run": name}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+		},
+	}
+}
+
+func (k *KubernetesRunner) pod(name string, opts RunOptions, prof *SandboxProfile) (*corev1.Pod, error) {
+	gitImage := k.GitImage
+	if gitImage == "" {
+		gitImage = "alpine/git:latest"
+	}
+	podSec, secure, err := podSecurity(prof)
+	if err != nil {
+		return nil, err
+	}
+	var env []corev1.EnvVar
+	if prof != nil && !prof.root() {
+		// the user has no home in the image; /tmp is writable
+		env = append(env, corev1.EnvVar{Name: "HOME", Value: "/tmp"})
+	}
+	repo := corev1.VolumeMount{Name: "repo", MountPath: "/repo"}
+	ctl := corev1.VolumeMount{Name: "qa", MountPath: "/qa"}
+	hidden := corev1.VolumeMount{Name: "hidden", MountPath: "/hidden"}
+	limits := corev1.ResourceList{
+		corev1.ResourceMemory: resource.MustParse("1Gi"),
+		corev1.ResourceCPU:    resource.MustParse("2"),
+	}
+
+	var volumes []corev1.Volume
+	// each container gets its own /tmp, like the Docker tmpfs
+	container := func(cname, image, script string, mounts ...corev1.VolumeMount) corev1.Container {
+		c := corev1.Container{
+			Name: cname, Image: image, Command: []string{"sh", "-c", script},
+			Env: env, VolumeMounts: mounts, SecurityContext: secure,
+		}
+		if prof != nil {
+			tmp := "tmp-" + cname
+			size := resource.MustParse(fmt.Sprintf("%dMi", prof.TmpMB))
+			volumes = append(volumes, corev1.Volume{Name: tmp, VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory, SizeLimit: &size},
+			}})
+			c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: tmp, MountPath: "/tmp"})
+		}
+		return c
+	}
+	git := func(cname, script string, mounts ...corev1.VolumeMount) corev1.Container {
+		c := container(cname, gitImage, script, append([]corev1.VolumeMount{repo}, mounts...)...)
+		// /repo itself is the emptyDir's, not the sandbox user's
+		c.Env = append(append([]corev1.EnvVar{}, c.Env...),
+			corev1.EnvVar{Name: "GIT_CONFIG_COUNT", Value: "1"},
+			corev1.EnvVar{Name: "GIT_CONFIG_KEY_0", Value: "safe.directory"},
+			corev1.EnvVar{Name: "GIT_CONFIG_VALUE_0", Value: "/repo"},
+		)
+		return c
+	}
+	test := func(cname string, opts RunOptions) corev1.Container {
+		c := container(cname, opts.Image, k8sTestScript(opts, prof), repo)
+		c.Resources = corev1.ResourceRequirements{Limits: limits, Requests: limits}
+		return c
+	}
+	wait := func(file string) string {
+		return fmt.Sprintf("until [ -e %s ]; do sleep 1; done", file)
+	}
+
+	receive := []corev1.VolumeMount{ctl}
+	if opts.Hidden != nil {
+		receive = append(receive, hidden)
+	}
+	inits := []corev1.Container{git("receive", wait(k8sReceived), receive...)}
+	if opts.Baseline {
+		inits = append(inits, test("baseline", opts), git("reset", k8sResetScript))
+	}
+	if strings.TrimSpace(opts.Patch) != "" {
+		inits = append(inits, git("apply", wait(k8sApplied), ctl))
+	}
+	main := test("tests", opts)
+	if h := opts.Hidden; h != nil {
+		hopts := opts
+		if h.Command != "" {
+			hopts.Command = h.Command
+		}
+		inits = append(inits, main, git("hidden-copy", "tar -xof /hidden/hidden.tar -C /repo", hidden))
+		main = test("hidden", hopts)
+	}
+
+	repoVol := &corev1.EmptyDirVolumeSource{}
+	if prof != nil && prof.RepoMB > 0 {
+		size := resource.MustParse(fmt.Sprintf("%dMi", prof.RepoMB))
+		repoVol.SizeLimit = &size
+	}
+	volumes = append(volumes,
+		corev1.Volume{Name: "repo", VolumeSource: corev1.VolumeSource{EmptyDir: repoVol}},
+		corev1.Volume{Name: "qa", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	)
+	if opts.Hidden != nil {
+		volumes = append(volumes, corev1.Volume{Name: "hidden", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}})
+	}
+
+	deadline := int64(runTimeout(opts) / time.Second)
+	noToken := false
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: k8sLabels(name)},
+		Spec: corev1.PodSpec{
+			RestartPolicy:                corev1.RestartPolicyNever,
+			ActiveDeadlineSeconds:        &deadline,
+			AutomountServiceAccountToken: &noToken,
+			SecurityContext:              podSec,
+			InitContainers:               inits,
+			Containers:                   []corev1.Container{main},
+			Volumes:                      volumes,
+		},
+	}, nil
+}
+
+// podSecurity maps the sandbox profile onto the Pod: every container runs
+// as the profile's user with all capabilities dropped, no privilege
+// escalation, the runtime's default seccomp profile and, unless the task
+// was allowed otherwise, a read-only root filesystem. The nofile and fsize
+// limits are set by the test script; the PIDs limit is the kubelet's. A nil
+// profile only forbids privilege escalation.
+func podSecurity(prof *SandboxProfile) (*corev1.PodSecurityContext, *corev1.SecurityContext, error) {
+	noEscalation := false
+	secure := &corev1.SecurityContext{AllowPrivilegeEscalation: &noEscalation}
+	if prof == nil {
+		return nil, secure, nil
+	}
+	uidStr, gidStr, ok := strings.Cut(prof.User, ":")
+	if !ok {
+		gidStr = uidStr
+	}
+	uid, err := strconv.ParseInt(uidStr, 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("kubernetes runner needs a numeric sandbox user, got %q", prof.User)
+	}
+	gid, err := strconv.ParseInt(gidStr, 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("kubernetes runner needs a numeric sandbox group, got %q", prof.User)
+	}
+	nonRoot := uid != 0
+	readOnly := prof.ReadOnlyRootfs
+	secure.Capabilities = &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}}
+	secure.ReadOnlyRootFilesystem = &readOnly
+	return &corev1.PodSecurityContext{
+		RunAsUser:      &uid,
+		RunAsGroup:     &gid,
+		RunAsNonRoot:   &nonRoot,
+		FSGroup:        &gid,
+		SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}, secure, nil
+}
+
+// k8sTestScript runs the test command in a subshell so its exit status can
+// be printed, then prints the report files base64-encoded. It always exits
+// 0 so a failing baseline doesn't stop the Pod. Under a sandbox profile the
+// subshell sets the nofile and fsize (512-byte blocks) limits first.
+func k8sTestScript(opts RunOptions, prof *SandboxProfile) string {
+	limits := ""
+	if prof != nil {
+		limits = fmt.Sprintf("ulimit -n %d || exit 125; ulimit -f %d || exit 125; ", prof.NoFile, prof.FileSizeMB<<11)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "(%scd /repo && %s); code=$?; cd /repo; ", limits, withReportCleanup(opts, opts.Command))
+	if len(opts.Reports) > 0 {
+		fmt.Fprintf(&b, `for f in %s; do [ -f "$f" ] && { echo "%s$f"; base64 "$f"; echo "%s"; }; done; `,
+			strings.Join(opts.Reports, " "), k8sReportMarker, k8sReportEndMarker)
+	}
+	fmt.Fprintf(&b, `echo "%s$code"; exit 0`, k8sExitMarker)
+	return b.String()
+}
+
+// k8sApplier runs the apply steps over exec in the waiting apply container.
+func k8sApplier(k *KubernetesRunner, pod string) patchApplier {
+	run := func(ctx context.Context, cmd ...string) (string, int, error) {
+		return k.podExec(ctx, pod, "apply", cmd, nil)
+	}
+	return patchApplier{
+		git: func(ctx context.Context, args ...string) (string, int, error) {
+			return run(ctx, append(append([]string{"git", "-C", "/repo", "apply"}, args...), k8sPatch)...)
+		},
+		patchFuzz: func(ctx context.Context) (string, int, error) {
+			return run(ctx, "sh", "-c", "cd /repo || exit 1; "+patchFuzzScript, "sh", k8sPatch)
+		},
+		reset: func(ctx context.Context) error {
+			out, code, err := run(ctx, "sh", "-c", k8sResetScript)
+			if err != nil {
+				return err
+			}
+			if code != 0 {
+				return fmt.Errorf("exit code=%d\n%s", code, out)
+			}
+			return nil
+		},
+	}
+}
+
+// send streams dir as a tar archive into script's stdin in container.
+func (k *KubernetesRunner) send(ctx context.Context, pod, container, dir, script string) error {
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(writeTar(pw, dir)) }()
+	out, code, err := k.podExec(ctx, pod, container, []string{"sh", "-c", script}, pr)
+	pr.Close()
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("exit code=%d\n%s", code, out)
+	}
+	return nil
+}
+
+// put writes content to file in container.
+func (k *KubernetesRunner) put(ctx context.Context, pod, container, file, content string) error {
+	out, code, err := k.podExec(ctx, pod, container, []string{"sh", "-c", "cat > " + file}, strings.NewReader(content))
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("exit code=%d\n%s", code, out)
+	}
+	return nil
+}
+
+func (k *KubernetesRunner) touch(ctx context.Context, pod, container, file string) error {
+	out, code, err := k.podExec(ctx, pod, container, []string{"touch", file}, nil)
+	if err == nil && code != 0 {
+		err = fmt.Errorf("exit code=%d\n%s", code, out)
+	}
+	if err != nil {
+		return fmt.Errorf("signal %s: %w", container, err)
+	}
+	return nil
+}
+
+// writeTar writes the tree under dir to w. The archive has no entry for
+// dir itself, so extracting it doesn't touch the target directory, which
+// the sandbox user doesn't own.
+func writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		hdr.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uname, hdr.Gname = "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func podFinished(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// wait polls the Pod until done reports true for it.
+func (k *KubernetesRunner) wait(ctx context.Context, name string, done func(*corev1.Pod) bool) (*corev1.Pod, error) {
+	interval := k.PollInterval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	for {
+		pod, err := k.Client.CoreV1().Pods(k.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if done(pod) {
+			return pod, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// waitRunning waits for one of the init containers the runner execs into.
+// If the Pod ends first, an earlier init container failed.
+func (k *KubernetesRunner) waitRunning(ctx context.Context, name, container string, opts RunOptions) error {
+	running := func(pod *corev1.Pod) bool {
+		for _, st := range pod.Status.InitContainerStatuses {
+			if st.Name == container && st.State.Running != nil {
+				return true
+			}
+		}
+		return false
+	}
+	pod, err := k.wait(ctx, name, func(p *corev1.Pod) bool { return podFinished(p) || running(p) })
+	if err != nil {
+		return fmt.Errorf("wait for %s: %w", container, err)
+	}
+	if running(pod) {
+		return nil
+	}
+	if err := k.initFailure(ctx, pod, opts); err != nil {
+		return err
+	}
+	return fmt.Errorf("pod %s ended before %s started: %s %s", name, container, pod.Status.Reason, pod.Status.Message)
+}
+
+// initFailure reports the first init container that failed, with its log.
+// A failed baseline or test run exits 0, so this is the receive, reset,
+// apply or hidden-copy step, or the Pod was killed.
+func (k *KubernetesRunner) initFailure(ctx context.Context, pod *corev1.Pod, opts RunOptions) error {
+	for _, st := range pod.Status.InitContainerStatuses {
+		if t := st.State.Terminated; t != nil && t.ExitCode != 0 {
+			logs, _ := k.podLogs(ctx, pod.Name, st.Name, false)
+			return fmt.Errorf("%s failed (exit=%d)\n%s", st.Name, t.ExitCode, vault.Scrub(logs, opts.Credential))
+		}
+	}
+	if pod.Status.Phase == corev1.PodFailed && pod.Status.Reason != "" {
+		return fmt.Errorf("pod failed: %s: %s", pod.Status.Reason, pod.Status.Message)
+	}
+	return nil
+}
+
+// podExec runs cmd in a container of the Pod and returns its combined
+// output and exit code.
+func (k *KubernetesRunner) podExec(ctx context.Context, pod, container string, cmd []string, stdin io.Reader) (string, int, error) {
+	if k.exec != nil {
+		return k.exec(ctx, pod, container, cmd, stdin)
+	}
+	if k.Config == nil {
+		return "", 0, errors.New("exec into the pod needs KubernetesRunner.Config")
+	}
+	req := k.Client.CoreV1().RESTClient().Post().
+		Namespace(k.Namespace).Resource("pods").Name(pod).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container, Command: cmd, Stdin: stdin != nil, Stdout: true, Stderr: true,
+		}, scheme.ParameterCodec)
+	ex, err := remotecommand.NewSPDYExecutor(k.Config, "POST", req.URL())
+	if err != nil {
+		return "", 0, err
+	}
+	var stdout, stderr bytes.Buffer
+	err = ex.StreamWithContext(ctx, remotecommand.StreamOptions{Stdin: stdin, Stdout: &stdout, Stderr: &stderr})
+	out := stdout.String() + stderr.String()
+	var exit utilexec.ExitError
+	if errors.As(err, &exit) {
+		return out, exit.ExitStatus(), nil
+	}
+	return out, 0, err
+}
+
+// podLogs reads one container's log; with follow set it keeps reading until
+// the container exits.
+func (k *KubernetesRunner) podLogs(ctx context.Context, pod, container string, follow bool) (string, error) {
+	if k.logs != nil {
+		return k.logs(ctx, pod, container)
+	}
+	rc, err := k.Client.CoreV1().Pods(k.Namespace).GetLogs(pod, &corev1.PodLogOptions{Container: container, Follow: follow}).Stream(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	return string(b), err
+}
+
+// follow starts reading a container's log as soon as it starts and until
+// it exits, so test output is collected while it is produced rather than
+// after the Pod is done. The returned func waits for the log.
+func (k *KubernetesRunner) follow(ctx context.Context, pod, container string) func() (string, error) {
+	var raw string
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		started := func(p *corev1.Pod) bool { return podFinished(p) || containerStarted(p, container) }
+		if _, err = k.wait(ctx, pod, started); err == nil {
+			raw, err = k.podLogs(ctx, pod, container, true)
+		}
+	}()
+	return func() (string, error) {
+		<-done
+		return raw, err
+	}
+}
+
+func containerStarted(pod *corev1.Pod, container string) bool {
+	for _, st := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if st.Name == container && (st.State.Running != nil || st.State.Terminated != nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// k8sTestResult turns a wrapped test container's log back into a
+// TestResult. The Pod API merges stdout and stderr, so everything lands in
+// Stdout.
+func k8sTestResult(logs func() (string, error), opts RunOptions) (*TestResult, error) {
+	raw, err := logs()
+	if err != nil {
+		return nil, err
+	}
+	res, reports, err := parseK8sLog(raw)
+	if err != nil {
+		return res, err
+	}
+	fillCases(res, Output{Stdout: res.Stdout, Reports: reports}, opts.Format)
+	return res, nil
+}
+
+func parseK8sLog(raw string) (*TestResult, map[string][]byte, error) {
+	res := &TestResult{ExitCode: -1}
+	reports := map[string][]byte{}
+	var out strings.Builder
+	var report string
+	var enc strings.Builder
+	sawExit := false
+	sc := bufio.NewScanner(strings.NewReader(raw))
+	sc.Buffer(make([]byte, 1024*1024), maxReportSize)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case report != "":
+			if line == k8sReportEndMarker {
+				if b, err := base64.StdEncoding.DecodeString(enc.String()); err == nil {
+					reports[report] = b
+				}
+				report = ""
+				enc.Reset()
+			} else {
+				enc.WriteString(strings.TrimSpace(line))
+			}
+		case strings.HasPrefix(line, k8sReportMarker):
+			report = strings.TrimPrefix(line, k8sReportMarker)
+		case strings.HasPrefix(line, k8sExitMarker):
+			res.ExitCode, _ = strconv.Atoi(strings.TrimPrefix(line, k8sExitMarker))
+			sawExit = true
+		default:
+			out.WriteString(line)
+			out.WriteByte('\n')
+		}
+	}
+	res.Stdout = out.String()
+	if err := sc.Err(); err != nil {
+		return res, reports, err
+	}
+	if !sawExit {
+		return res, reports, errors.New("test container log has no exit status")
+	}
+	res.OK = res.ExitCode == 0
+	return res, reports, nil
+}
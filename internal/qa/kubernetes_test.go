@@ -0,0 +1,464 @@
+package qa
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeCluster plays the kubelet for one run: a reactor reports the Pod's
+// phase and container states from what the runner has exec'd so far.
+type fakeCluster struct {
+	t  *testing.T
+	cs *fake.Clientset
+
+	mu       sync.Mutex
+	pod      *corev1.Pod
+	policy   *networkingv1.NetworkPolicy
+	cm       *corev1.ConfigMap
+	patch    string
+	received bool
+	applied  bool
+	sent     map[string][]string // files streamed in, by exec script
+	execs    []string
+	// applyExit is the exit code of `git apply` with the given args
+	applyExit func(args []string) int
+	// failInit makes an init container exit non-zero
+	failInit string
+	logs     map[string]string
+}
+
+func newFakeCluster(t *testing.T) (*fakeCluster, *KubernetesRunner) {
+	f := &fakeCluster{t: t, cs: fake.NewSimpleClientset(), sent: map[string][]string{}, logs: map[string]string{}}
+	f.cs.PrependReactor("create", "*", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		switch o := a.(k8stesting.CreateAction).GetObject().(type) {
+		case *corev1.Pod:
+			if f.policy == nil {
+				t.Error("pod created before its network policy")
+			}
+			f.pod = o.DeepCopy()
+		case *networkingv1.NetworkPolicy:
+			f.policy = o.DeepCopy()
+		case *corev1.ConfigMap:
+			f.cm = o.DeepCopy()
+		}
+		return false, nil, nil
+	})
+	f.cs.PrependReactor("get", "pods", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return true, f.status(), nil
+	})
+	k := &KubernetesRunner{
+		Client: f.cs, Namespace: "qa", Dir: t.TempDir(), PollInterval: 1,
+		exec: f.exec,
+		logs: func(_ context.Context, _, container string) (string, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			return f.logs[container], nil
+		},
+	}
+	return f, k
+}
+
+// status runs the init containers up to the first one waiting on the
+// runner, or to the end.
+func (f *fakeCluster) status() *corev1.Pod {
+	pod := f.pod.DeepCopy()
+	pod.Status.Phase = corev1.PodPending
+	for _, c := range pod.Spec.InitContainers {
+		st := corev1.ContainerStatus{Name: c.Name}
+		switch {
+		case c.Name == "receive" && !f.received, c.Name == "apply" && !f.applied:
+			st.State.Running = &corev1.ContainerStateRunning{}
+			pod.Status.InitContainerStatuses = append(pod.Status.InitContainerStatuses, st)
+			return pod
+		case c.Name == f.failInit:
+			st.State.Terminated = &corev1.ContainerStateTerminated{ExitCode: 128}
+			pod.Status.InitContainerStatuses = append(pod.Status.InitContainerStatuses, st)
+			pod.Status.Phase = corev1.PodFailed
+			return pod
+		}
+		st.State.Terminated = &corev1.ContainerStateTerminated{}
+		pod.Status.InitContainerStatuses = append(pod.Status.InitContainerStatuses, st)
+	}
+	pod.Status.Phase = corev1.PodSucceeded
+	return pod
+}
+
+func (f *fakeCluster) exec(_ context.Context, _, container string, cmd []string, stdin io.Reader) (string, int, error) {
+	var files []string
+	if stdin != nil && cmd[2] == "cat > "+k8sPatch {
+		b, err := io.ReadAll(stdin)
+		if err != nil {
+			return "", 0, err
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.execs = append(f.execs, container+": "+strings.Join(cmd, " "))
+		f.patch = string(b)
+		return "", 0, nil
+	}
+	if stdin != nil {
+		tr := tar.NewReader(stdin)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", 0, err
+			}
+			files = append(files, hdr.Name)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.execs = append(f.execs, container+": "+strings.Join(cmd, " "))
+	switch {
+	case stdin != nil:
+		f.sent[cmd[len(cmd)-1]] = files
+	case cmd[0] == "touch" && cmd[1] == k8sReceived:
+		f.received = true
+	case cmd[0] == "touch" && cmd[1] == k8sApplied:
+		f.applied = true
+	case cmd[0] == "git" && f.applyExit != nil:
+		return "error: patch failed: calc.txt:1\n", f.applyExit(cmd[4 : len(cmd)-1]), nil
+	case cmd[0] == "sh" && strings.Contains(cmd[2], patchFuzzScript):
+		// alpine/git only has busybox patch
+		return "", 127, nil
+	}
+	return "", 0, nil
+}
+
+func k8sLog(out string, code int) string {
+	return out + "\n" + k8sExitMarker + strconv.Itoa(code) + "\n"
+}
+
+func hiddenTar(t *testing.T) []byte {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	body := "test hidden\n"
+	if err := tw.WriteHeader(&tar.Header{Name: "hidden_test.sh", Mode: 0o644, Size: int64(len(body))}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte(body))
+	tw.Close()
+	return b.Bytes()
+}
+
+func TestKubernetesRunnerRun(t *testing.T) {
+	repo := gitRepo(t, map[string]string{"calc.txt": "one\n"})
+	f, k := newFakeCluster(t)
+	k.Sandbox = &SandboxPolicy{
+		Default: SandboxProfile{User: "1000:1000", ReadOnlyRootfs: true, Pids: 512, NoFile: 1024, FileSizeMB: 16, TmpMB: 64, RepoMB: 256},
+		MaxPids: 512, MaxNoFile: 1024, MaxFileSizeMB: 16, MaxTmpMB: 64,
+	}
+	// plain git apply fails, --3way works
+	f.applyExit = func(args []string) int {
+		if len(args) > 0 && args[0] == "--3way" {
+			return 0
+		}
+		return 1
+	}
+	f.logs["baseline"] = k8sLog("--- FAIL: TestCalc (0.00s)\nFAIL\nFAIL\tcalc\t0.01s", 1)
+	report := "<testsuite name=\"calc\"><testcase name=\"TestCalc\" classname=\"calc\"/></testsuite>"
+	f.logs["tests"] = "ok\n" + k8sReportMarker + "reports/junit.xml\n" +
+		base64.StdEncoding.EncodeToString([]byte(report)) + "\n" + k8sReportEndMarker + "\n" + k8sLog("", 0)
+	f.logs["hidden"] = k8sLog("--- PASS: TestHidden (0.00s)\nPASS\nok\tcalc\t0.01s", 0)
+
+	opts := RunOptions{
+		RepoURL: repo, StartCommit: "HEAD", Image: "golang:1.24", Command: "go test -v ./...",
+		Patch: "--- a/calc.txt\n+++ b/calc.txt\n@@ -1 +1 @@\n-one\n+two\n", Baseline: true,
+		Reports: []string{"reports/*.xml"}, Hidden: &HiddenTests{Archive: hiddenTar(t)},
+	}
+	res, err := k.Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if res.Baseline == nil || res.Baseline.OK || res.Baseline.ExitCode != 1 {
+		t.Errorf("baseline = %+v, want a failure with exit 1", res.Baseline)
+	}
+	if !res.Patched.OK || len(res.Patched.Cases) != 1 || res.Patched.Cases[0].Name != "TestCalc" {
+		t.Errorf("patched = %+v, want TestCalc from the JUnit report", res.Patched)
+	}
+	if res.Hidden == nil || len(res.Hidden.Cases) != 1 || res.Hidden.Cases[0].Name != "TestHidden" {
+		t.Errorf("hidden = %+v, want TestHidden", res.Hidden)
+	}
+	if res.Apply == nil || res.Apply.Strategy != "git-apply-3way" {
+		t.Errorf("apply = %+v, want git-apply-3way", res.Apply)
+	}
+	if res.Sandbox == nil || res.Sandbox.User != "1000:1000" {
+		t.Errorf("sandbox = %+v, want the default profile", res.Sandbox)
+	}
+
+	// the checkout and the hidden tests were streamed in, without an entry
+	// for the target directory itself
+	checkout := strings.Join(f.sent["tar -xof - -C /repo"], " ")
+	if !strings.Contains(checkout, "calc.txt") || !strings.Contains(checkout, ".git/") || strings.Contains(checkout, "./") {
+		t.Errorf("checkout archive = %s", checkout)
+	}
+	if got := f.sent["cat > /hidden/hidden.tar"]; len(got) != 1 || got[0] != "hidden_test.sh" {
+		t.Errorf("hidden archive = %v", got)
+	}
+	execs := strings.Join(f.execs, "\n")
+	for _, want := range []string{
+		"apply: sh -c cat > /qa/patch.diff",
+		"apply: git -C /repo apply /qa/patch.diff",
+		"apply: sh -c " + k8sResetScript,
+		"apply: git -C /repo apply --3way /qa/patch.diff",
+	} {
+		if !strings.Contains(execs, want) {
+			t.Errorf("execs missing %q:\n%s", want, execs)
+		}
+	}
+
+	if f.patch != opts.Patch {
+		t.Errorf("patch streamed in = %q, want %q", f.patch, opts.Patch)
+	}
+	if f.cm != nil {
+		t.Errorf("configmap created: %+v", f.cm)
+	}
+	if d := f.pod.Spec.ActiveDeadlineSeconds; d == nil || *d != int64(runTimeout(opts).Seconds()) {
+		t.Errorf("pod deadline = %v, want %v", d, runTimeout(opts))
+	}
+	if p := f.policy; p == nil || p.Spec.PodSelector.MatchLabels["qa-run"] != f.pod.Name ||
+		len(p.Spec.PolicyTypes) != 2 || len(p.Spec.Ingress) != 0 || len(p.Spec.Egress) != 0 {
+		t.Errorf("network policy = %+v, want deny-all for the pod", p)
+	}
+
+	var names []string
+	for _, c := range f.pod.Spec.InitContainers {
+		names = append(names, c.Name)
+	}
+	if got, want := strings.Join(names, ","), "receive,baseline,reset,apply,tests,hidden-copy"; got != want {
+		t.Errorf("init containers = %s, want %s", got, want)
+	}
+	if c := f.pod.Spec.Containers; len(c) != 1 || c[0].Name != "hidden" {
+		t.Errorf("main container = %+v, want hidden", c)
+	}
+	ps := f.pod.Spec.SecurityContext
+	if ps == nil || *ps.RunAsUser != 1000 || *ps.RunAsGroup != 1000 || !*ps.RunAsNonRoot ||
+		ps.SeccompProfile.Type != corev1.SeccompProfileTypeRuntimeDefault {
+		t.Errorf("pod security context = %+v", ps)
+	}
+	if *f.pod.Spec.AutomountServiceAccountToken {
+		t.Error("pod mounts a service account token")
+	}
+	for _, c := range append(f.pod.Spec.InitContainers, f.pod.Spec.Containers...) {
+		sc := c.SecurityContext
+		if *sc.AllowPrivilegeEscalation || !*sc.ReadOnlyRootFilesystem || len(sc.Capabilities.Drop) != 1 || sc.Capabilities.Drop[0] != "ALL" {
+			t.Errorf("%s: security context = %+v", c.Name, sc)
+		}
+		tmp := false
+		for _, m := range c.VolumeMounts {
+			tmp = tmp || m.MountPath == "/tmp"
+		}
+		if !tmp {
+			t.Errorf("%s: no /tmp mount", c.Name)
+		}
+		if len(c.EnvFrom) > 0 {
+			t.Errorf("%s: env from %+v", c.Name, c.EnvFrom)
+		}
+	}
+	for _, v := range f.pod.Spec.Volumes {
+		switch {
+		case v.Name == "repo" && v.EmptyDir.SizeLimit.String() != "256Mi":
+			t.Errorf("repo volume = %+v, want a 256Mi limit", v.EmptyDir)
+		case strings.HasPrefix(v.Name, "tmp-") && (v.EmptyDir.Medium != corev1.StorageMediumMemory || v.EmptyDir.SizeLimit.String() != "64Mi"):
+			t.Errorf("%s volume = %+v, want 64Mi of memory", v.Name, v.EmptyDir)
+		}
+	}
+	if script := f.pod.Spec.Containers[0].Command[2]; !strings.Contains(script, "ulimit -n 1024") || !strings.Contains(script, "ulimit -f 32768") {
+		t.Errorf("test script has no sandbox limits: %s", script)
+	}
+
+	// everything is deleted afterwards
+	ctx := context.Background()
+	if l, _ := f.cs.CoreV1().Pods("qa").List(ctx, metav1.ListOptions{}); len(l.Items) != 0 {
+		t.Errorf("pods left: %d", len(l.Items))
+	}
+	if l, _ := f.cs.CoreV1().ConfigMaps("qa").List(ctx, metav1.ListOptions{}); len(l.Items) != 0 {
+		t.Errorf("configmaps left: %d", len(l.Items))
+	}
+	if l, _ := f.cs.NetworkingV1().NetworkPolicies("qa").List(ctx, metav1.ListOptions{}); len(l.Items) != 0 {
+		t.Errorf("network policies left: %d", len(l.Items))
+	}
+}
+
+func TestKubernetesRunnerInitFailure(t *testing.T) {
+	repo := gitRepo(t, map[string]string{"calc.txt": "one\n"})
+	f, k := newFakeCluster(t)
+	f.failInit = "reset"
+	f.logs["reset"] = "fatal: could not reset index file"
+	_, err := k.Run(context.Background(), RunOptions{RepoURL: repo, Image: "golang:1.24", Command: "go test ./...", Baseline: true})
+	if err == nil || !strings.Contains(err.Error(), "reset failed (exit=128)") || !strings.Contains(err.Error(), "could not reset") {
+		t.Errorf("Run err = %v, want the reset failure with its log", err)
+	}
+	if f.pod.Spec.SecurityContext != nil {
+		t.Errorf("pod security context without a sandbox = %+v", f.pod.Spec.SecurityContext)
+	}
+}
+
+func TestKubernetesRunnerApplyError(t *testing.T) {
+	repo := gitRepo(t, map[string]string{"calc.txt": "one\n"})
+	f, k := newFakeCluster(t)
+	f.applyExit = func([]string) int { return 1 }
+	res, err := k.Run(context.Background(), RunOptions{RepoURL: repo, Image: "golang:1.24", Command: "go test ./...", Patch: "--- a/calc.txt\n+++ b/calc.txt\n@@ -1 +1 @@\n-uno\n+two\n"})
+	var aerr *ApplyError
+	if !errors.As(err, &aerr) {
+		t.Fatalf("Run err = %v, want an ApplyError", err)
+	}
+	if got := len(res.Apply.Attempts); got != len(applyStrategies) {
+		t.Errorf("%d apply attempts, want %d", got, len(applyStrategies))
+	}
+	if f.applied {
+		t.Error("apply container released after the patch failed")
+	}
+}
+
+func TestKubernetesRunnerRejects(t *testing.T) {
+	pids := int64(64)
+	for _, tc := range []struct {
+		name string
+		opts RunOptions
+		want string
+	}{
+		{"pipeline", RunOptions{Pipeline: &Pipeline{}}, "pipeline"},
+		{"pids override", RunOptions{Sandbox: &SandboxSpec{Pids: &pids}}, "sandbox pids"},
+		{"report outside repo", RunOptions{Reports: []string{"../etc/passwd"}}, "invalid report path"},
+		{"override without sandbox", RunOptions{Sandbox: &SandboxSpec{}}, "QA_SANDBOX is off"},
+	} {
+		_, k := newFakeCluster(t)
+		if _, err := k.Run(context.Background(), tc.opts); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestKubernetesRunnerLargePatch(t *testing.T) {
+	repo := gitRepo(t, map[string]string{"calc.txt": "one\n"})
+	f, k := newFakeCluster(t)
+	f.logs["tests"] = k8sLog("ok", 0)
+	// well past the 1 MiB a ConfigMap can hold
+	patch := "--- /dev/null\n+++ b/big.txt\n@@ -0,0 +1,40000 @@\n" + strings.Repeat("+"+strings.Repeat("x", 60)+"\n", 40000)
+	res, err := k.Run(context.Background(), RunOptions{RepoURL: repo, Image: "golang:1.24", Command: "go test ./...", Patch: patch})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if f.patch != patch || !res.Patched.OK {
+		t.Errorf("patch of %d bytes streamed as %d bytes; patched = %+v", len(patch), len(f.patch), res.Patched)
+	}
+}
+
+func TestKubernetesDegrade(t *testing.T) {
+	pids, tmp := int64(64), int64(128)
+	p, err := ParsePipeline([]byte("stages:\n- {name: build, command: make}\n- {name: unit, image: node:22, command: npm test, tests: true}\n- {name: e2e, command: make e2e}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := RunOptions{
+		Image: "golang:1.24", Command: "go test ./...", Pipeline: p,
+		Lint: &LintOptions{}, Coverage: &CoverageOptions{}, Mutation: &MutationOptions{},
+		Sandbox: &SandboxSpec{Pids: &pids, TmpMB: &tmp},
+	}
+	_, k := newFakeCluster(t)
+	got, skipped := Degrade(k, opts)
+	var names []string
+	for _, s := range skipped {
+		names = append(names, s.Option)
+		if s.Reason == "" {
+			t.Errorf("%s skipped without a reason", s.Option)
+		}
+	}
+	if want := "pipeline,lint,coverage,mutation,sandbox pids"; strings.Join(names, ",") != want {
+		t.Errorf("skipped = %s, want %s", strings.Join(names, ","), want)
+	}
+	if got.Pipeline != nil || got.Lint != nil || got.Coverage != nil || got.Mutation != nil {
+		t.Errorf("options left: %+v", got)
+	}
+	if got.Command != "npm test" || got.Image != "node:22" {
+		t.Errorf("command %q image %q, want the tests stage's", got.Command, got.Image)
+	}
+	if got.Sandbox.Pids != nil || got.Sandbox.TmpMB == nil || *got.Sandbox.TmpMB != 128 || opts.Sandbox.Pids == nil {
+		t.Errorf("sandbox = %+v (caller's %+v), want only pids dropped from a copy", got.Sandbox, opts.Sandbox)
+	}
+	if s := k.Unsupported(got); len(s) != 0 {
+		t.Errorf("still unsupported after Degrade: %+v", s)
+	}
+
+	if got, skipped := Degrade(DockerRunner{}, opts); skipped != nil || got.Pipeline == nil || got.Lint == nil {
+		t.Errorf("docker runner degraded: %+v", skipped)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	p, err := ParsePipeline([]byte("stages:\n- {name: build, command: make, timeout: 20m}\n- {name: test, command: make test}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		opts RunOptions
+		want time.Duration
+	}{
+		{"command", RunOptions{}, 15 * time.Minute},
+		{"command with baseline", RunOptions{Baseline: true}, 15 * time.Minute},
+		{"pipeline", RunOptions{Pipeline: p}, 15*time.Minute + 35*time.Minute},
+		{"pipeline with baseline", RunOptions{Pipeline: p, Baseline: true}, 15*time.Minute + 70*time.Minute},
+	} {
+		if got := runTimeout(tc.opts); got != tc.want {
+			t.Errorf("%s: runTimeout = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseK8sLog(t *testing.T) {
+	report := base64.StdEncoding.EncodeToString([]byte("<testsuite/>"))
+	for _, tc := range []struct {
+		name, raw  string
+		wantExit   int
+		wantOK     bool
+		wantOut    string
+		wantReport string
+		wantErr    bool
+	}{
+		{name: "pass", raw: "ok\n" + k8sExitMarker + "0\n", wantOK: true, wantOut: "ok\n"},
+		{name: "fail", raw: "FAIL\n" + k8sExitMarker + "2\n", wantExit: 2, wantOut: "FAIL\n"},
+		{
+			name:     "report",
+			raw:      "out\n" + k8sReportMarker + "r/junit.xml\n" + report[:4] + "\n" + report[4:] + "\n" + k8sReportEndMarker + "\n" + k8sExitMarker + "1\n",
+			wantExit: 1, wantOut: "out\n", wantReport: "<testsuite/>",
+		},
+		{name: "no exit status", raw: "killed\n", wantExit: -1, wantOut: "killed\n", wantErr: true},
+	} {
+		res, reports, err := parseK8sLog(tc.raw)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tc.name, err, tc.wantErr)
+		}
+		if res.ExitCode != tc.wantExit || res.OK != tc.wantOK || res.Stdout != tc.wantOut {
+			t.Errorf("%s: exit=%d ok=%v out=%q, want exit=%d ok=%v out=%q", tc.name, res.ExitCode, res.OK, res.Stdout, tc.wantExit, tc.wantOK, tc.wantOut)
+		}
+		if got := string(reports["r/junit.xml"]); got != tc.wantReport {
+			t.Errorf("%s: report = %q, want %q", tc.name, got, tc.wantReport)
+		}
+	}
+}
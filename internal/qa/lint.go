@@ -0,0 +1,190 @@
+package qa
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// Analyzer statuses.
+const (
+	LintOK          = "ok"
+	LintSkipped     = "skipped"     // not applicable to the repository
+	LintUnavailable = "unavailable" // the tool isn't installed in the image
+)
+
+type LintOptions struct {
+	// Analyzers to run by name; empty runs every analyzer that applies to
+	// the repository.
+	Analyzers []string
+}
+
+// Diagnostic is one analyzer finding.
+type Diagnostic struct {
+	Analyzer string `json:"analyzer"`
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Message  string `json:"message"`
+}
+
+type AnalyzerResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Before int    `json:"before"`
+	After  int    `json:"after"`
+}
+
+// LintReport lists the diagnostics the patch introduced: those on the
+// patched tree with no match on the start commit. Diagnostics are matched
+// on analyzer, file and message, so findings that only moved are not
+// reported.
+type LintReport struct {
+	Analyzers  []AnalyzerResult `json:"analyzers"`
+	Introduced []Diagnostic     `json:"introduced"`
+	Fixed      int              `json:"fixed"`
+}
+
+type analyzer struct {
+	name string
+	// applies is a shell test for whether the repository uses the language.
+	applies string
+	tool    string
+	cmd     string
+}
+
+// analyzers run in /repo inside the test image with the network disabled,
+// so they must already be installed there.
+var analyzers = []analyzer{
+	{"go-vet", "test -f go.mod", "go", "go vet ./... 2>&1"},
+	{"staticcheck", "test -f go.mod", "staticcheck", "staticcheck -f text ./... 2>&1"},
+	{"gofmt", "test -f go.mod", "gofmt", "gofmt -l . 2>&1"},
+	{"ruff", "find . -name '*.py' -not -path './.git/*' | grep -q .", "ruff", "ruff check --output-format=concise --no-cache . 2>&1"},
+	{"eslint", "test -f package.json", "npx", "npx --no-install eslint -f unix . 2>&1"},
+}
+
+const (
+	exitNotApplicable = 200
+	exitNoTool        = 201
+)
+
+// path:line[:col]: message, as printed by go vet, staticcheck, ruff
+// (concise) and eslint (unix).
+var diagLine = regexp.MustCompile(`^(.+?):(\d+)(?::(\d+))?:\s+(.*)$`)
+
+func lintScript(a analyzer) string {
+	return fmt.Sprintf("(%s) || exit %d; command -v %s >/dev/null || exit %d; %s",
+		a.applies, exitNotApplicable, a.tool, exitNoTool, a.cmd)
+}
+
+func selectAnalyzers(names []string) ([]analyzer, error) {
+	if len(names) == 0 {
+		return analyzers, nil
+	}
+	var out []analyzer
+	for _, n := range names {
+		found := false
+		for _, a := range analyzers {
+			if a.name == n {
+				out, found = append(out, a), true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown analyzer %q", n)
+		}
+	}
+	return out, nil
+}
+
+// parseDiagnostics reads one analyzer's output.
+func parseDiagnostics(name, output string) []Diagnostic {
+	var out []Diagnostic
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if name == "gofmt" {
+			out = append(out, Diagnostic{Analyzer: name, File: cleanRepoPath(line), Message: "file is not gofmt-formatted"})
+			continue
+		}
+		m := diagLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		d := Diagnostic{Analyzer: name, File: cleanRepoPath(m[1]), Message: m[4]}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		out = append(out, d)
+	}
+	return out
+}
+
+func cleanRepoPath(p string) string {
+	p = strings.TrimPrefix(p, "/repo/")
+	return path.Clean(strings.TrimPrefix(p, "./"))
+}
+
+// lintTree runs the analyzers on the current checkout. It returns each
+// analyzer's status and diagnostics.
+func lintTree(ctx context.Context, cli *client.Client, volName string, opts RunOptions, as []analyzer) (map[string]string, map[string][]Diagnostic, error) {
+	status := map[string]string{}
+	diags := map[string][]Diagnostic{}
+	lopts := opts
+	lopts.Reports, lopts.Format = nil, ""
+	for _, a := range as {
+		lopts.Command = lintScript(a)
+		res, err := runTestCommand(ctx, cli, volName, lopts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", a.name, err)
+		}
+		switch res.ExitCode {
+		case exitNotApplicable:
+			status[a.name] = LintSkipped
+		case exitNoTool:
+			status[a.name] = LintUnavailable
+		default:
+			status[a.name] = LintOK
+			diags[a.name] = parseDiagnostics(a.name, res.Stdout+"\n"+res.Stderr)
+		}
+	}
+	return status, diags, nil
+}
+
+// CompareLint builds the report from the diagnostics before and after the
+// patch.
+func CompareLint(names []string, status map[string]string, before, after map[string][]Diagnostic) *LintReport {
+	rep := &LintReport{Analyzers: []AnalyzerResult{}, Introduced: []Diagnostic{}}
+	key := func(d Diagnostic) string { return d.File + "\x00" + d.Message }
+	for _, n := range names {
+		rep.Analyzers = append(rep.Analyzers, AnalyzerResult{Name: n, Status: status[n], Before: len(before[n]), After: len(after[n])})
+		seen := map[string]int{}
+		for _, d := range before[n] {
+			seen[key(d)]++
+		}
+		for _, d := range after[n] {
+			if seen[key(d)] > 0 {
+				seen[key(d)]--
+				continue
+			}
+			rep.Introduced = append(rep.Introduced, d)
+		}
+		for _, left := range seen {
+			rep.Fixed += left
+		}
+	}
+	sort.SliceStable(rep.Introduced, func(i, j int) bool {
+		a, b := rep.Introduced[i], rep.Introduced[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return rep
+}
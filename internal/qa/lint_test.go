@@ -0,0 +1,108 @@
+package qa
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCompareLint(t *testing.T) {
+	d := func(a, file string, line int, msg string) Diagnostic {
+		return Diagnostic{Analyzer: a, File: file, Line: line, Message: msg}
+	}
+	unused := "declared and not used: x"
+	for _, tc := range []struct {
+		name          string
+		before, after map[string][]Diagnostic
+		introduced    []string // file:line message
+		fixed         int
+	}{
+		{"clean", nil, nil, nil, 0},
+		{
+			"moved, not introduced",
+			map[string][]Diagnostic{"go-vet": {d("go-vet", "calc.go", 10, unused)}},
+			map[string][]Diagnostic{"go-vet": {d("go-vet", "calc.go", 14, unused)}},
+			nil, 0,
+		},
+		{
+			"introduced",
+			map[string][]Diagnostic{"go-vet": {d("go-vet", "calc.go", 10, unused)}},
+			map[string][]Diagnostic{"go-vet": {d("go-vet", "calc.go", 10, unused), d("go-vet", "calc.go", 3, "unreachable code")}},
+			[]string{"calc.go:3 unreachable code"}, 0,
+		},
+		{
+			"fixed",
+			map[string][]Diagnostic{"go-vet": {d("go-vet", "calc.go", 10, unused), d("go-vet", "util.go", 2, unused)}},
+			map[string][]Diagnostic{"go-vet": {d("go-vet", "util.go", 2, unused)}},
+			nil, 1,
+		},
+		{
+			"repeats counted",
+			map[string][]Diagnostic{"go-vet": {d("go-vet", "calc.go", 10, unused)}},
+			map[string][]Diagnostic{"go-vet": {d("go-vet", "calc.go", 10, unused), d("go-vet", "calc.go", 20, unused)}},
+			[]string{"calc.go:20 " + unused}, 0,
+		},
+		{
+			"same message in another file",
+			map[string][]Diagnostic{"go-vet": {d("go-vet", "calc.go", 10, unused)}},
+			map[string][]Diagnostic{"go-vet": {d("go-vet", "util.go", 10, unused)}},
+			[]string{"util.go:10 " + unused}, 1,
+		},
+		{
+			"matched per analyzer",
+			map[string][]Diagnostic{"go-vet": {d("go-vet", "calc.go", 10, unused)}},
+			map[string][]Diagnostic{"staticcheck": {d("staticcheck", "calc.go", 10, unused)}},
+			[]string{"calc.go:10 " + unused}, 1,
+		},
+		{
+			"sorted by file and line",
+			nil,
+			map[string][]Diagnostic{
+				"go-vet":      {d("go-vet", "b.go", 5, "x"), d("go-vet", "a.go", 9, "y")},
+				"staticcheck": {d("staticcheck", "a.go", 2, "z")},
+			},
+			[]string{"a.go:2 z", "a.go:9 y", "b.go:5 x"}, 0,
+		},
+	} {
+		names := []string{"go-vet", "staticcheck"}
+		status := map[string]string{"go-vet": LintOK, "staticcheck": LintOK}
+		rep := CompareLint(names, status, tc.before, tc.after)
+		var got []string
+		for _, d := range rep.Introduced {
+			got = append(got, fmt.Sprintf("%s:%d %s", d.File, d.Line, d.Message))
+		}
+		if !reflect.DeepEqual(got, tc.introduced) || rep.Fixed != tc.fixed {
+			t.Errorf("%s: introduced %q fixed %d, want %q fixed %d", tc.name, got, rep.Fixed, tc.introduced, tc.fixed)
+		}
+		for _, a := range rep.Analyzers {
+			if a.Before != len(tc.before[a.Name]) || a.After != len(tc.after[a.Name]) || a.Status != LintOK {
+				t.Errorf("%s: analyzer %+v", tc.name, a)
+			}
+		}
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	for _, tc := range []struct {
+		name, analyzer, out string
+		want                []Diagnostic
+	}{
+		{"go vet", "go-vet", "# example.com/calc\n./calc.go:12:2: declared and not used: x\n", []Diagnostic{
+			{Analyzer: "go-vet", File: "calc.go", Line: 12, Column: 2, Message: "declared and not used: x"},
+		}},
+		{"ruff absolute", "ruff", "/repo/pkg/calc.py:3:1: F401 `os` imported but unused\nFound 1 error.\n", []Diagnostic{
+			{Analyzer: "ruff", File: "pkg/calc.py", Line: 3, Column: 1, Message: "F401 `os` imported but unused"},
+		}},
+		{"gofmt", "gofmt", "calc.go\r\n./pkg/util.go\n", []Diagnostic{
+			{Analyzer: "gofmt", File: "calc.go", Message: "file is not gofmt-formatted"},
+			{Analyzer: "gofmt", File: "pkg/util.go", Message: "file is not gofmt-formatted"},
+		}},
+		{"no column", "eslint", "src/a.js:7: Unexpected var\n", []Diagnostic{
+			{Analyzer: "eslint", File: "src/a.js", Line: 7, Message: "Unexpected var"},
+		}},
+	} {
+		if got := parseDiagnostics(tc.analyzer, tc.out); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package qa
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"datacurve-takehome/internal/vault"
+)
+
+// LocalRunner runs QA as plain subprocesses in a temporary directory, using
+// the host's git and sh, so the QA path can run in CI or a dev setup
+// without Docker. The test image is ignored: commands see whatever
+// toolchains the host has. Repositories may be file:// URLs or local paths.
+//
+// Without Isolate the tests run with the worker's own privileges and
+// network; only use it on trusted patches.
+type LocalRunner struct {
+	// Dir holds the temporary checkouts; default os.TempDir().
+	Dir string
+	// Isolate runs test commands under `unshare --user --map-root-user
+	// --net`, so they get no network. Needs util-linux and unprivileged
+	// user namespaces.
+	Isolate bool
+	// MemoryKB and CPUSeconds, when set, are applied to test commands with
+	// ulimit -v and ulimit -t.
+	MemoryKB   int64
+	CPUSeconds int
+}
+
+// LocalRunnerFromEnv reads QA_LOCAL_DIR, QA_LOCAL_ISOLATE (true/1) and the
+// QA_LOCAL_MEMORY_KB and QA_LOCAL_CPU_SECONDS limits.
+func LocalRunnerFromEnv() (*LocalRunner, error) {
+	l := &LocalRunner{Dir: os.Getenv("QA_LOCAL_DIR")}
+	switch strings.ToLower(os.Getenv("QA_LOCAL_ISOLATE")) {
+	case "", "0", "false":
+	case "1", "true":
+		l.Isolate = true
+	default:
+		return nil, fmt.Errorf("bad QA_LOCAL_ISOLATE %q", os.Getenv("QA_LOCAL_ISOLATE"))
+	}
+	if v := os.Getenv("QA_LOCAL_MEMORY_KB"); v != "" {
+		if _, err := fmt.Sscan(v, &l.MemoryKB); err != nil || l.MemoryKB <= 0 {
+			return nil, fmt.Errorf("bad QA_LOCAL_MEMORY_KB %q", v)
+		}
+	}
+	if v := os.Getenv("QA_LOCAL_CPU_SECONDS"); v != "" {
+		if _, err := fmt.Sscan(v, &l.CPUSeconds); err != nil || l.CPUSeconds <= 0 {
+			return nil, fmt.Errorf("bad QA_LOCAL_CPU_SECONDS %q", v)
+		}
+	}
+	if l.Isolate {
+		if _, err := exec.LookPath("unshare"); err != nil {
+			return nil, errors.New("QA_LOCAL_ISOLATE needs unshare (util-linux)")
+		}
+	}
+	return l, nil
+}
+
+func (l *LocalRunner) Name() string { return "local" }
+
+// Unsupported implements Limited: the local runner runs the tests but none
+// of the optional stages, and has no sandbox profile to override.
+func (l *LocalRunner) Unsupported(opts RunOptions) []Skipped {
+	out := unsupported("local", opts)
+	if opts.Sandbox != nil {
+		out = append(out, Skipped{Option: "sandbox", Reason: "the local runner has no sandbox profile; QA_LOCAL_* set its limits"})
+	}
+	return out
+}
+
+// Run expects options Degrade has already trimmed and rejects the rest.
+func (l *LocalRunner) Run(ctx context.Context, opts RunOptions) (*RunResult, error) {
+	if s := l.Unsupported(opts); len(s) > 0 {
+		return nil, rejectUnsupported("local", s)
+	}
+	for _, g := range opts.Reports {
+		if !reportGlob.MatchString(g) || strings.Contains(g, "..") {
+			return nil, fmt.Errorf("invalid report path %q", g)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, runTimeout(opts))
+	defer cancel()
+	work, err := os.MkdirTemp(l.Dir, "qa-local-")
+	if err != nil {
+		return nil, fmt.Errorf("temp dir: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(work); err != nil {
+			log.Printf("warn: remove %s: %v", work, err)
+		}
+	}()
+	repo := filepath.Join(work, "repo")
+
+	if err := localCheckout(ctx, work, repo, opts); err != nil {
+		return nil, err
+	}
+
+	out := &RunResult{}
+	if opts.Baseline {
+		log.Println("QA runner: running baseline tests on the start commit")
+		if out.Baseline, err = l.runTestCommand(ctx, repo, opts); err != nil {
+			return out, fmt.Errorf("baseline test run: %w", err)
+		}
+		if err := localGit(ctx, repo, "reset", "-q", "--hard"); err != nil {
+			return out, fmt.Errorf("reset after baseline: %w", err)
+		}
+		if err := localGit(ctx, repo, "clean", "-fdq"); err != nil {
+			return out, fmt.Errorf("reset after baseline: %w", err)
+		}
+	}
+
+	if strings.TrimSpace(opts.Patch) != "" {
+		// outside the checkout so the tests don't see it
+		patchFile := filepath.Join(work, "patch.diff")
+		if err := os.WriteFile(patchFile, []byte(opts.Patch), 0o644); err != nil {
+			return out, fmt.Errorf("write patch: %w", err)
+		}
+		log.Println("QA runner: applying patch")
+		out.Apply, err = applyPatch(ctx, localApplier(repo, patchFile), opts.Patch)
+		if err != nil {
+			return out, fmt.Errorf("apply patch: %w", err)
+		}
+	}
+
+	log.Println("QA runner: running tests with command:", opts.Command)
+	res, err := l.runTestCommand(ctx, repo, opts)
+	out.Patched = res
+	if err != nil {
+		return out, fmt.Errorf("test run: %w", err)
+	}
+
+	if h := opts.Hidden; h != nil {
+		log.Println("QA runner: running hidden tests")
+		if err := extractLocal(ctx, work, repo, "hidden.tar", h.Archive); err != nil {
+			return out, fmt.Errorf("copy hidden tests: %w", err)
+		}
+		hopts := opts
+		if h.Command != "" {
+			hopts.Command = h.Command
+		}
+		hres, err := l.runTestCommand(ctx, repo, hopts)
+		if err != nil {
+			return out, fmt.Errorf("hidden test run: %w", err)
+		}
+		out.Hidden = hiddenResult(res, hres)
+	}
+	return out, nil
+}
+
+// localCheckout puts the start tree into repo, a new directory under work,
+// with the host's git and tar: the extracted snapshot committed to a fresh
+// repository, or a clone of RepoURL (from Mirror when possible) at
+// StartCommit.
+func localCheckout(ctx context.Context, work, repo string, opts RunOptions) error {
+	if opts.Snapshot != nil {
+		log.Println("QA runner: extracting repository snapshot")
+		if err := extractLocal(ctx, work, repo, "snapshot.tar", opts.Snapshot); err != nil {
+			return fmt.Errorf("copy snapshot: %w", err)
+		}
+		for _, c := range snapshotGitInit {
+			if err := localGit(ctx, repo, c...); err != nil {
+				return fmt.Errorf("snapshot git init: %w", err)
+			}
+		}
+	} else {
+		log.Println("QA runner: cloning repo", vault.Scrub(opts.RepoURL, nil), "commit", opts.StartCommit)
+		cloned := false
+		if opts.Mirror != "" {
+			err := localGit(ctx, "", "clone", "-q", "--no-local", opts.Mirror, repo)
+			if err == nil {
+				err = localGit(ctx, repo, "remote", "set-url", "origin", opts.RepoURL)
+			}
+			if err != nil {
+				log.Printf("QA runner: clone from mirror %s failed, cloning upstream: %s", opts.Mirror, vault.Scrub(err.Error(), nil))
+				_ = os.RemoveAll(repo)
+			} else {
+				cloned = true
+			}
+		}
+		if !cloned {
+			if err := runLocal(ctx, "", opts.Credential.Env(), "git", "clone", "-q", opts.RepoURL, repo); err != nil {
+				return fmt.Errorf("git clone phase: %s", vault.Scrub(err.Error(), opts.Credential))
+			}
+		}
+		if c := strings.TrimSpace(opts.StartCommit); c != "" && c != "HEAD" {
+			if err := localGit(ctx, repo, "checkout", "-q", c); err != nil {
+				return fmt.Errorf("git checkout %q: %w", c, err)
+			}
+		}
+	}
+	return nil
+}
+
+// extractLocal writes archive to work/name, outside the checkout, and
+// unpacks it into dir. tar detects the compression and refuses members
+// outside dir.
+func extractLocal(ctx context.Context, work, dir, name string, archive []byte) error {
+	file := filepath.Join(work, name)
+	if err := os.WriteFile(file, archive, 0o600); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return runLocal(ctx, "", nil, "tar", "-xf", file, "-C", dir)
+}
+
+// runTestCommand runs the test command in repo under the configured limits
+// and parses its output and report files.
+func (l *LocalRunner) runTestCommand(ctx context.Context, repo string, opts RunOptions) (*TestResult, error) {
+	script := withReportCleanup(opts, opts.Command)
+	if l.MemoryKB > 0 {
+		script = fmt.Sprintf("ulimit -v %d || exit 125; %s", l.MemoryKB, script)
+	}
+	if l.CPUSeconds > 0 {
+		script = fmt.Sprintf("ulimit -t %d || exit 125; %s", l.CPUSeconds, script)
+	}
+	args := []string{"sh", "-c", script}
+	if l.Isolate {
+		args = append([]string{"unshare", "--user", "--map-root-user", "--net", "--"}, args...)
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = repo
+	cmd.WaitDelay = 5 * time.Second
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+
+	res := &TestResult{Stdout: stdout.String(), Stderr: stderr.String()}
+	var exit *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return res, ctx.Err()
+	case errors.As(err, &exit):
+		res.ExitCode = exit.ExitCode()
+	case err != nil:
+		return res, err
+	}
+	res.OK = res.ExitCode == 0
+
+	out := Output{Stdout: res.Stdout, Stderr: res.Stderr}
+	if len(opts.Reports) > 0 {
+		reports, err := readReports(repo, opts.Reports)
+		if err != nil {
+			log.Printf("QA runner: collect reports: %v", err)
+		}
+		out.Reports = reports
+	}
+	fillCases(res, out, opts.Format)
+	return res, nil
+}
+
+// readReports reads the files under repo matching globs, keyed by their
+// slash-separated path relative to repo.
+func readReports(repo string, globs []string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for _, g := range globs {
+		matches, err := filepath.Glob(filepath.Join(repo, filepath.FromSlash(g)))
+		if err != nil {
+			return files, err
+		}
+		for _, m := range matches {
+			fi, err := os.Lstat(m)
+			if err != nil || !fi.Mode().IsRegular() {
+				continue
+			}
+			f, err := os.Open(m)
+			if err != nil {
+				return files, err
+			}
+			b, err := io.ReadAll(io.LimitReader(f, maxReportSize))
+			f.Close()
+			if err != nil {
+				return files, err
+			}
+			rel, _ := filepath.Rel(repo, m)
+			files[filepath.ToSlash(rel)] = b
+		}
+	}
+	return files, nil
+}
+
+// localApplier applies patchFile to repo with the host's git and patch.
+func localApplier(repo, patchFile string) patchApplier {
+	run := func(ctx context.Context, name string, args ...string) (string, int, error) {
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return string(out), exit.ExitCode(), nil
+		}
+		return string(out), 0, err
+	}
+	return patchApplier{
+		git: func(ctx context.Context, args ...string) (string, int, error) {
+			return run(ctx, "git", append(append(append(append([]string{}, gitHardening...), "apply"), args...), patchFile)...)
+		},
+		patchFuzz: func(ctx context.Context) (string, int, error) {
+			return run(ctx, "sh", "-c", patchFuzzScript, "sh", patchFile)
+		},
+		reset: func(ctx context.Context) error {
+			if err := localGit(ctx, repo, "reset", "-q", "--hard"); err != nil {
+				return err
+			}
+			return localGit(ctx, repo, "clean", "-fdq")
+		},
+	}
+}
+
+// localGit runs a git command in dir, never prompting for credentials and
+// without the hooks or fsmonitor the baseline tests may have configured.
+func localGit(ctx context.Context, dir string, args ...string) error {
+	return runLocal(ctx, dir, nil, "git", append(append([]string{}, gitHardening...), args...)...)
+}
+
+// runLocal runs a command with env added to the worker's environment and
+// returns its output in the error when it fails.
+func runLocal(ctx context.Context, dir string, env []string, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %w\n%s", name, strings.Join(args, " "), err, out)
+	}
+	return nil
+}
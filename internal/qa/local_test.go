@@ -0,0 +1,132 @@
+package qa
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitRepo commits files to a new repository in a temp dir and returns its
+// file:// URL.
+func gitRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=qa", "-c", "user.email=qa@example.com", "commit", "-q", "-m", "start"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	return "file://" + dir
+}
+
+func TestLocalRunnerRun(t *testing.T) {
+	repo := gitRepo(t, map[string]string{"calc.txt": "one\ntwo\nthree\n"})
+	const (
+		fix    = "--- a/calc.txt\n+++ b/calc.txt\n@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n"
+		broken = "--- a/calc.txt\n+++ b/calc.txt\n@@ -1,3 +1,3 @@\n uno\n-dos\n+2\n tres\n"
+		check  = "grep -qx 2 calc.txt"
+	)
+	for _, tc := range []struct {
+		name, patch, command string
+		baseline             bool
+		wantOK, wantBaseOK   bool
+		wantExit             int
+		wantStrategy         string
+		wantApplyErr         bool
+	}{
+		{name: "passing", patch: fix, command: check, wantOK: true, wantStrategy: "git-apply"},
+		{name: "failing", patch: fix, command: "grep -qx two calc.txt", wantExit: 1, wantStrategy: "git-apply"},
+		{name: "no patch", command: check, wantExit: 1},
+		{name: "baseline", patch: fix, command: check, baseline: true, wantOK: true, wantStrategy: "git-apply"},
+		{name: "baseline passes", patch: fix, command: "grep -qx three calc.txt", baseline: true, wantOK: true, wantBaseOK: true, wantStrategy: "git-apply"},
+		{name: "patch does not apply", patch: broken, command: check, wantApplyErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &LocalRunner{Dir: t.TempDir()}
+			res, err := l.Run(context.Background(), RunOptions{
+				RepoURL: repo, StartCommit: "HEAD", Patch: tc.patch, Command: tc.command, Baseline: tc.baseline,
+			})
+			var aerr *ApplyError
+			if tc.wantApplyErr {
+				if !errors.As(err, &aerr) {
+					t.Fatalf("Run err = %v, want an ApplyError", err)
+				}
+				if res.Patched != nil {
+					t.Errorf("tests ran after the patch failed to apply")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if res.Patched.OK != tc.wantOK || res.Patched.ExitCode != tc.wantExit {
+				t.Errorf("patched OK=%v exit=%d, want OK=%v exit=%d\n%s%s", res.Patched.OK, res.Patched.ExitCode,
+					tc.wantOK, tc.wantExit, res.Patched.Stdout, res.Patched.Stderr)
+			}
+			if tc.baseline && (res.Baseline == nil || res.Baseline.OK != tc.wantBaseOK) {
+				t.Errorf("baseline = %+v, want OK=%v", res.Baseline, tc.wantBaseOK)
+			}
+			var strategy string
+			if res.Apply != nil {
+				strategy = res.Apply.Strategy
+			}
+			if strategy != tc.wantStrategy {
+				t.Errorf("apply strategy = %q, want %q", strategy, tc.wantStrategy)
+			}
+		})
+	}
+}
+
+func TestLocalRunnerRejectsUnsupported(t *testing.T) {
+	l := &LocalRunner{Dir: t.TempDir()}
+	_, err := l.Run(context.Background(), RunOptions{RepoURL: "file:///nonexistent", Command: "true", Lint: &LintOptions{}})
+	if err == nil || !strings.Contains(err.Error(), "lint") {
+		t.Errorf("Run with lint: err = %v, want it rejected", err)
+	}
+
+	opts, skipped := Degrade(l, RunOptions{Command: "true", Lint: &LintOptions{}, Sandbox: &SandboxSpec{}})
+	if len(skipped) != 2 || skipped[0].Option != "lint" || skipped[1].Option != "sandbox" || opts.Lint != nil || opts.Sandbox != nil {
+		t.Errorf("Degrade = %+v, %+v; want lint and sandbox dropped", opts, skipped)
+	}
+}
+
+// The baseline tests run in the checkout first and can configure git there;
+// the reset and apply that follow must not run what they set up.
+func TestLocalRunnerGitHardening(t *testing.T) {
+	repo := gitRepo(t, map[string]string{"calc.txt": "one\ntwo\nthree\n"})
+	mark := filepath.Join(t.TempDir(), "ran")
+	plant := `printf '#!/bin/sh\ntouch ` + mark + `\n' > .git/evil && chmod +x .git/evil && ` +
+		`git config core.fsmonitor "$PWD/.git/evil" && git config core.hooksPath .git && cp .git/evil .git/post-checkout; true`
+	l := &LocalRunner{Dir: t.TempDir()}
+	res, err := l.Run(context.Background(), RunOptions{
+		RepoURL: repo, StartCommit: "HEAD", Baseline: true, Command: plant,
+		Patch: "--- a/calc.txt\n+++ b/calc.txt\n@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n",
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.Apply == nil || res.Apply.Strategy != "git-apply" {
+		t.Errorf("apply = %+v", res.Apply)
+	}
+	if _, err := os.Stat(mark); err == nil {
+		t.Error("git ran a hook or fsmonitor the baseline tests configured")
+	}
+}
@@ -0,0 +1,199 @@
+package qa
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// maxParallelCells bounds how many matrix cells run at once.
+const maxParallelCells = 8
+
+// MatrixSpec is a task's test matrix: every image is combined with every
+// command, plus the Include cells. Empty lists fall back to the task's test
+// image and command.
+type MatrixSpec struct {
+	Image   []string `json:"image"`
+	Command []string `json:"command"`
+	Include []Cell   `json:"include"`
+	// MaxParallel cells run at once; 0 or 1 runs them one after another.
+	MaxParallel int `json:"max_parallel"`
+}
+
+type Cell struct {
+	Image   string `json:"image"`
+	Command string `json:"command"`
+}
+
+// Cells expands the spec, dropping duplicates.
+func (m MatrixSpec) Cells(defImage, defCommand string) []Cell {
+	images, commands := m.Image, m.Command
+	if len(images) == 0 {
+		images = []string{defImage}
+	}
+	if len(commands) == 0 {
+		commands = []string{defCommand}
+	}
+	var out []Cell
+	seen := map[Cell]bool{}
+	add := func(c Cell) {
+		if c.Image == "" {
+			c.Image = defImage
+		}
+		if c.Command == "" {
+			c.Command = defCommand
+		}
+		if !seen[c] {
+			seen[c] = true
+			out = append(out, c)
+		}
+	}
+	for _, img := range images {
+		for _, cmd := range commands {
+			add(Cell{img, cmd})
+		}
+	}
+	for _, c := range m.Include {
+		add(c)
+	}
+	return out
+}
+
+// HasCommands reports whether the spec sets commands, which a pipeline
+// can't honour: its stages carry their own.
+func (m MatrixSpec) HasCommands() bool {
+	if len(m.Command) > 0 {
+		return true
+	}
+	for _, c := range m.Include {
+		if c.Command != "" {
+			return true
+		}
+	}
+	return false
+}
+
+type CellResult struct {
+	Image   string   `json:"image"`
+	Command string   `json:"command"`
+	OK      bool     `json:"ok"`
+	Summary *Summary `json:"summary,omitempty"`
+	// Verification is set when the cell ran a baseline.
+	Verification *Verification `json:"verification,omitempty"`
+	Error        string        `json:"error,omitempty"`
+
+	Run *RunResult `json:"-"`
+	Err error      `json:"-"`
+}
+
+type MatrixResult struct {
+	// OK is set when the tests passed in every cell.
+	OK     bool         `json:"ok"`
+	Passed int          `json:"passed"`
+	Failed int          `json:"failed"`
+	Cells  []CellResult `json:"cells"`
+	// Skipped lists the optional stages no cell could report.
+	Skipped []Skipped `json:"skipped,omitempty"`
+}
+
+// RunMatrix runs r once per cell, each in its own sandbox. The first cell
+// gets every optional stage; the others only run the tests (with baseline
+// and hidden tests when configured), since lint, coverage and mutation
+// results don't depend on the toolchain. If the first cell errors, the
+// optional stages run again with the cell that becomes primary, and are
+// listed in Skipped if that fails too.
+func RunMatrix(ctx context.Context, r Runner, base RunOptions, cells []Cell, parallel int, targets []string) *MatrixResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+	if parallel > maxParallelCells {
+		parallel = maxParallelCells
+	}
+	out := &MatrixResult{Cells: make([]CellResult, len(cells))}
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, c := range cells {
+		opts := base
+		opts.Image, opts.Command = c.Image, c.Command
+		if i > 0 {
+			opts.Lint, opts.Coverage, opts.Mutation = nil, nil, nil
+		}
+		wg.Add(1)
+		go func(i int, c Cell, opts RunOptions) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			run, err := r.Run(ctx, opts)
+			cr := CellResult{Image: c.Image, Command: c.Command, Run: run, Err: err}
+			if err != nil {
+				cr.Error = err.Error()
+			}
+			if run != nil && run.Patched != nil {
+				cr.OK = err == nil && run.Patched.OK
+				cr.Summary = run.Patched.Summary
+				if run.Baseline != nil {
+					cr.Verification = Verify(run.Baseline.Cases, run.Patched.Cases, targets)
+				}
+			}
+			out.Cells[i] = cr
+		}(i, c, opts)
+	}
+	wg.Wait()
+	if len(cells) > 1 && !out.Cells[0].ran() {
+		out.rerunStages(ctx, r, base)
+	}
+
+	for _, c := range out.Cells {
+		if c.OK {
+			out.Passed++
+		} else {
+			out.Failed++
+		}
+	}
+	out.OK = out.Failed == 0 && len(out.Cells) > 0
+	return out
+}
+
+// rerunStages runs the optional stages base asks for with the primary
+// cell, which isn't the first, and adds their reports to its result.
+func (m *MatrixResult) rerunStages(ctx context.Context, r Runner, base RunOptions) {
+	var names []string
+	for _, o := range []struct {
+		name string
+		set  bool
+	}{{"lint", base.Lint != nil}, {"coverage", base.Coverage != nil}, {"mutation", base.Mutation != nil}} {
+		if o.set {
+			names = append(names, o.name)
+		}
+	}
+	p := m.Primary()
+	if len(names) == 0 || p == nil {
+		return
+	}
+	opts := base
+	opts.Image, opts.Command = p.Image, p.Command
+	run, err := r.Run(ctx, opts)
+	if err != nil {
+		for _, n := range names {
+			m.Skipped = append(m.Skipped, Skipped{Option: n, Reason: fmt.Sprintf("the first cell errored and the rerun with %s failed: %v", p.Image, err)})
+		}
+		return
+	}
+	p.Run.Lint, p.Run.Coverage, p.Run.Mutation = run.Lint, run.Coverage, run.Mutation
+}
+
+// ran reports whether the cell's run went to completion.
+func (c CellResult) ran() bool {
+	return c.Err == nil && c.Run != nil && c.Run.Patched != nil
+}
+
+// Primary is the first cell that ran to completion, or nil when every cell
+// errored.
+func (m *MatrixResult) Primary() *CellResult {
+	for i, c := range m.Cells {
+		if c.ran() {
+			return &m.Cells[i]
+		}
+	}
+	return nil
+}
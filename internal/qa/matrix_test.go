@@ -0,0 +1,149 @@
+package qa
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// cellRunner fails or passes each cell by image.
+type cellRunner map[string]error
+
+func (cellRunner) Name() string { return "cells" }
+
+func (r cellRunner) Run(_ context.Context, opts RunOptions) (*RunResult, error) {
+	if err := r[opts.Image]; err != nil {
+		return nil, err
+	}
+	return &RunResult{Patched: &TestResult{OK: opts.Image != "failing"}}, nil
+}
+
+func TestRunMatrix(t *testing.T) {
+	boom := errors.New("boom")
+	for _, tc := range []struct {
+		name        string
+		images      []string
+		errs        cellRunner
+		wantOK      bool
+		wantPrimary string
+	}{
+		{"all pass", []string{"a", "b"}, nil, true, "a"},
+		{"one fails", []string{"a", "failing"}, nil, false, "a"},
+		{"first errors", []string{"a", "b"}, cellRunner{"a": boom}, false, "b"},
+		{"all error", []string{"a", "b"}, cellRunner{"a": boom, "b": boom}, false, ""},
+	} {
+		r := tc.errs
+		if r == nil {
+			r = cellRunner{}
+		}
+		cells := MatrixSpec{Image: tc.images}.Cells("", "go test ./...")
+		m := RunMatrix(context.Background(), r, RunOptions{}, cells, 2, nil)
+		if m.OK != tc.wantOK {
+			t.Errorf("%s: OK = %v, want %v", tc.name, m.OK, tc.wantOK)
+		}
+		if m.Passed+m.Failed != len(tc.images) {
+			t.Errorf("%s: passed %d + failed %d, want %d cells", tc.name, m.Passed, m.Failed, len(tc.images))
+		}
+		var got string
+		if p := m.Primary(); p != nil {
+			got = p.Image
+		}
+		if got != tc.wantPrimary {
+			t.Errorf("%s: primary = %q, want %q", tc.name, got, tc.wantPrimary)
+		}
+	}
+}
+
+// stageRunner errors on the images in errs and, from the given call on,
+// on every run; it fills the optional reports the options ask for.
+type stageRunner struct {
+	mu        sync.Mutex
+	errs      map[string]bool
+	failAfter int
+	calls     []string
+}
+
+func (*stageRunner) Name() string { return "stages" }
+
+func (r *stageRunner) Run(_ context.Context, opts RunOptions) (*RunResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	call := opts.Image
+	if opts.Lint != nil {
+		call += "+lint"
+	}
+	r.calls = append(r.calls, call)
+	if r.errs[opts.Image] || (r.failAfter > 0 && len(r.calls) > r.failAfter) {
+		return nil, errors.New("boom")
+	}
+	res := &RunResult{Patched: &TestResult{OK: true}}
+	if opts.Lint != nil {
+		res.Lint = &LintReport{}
+	}
+	if opts.Coverage != nil {
+		res.Coverage = &CoverageReport{}
+	}
+	return res, nil
+}
+
+func TestRunMatrixOptionalStages(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		errs        map[string]bool
+		failAfter   int
+		wantCalls   string
+		wantReports bool
+		wantSkipped string
+	}{
+		{name: "first cell runs them", wantCalls: "a+lint b c", wantReports: true},
+		{name: "rerun on the primary", errs: map[string]bool{"a": true}, wantCalls: "a+lint b c b+lint", wantReports: true},
+		{name: "rerun fails", errs: map[string]bool{"a": true}, failAfter: 3, wantCalls: "a+lint b c b+lint", wantSkipped: "lint coverage"},
+		{name: "every cell errors", errs: map[string]bool{"a": true, "b": true, "c": true}, wantCalls: "a+lint b c"},
+	} {
+		r := &stageRunner{errs: tc.errs, failAfter: tc.failAfter}
+		cells := MatrixSpec{Image: []string{"a", "b", "c"}}.Cells("", "make test")
+		m := RunMatrix(context.Background(), r, RunOptions{Lint: &LintOptions{}, Coverage: &CoverageOptions{}}, cells, 1, nil)
+
+		// the cells run concurrently; any rerun comes after them
+		sort.Strings(r.calls[:len(cells)])
+		if got := strings.Join(r.calls, " "); got != tc.wantCalls {
+			t.Errorf("%s: runs = %s, want %s", tc.name, got, tc.wantCalls)
+		}
+		var lint, coverage bool
+		if p := m.Primary(); p != nil {
+			lint, coverage = p.Run.Lint != nil, p.Run.Coverage != nil
+		}
+		if lint != tc.wantReports || coverage != tc.wantReports {
+			t.Errorf("%s: primary has lint %v coverage %v, want %v", tc.name, lint, coverage, tc.wantReports)
+		}
+		var skipped []string
+		for _, s := range m.Skipped {
+			skipped = append(skipped, s.Option)
+		}
+		if got := strings.Join(skipped, " "); got != tc.wantSkipped {
+			t.Errorf("%s: skipped = %q, want %q", tc.name, got, tc.wantSkipped)
+		}
+		if p := m.Primary(); p != nil && tc.errs["a"] && !p.OK {
+			t.Errorf("%s: primary verdict changed by the rerun: %+v", tc.name, p)
+		}
+	}
+}
+
+func TestHasCommands(t *testing.T) {
+	for _, tc := range []struct {
+		spec MatrixSpec
+		want bool
+	}{
+		{MatrixSpec{Image: []string{"golang:1.22", "golang:1.24"}}, false},
+		{MatrixSpec{Command: []string{"go test ./..."}}, true},
+		{MatrixSpec{Include: []Cell{{Image: "golang:1.24"}}}, false},
+		{MatrixSpec{Include: []Cell{{Command: "go vet ./..."}}}, true},
+	} {
+		if got := tc.spec.HasCommands(); got != tc.want {
+			t.Errorf("%+v: HasCommands = %v, want %v", tc.spec, got, tc.want)
+		}
+	}
+}
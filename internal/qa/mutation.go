@@ -0,0 +1,357 @@
+package qa
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+
+	"datacurve-takehome/internal/patch"
+	"datacurve-takehome/internal/policy"
+)
+
+// Mutant statuses.
+const (
+	MutantKilled   = "killed"
+	MutantSurvived = "survived"
+	MutantTimeout  = "timeout" // counted as killed
+	MutantError    = "error"   // the mutant could not be run; not scored
+	// MutantNonViable mutants don't build (e.g. a removed statement leaves
+	// a variable unused); they say nothing about the tests and aren't
+	// scored.
+	MutantNonViable = "non_viable"
+)
+
+type MutationOptions struct {
+	// MaxMutants caps how many mutants are run; 0 means 25.
+	MaxMutants int
+	// Timeout bounds each mutant's test run; 0 means 5 minutes.
+	Timeout time.Duration
+	// Budget bounds the whole mutation stage; 0 means 20 minutes. Mutants
+	// not run by then are left out of the report.
+	Budget time.Duration
+}
+
+type Mutant struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Operator string `json:"operator"`
+	Original string `json:"original"`
+	Mutated  string `json:"mutated"`
+	Status   string `json:"status,omitempty"`
+}
+
+type MutationReport struct {
+	// Score is killed / (killed + survived); nil when no mutant was scored.
+	Score     *float64 `json:"score"`
+	Total     int      `json:"total"`
+	Killed    int      `json:"killed"`
+	Survived  int      `json:"survived"`
+	Errors    int      `json:"errors"`
+	NonViable int      `json:"non_viable"`
+	// Skipped says why no mutant was run; Stopped why the run ended
+	// before MaxMutants.
+	Skipped   string   `json:"skipped,omitempty"`
+	Stopped   string   `json:"stopped,omitempty"`
+	Survivors []Mutant `json:"survivors"`
+	Mutants   []Mutant `json:"mutants"`
+}
+
+// mutableExt are the source files mutants are generated for.
+var mutableExt = map[string]bool{
+	".go": true, ".py": true, ".js": true, ".jsx": true, ".ts": true, ".tsx": true, ".mjs": true,
+	".java": true, ".kt": true, ".rb": true, ".rs": true, ".c": true, ".h": true, ".cc": true,
+	".cpp": true, ".cs": true, ".php": true, ".swift": true, ".scala": true,
+}
+
+// ChangedLines maps each non-test source file the diff adds lines to onto
+// those line numbers in the new file.
+func ChangedLines(diff string) (map[string][]int, error) {
+	files, err := patch.Parse(diff)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string][]int{}
+	for _, fd := range files {
+		if fd.Deleted() || !mutableExt[path.Ext(fd.NewPath)] || policy.IsTestFile(fd.NewPath) {
+			continue
+		}
+		for _, h := range fd.Hunks {
+			n := h.NewStart
+			for _, l := range h.Lines {
+				switch l.Op {
+				case '+':
+					out[fd.NewPath] = append(out[fd.NewPath], n)
+					n++
+				case ' ':
+					n++
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// swaps are tried in order; each yields at most one mutant per line.
+var swaps = []struct{ from, to string }{
+	{"==", "!="}, {"!=", "=="}, {"<=", ">"}, {">=", "<"}, {"<", ">="}, {">", "<="},
+	{"&&", "||"}, {"||", "&&"}, {"+", "-"}, {"-", "+"}, {"*", "/"},
+}
+
+var (
+	intLiteral  = regexp.MustCompile(`\b\d+\b`)
+	boolLiteral = regexp.MustCompile(`\b(true|false|True|False)\b`)
+	boolFlip    = map[string]string{"true": "false", "false": "true", "True": "False", "False": "True"}
+	// lines that declare, open or close something; removing them only
+	// breaks the build
+	notStatement = regexp.MustCompile(`^(func|def|class|if|else|elif|for|while|switch|case|default|try|except|finally|import|package|var|const|type|return|export|from|@)\b|[{}:(\[,]$|^[)}\]]|:=`)
+)
+
+// GenerateMutants makes mutants of content for the given 1-based lines:
+// flipped operators, altered constants and removed statements.
+func GenerateMutants(file, content string, lines []int) []Mutant {
+	src := strings.Split(content, "\n")
+	var out []Mutant
+	for _, n := range lines {
+		if n < 1 || n > len(src) {
+			continue
+		}
+		line := src[n-1]
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || isComment(trimmed) {
+			continue
+		}
+		mk := func(op, mutated string) {
+			out = append(out, Mutant{File: file, Line: n, Operator: op, Original: line, Mutated: mutated})
+		}
+		code := codeMask(line)
+		for _, s := range swaps {
+			if i := findOperator(line, code, s.from); i >= 0 {
+				mk(s.from+" -> "+s.to, line[:i]+s.to+line[i+len(s.from):])
+			}
+		}
+		if loc := firstInCode(intLiteral, line, code); loc != nil {
+			v, _ := strconv.Atoi(line[loc[0]:loc[1]])
+			mk("constant", line[:loc[0]]+strconv.Itoa(v+1)+line[loc[1]:])
+		}
+		if loc := firstInCode(boolLiteral, line, code); loc != nil {
+			mk("constant", line[:loc[0]]+boolFlip[line[loc[0]:loc[1]]]+line[loc[1]:])
+		}
+		if !notStatement.MatchString(trimmed) {
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			repl := ""
+			if path.Ext(file) == ".py" {
+				repl = indent + "pass"
+			}
+			mk("remove statement", repl)
+		}
+	}
+	return out
+}
+
+func isComment(s string) bool {
+	for _, p := range []string{"//", "#", "/*"} {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// codeMask marks which bytes of line are code rather than string literals
+// or a trailing comment.
+func codeMask(line string) []bool {
+	mask := make([]bool, len(line))
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case strings.HasPrefix(line[i:], "//") || c == '#':
+			return mask
+		default:
+			mask[i] = true
+		}
+	}
+	return mask
+}
+
+// findOperator finds op in code where it is not part of a longer operator.
+func findOperator(line string, code []bool, op string) int {
+	const opChars = "=!<>&|+-*/%^"
+	for i := 0; i+len(op) <= len(line); i++ {
+		if line[i:i+len(op)] != op || !code[i] {
+			continue
+		}
+		if i > 0 && strings.IndexByte(opChars, line[i-1]) >= 0 {
+			continue
+		}
+		if j := i + len(op); j < len(line) && strings.IndexByte(opChars, line[j]) >= 0 {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+func firstInCode(re *regexp.Regexp, line string, code []bool) []int {
+	for _, loc := range re.FindAllStringIndex(line, -1) {
+		if code[loc[0]] {
+			return loc
+		}
+	}
+	return nil
+}
+
+// buildFailure matches the output of test commands that failed to build or
+// load the code rather than failing a test.
+var buildFailure = regexp.MustCompile(`\[build failed\]|\[setup failed\]|"Action":"build-fail"|could not compile|error\[E\d+\]|COMPILATION ERROR|\berror TS\d+|\berror CS\d+|(?m)^\S*(SyntaxError|IndentationError|TabError):`)
+
+// SkippedMutation is the report of a mutation stage that didn't run.
+func SkippedMutation(reason string) *MutationReport {
+	return &MutationReport{Skipped: reason, Survivors: []Mutant{}, Mutants: []Mutant{}}
+}
+
+// runMutants writes each mutant into the checkout, reruns the test command
+// in the same sandbox as the patched run and restores the file. ctx should
+// not be the setup phase's: the stage has its own budget, and stops
+// cleanly, with the file restored, when either runs out.
+func runMutants(ctx context.Context, cli *client.Client, volName string, opts RunOptions) (*MutationReport, error) {
+	mo := opts.Mutation
+	max, timeout, budget := mo.MaxMutants, mo.Timeout, mo.Budget
+	if max <= 0 {
+		max = 25
+	}
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	if budget <= 0 {
+		budget = 20 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+	// restores must succeed after the budget has run out; they write the
+	// original bytes back under the original header, so the file keeps its
+	// mode and owner
+	restore := func(f volumeFile) error {
+		rctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		return copyFileToVolume(rctx, cli, volName, f.Header, f.Data)
+	}
+	changed, err := ChangedLines(opts.Patch)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := &MutationReport{Survivors: []Mutant{}, Mutants: []Mutant{}}
+	// only the logs matter here
+	topts := opts
+	topts.Reports, topts.Format = nil, ""
+	names := make([]string, 0, len(changed))
+	for f := range changed {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+files:
+	for _, file := range names {
+		if len(rep.Mutants) >= max {
+			break
+		}
+		if ctx.Err() != nil {
+			rep.Stopped = "mutation budget exhausted"
+			break
+		}
+		lines := changed[file]
+		files, err := readVolumeFiles(ctx, cli, volName, []string{file})
+		f, ok := files[file]
+		if err != nil || !ok {
+			log.Printf("QA runner: mutation: read %s: %v", file, err)
+			continue
+		}
+		if int64(len(f.Data)) != f.Header.Size {
+			// cut at maxReportSize: it could not be restored in full
+			log.Printf("QA runner: mutation: %s is too large to mutate", file)
+			continue
+		}
+		orig := string(f.Data)
+		src := strings.Split(orig, "\n")
+		for _, m := range GenerateMutants(file, orig, lines) {
+			if len(rep.Mutants) >= max {
+				break
+			}
+			mutated := make([]string, len(src))
+			copy(mutated, src)
+			mutated[m.Line-1] = m.Mutated
+			if err := copyFileToVolume(ctx, cli, volName, f.Header, []byte(strings.Join(mutated, "\n"))); err != nil {
+				if ctx.Err() != nil {
+					rep.Stopped = "mutation budget exhausted"
+					break files
+				}
+				return rep, fmt.Errorf("write mutant: %w", err)
+			}
+			mctx, cancel := context.WithTimeout(ctx, timeout)
+			res, err := runTestCommand(mctx, cli, volName, topts)
+			// only this mutant's own deadline is a timeout; the budget
+			// running out says nothing about it
+			timedOut := mctx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+			cancel()
+			if ctx.Err() != nil {
+				rep.Stopped = "mutation budget exhausted"
+				if err := restore(f); err != nil {
+					return rep, fmt.Errorf("restore %s: %w", file, err)
+				}
+				break files
+			}
+			switch {
+			case timedOut:
+				m.Status = MutantTimeout
+			case err != nil:
+				m.Status = MutantError
+			case res.ExitCode != 0 && buildFailure.MatchString(res.Stdout+res.Stderr):
+				m.Status = MutantNonViable
+			case res.ExitCode != 0:
+				m.Status = MutantKilled
+			default:
+				m.Status = MutantSurvived
+			}
+			rep.Mutants = append(rep.Mutants, m)
+			if err := restore(f); err != nil {
+				return rep, fmt.Errorf("restore %s: %w", file, err)
+			}
+		}
+	}
+
+	for _, m := range rep.Mutants {
+		switch m.Status {
+		case MutantKilled, MutantTimeout:
+			rep.Killed++
+		case MutantSurvived:
+			rep.Survived++
+			rep.Survivors = append(rep.Survivors, m)
+		case MutantNonViable:
+			rep.NonViable++
+		default:
+			rep.Errors++
+		}
+	}
+	rep.Total = len(rep.Mutants)
+	if scored := rep.Killed + rep.Survived; scored > 0 {
+		score := float64(rep.Killed) / float64(scored)
+		rep.Score = &score
+	}
+	return rep, nil
+}
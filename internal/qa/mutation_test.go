@@ -0,0 +1,136 @@
+package qa
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBuildFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name, out string
+		want      bool
+	}{
+		{"go unused variable", "# example.com/calc\n./calc.go:12:2: declared and not used: x\nFAIL\texample.com/calc [build failed]\n", true},
+		{"go json build-fail", `{"ImportPath":"example.com/calc","Action":"build-fail"}`, true},
+		{"go setup failed", "FAIL\texample.com/calc [setup failed]\n", true},
+		{"rust", "error[E0425]: cannot find value `x` in this scope\n", true},
+		{"python syntax error", "  File \"calc.py\", line 3\nSyntaxError: invalid syntax\n", true},
+		{"typescript", "src/calc.ts(3,1): error TS2304: Cannot find name 'x'.\n", true},
+		{"failing test", "--- FAIL: TestAdd (0.00s)\n    calc_test.go:9: got 3, want 4\nFAIL\nFAIL\texample.com/calc\t0.002s\n", false},
+		{"assertion mentioning syntax", "AssertionError: expected SyntaxError to be raised\n", false},
+	} {
+		if got := buildFailure.MatchString(tc.out); got != tc.want {
+			t.Errorf("%s: buildFailure = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// extract unpacks archive into dir the way the daemon's CopyToContainer
+// does: keeping the mode, owner and times of each entry.
+func extract(t *testing.T, dir string, archive []byte) {
+	t.Helper()
+	cmd := exec.Command("tar", "-x", "-p", "--same-owner", "-C", dir)
+	cmd.Stdin = bytes.NewReader(archive)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("tar: %v\n%s", err, out)
+	}
+}
+
+// treeState describes every file under dir by content, mode, owner and
+// modification time.
+func treeState(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	state := map[string]string{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		rel, _ := filepath.Rel(dir, p)
+		state[rel] = fmt.Sprintf("%v %d:%d %d %q", fi.Mode(), st.Uid, st.Gid, fi.ModTime().Unix(), b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestMutantRestoreKeepsFile(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root to extract files owned by another user")
+	}
+	dir := t.TempDir()
+	for name, mode := range map[string]os.FileMode{"calc.go": 0644, "scripts/check.sh": 0755} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("if a > b {\n\treturn a\n}\n"), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(p, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chown(p, 1000, 1001); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := treeState(t, dir)
+
+	for _, name := range []string{"calc.go", "scripts/check.sh"} {
+		// the header as CopyFromContainer reports it
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr.Name = name
+		orig, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := volumeFile{Header: hdr, Data: orig}
+
+		mutant, err := fileArchive(f.Header, []byte("if a <= b {\n\treturn a\n}\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		extract(t, dir, mutant)
+		if got := treeState(t, dir); reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: mutant not written", name)
+		}
+		restored, err := fileArchive(f.Header, f.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		extract(t, dir, restored)
+		if got := treeState(t, dir); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: restored tree = %q, want %q", name, got, want)
+		}
+	}
+}
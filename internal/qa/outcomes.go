@@ -0,0 +1,86 @@
+package qa
+
+import (
+	"slices"
+	"strings"
+)
+
+// Test statuses.
+const (
+	StatusPass = "pass"
+	StatusFail = "fail"
+	StatusSkip = "skip"
+)
+
+// Verification compares the baseline (unpatched) and patched runs the way
+// SWE-bench does. A test missing from a run counts as failed in that run,
+// so tests the patch adds land in FailToPass and tests it deletes in
+// PassToFail. Skipped tests are left out.
+type Verification struct {
+	FailToPass []string `json:"FAIL_TO_PASS"`
+	PassToPass []string `json:"PASS_TO_PASS"`
+	PassToFail []string `json:"PASS_TO_FAIL"`
+	FailToFail []string `json:"FAIL_TO_FAIL"`
+	// Targets are the tests the task expects the patch to fix; empty when
+	// the task names none. A target matches a full key or a bare test name.
+	Targets []string `json:"targets,omitempty"`
+	// VerifiedFix is set when every target (or, without targets, at least
+	// one test) flips from fail to pass and no test regresses.
+	VerifiedFix bool `json:"verified_fix"`
+}
+
+func Verify(baseline, patched []TestCase, targets []string) *Verification {
+	v := &Verification{
+		FailToPass: []string{},
+		PassToPass: []string{},
+		PassToFail: []string{},
+		FailToFail: []string{},
+		Targets:    targets,
+	}
+	before, after := outcomes(baseline), outcomes(patched)
+	names := map[string]bool{}
+	for n := range before {
+		names[n] = true
+	}
+	for n := range after {
+		names[n] = true
+	}
+	for n := range names {
+		before, after := before[n], after[n]
+		if before == StatusSkip || after == StatusSkip {
+			continue
+		}
+		switch {
+		case before == StatusPass && after == StatusPass:
+			v.PassToPass = append(v.PassToPass, n)
+		case before == StatusPass:
+			v.PassToFail = append(v.PassToFail, n)
+		case after == StatusPass:
+			v.FailToPass = append(v.FailToPass, n)
+		default:
+			v.FailToFail = append(v.FailToFail, n)
+		}
+	}
+	for _, s := range [][]string{v.FailToPass, v.PassToPass, v.PassToFail, v.FailToFail} {
+		slices.Sort(s)
+	}
+
+	flipped := len(v.FailToPass) > 0
+	for _, t := range targets {
+		if !slices.ContainsFunc(v.FailToPass, func(n string) bool { return n == t || strings.HasSuffix(n, "::"+t) }) {
+			flipped = false
+		}
+	}
+	v.VerifiedFix = flipped && len(v.PassToFail) == 0
+	return v
+}
+
+// outcomes indexes cases by Key. Tests that appear more than once keep
+// their last outcome.
+func outcomes(cases []TestCase) map[string]string {
+	out := make(map[string]string, len(cases))
+	for _, c := range cases {
+		out[c.Key()] = c.Status
+	}
+	return out
+}
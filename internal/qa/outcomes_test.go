@@ -0,0 +1,85 @@
+package qa
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	c := func(suite, name, status string) TestCase { return TestCase{Suite: suite, Name: name, Status: status} }
+	for _, tc := range []struct {
+		name              string
+		baseline, patched []TestCase
+		targets           []string
+		want              Verification
+	}{
+		{
+			"fix",
+			[]TestCase{c("calc", "TestAdd", StatusFail), c("calc", "TestSub", StatusPass)},
+			[]TestCase{c("calc", "TestAdd", StatusPass), c("calc", "TestSub", StatusPass)},
+			nil,
+			Verification{FailToPass: []string{"calc::TestAdd"}, PassToPass: []string{"calc::TestSub"}, VerifiedFix: true},
+		},
+		{
+			"regression",
+			[]TestCase{c("calc", "TestAdd", StatusFail), c("calc", "TestSub", StatusPass)},
+			[]TestCase{c("calc", "TestAdd", StatusPass), c("calc", "TestSub", StatusFail)},
+			nil,
+			Verification{FailToPass: []string{"calc::TestAdd"}, PassToFail: []string{"calc::TestSub"}},
+		},
+		{
+			"nothing flipped",
+			[]TestCase{c("calc", "TestAdd", StatusFail), c("calc", "TestSub", StatusPass)},
+			[]TestCase{c("calc", "TestAdd", StatusFail), c("calc", "TestSub", StatusPass)},
+			nil,
+			Verification{PassToPass: []string{"calc::TestSub"}, FailToFail: []string{"calc::TestAdd"}},
+		},
+		{
+			"added test is fail to pass, deleted is pass to fail",
+			[]TestCase{c("calc", "TestOld", StatusPass)},
+			[]TestCase{c("calc", "TestNew", StatusPass)},
+			nil,
+			Verification{FailToPass: []string{"calc::TestNew"}, PassToFail: []string{"calc::TestOld"}},
+		},
+		{
+			"skipped left out",
+			[]TestCase{c("calc", "TestAdd", StatusFail), c("calc", "TestSlow", StatusSkip)},
+			[]TestCase{c("calc", "TestAdd", StatusPass), c("calc", "TestSlow", StatusFail)},
+			nil,
+			Verification{FailToPass: []string{"calc::TestAdd"}, VerifiedFix: true},
+		},
+		{
+			"targets by bare name",
+			[]TestCase{c("calc", "TestAdd", StatusFail), c("calc", "TestSub", StatusFail)},
+			[]TestCase{c("calc", "TestAdd", StatusPass), c("calc", "TestSub", StatusFail)},
+			[]string{"TestAdd"},
+			Verification{FailToPass: []string{"calc::TestAdd"}, FailToFail: []string{"calc::TestSub"}, Targets: []string{"TestAdd"}, VerifiedFix: true},
+		},
+		{
+			"target not fixed",
+			[]TestCase{c("calc", "TestAdd", StatusFail), c("calc", "TestSub", StatusFail)},
+			[]TestCase{c("calc", "TestAdd", StatusPass), c("calc", "TestSub", StatusFail)},
+			[]string{"calc::TestAdd", "calc::TestSub"},
+			Verification{FailToPass: []string{"calc::TestAdd"}, FailToFail: []string{"calc::TestSub"}, Targets: []string{"calc::TestAdd", "calc::TestSub"}},
+		},
+		{
+			"last outcome of a repeated test wins",
+			[]TestCase{c("calc", "TestFlaky", StatusPass), c("calc", "TestFlaky", StatusFail)},
+			[]TestCase{c("calc", "TestFlaky", StatusFail), c("calc", "TestFlaky", StatusPass)},
+			nil,
+			Verification{FailToPass: []string{"calc::TestFlaky"}, VerifiedFix: true},
+		},
+		{"no tests", nil, nil, nil, Verification{}},
+	} {
+		got := Verify(tc.baseline, tc.patched, tc.targets)
+		want := tc.want
+		for _, s := range []*[]string{&want.FailToPass, &want.PassToPass, &want.PassToFail, &want.FailToFail} {
+			if *s == nil {
+				*s = []string{}
+			}
+		}
+		if !reflect.DeepEqual(*got, want) {
+			t.Errorf("%s: Verify = %+v, want %+v", tc.name, *got, want)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package qa
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// TestCase is one test's outcome. Suite is the package, file or class the
+// test belongs to when the format reports one.
+type TestCase struct {
+	Name       string `json:"name"`
+	Suite      string `json:"suite,omitempty"`
+	Status     string `json:"status"` // pass, fail or skip
+	DurationMS int64  `json:"duration_ms"`
+	Message    string `json:"message,omitempty"`
+}
+
+// Key identifies the test across runs.
+func (c TestCase) Key() string {
+	if c.Suite == "" {
+		return c.Name
+	}
+	return c.Suite + "::" + c.Name
+}
+
+type Summary struct {
+	Passed     int   `json:"passed"`
+	Failed     int   `json:"failed"`
+	Skipped    int   `json:"skipped"`
+	DurationMS int64 `json:"duration_ms"`
+}
+
+func Summarize(cases []TestCase) *Summary {
+	s := &Summary{}
+	for _, c := range cases {
+		switch c.Status {
+		case StatusPass:
+			s.Passed++
+		case StatusFail:
+			s.Failed++
+		default:
+			s.Skipped++
+		}
+		s.DurationMS += c.DurationMS
+	}
+	return s
+}
+
+// Output is what a test run left behind: its logs and any report files
+// collected from the repository (paths relative to /repo).
+type Output struct {
+	Stdout, Stderr string
+	Reports        map[string][]byte
+}
+
+// A Parser turns one test report format into test cases.
+type Parser interface {
+	Name() string
+	Detect(out Output) bool
+	Parse(out Output) ([]TestCase, error)
+}
+
+// Parsers are tried in order; the first whose Detect matches is used.
+var Parsers = []Parser{JUnitParser{}, JestParser{}, GoJSONParser{}, PytestParser{}, GoVerboseParser{}}
+
+// ParseResults picks a parser (by name when format is set, otherwise by
+// detection) and returns its name and the test cases it found.
+func ParseResults(out Output, format string) (string, []TestCase, error) {
+	for _, p := range Parsers {
+		if format != "" && p.Name() != format {
+			continue
+		}
+		if format == "" && !p.Detect(out) {
+			continue
+		}
+		cases, err := p.Parse(out)
+		sort.SliceStable(cases, func(i, j int) bool { return cases[i].Key() < cases[j].Key() })
+		return p.Name(), cases, err
+	}
+	if format != "" {
+		return "", nil, errors.New("unknown test format " + strconv.Quote(format))
+	}
+	return "", nil, nil
+}
+
+// --- go test -json ---
+
+type GoJSONParser struct{}
+
+type goTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+func (GoJSONParser) Name() string { return "go-json" }
+
+func (GoJSONParser) Detect(out Output) bool {
+	for _, line := range strings.Split(out.Stdout, "\n") {
+		if strings.HasPrefix(line, "{") && strings.Contains(line, `"Action":`) {
+			return true
+		}
+	}
+	return false
+}
+
+func (GoJSONParser) Parse(out Output) ([]TestCase, error) {
+	var cases []TestCase
+	logs := map[string]*strings.Builder{}
+	for _, line := range strings.Split(out.Stdout, "\n") {
+		var ev goTestEvent
+		if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &ev) != nil || ev.Test == "" {
+			continue
+		}
+		key := ev.Package + "\x00" + ev.Test
+		switch ev.Action {
+		case "output":
+			if logs[key] == nil {
+				logs[key] = &strings.Builder{}
+			}
+			logs[key].WriteString(ev.Output)
+		case "pass", "fail", "skip":
+			c := TestCase{Name: ev.Test, Suite: ev.Package, Status: ev.Action, DurationMS: int64(ev.Elapsed * 1000)}
+			if ev.Action == StatusFail && logs[key] != nil {
+				c.Message = trimMessage(logs[key].String())
+			}
+			cases = append(cases, c)
+		}
+	}
+	return cases, nil
+}
+
+// --- JUnit XML ---
+
+type JUnitParser struct{}
+
+type junitCase struct {
+	Name      string  `xml:"name,attr"`
+	Classname string  `xml:"classname,attr"`
+	Time      float64 `xml:"time,attr"`
+	Failure   *struct {
+		Message string `xml:"message,attr"`
+		Body    string `xml:",chardata"`
+	} `xml:"failure"`
+	Error *struct {
+		Message string `xml:"message,attr"`
+		Body    string `xml:",chardata"`
+	} `xml:"error"`
+	Skipped *struct{} `xml:"skipped"`
+}
+
+func (JUnitParser) Name() string { return "junit" }
+
+func (JUnitParser) Detect(out Output) bool {
+	for name := range out.Reports {
+		if path.Ext(name) == ".xml" {
+			return true
+		}
+	}
+	return strings.Contains(out.Stdout, "<testsuite")
+}
+
+func (JUnitParser) Parse(out Output) ([]TestCase, error) {
+	var docs [][]byte
+	names := make([]string, 0, len(out.Reports))
+	for name := range out.Reports {
+		if path.Ext(name) == ".xml" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		docs = append(docs, out.Reports[n])
+	}
+	if len(docs) == 0 {
+		docs = append(docs, []byte(out.Stdout[strings.Index(out.Stdout, "<testsuite"):]))
+	}
+
+	var cases []TestCase
+	for _, doc := range docs {
+		// Walk the tokens so both <testsuites> and bare <testsuite> roots,
+		// and nested suites, work.
+		dec := xml.NewDecoder(bytes.NewReader(doc))
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return cases, err
+			}
+			se, ok := tok.(xml.StartElement)
+			if !ok || se.Name.Local != "testcase" {
+				continue
+			}
+			var jc junitCase
+			if err := dec.DecodeElement(&jc, &se); err != nil {
+				return cases, err
+			}
+			c := TestCase{Name: jc.Name, Suite: jc.Classname, Status: StatusPass, DurationMS: int64(jc.Time * 1000)}
+			switch {
+			case jc.Failure != nil:
+				c.Status, c.Message = StatusFail, trimMessage(firstNonEmpty(jc.Failure.Message, jc.Failure.Body))
+			case jc.Error != nil:
+				c.Status, c.Message = StatusFail, trimMessage(firstNonEmpty(jc.Error.Message, jc.Error.Body))
+			case jc.Skipped != nil:
+				c.Status = StatusSkip
+			}
+			cases = append(cases, c)
+		}
+	}
+	return cases, nil
+}
+
+// --- Jest --json ---
+
+type JestParser struct{}
+
+type jestReport struct {
+	NumTotalTests int `json:"numTotalTests"`
+	TestResults   []struct {
+		Name             string `json:"name"`
+		AssertionResults []struct {
+			FullName        string   `json:"fullName"`
+			Status          string   `json:"status"`
+			Duration        *float64 `json:"duration"`
+			FailureMessages []string `json:"failureMessages"`
+		} `json:"assertionResults"`
+	} `json:"testResults"`
+}
+
+func (JestParser) Name() string { return "jest" }
+
+func (JestParser) Detect(out Output) bool {
+	_, ok := jestJSON(out)
+	return ok
+}
+
+// jestJSON finds the report in a collected .json file or in stdout, where
+// jest may print it after other output.
+func jestJSON(out Output) ([]byte, bool) {
+	for name, b := range out.Reports {
+		if path.Ext(name) == ".json" && bytes.Contains(b, []byte(`"testResults"`)) {
+			return b, true
+		}
+	}
+	if i := strings.Index(out.Stdout, `{"num`); i >= 0 && strings.Contains(out.Stdout[i:], `"testResults"`) {
+		return []byte(out.Stdout[i:]), true
+	}
+	return nil, false
+}
+
+func (JestParser) Parse(out Output) ([]TestCase, error) {
+	b, _ := jestJSON(out)
+	var rep jestReport
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&rep); err != nil {
+		return nil, err
+	}
+	var cases []TestCase
+	for _, file := range rep.TestResults {
+		for _, a := range file.AssertionResults {
+			c := TestCase{Name: a.FullName, Suite: strings.TrimPrefix(file.Name, "/repo/")}
+			switch a.Status {
+			case "passed":
+				c.Status = StatusPass
+			case "failed":
+				c.Status = StatusFail
+				c.Message = trimMessage(strings.Join(a.FailureMessages, "\n"))
+			default: // pending, skipped, todo, disabled
+				c.Status = StatusSkip
+			}
+			if a.Duration != nil {
+				c.DurationMS = int64(*a.Duration)
+			}
+			cases = append(cases, c)
+		}
+	}
+	return cases, nil
+}
+
+// --- pytest (verbose text) ---
+
+type PytestParser struct{}
+
+var (
+	// tests/test_calc.py::test_add PASSED [ 50%]
+	pytestVerboseLine = regexp.MustCompile(`^(\S+::\S+)\s+(PASSED|FAILED|ERROR|SKIPPED|XFAIL|XPASS)\b`)
+	// FAILED tests/test_calc.py::test_add - assert 1 == 2 (short summary, -rA)
+	pytestSummaryLine = regexp.MustCompile(`^(PASSED|FAILED|ERROR|SKIPPED|XFAIL|XPASS) (\S+::\S+)(?: - (.*))?`)
+)
+
+func (PytestParser) Name() string { return "pytest" }
+
+func (PytestParser) Detect(out Output) bool {
+	for _, line := range strings.Split(out.Stdout, "\n") {
+		if pytestVerboseLine.MatchString(line) || pytestSummaryLine.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func (PytestParser) Parse(out Output) ([]TestCase, error) {
+	byID := map[string]*TestCase{}
+	var order []string
+	set := func(id, status, msg string) {
+		c, ok := byID[id]
+		if !ok {
+			c = &TestCase{Name: id}
+			byID[id] = c
+			order = append(order, id)
+		}
+		c.Status = pytestStatus(status)
+		if msg != "" {
+			c.Message = msg
+		}
+	}
+	for _, line := range strings.Split(out.Stdout, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if m := pytestVerboseLine.FindStringSubmatch(line); m != nil {
+			set(m[1], m[2], "")
+		} else if m := pytestSummaryLine.FindStringSubmatch(line); m != nil {
+			set(m[2], m[1], m[3])
+		}
+	}
+	cases := make([]TestCase, 0, len(order))
+	for _, id := range order {
+		cases = append(cases, *byID[id])
+	}
+	return cases, nil
+}
+
+func pytestStatus(s string) string {
+	switch s {
+	case "PASSED", "XPASS":
+		return StatusPass
+	case "SKIPPED", "XFAIL":
+		return StatusSkip
+	default:
+		return StatusFail
+	}
+}
+
+// --- go test -v (text) ---
+
+type GoVerboseParser struct{}
+
+// --- PASS: TestAdd (0.01s), indented for subtests
+var goVerboseLine = regexp.MustCompile(`^\s*--- (PASS|FAIL|SKIP): (\S+) \(([\d.]+)s\)`)
+
+// ok  	example.com/calc	0.01s, or FAIL	example.com/calc [build failed]:
+// the package result printed after its tests
+var goVerbosePackage = regexp.MustCompile(`^(?:ok|FAIL)\s+(\S+)(?:\s|$)`)
+
+func (GoVerboseParser) Name() string { return "go-verbose" }
+
+func (GoVerboseParser) Detect(out Output) bool {
+	return goVerboseLine.MatchString(out.Stdout) || strings.Contains(out.Stdout, "\n--- ")
+}
+
+// Parse sets each test's Suite to its package, which go test prints once
+// the package's tests are done; with several packages their output isn't
+// interleaved.
+func (GoVerboseParser) Parse(out Output) ([]TestCase, error) {
+	var cases []TestCase
+	suiteless := 0 // cases[suiteless:] wait for their package line
+	for _, line := range strings.Split(out.Stdout, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if m := goVerbosePackage.FindStringSubmatch(line); m != nil {
+			for i := suiteless; i < len(cases); i++ {
+				cases[i].Suite = m[1]
+			}
+			suiteless = len(cases)
+			continue
+		}
+		m := goVerboseLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		secs, _ := strconv.ParseFloat(m[3], 64)
+		cases = append(cases, TestCase{Name: m[2], Status: strings.ToLower(m[1]), DurationMS: int64(secs * 1000)})
+	}
+	return cases, nil
+}
+
+// trimMessage keeps failure messages to a size that is reasonable to store
+// with every QA run.
+func trimMessage(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > 4000 {
+		// back off to a rune boundary so the cut never splits a character
+		i := 4000
+		for i > 0 && !utf8.RuneStart(s[i]) {
+			i--
+		}
+		s = s[:i] + "…"
+	}
+	return s
+}
+
+func firstNonEmpty(ss ...string) string {
+	for _, s := range ss {
+		if strings.TrimSpace(s) != "" {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+package qa
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestGoVerboseParserSuite(t *testing.T) {
+	out := Output{Stdout: strings.Join([]string{
+		"=== RUN   TestAdd",
+		"--- PASS: TestAdd (0.00s)",
+		"=== RUN   TestSub",
+		"=== RUN   TestSub/negative",
+		"    --- FAIL: TestSub/negative (0.01s)",
+		"--- FAIL: TestSub (0.01s)",
+		"FAIL",
+		"FAIL\texample.com/calc\t0.012s",
+		"=== RUN   TestParse",
+		"--- SKIP: TestParse (0.00s)",
+		"PASS",
+		"ok  \texample.com/calc/parse\t0.003s",
+		"?   \texample.com/calc/cmd\t[no test files]",
+	}, "\n")}
+	cases, err := GoVerboseParser{}.Parse(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TestCase{
+		{Name: "TestAdd", Suite: "example.com/calc", Status: StatusPass},
+		{Name: "TestSub/negative", Suite: "example.com/calc", Status: StatusFail, DurationMS: 10},
+		{Name: "TestSub", Suite: "example.com/calc", Status: StatusFail, DurationMS: 10},
+		{Name: "TestParse", Suite: "example.com/calc/parse", Status: StatusSkip},
+	}
+	if len(cases) != len(want) {
+		t.Fatalf("got %d cases, want %d: %+v", len(cases), len(want), cases)
+	}
+	for i := range want {
+		if cases[i] != want[i] {
+			t.Errorf("case %d = %+v, want %+v", i, cases[i], want[i])
+		}
+	}
+}
+
+func TestTrimMessage(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		max  int
+	}{
+		{"short", "  boom  ", 4},
+		{"ascii", strings.Repeat("a", 5000), 4000 + len("…")},
+		{"multibyte at the cut", strings.Repeat("a", 3999) + strings.Repeat("é", 100), 3999 + len("…")},
+	} {
+		got := trimMessage(tc.in)
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: trimMessage split a rune", tc.name)
+		}
+		if len(got) != tc.max {
+			t.Errorf("%s: len = %d, want %d", tc.name, len(got), tc.max)
+		}
+	}
+}
+
+func TestParseResults(t *testing.T) {
+	junit := `<?xml version="1.0"?>
+<testsuites><testsuite name="calc">
+  <testcase classname="tests.test_calc" name="test_add" time="0.012"/>
+  <testcase classname="tests.test_calc" name="test_sub" time="0.5"><failure message="assert 1 == 2">trace</failure></testcase>
+  <testcase classname="tests.test_calc" name="test_div"><error>ZeroDivisionError</error></testcase>
+  <testcase classname="tests.test_calc" name="test_mul"><skipped/></testcase>
+</testsuite></testsuites>`
+	jest := `{"numTotalTests":3,"testResults":[{"name":"/repo/src/calc.test.js","assertionResults":[` +
+		`{"fullName":"calc adds","status":"passed","duration":4,"failureMessages":[]},` +
+		`{"fullName":"calc subtracts","status":"failed","duration":2,"failureMessages":["expected 1","received 2"]},` +
+		`{"fullName":"calc divides","status":"pending","duration":null,"failureMessages":[]}]}]}`
+	for _, tc := range []struct {
+		name   string
+		out    Output
+		format string
+		parser string
+		want   []string // key status duration message
+	}{
+		{
+			"go json", Output{Stdout: strings.Join([]string{
+				`{"Action":"run","Package":"example.com/calc","Test":"TestSub"}`,
+				`{"Action":"output","Package":"example.com/calc","Test":"TestSub","Output":"    calc_test.go:9: got 3, want 4\n"}`,
+				`{"Action":"fail","Package":"example.com/calc","Test":"TestSub","Elapsed":0.01}`,
+				`{"Action":"pass","Package":"example.com/calc","Test":"TestAdd","Elapsed":0}`,
+				`{"Action":"pass","Package":"example.com/calc","Elapsed":0.02}`,
+			}, "\n")}, "", "go-json",
+			[]string{"example.com/calc::TestAdd pass 0 ", "example.com/calc::TestSub fail 10 calc_test.go:9: got 3, want 4"},
+		},
+		{
+			"junit report file", Output{Stdout: "collected 4 items", Reports: map[string][]byte{"report.xml": []byte(junit)}}, "", "junit",
+			[]string{
+				"tests.test_calc::test_add pass 12 ", "tests.test_calc::test_div fail 0 ZeroDivisionError",
+				"tests.test_calc::test_mul skip 0 ", "tests.test_calc::test_sub fail 500 assert 1 == 2",
+			},
+		},
+		{
+			"junit in stdout", Output{Stdout: "running\n" + junit}, "", "junit",
+			[]string{
+				"tests.test_calc::test_add pass 12 ", "tests.test_calc::test_div fail 0 ZeroDivisionError",
+				"tests.test_calc::test_mul skip 0 ", "tests.test_calc::test_sub fail 500 assert 1 == 2",
+			},
+		},
+		{
+			"jest after other output", Output{Stdout: "> jest --json\n" + jest}, "", "jest",
+			[]string{"src/calc.test.js::calc adds pass 4 ", "src/calc.test.js::calc divides skip 0 ", "src/calc.test.js::calc subtracts fail 2 expected 1\nreceived 2"},
+		},
+		{
+			"pytest verbose and summary", Output{Stdout: strings.Join([]string{
+				"tests/test_calc.py::test_add PASSED [ 33%]",
+				"tests/test_calc.py::test_sub FAILED [ 66%]",
+				"tests/test_calc.py::test_old XFAIL [100%]",
+				"FAILED tests/test_calc.py::test_sub - assert 1 == 2",
+			}, "\n")}, "", "pytest",
+			[]string{"tests/test_calc.py::test_add pass 0 ", "tests/test_calc.py::test_old skip 0 ", "tests/test_calc.py::test_sub fail 0 assert 1 == 2"},
+		},
+		{
+			"format forced", Output{Stdout: "--- PASS: TestAdd (0.00s)\nok  \texample.com/calc\t0.01s"}, "go-verbose", "go-verbose",
+			[]string{"example.com/calc::TestAdd pass 0 "},
+		},
+		{"nothing recognized", Output{Stdout: "Ran 3 tests\nOK"}, "", "", nil},
+	} {
+		parser, cases, err := ParseResults(tc.out, tc.format)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var got []string
+		for _, c := range cases {
+			got = append(got, fmt.Sprintf("%s %s %d %s", c.Key(), c.Status, c.DurationMS, c.Message))
+		}
+		if parser != tc.parser || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: parser %q cases %q, want %q %q", tc.name, parser, got, tc.parser, tc.want)
+		}
+	}
+	if _, _, err := ParseResults(Output{}, "tap"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
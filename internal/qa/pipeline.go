@@ -0,0 +1,274 @@
+package qa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"gopkg.in/yaml.v3"
+)
+
+// Pipeline replaces the single test command with ordered stages, e.g. a
+// setup stage running `npm ci` or migrations before the tests. It comes from
+// task.pipeline or a .qa.yml at the start commit:
+//
+//	stages:
+//	  - name: deps
+//	    image: node:20
+//	    command: npm ci
+//	    network: true
+//	  - name: test
+//	    command: npx jest --json --outputFile=reports/jest.json
+//	    tests: true
+//	    timeout: 10m
+//	    resources: {memory: 2g, cpus: 2}
+//	    artifacts: [reports/*.json]
+type Pipeline struct {
+	Stages []Stage `yaml:"stages" json:"stages"`
+}
+
+type Stage struct {
+	Name string `yaml:"name" json:"name"`
+	// Image defaults to the task's test image.
+	Image   string `yaml:"image" json:"image,omitempty"`
+	Command string `yaml:"command" json:"command"`
+	Network bool   `yaml:"network" json:"network,omitempty"`
+	// Timeout is a Go duration such as "90s" or "10m"; default 15m.
+	Timeout   string         `yaml:"timeout" json:"timeout,omitempty"`
+	Resources StageResources `yaml:"resources" json:"resources"`
+	// ContinueOnFailure lets later stages run after this one fails.
+	ContinueOnFailure bool `yaml:"continue_on_failure" json:"continue_on_failure,omitempty"`
+	// Artifacts are globs, relative to the repository root, of files to keep
+	// after the stage.
+	Artifacts []string `yaml:"artifacts" json:"artifacts,omitempty"`
+	// Tests marks the stage whose output holds the test results; the last
+	// stage when none is marked.
+	Tests bool `yaml:"tests" json:"tests,omitempty"`
+
+	timeout time.Duration
+}
+
+type StageResources struct {
+	// Memory is bytes or a size with a k, m or g suffix; default 1g.
+	Memory string  `yaml:"memory" json:"memory,omitempty"`
+	CPUs   float64 `yaml:"cpus" json:"cpus,omitempty"`
+
+	memory int64
+}
+
+// Stage statuses.
+const (
+	StageOK      = "ok"
+	StageFailed  = "failed"
+	StageTimeout = "timeout"
+	StageSkipped = "skipped" // an earlier stage failed
+)
+
+type StageResult struct {
+	Name       string `json:"name"`
+	Image      string `json:"image"`
+	Command    string `json:"command"`
+	Status     string `json:"status"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMS int64  `json:"duration_ms"`
+	Stdout     string `json:"stdout,omitempty"`
+	Stderr     string `json:"stderr,omitempty"`
+	// Files holds the collected artifacts by path; the worker stores them
+	// and records their references in ArtifactRefs.
+	Files        map[string][]byte `json:"-"`
+	ArtifactRefs map[string]string `json:"artifacts,omitempty"`
+}
+
+// MaxPipelineDuration caps the sum of a pipeline's stage timeouts, so a run
+// with a baseline ends well within the QA task's timeout.
+const MaxPipelineDuration = 75 * time.Minute
+
+// ParsePipeline reads a YAML (or JSON) pipeline spec and checks it.
+func ParsePipeline(b []byte) (*Pipeline, error) {
+	var p Pipeline
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("pipeline spec: %w", err)
+	}
+	if len(p.Stages) == 0 {
+		return nil, errors.New("pipeline spec: no stages")
+	}
+	names := map[string]bool{}
+	tests := 0
+	for i := range p.Stages {
+		st := &p.Stages[i]
+		if st.Name == "" {
+			st.Name = "stage-" + strconv.Itoa(i+1)
+		}
+		if names[st.Name] {
+			return nil, fmt.Errorf("pipeline spec: duplicate stage %q", st.Name)
+		}
+		names[st.Name] = true
+		if strings.TrimSpace(st.Command) == "" {
+			return nil, fmt.Errorf("pipeline stage %q: command is required", st.Name)
+		}
+		st.timeout = 15 * time.Minute
+		if st.Timeout != "" {
+			d, err := time.ParseDuration(st.Timeout)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("pipeline stage %q: bad timeout %q", st.Name, st.Timeout)
+			}
+			st.timeout = d
+		}
+		st.Resources.memory = 1 << 30
+		if st.Resources.Memory != "" {
+			n, err := parseSize(st.Resources.Memory)
+			if err != nil {
+				return nil, fmt.Errorf("pipeline stage %q: %w", st.Name, err)
+			}
+			st.Resources.memory = n
+		}
+		if st.Resources.CPUs == 0 {
+			st.Resources.CPUs = 2
+		}
+		for _, g := range st.Artifacts {
+			if !reportGlob.MatchString(g) || strings.Contains(g, "..") {
+				return nil, fmt.Errorf("pipeline stage %q: invalid artifact path %q", st.Name, g)
+			}
+		}
+		if st.Tests {
+			tests++
+		}
+	}
+	if tests > 1 {
+		return nil, errors.New("pipeline spec: more than one tests stage")
+	}
+	if tests == 0 {
+		p.Stages[len(p.Stages)-1].Tests = true
+	}
+	if d := p.duration(); d > MaxPipelineDuration {
+		return nil, fmt.Errorf("pipeline spec: stage timeouts add up to %v, more than %v", d, MaxPipelineDuration)
+	}
+	return &p, nil
+}
+
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	switch strings.ToLower(s[len(s)-1:]) {
+	case "k":
+		mult = 1 << 10
+	case "m":
+		mult = 1 << 20
+	case "g":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad memory size %q", s)
+	}
+	return n * mult, nil
+}
+
+// images lists the stage images, defaulting to the test image.
+func (p *Pipeline) images(def string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, st := range p.Stages {
+		img := st.Image
+		if img == "" {
+			img = def
+		}
+		if !seen[img] {
+			seen[img] = true
+			out = append(out, img)
+		}
+	}
+	return out
+}
+
+// duration is the sum of the stage timeouts, the longest the stages can
+// take.
+func (p *Pipeline) duration() time.Duration {
+	var d time.Duration
+	for _, st := range p.Stages {
+		d += st.timeout
+	}
+	return d
+}
+
+// runPipeline runs the stages in order on the checkout. The tests stage's
+// output becomes the TestResult; if the pipeline stops before it, the
+// result records which stage failed. Artifacts are collected only when
+// collect is set.
+func runPipeline(ctx context.Context, cli *client.Client, volName string, opts RunOptions, collect bool) (*TestResult, []StageResult, error) {
+	var results []StageResult
+	var tests *TestResult
+	stopped := ""
+	for _, st := range opts.Pipeline.Stages {
+		r := StageResult{Name: st.Name, Image: st.Image, Command: st.Command}
+		if r.Image == "" {
+			r.Image = opts.Image
+		}
+		if stopped != "" {
+			r.Status = StageSkipped
+			results = append(results, r)
+			continue
+		}
+
+		cmd := st.Command
+		if st.Tests {
+			cmd = withReportCleanup(opts, cmd)
+		}
+		log.Printf("QA runner: stage %s: %s", st.Name, st.Command)
+		sctx, cancel := context.WithTimeout(ctx, st.timeout)
+		start := time.Now()
+		stdout, stderr, exitCode, err := runSandboxed(sctx, cli, opts.profile, r.Image, volName,
+			[]string{"sh", "-c", fmt.Sprintf("cd /repo && %s", cmd)}, st.Network, &container.Resources{
+				Memory:   st.Resources.memory,
+				NanoCPUs: int64(st.Resources.CPUs * 1e9),
+			})
+		// only the stage's own deadline is a stage timeout; when the run's
+		// deadline passed, the pipeline was cut short
+		timedOut := sctx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+		cancel()
+		r.DurationMS = time.Since(start).Milliseconds()
+		r.Stdout, r.Stderr, r.ExitCode = stdout, stderr, exitCode
+		switch {
+		case ctx.Err() != nil:
+			return nil, results, fmt.Errorf("stage %s: QA deadline exceeded: %w", st.Name, ctx.Err())
+		case timedOut:
+			r.Status = StageTimeout
+		case err != nil:
+			return nil, results, fmt.Errorf("stage %s: %w", st.Name, err)
+		case exitCode != 0:
+			r.Status = StageFailed
+		default:
+			r.Status = StageOK
+		}
+
+		if collect && len(st.Artifacts) > 0 {
+			files, err := copyFilesFromVolume(ctx, cli, volName, st.Artifacts)
+			if err != nil {
+				log.Printf("QA runner: stage %s: collect artifacts: %v", st.Name, err)
+			}
+			r.Files = files
+		}
+		if st.Tests {
+			tests = &TestResult{OK: r.Status == StageOK, Stdout: stdout, Stderr: stderr, ExitCode: exitCode}
+			parseTestOutput(ctx, cli, volName, opts, tests)
+		}
+		if r.Status != StageOK && !st.ContinueOnFailure {
+			stopped = st.Name
+		}
+		results = append(results, r)
+	}
+
+	if tests == nil {
+		tests = &TestResult{ExitCode: -1, Cases: []TestCase{}, Summary: Summarize(nil),
+			Stderr: fmt.Sprintf("pipeline stopped at stage %s before the tests ran", stopped)}
+	}
+	return tests, results, nil
+}
@@ -0,0 +1,47 @@
+package qa
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipelineDuration(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want time.Duration
+	}{
+		{"stages:\n  - command: go test ./...\n", 15 * time.Minute},
+		{"stages:\n  - command: npm ci\n    timeout: 20m\n  - command: npm test\n    timeout: 90s\n", 21*time.Minute + 30*time.Second},
+		{"stages:\n  - command: make deps\n  - command: make test\n    timeout: 1h\n", 75 * time.Minute},
+	} {
+		p, err := ParsePipeline([]byte(tc.spec))
+		if err != nil {
+			t.Fatalf("ParsePipeline(%q): %v", tc.spec, err)
+		}
+		if got := p.duration(); got != tc.want {
+			t.Errorf("duration of %q = %v, want %v", tc.spec, got, tc.want)
+		}
+	}
+}
+
+func TestParsePipelineDurationCap(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		ok   bool
+	}{
+		{"stages:\n  - command: make test\n    timeout: 75m\n", true},
+		{"stages:\n  - command: make deps\n    timeout: 1h\n  - command: make test\n    timeout: 15m\n", true},
+		{"stages:\n  - command: make deps\n    timeout: 1h\n  - command: make test\n", true},
+		{"stages:\n  - command: make test\n    timeout: 76m\n", false},
+		{"stages:\n  - command: make deps\n    timeout: 1h\n  - command: make lint\n  - command: make test\n", false},
+	} {
+		_, err := ParsePipeline([]byte(tc.spec))
+		if (err == nil) != tc.ok {
+			t.Errorf("ParsePipeline(%q) = %v, want ok %v", tc.spec, err, tc.ok)
+		}
+		if err != nil && !strings.Contains(err.Error(), "add up to") {
+			t.Errorf("ParsePipeline(%q) = %v, want the timeout cap", tc.spec, err)
+		}
+	}
+}
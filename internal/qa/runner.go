@@ -8,6 +8,8 @@ import (
 	"io"
 	"log"
 	"os"
+	"path"
+	"regexp"
 	"strings"
 	"time"
 
@@ -17,6 +19,8 @@ import (
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
+
+	"datacurve-takehome/internal/vault"
 )
 
 type TestResult struct {
@@ -24,13 +28,102 @@ type TestResult struct {
 	Stdout   string `json:"stdout"`
 	Stderr   string `json:"stderr"`
 	ExitCode int    `json:"exit_code"`
+	// Parser names the report format the cases were read from.
+	Parser  string     `json:"parser,omitempty"`
+	Cases   []TestCase `json:"cases"`
+	Summary *Summary   `json:"summary"`
+	// ParseError is set when a report was found but could not be read.
+	ParseError string `json:"parse_error,omitempty"`
+}
+
+type RunOptions struct {
+	RepoURL     string
+	StartCommit string
+	// Mirror is the path of a bare mirror of RepoURL that holds
+	// StartCommit. Runners that can reach it clone from it instead of
+	// RepoURL, falling back to RepoURL if that fails.
+	Mirror string
+	// Snapshot, when set, is a tar of the repository extracted in place of
+	// cloning RepoURL at StartCommit. It is committed to a fresh git
+	// repository so the baseline reset and git apply work as usual.
+	Snapshot []byte
+	// Credential, when set, authenticates clones of RepoURL. Only the clone
+	// sees it, through its environment; errors are scrubbed of it.
+	Credential *vault.Credential
+	Patch      string
+	Image      string
+	Command    string
+	// Baseline also runs Command on the unpatched start commit first.
+	Baseline bool
+	// Reports are globs, relative to the repository root, of report files
+	// (JUnit XML, Jest JSON) the command writes. They are collected after
+	// each run and parsed ahead of the logs.
+	Reports []string
+	// Format forces a parser by name instead of detecting one.
+	Format string
+	// Hidden, when set, is extracted into the repository after the patched
+	// run and run separately.
+	Hidden *HiddenTests
+	// Pipeline, when set, replaces Command with ordered stages for both the
+	// baseline and the patched run.
+	Pipeline *Pipeline
+	// Lint, when set, runs static analyzers on the start commit and on the
+	// patched tree and reports the diagnostics the patch introduced.
+	Lint *LintOptions
+	// Coverage, when set, measures which lines the patch changes the tests
+	// execute, after the patched run.
+	Coverage *CoverageOptions
+	// Mutation, when set, scores the tests against mutants of the lines the
+	// patch changes, after the patched run.
+	Mutation *MutationOptions
+	// Sandbox holds the task's overrides of the sandbox profile; the
+	// runner checks them against its policy.
+	Sandbox *SandboxSpec
+
+	// profile is the resolved sandbox profile; DockerRunner sets it.
+	profile *SandboxProfile
+}
+
+// HiddenTests are held-out test files the contributor never sees.
+type HiddenTests struct {
+	// Archive is a tar (optionally gzip, bzip2 or xz compressed) whose
+	// paths are relative to the repository root.
+	Archive []byte
+	// Command runs them; defaults to RunOptions.Command.
+	Command string
+}
+
+type RunResult struct {
+	Baseline *TestResult `json:"baseline,omitempty"`
+	Patched  *TestResult `json:"patched"`
+	// Apply records how the patch was applied, or why it couldn't be.
+	Apply *ApplyReport `json:"apply,omitempty"`
+	// BaselineStages and Stages are the pipeline stage results of each run;
+	// only the patched run's artifacts are collected.
+	BaselineStages []StageResult `json:"baseline_stages,omitempty"`
+	Stages         []StageResult `json:"stages,omitempty"`
+	// Hidden holds only the tests the hidden run added, without logs or
+	// failure messages, so hidden test code can't leak through results.
+	Hidden   *TestResult     `json:"hidden,omitempty"`
+	Sandbox  *SandboxProfile `json:"sandbox,omitempty"`
+	Lint     *LintReport     `json:"lint,omitempty"`
+	Coverage *CoverageReport `json:"coverage,omitempty"`
+	Mutation *MutationReport `json:"mutation,omitempty"`
 }
 
-// RunTests clones repo@startCommit into a docker *volume*, applies finalPatch,
-// then runs `cmd` inside `image` with /repo mounted read-write.
+// RunTests clones repo@startCommit into a docker *volume*, optionally runs the
+// tests there as a baseline, applies the patch, then runs the command inside
+// the image with /repo mounted read-write.
 // Requires DOCKER_HOST to point to your DinD (e.g., tcp://dind:2375).
-func RunTests(ctx context.Context, repoURL, startCommit, finalPatch, image, cmd string) (*TestResult, error) {
+// This is the DockerRunner backend; called directly, it runs without a
+// sandbox profile.
+func RunTests(ctx context.Context, opts RunOptions) (*RunResult, error) {
 	log.Println("QA runner: starting test run")
+	for _, g := range opts.Reports {
+		if !reportGlob.MatchString(g) || strings.Contains(g, "..") {
+			return nil, fmt.Errorf("invalid report path %q", g)
+		}
+	}
 	cli, err := client.NewClientWithOpts(
 		client.FromEnv,
 		client.WithAPIVersionNegotiation(),
@@ -45,20 +138,30 @@ func RunTests(ctx context.Context, repoURL, startCommit, finalPatch, image, cmd
 	}
 	log.Println("QA runner: docker daemon reachable")
 
-	// We scope a generous timeout per phase
-	phaseCtx, cancel := context.WithTimeout(ctx, 15*time.Minute)
+	phaseCtx, cancel := context.WithTimeout(ctx, runTimeout(opts))
 	defer cancel()
 
-	log.Println("QA runner: pulling test image", image)
-	if err := pullIfNeeded(phaseCtx, cli, image); err != nil {
-		return nil, fmt.Errorf("pull test image %s: %w", image, err)
+	images := []string{opts.Image}
+	if opts.Pipeline != nil {
+		images = opts.Pipeline.images(opts.Image)
+	}
+	for _, image := range images {
+		log.Println("QA runner: pulling test image", image)
+		if err := pullIfNeeded(phaseCtx, cli, image); err != nil {
+			return nil, fmt.Errorf("pull test image %s: %w", image, err)
+		}
 	}
 
 	log.Println("QA runner: preparing volume and running tests")
 	volName := fmt.Sprintf("qa-runner-%d", time.Now().UnixNano())
 
 	// Create a named volume for repo contents
-	if _, err := cli.VolumeCreate(phaseCtx, volume.CreateOptions{Name: volName}); err != nil {
+	vopts := volume.CreateOptions{Name: volName}
+	if p := opts.profile; p != nil && p.RepoMB > 0 {
+		vopts.Driver = "local"
+		vopts.DriverOpts = map[string]string{"type": "tmpfs", "device": "tmpfs", "o": fmt.Sprintf("size=%dm", p.RepoMB)}
+	}
+	if _, err := cli.VolumeCreate(phaseCtx, vopts); err != nil {
 		return nil, fmt.Errorf("volume create: %w", err)
 	}
 	// Always attempt cleanup on exit
@@ -74,60 +177,257 @@ func RunTests(ctx context.Context, repoURL, startCommit, finalPatch, image, cmd
 	if err := pullIfNeeded(phaseCtx, cli, gitImage); err != nil {
 		return nil, fmt.Errorf("pull %s: %w", gitImage, err)
 	}
-	log.Println("QA runner: cloning repo", repoURL, "commit", startCommit)
-	// 1) git clone
-	if err := runOneShotNet(phaseCtx, cli, gitImage, volName,
-		[]string{"clone", repoURL, "/repo"},
-		true, nil, true,
-	); err != nil {
-		return nil, fmt.Errorf("git clone phase: %w", err)
-	}
-	log.Println("QA runner: cloned repo")
-	// 2) optional checkout
-	if c := strings.TrimSpace(startCommit); c != "" && c != "HEAD" {
-		if err := runOneShotNet(phaseCtx, cli, gitImage, volName,
-			[]string{"-C", "/repo", "checkout", c},
-			true, nil, true,
-		); err != nil {
-			return nil, fmt.Errorf("git checkout %q: %w", c, err)
+	if opts.Snapshot != nil {
+		log.Println("QA runner: extracting repository snapshot")
+		if err := copyArchiveToVolume(phaseCtx, cli, volName, opts.Snapshot); err != nil {
+			return nil, fmt.Errorf("copy snapshot: %w", err)
+		}
+		for _, c := range snapshotGitInit {
+			if err := runOneShot(phaseCtx, cli, gitImage, volName, append([]string{"-C", "/repo"}, c...), true, nil); err != nil {
+				return nil, fmt.Errorf("snapshot git init: %w", err)
+			}
+		}
+	} else {
+		log.Println("QA runner: cloning repo", vault.Scrub(opts.RepoURL, nil), "commit", opts.StartCommit)
+		// 1) git clone, from the worker's mirror when the daemon can see it
+		cloned := false
+		if opts.Mirror != "" {
+			if err := cloneFromMirror(phaseCtx, cli, gitImage, volName, opts); err != nil {
+				log.Printf("QA runner: clone from mirror %s failed, cloning upstream: %s", opts.Mirror, vault.Scrub(err.Error(), nil))
+			} else {
+				cloned = true
+			}
+		}
+		if !cloned {
+			// only the clone container gets the credential
+			if err := runOneShotNet(phaseCtx, cli, gitImage, volName,
+				[]string{"clone", opts.RepoURL, "/repo"},
+				true, nil, true, withEnv(opts.Credential.Env()),
+			); err != nil {
+				return nil, fmt.Errorf("git clone phase: %s", vault.Scrub(err.Error(), opts.Credential))
+			}
+		}
+		log.Println("QA runner: cloned repo")
+		// 2) optional checkout
+		if c := strings.TrimSpace(opts.StartCommit); c != "" && c != "HEAD" {
+			if err := runOneShotNet(phaseCtx, cli, gitImage, volName,
+				[]string{"-C", "/repo", "checkout", c},
+				true, nil, true,
+			); err != nil {
+				return nil, fmt.Errorf("git checkout %q: %w", c, err)
+			}
+		}
+		log.Println("QA runner: checked out commit", opts.StartCommit)
+	}
+
+	// from here on repository code runs, and may write to .git
+	rgit, err := newRepoGit(phaseCtx, cli, gitImage, volName, opts.profile)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &RunResult{Sandbox: opts.profile}
+	var lintBefore map[string][]Diagnostic
+	var lintAnalyzers []analyzer
+	if opts.Lint != nil {
+		if lintAnalyzers, err = selectAnalyzers(opts.Lint.Analyzers); err != nil {
+			return nil, err
+		}
+		log.Println("QA runner: linting the start commit")
+		if _, lintBefore, err = lintTree(phaseCtx, cli, volName, opts, lintAnalyzers); err != nil {
+			return nil, fmt.Errorf("lint start commit: %w", err)
+		}
+	}
+	if opts.Baseline {
+		log.Println("QA runner: running baseline tests on the start commit")
+		res, stages, err := runSuite(phaseCtx, cli, volName, opts, false)
+		out.Baseline, out.BaselineStages = res, stages
+		if err != nil {
+			return out, fmt.Errorf("baseline test run: %w", err)
+		}
+		// undo whatever the baseline run wrote before applying the patch
+		if err := rgit.reset(phaseCtx); err != nil {
+			return out, fmt.Errorf("reset after baseline: %w", err)
 		}
 	}
-	log.Println("QA runner: checked out commit", startCommit)
 
 	// --- Phase 2: apply patch if provided ---
-	if strings.TrimSpace(finalPatch) != "" {
+	if strings.TrimSpace(opts.Patch) != "" {
 		// Put /patch.diff into a tiny helper container (alpine/git has sh)
-		if err := copyBytesToVolume(phaseCtx, cli, volName, "patch.diff", []byte(finalPatch)); err != nil {
-			return nil, fmt.Errorf("copy patch: %w", err)
+		if err := copyBytesToVolume(phaseCtx, cli, volName, "patch.diff", []byte(opts.Patch)); err != nil {
+			return out, fmt.Errorf("copy patch: %w", err)
 		}
 
 		log.Println("QA runner: applying patch")
-		applyCmd := []string{"-C", "/repo", "apply", "patch.diff"}
-		if err := runOneShot(phaseCtx, cli, "alpine/git:latest", volName, applyCmd, true, nil); err != nil {
-			return nil, fmt.Errorf("git apply: %w", err)
+		out.Apply, err = applyPatch(phaseCtx, dockerApplier(cli, rgit, volName, opts.Image, opts.profile), opts.Patch)
+		if err != nil {
+			return out, fmt.Errorf("apply patch: %w", err)
+		}
+	}
+
+	if opts.Lint != nil {
+		log.Println("QA runner: linting the patched tree")
+		status, after, err := lintTree(phaseCtx, cli, volName, opts, lintAnalyzers)
+		if err != nil {
+			return out, fmt.Errorf("lint patched tree: %w", err)
 		}
-		log.Println("QA runner: applied patch")
+		names := make([]string, len(lintAnalyzers))
+		for i, a := range lintAnalyzers {
+			names[i] = a.name
+		}
+		out.Lint = CompareLint(names, status, lintBefore, after)
 	}
 
-	log.Println("QA runner: running tests with command:", cmd)
+	log.Println("QA runner: running tests with command:", opts.Command)
 
 	// --- Phase 3: run tests ---
+	res, stages, err := runSuite(phaseCtx, cli, volName, opts, true)
+	out.Patched, out.Stages = res, stages
+	if err != nil {
+		return out, fmt.Errorf("test run: %w", err)
+	}
+
+	// --- Phase 4: diff coverage ---
+	if opts.Coverage != nil && strings.TrimSpace(opts.Patch) != "" {
+		log.Println("QA runner: measuring diff coverage")
+		cov, err := runCoverage(phaseCtx, cli, volName, opts)
+		if err != nil {
+			// a missing coverage tool shouldn't fail the QA run
+			log.Printf("QA runner: coverage: %v", err)
+			cov = &CoverageReport{Format: opts.Coverage.Format, Files: []FileCoverage{}, Error: err.Error()}
+		}
+		out.Coverage = cov
+	}
+
+	// --- Phase 5: mutation testing ---
+	// mutants are only killed by failing tests, so a failing suite would
+	// kill them all
+	if opts.Mutation != nil && strings.TrimSpace(opts.Patch) != "" {
+		if !res.OK {
+			out.Mutation = SkippedMutation("the patched tests fail")
+		} else {
+			log.Println("QA runner: running mutation tests")
+			// its own budget, not what's left of the phase's
+			out.Mutation, err = runMutants(ctx, cli, volName, opts)
+			if err != nil {
+				return out, fmt.Errorf("mutation testing: %w", err)
+			}
+		}
+	}
+
+	// --- Phase 6: hidden tests ---
+	if h := opts.Hidden; h != nil {
+		log.Println("QA runner: running hidden tests")
+		hctx, cancel := context.WithTimeout(ctx, 15*time.Minute)
+		defer cancel()
+		if err := copyArchiveToVolume(hctx, cli, volName, h.Archive); err != nil {
+			return out, fmt.Errorf("copy hidden tests: %w", err)
+		}
+		hopts := opts
+		if h.Command != "" {
+			hopts.Command = h.Command
+		}
+		hres, err := runTestCommand(hctx, cli, volName, hopts)
+		if err != nil {
+			return out, fmt.Errorf("hidden test run: %w", err)
+		}
+		out.Hidden = hiddenResult(res, hres)
+	}
+	return out, nil
+}
+
+// hiddenResult keeps the cases of the hidden run that the visible run did
+// not have, and drops everything that could echo hidden test code.
+func hiddenResult(visible, hidden *TestResult) *TestResult {
+	seen := outcomes(visible.Cases)
+	out := &TestResult{OK: hidden.OK, ExitCode: hidden.ExitCode, Parser: hidden.Parser, Cases: []TestCase{}}
+	for _, c := range hidden.Cases {
+		if _, ok := seen[c.Key()]; ok {
+			continue
+		}
+		c.Message = ""
+		out.Cases = append(out.Cases, c)
+	}
+	out.Summary = Summarize(out.Cases)
+	return out
+}
+
+// snapshotGitInit turns an extracted snapshot into a one-commit repository.
+var snapshotGitInit = [][]string{
+	{"init", "-q"},
+	{"add", "-A"},
+	{"-c", "user.name=qa", "-c", "user.email=qa@localhost", "commit", "-q", "--allow-empty", "-m", "snapshot"},
+}
+
+// reportGlob limits report globs to characters that are safe to splice into
+// the shell command.
+var reportGlob = regexp.MustCompile(`^[A-Za-z0-9_./*?-]+$`)
+
+// runSuite runs the pipeline when there is one and the test command
+// otherwise.
+func runSuite(ctx context.Context, cli *client.Client, volName string, opts RunOptions, collect bool) (*TestResult, []StageResult, error) {
+	if opts.Pipeline != nil {
+		return runPipeline(ctx, cli, volName, opts, collect)
+	}
+	res, err := runTestCommand(ctx, cli, volName, opts)
+	return res, nil, err
+}
+
+// runTestCommand runs the test command in /repo, collects its report files
+// and parses per-test results. On error the result still carries whatever
+// logs were captured.
+func runTestCommand(ctx context.Context, cli *client.Client, volName string, opts RunOptions) (*TestResult, error) {
 	// Security: disable network by default; set small resources as an example.
 	res := &TestResult{}
-	testCmd := []string{"sh", "-c", fmt.Sprintf("cd /repo && %s", cmd)}
-	stdout, stderr, exitCode, err := runWithLogs(phaseCtx, cli, image, volName, testCmd, false, &container.Resources{
+	testCmd := []string{"sh", "-c", fmt.Sprintf("cd /repo && %s", withReportCleanup(opts, opts.Command))}
+	stdout, stderr, exitCode, err := runSandboxed(ctx, cli, opts.profile, opts.Image, volName, testCmd, false, &container.Resources{
 		Memory:   1 << 30, // 1 GiB
 		NanoCPUs: 2e9,     // 2 CPUs
 	})
 	res.Stdout, res.Stderr, res.ExitCode = stdout, stderr, exitCode
 	res.OK = (err == nil && exitCode == 0)
 	if err != nil {
-		// Return both error and captured logs/exit for debugging
-		return res, fmt.Errorf("test run: %w", err)
+		return res, err
 	}
+	parseTestOutput(ctx, cli, volName, opts, res)
 	return res, nil
 }
 
+// withReportCleanup prefixes cmd so that stale reports (e.g. ignored files
+// left by the baseline run) are not mistaken for this run's.
+func withReportCleanup(opts RunOptions, cmd string) string {
+	if len(opts.Reports) == 0 {
+		return cmd
+	}
+	return fmt.Sprintf("rm -f -- %s; %s", strings.Join(opts.Reports, " "), cmd)
+}
+
+// parseTestOutput collects the run's report files and fills in the parsed
+// test cases.
+func parseTestOutput(ctx context.Context, cli *client.Client, volName string, opts RunOptions, res *TestResult) {
+	out := Output{Stdout: res.Stdout, Stderr: res.Stderr}
+	if len(opts.Reports) > 0 {
+		reports, cerr := copyFilesFromVolume(ctx, cli, volName, opts.Reports)
+		if cerr != nil {
+			log.Printf("QA runner: collect reports: %v", cerr)
+		}
+		out.Reports = reports
+	}
+	fillCases(res, out, opts.Format)
+}
+
+// fillCases parses out into res.
+func fillCases(res *TestResult, out Output, format string) {
+	parser, cases, perr := ParseResults(out, format)
+	res.Parser, res.Cases, res.Summary = parser, cases, Summarize(cases)
+	if res.Cases == nil {
+		res.Cases = []TestCase{}
+	}
+	if perr != nil {
+		res.ParseError = perr.Error()
+	}
+}
+
 // --- helpers ---
 
 func pullIfNeeded(ctx context.Context, cli *client.Client, image string) error {
@@ -157,6 +457,45 @@ func checkoutCmd(commit string) string {
 	return fmt.Sprintf("git checkout %q", commit)
 }
 
+// cloneFromMirror clones the bare mirror at opts.Mirror, bind-mounted
+// read-only, into /repo without network access, then points origin back at
+// the upstream URL. The daemon must see the mirror at the same path as the
+// worker (docker-compose mounts the git-mirrors volume into both).
+func cloneFromMirror(ctx context.Context, cli *client.Client, gitImage, volName string, opts RunOptions) error {
+	mirror := mount.Mount{Type: mount.TypeBind, Source: opts.Mirror, Target: "/mirror", ReadOnly: true}
+	for _, cmd := range [][]string{
+		// the mirror belongs to the worker's user, not the container's
+		{"-c", "safe.directory=*", "clone", "--no-local", "-q", "/mirror", "/repo"},
+		{"-C", "/repo", "remote", "set-url", "origin", opts.RepoURL},
+	} {
+		stdout, stderr, exitCode, err := runWithLogs(ctx, cli, gitImage, volName, cmd, false, nil, withMount(mirror))
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return fmt.Errorf("%s exit code=%d\n%s%s", strings.Join(cmd, " "), exitCode, stdout, stderr)
+		}
+	}
+	return nil
+}
+
+// dockerApplier applies /repo/patch.diff with git through g and with
+// patch(1) in the test image, which is likelier to have GNU patch. The test
+// image is the repository's choice, so it runs under the sandbox profile s.
+func dockerApplier(cli *client.Client, g *repoGit, volName, testImage string, s *SandboxProfile) patchApplier {
+	return patchApplier{
+		git: func(ctx context.Context, args ...string) (string, int, error) {
+			return g.run(ctx, append(append([]string{"apply"}, args...), "patch.diff")...)
+		},
+		patchFuzz: func(ctx context.Context) (string, int, error) {
+			cmd := []string{"sh", "-c", "cd /repo && " + patchFuzzScript, "sh", "patch.diff"}
+			stdout, stderr, exitCode, err := runSandboxed(ctx, cli, s, testImage, volName, cmd, false, nil)
+			return stdout + stderr, exitCode, err
+		},
+		reset: g.reset,
+	}
+}
+
 // runOneShot runs a short-lived container with /repo mounted from a volume.
 // If expectZeroExit is true, returns error on non-zero exit.
 func runOneShot(ctx context.Context, cli *client.Client, image, volName string, cmd []string, expectZeroExit bool, res *container.Resources) error {
@@ -172,8 +511,8 @@ func runOneShot(ctx context.Context, cli *client.Client, image, volName string,
 	return nil
 }
 
-func runOneShotNet(ctx context.Context, cli *client.Client, image, volName string, cmd []string, expectZeroExit bool, res *container.Resources, netEnabled bool) error {
-	stdout, stderr, exitCode, err := runWithLogs(ctx, cli, image, volName, cmd, netEnabled, res)
+func runOneShotNet(ctx context.Context, cli *client.Client, image, volName string, cmd []string, expectZeroExit bool, res *container.Resources, netEnabled bool, copts ...containerOption) error {
+	stdout, stderr, exitCode, err := runWithLogs(ctx, cli, image, volName, cmd, netEnabled, res, copts...)
 	if err != nil {
 		// surface logs on error for easier debugging
 		return fmt.Errorf("%s failed (exit=%d)\nstdout:\n%s\nstderr:\n%s\nerr: %w",
@@ -186,8 +525,23 @@ func runOneShotNet(ctx context.Context, cli *client.Client, image, volName strin
 	return nil
 }
 
+// containerOption adjusts a container before runWithLogs creates it.
+type containerOption func(*container.Config, *container.HostConfig)
+
+func withMount(m mount.Mount) containerOption {
+	return func(_ *container.Config, h *container.HostConfig) { h.Mounts = append(h.Mounts, m) }
+}
+
+func withEnv(env []string) containerOption {
+	return func(c *container.Config, _ *container.HostConfig) { c.Env = append(c.Env, env...) }
+}
+
+func withEntrypoint(ep ...string) containerOption {
+	return func(c *container.Config, _ *container.HostConfig) { c.Entrypoint = ep }
+}
+
 // runWithLogs creates a container, attaches /repo volume, runs cmd, collects logs, cleans up.
-func runWithLogs(ctx context.Context, cli *client.Client, image, volName string, cmd []string, netEnabled bool, res *container.Resources) (stdout, stderr string, exitCode int, err error) {
+func runWithLogs(ctx context.Context, cli *client.Client, image, volName string, cmd []string, netEnabled bool, res *container.Resources, copts ...containerOption) (stdout, stderr string, exitCode int, err error) {
 	networkMode := container.NetworkMode("none")
 	if netEnabled {
 		networkMode = ""
@@ -203,12 +557,16 @@ func runWithLogs(ctx context.Context, cli *client.Client, image, volName string,
 	if res != nil {
 		hostCfg.Resources = *res
 	}
-
-	create, err := cli.ContainerCreate(ctx, &container.Config{
+	cfg := &container.Config{
 		Image: image,
 		Cmd:   cmd,
 		Tty:   false,
-	}, hostCfg, nil, nil, "")
+	}
+	for _, o := range copts {
+		o(cfg, hostCfg)
+	}
+
+	create, err := cli.ContainerCreate(ctx, cfg, hostCfg, nil, nil, "")
 	if err != nil {
 		return "", "", 0, fmt.Errorf("create: %w", err)
 	}
@@ -259,6 +617,45 @@ func runWithLogs(ctx context.Context, cli *client.Client, image, volName string,
 // then using the "archive upload" API (CopyToContainer).
 func copyBytesToVolume(ctx context.Context, cli *client.Client, volName, destPath string, data []byte) error {
 	log.Printf("copyBytesToVolume: copying to %s in volume %s", destPath, volName)
+	if err := copyFileToVolume(ctx, cli, volName, &tar.Header{Name: destPath, Mode: 0644}, data); err != nil {
+		return err
+	}
+	log.Printf("copyBytesToVolume: successfully copied %s", destPath)
+	return nil
+}
+
+// copyFileToVolume writes data into /repo with hdr's name, mode, owner and
+// times, so a file read with readVolumeFiles can be put back as it was.
+func copyFileToVolume(ctx context.Context, cli *client.Client, volName string, hdr *tar.Header, data []byte) error {
+	archive, err := fileArchive(hdr, data)
+	if err != nil {
+		return err
+	}
+	return copyArchiveToVolume(ctx, cli, volName, archive)
+}
+
+// fileArchive builds a one-file tar archive holding data under hdr.
+func fileArchive(hdr *tar.Header, data []byte) ([]byte, error) {
+	h := *hdr
+	h.Typeflag = tar.TypeReg
+	h.Size = int64(len(data))
+	tarBuf := new(bytes.Buffer)
+	tw := tar.NewWriter(tarBuf)
+	if err := tw.WriteHeader(&h); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return tarBuf.Bytes(), nil
+}
+
+// copyArchiveToVolume extracts a tar archive into /repo. The daemon accepts
+// plain, gzip, bzip2 and xz archives.
+func copyArchiveToVolume(ctx context.Context, cli *client.Client, volName string, archive []byte) error {
 	// Helper container (alpine/git) with /repo mounted
 	create, err := cli.ContainerCreate(ctx, &container.Config{
 		Image: "alpine/git:latest",
@@ -285,32 +682,119 @@ func copyBytesToVolume(ctx context.Context, cli *client.Client, volName, destPat
 		return fmt.Errorf("copy helper start: %w", err)
 	}
 
-	// Build a tar archive with the file at the requested path
-	tarBuf := new(bytes.Buffer)
-	tw := tar.NewWriter(tarBuf)
-	hdr := &tar.Header{
-		Name: destPath,
-		Mode: 0644,
-		Size: int64(len(data)),
-	}
-	if err := tw.WriteHeader(hdr); err != nil {
+	// Upload to /repo (archive paths are relative to it)
+	err = cli.CopyToContainer(ctx, cid, "/repo", bytes.NewReader(archive), container.CopyToContainerOptions{AllowOverwriteDirWithFile: true})
+	if err != nil {
+		log.Printf("copyArchiveToVolume: CopyToContainer failed: %v", err)
 		return err
 	}
-	if _, err := tw.Write(data); err != nil {
-		return err
+	return nil
+}
+
+// runTimeout bounds a whole run. We scope a generous 15m for the checkout,
+// apply and test commands; a pipeline's stages bring their own timeouts,
+// which each of its runs must be able to use in full.
+func runTimeout(opts RunOptions) time.Duration {
+	d := 15 * time.Minute
+	if opts.Pipeline != nil {
+		runs := time.Duration(1)
+		if opts.Baseline {
+			runs = 2
+		}
+		d += runs * opts.Pipeline.duration()
 	}
-	if err := tw.Close(); err != nil {
-		return err
+	return d
+}
+
+// maxReportSize caps how much of each report file is read back.
+const maxReportSize = 16 << 20
+
+// copyFilesFromVolume reads the files in /repo matching globs using the
+// archive download API (CopyFromContainer) on a helper container. Each glob
+// downloads its directory part and filters the entries, so keep globs
+// narrow.
+func copyFilesFromVolume(ctx context.Context, cli *client.Client, volName string, globs []string) (map[string][]byte, error) {
+	entries, err := readVolumeFiles(ctx, cli, volName, globs)
+	files := make(map[string][]byte, len(entries))
+	for name, e := range entries {
+		files[name] = e.Data
 	}
+	return files, err
+}
 
-	// Upload to /repo (destPath is absolute inside container root)
-	path := "/repo"
-	log.Printf("copyBytesToVolume: uploading to path %s with destPath %s", path, destPath)
-	err = cli.CopyToContainer(ctx, cid, path, bytes.NewReader(tarBuf.Bytes()), container.CopyToContainerOptions{AllowOverwriteDirWithFile: true})
+// volumeFile is a file read from /repo with its tar header, which carries
+// the mode, owner and times. Data is cut at maxReportSize; Header.Size is
+// the file's full size.
+type volumeFile struct {
+	Header *tar.Header
+	Data   []byte
+}
+
+// readVolumeFiles is copyFilesFromVolume keeping each file's header.
+func readVolumeFiles(ctx context.Context, cli *client.Client, volName string, globs []string) (map[string]volumeFile, error) {
+	create, err := cli.ContainerCreate(ctx, &container.Config{
+		Image: "alpine/git:latest",
+		Cmd:   []string{"sleep", "60"},
+		Tty:   false,
+	}, &container.HostConfig{
+		Mounts: []mount.Mount{{
+			Type:   mount.TypeVolume,
+			Source: volName,
+			Target: "/repo",
+		}},
+	}, nil, nil, "")
 	if err != nil {
-		log.Printf("copyBytesToVolume: CopyToContainer failed: %v", err)
-		return err
+		return nil, fmt.Errorf("copy helper create: %w", err)
 	}
-	log.Printf("copyBytesToVolume: successfully copied %s", destPath)
-	return nil
+	cid := create.ID
+	defer func() {
+		timeout := 2
+		_ = cli.ContainerStop(context.Background(), cid, container.StopOptions{Timeout: &timeout})
+		_ = cli.ContainerRemove(context.Background(), cid, container.RemoveOptions{Force: true})
+	}()
+	if err := cli.ContainerStart(ctx, cid, container.StartOptions{}); err != nil {
+		return nil, fmt.Errorf("copy helper start: %w", err)
+	}
+
+	files := map[string]volumeFile{}
+	for _, g := range globs {
+		g = path.Clean(strings.TrimPrefix(g, "./"))
+		// download the longest directory prefix without glob characters
+		dir := g
+		for strings.ContainsAny(dir, "*?") {
+			dir = path.Dir(dir)
+		}
+		rc, stat, err := cli.CopyFromContainer(ctx, cid, path.Join("/repo", dir))
+		if err != nil {
+			continue // nothing matched
+		}
+		tr := tar.NewReader(rc)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			// entries are named after the base of what was copied
+			name := dir
+			if stat.Mode.IsDir() {
+				_, rel, _ := strings.Cut(hdr.Name, "/")
+				name = path.Join(dir, rel)
+			}
+			if ok, _ := path.Match(g, name); !ok {
+				continue
+			}
+			b, err := io.ReadAll(io.LimitReader(tr, maxReportSize))
+			if err != nil {
+				rc.Close()
+				return files, err
+			}
+			hdr.Name = name
+			files[name] = volumeFile{Header: hdr, Data: b}
+		}
+		rc.Close()
+	}
+	return files, nil
 }
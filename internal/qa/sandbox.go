@@ -0,0 +1,296 @@
+package qa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// SandboxProfile hardens the containers that run repository code: the test
+// command, pipeline stages, linters, coverage and mutation runs. They drop
+// every capability, can't gain privileges, and run as User with a
+// read-only root filesystem, a writable /repo and a tmpfs /tmp.
+type SandboxProfile struct {
+	// User is uid:gid; "0:0" when a task was allowed to run as root.
+	User           string `json:"user"`
+	ReadOnlyRootfs bool   `json:"read_only_rootfs"`
+	Pids           int64  `json:"pids"`
+	// NoFile and FileSizeMB are the nofile and fsize ulimits; FileSizeMB
+	// caps every file the tests write.
+	NoFile     int64 `json:"nofile"`
+	FileSizeMB int64 `json:"file_size_mb"`
+	TmpMB      int64 `json:"tmp_mb"`
+	// RepoMB, when set, makes /repo a tmpfs volume of that size.
+	RepoMB int64 `json:"repo_mb,omitempty"`
+	// Seccomp is a seccomp profile (JSON); empty uses the daemon's default
+	// profile.
+	Seccomp string `json:"-"`
+}
+
+// SandboxSpec is task.sandbox, a task's overrides of the default profile.
+type SandboxSpec struct {
+	Pids           *int64 `json:"pids"`
+	NoFile         *int64 `json:"nofile"`
+	FileSizeMB     *int64 `json:"file_size_mb"`
+	TmpMB          *int64 `json:"tmp_mb"`
+	ReadOnlyRootfs *bool  `json:"read_only_rootfs"`
+	Root           *bool  `json:"root"`
+}
+
+// SandboxPolicy is the admin's default profile and the bounds task
+// overrides must stay within.
+type SandboxPolicy struct {
+	Default                                     SandboxProfile
+	MaxPids, MaxNoFile, MaxFileSizeMB, MaxTmpMB int64
+	AllowRoot, AllowWritableRootfs              bool
+}
+
+// SandboxPolicyFromEnv reads the QA_SANDBOX_* settings. QA_SANDBOX=off
+// disables the profile (nil policy). Each limit has a default and a
+// QA_SANDBOX_MAX_* bound for task overrides, which defaults to the default,
+// so tasks can only tighten limits unless the admin raises the bound.
+func SandboxPolicyFromEnv() (*SandboxPolicy, error) {
+	if strings.ToLower(os.Getenv("QA_SANDBOX")) == "off" {
+		return nil, nil
+	}
+	p := &SandboxPolicy{Default: SandboxProfile{User: os.Getenv("QA_SANDBOX_USER"), ReadOnlyRootfs: true}}
+	if p.Default.User == "" {
+		p.Default.User = "1000:1000"
+	}
+	if uid, _, _ := strings.Cut(p.Default.User, ":"); uid == "0" || uid == "root" {
+		return nil, errors.New("QA_SANDBOX_USER must not be root")
+	}
+	for _, l := range []struct {
+		name     string
+		def      int64
+		val, max *int64
+	}{
+		{"PIDS", 512, &p.Default.Pids, &p.MaxPids},
+		{"NOFILE", 1024, &p.Default.NoFile, &p.MaxNoFile},
+		{"FILE_SIZE_MB", 1024, &p.Default.FileSizeMB, &p.MaxFileSizeMB},
+		{"TMP_MB", 512, &p.Default.TmpMB, &p.MaxTmpMB},
+	} {
+		var err error
+		if *l.val, err = envPositive("QA_SANDBOX_"+l.name, l.def); err != nil {
+			return nil, err
+		}
+		if *l.max, err = envPositive("QA_SANDBOX_MAX_"+l.name, *l.val); err != nil {
+			return nil, err
+		}
+		if *l.max < *l.val {
+			return nil, fmt.Errorf("QA_SANDBOX_MAX_%s is below QA_SANDBOX_%s", l.name, l.name)
+		}
+	}
+	var err error
+	// 0 keeps /repo on a disk-backed volume without a size limit
+	if os.Getenv("QA_SANDBOX_REPO_MB") != "0" {
+		if p.Default.RepoMB, err = envPositive("QA_SANDBOX_REPO_MB", 4096); err != nil {
+			return nil, err
+		}
+	}
+	if p.AllowRoot, err = envBool("QA_SANDBOX_ALLOW_ROOT"); err != nil {
+		return nil, err
+	}
+	if p.AllowWritableRootfs, err = envBool("QA_SANDBOX_ALLOW_WRITABLE_ROOTFS"); err != nil {
+		return nil, err
+	}
+	if f := os.Getenv("QA_SANDBOX_SECCOMP"); f != "" {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("QA_SANDBOX_SECCOMP: %w", err)
+		}
+		p.Default.Seccomp = string(b)
+	}
+	return p, nil
+}
+
+func envPositive(name string, def int64) (int64, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	var n int64
+	if _, err := fmt.Sscan(v, &n); err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad %s %q", name, v)
+	}
+	return n, nil
+}
+
+func envBool(name string) (bool, error) {
+	switch strings.ToLower(os.Getenv(name)) {
+	case "", "0", "false":
+		return false, nil
+	case "1", "true":
+		return true, nil
+	}
+	return false, fmt.Errorf("bad %s %q", name, os.Getenv(name))
+}
+
+// Profile applies a task's overrides to the default profile. Overrides
+// beyond the policy's bounds are an error, not clamped, so a task never
+// silently runs under other limits than it asked for. A nil policy
+// (sandbox off) yields a nil profile.
+func (p *SandboxPolicy) Profile(spec *SandboxSpec) (*SandboxProfile, error) {
+	if p == nil {
+		if spec != nil {
+			return nil, errors.New("task sandbox overrides need the sandbox profile (QA_SANDBOX is off)")
+		}
+		return nil, nil
+	}
+	prof := p.Default
+	if spec == nil {
+		return &prof, nil
+	}
+	for _, l := range []struct {
+		name     string
+		override *int64
+		max      int64
+		val      *int64
+	}{
+		{"pids", spec.Pids, p.MaxPids, &prof.Pids},
+		{"nofile", spec.NoFile, p.MaxNoFile, &prof.NoFile},
+		{"file_size_mb", spec.FileSizeMB, p.MaxFileSizeMB, &prof.FileSizeMB},
+		{"tmp_mb", spec.TmpMB, p.MaxTmpMB, &prof.TmpMB},
+	} {
+		if l.override == nil {
+			continue
+		}
+		if *l.override <= 0 || *l.override > l.max {
+			return nil, fmt.Errorf("sandbox %s must be between 1 and %d", l.name, l.max)
+		}
+		*l.val = *l.override
+	}
+	if spec.ReadOnlyRootfs != nil {
+		if !*spec.ReadOnlyRootfs && !p.AllowWritableRootfs {
+			return nil, errors.New("sandbox read_only_rootfs can't be disabled (QA_SANDBOX_ALLOW_WRITABLE_ROOTFS)")
+		}
+		prof.ReadOnlyRootfs = *spec.ReadOnlyRootfs
+	}
+	if spec.Root != nil && *spec.Root {
+		if !p.AllowRoot {
+			return nil, errors.New("sandbox root isn't allowed (QA_SANDBOX_ALLOW_ROOT)")
+		}
+		prof.User = "0:0"
+	}
+	return &prof, nil
+}
+
+func (s *SandboxProfile) root() bool {
+	uid, _, _ := strings.Cut(s.User, ":")
+	return uid == "0" || uid == "root"
+}
+
+// withSandbox applies s to a container. It runs after the resources are
+// set, so it only adds to them.
+func withSandbox(s *SandboxProfile) containerOption {
+	return func(c *container.Config, h *container.HostConfig) {
+		c.User = s.User
+		if !s.root() {
+			// the user has no home in the image; /tmp is writable
+			c.Env = append(c.Env, "HOME=/tmp")
+		}
+		h.CapDrop = []string{"ALL"}
+		h.SecurityOpt = append(h.SecurityOpt, "no-new-privileges:true")
+		if s.Seccomp != "" {
+			h.SecurityOpt = append(h.SecurityOpt, "seccomp="+s.Seccomp)
+		}
+		h.ReadonlyRootfs = s.ReadOnlyRootfs
+		h.Tmpfs = map[string]string{"/tmp": fmt.Sprintf("rw,nosuid,nodev,size=%dm,mode=1777", s.TmpMB)}
+		h.PidsLimit = &s.Pids
+		h.Ulimits = append(h.Ulimits,
+			&container.Ulimit{Name: "nofile", Soft: s.NoFile, Hard: s.NoFile},
+			&container.Ulimit{Name: "fsize", Soft: s.FileSizeMB << 20, Hard: s.FileSizeMB << 20},
+		)
+	}
+}
+
+// runSandboxed runs cmd like runWithLogs, under s when it is set. /repo is
+// handed to the sandbox user for the run and back to root afterwards, so
+// the git steps, which run as root, don't refuse it as dubiously owned.
+func runSandboxed(ctx context.Context, cli *client.Client, s *SandboxProfile, image, volName string, cmd []string, netEnabled bool, res *container.Resources) (stdout, stderr string, exitCode int, err error) {
+	if s == nil {
+		return runWithLogs(ctx, cli, image, volName, cmd, netEnabled, res)
+	}
+	if !s.root() {
+		if err := chownRepo(ctx, cli, volName, s.User); err != nil {
+			return "", "", 0, err
+		}
+		defer func() {
+			if cerr := chownRepo(context.Background(), cli, volName, "0:0"); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+	}
+	return runWithLogs(ctx, cli, image, volName, cmd, netEnabled, res, withSandbox(s))
+}
+
+func chownRepo(ctx context.Context, cli *client.Client, volName, owner string) error {
+	cmd := []string{"-R", owner, "/repo"}
+	stdout, stderr, exitCode, err := runWithLogs(ctx, cli, "alpine/git:latest", volName, cmd, false, nil, withEntrypoint("chown"))
+	if err != nil {
+		return fmt.Errorf("chown /repo: %w", err)
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("chown /repo to %s: exit code=%d\n%s%s", owner, exitCode, stdout, stderr)
+	}
+	return nil
+}
+
+// gitHardening keeps git from running programs the checkout configures: an
+// fsmonitor in .git/config or hooks in .git/hooks.
+var gitHardening = []string{"-c", "core.fsmonitor=false", "-c", "core.hooksPath=/dev/null"}
+
+// repoGit runs the git steps that follow repository code (the baseline
+// reset and patch application) on the tree that code could write to,
+// .git included. Each step restores .git/config from the copy taken after
+// the checkout and runs git with gitHardening under the sandbox profile,
+// so nothing planted in .git runs with more privileges than the tests had.
+type repoGit struct {
+	cli            *client.Client
+	image, volName string
+	s              *SandboxProfile
+	config         []byte
+}
+
+// newRepoGit copies .git/config out of the fresh checkout; call it before
+// running any repository code.
+func newRepoGit(ctx context.Context, cli *client.Client, image, volName string, s *SandboxProfile) (*repoGit, error) {
+	stdout, stderr, exitCode, err := runWithLogs(ctx, cli, image, volName, []string{"/repo/.git/config"}, false, nil, withEntrypoint("cat"))
+	if err != nil {
+		return nil, fmt.Errorf("read .git/config: %w", err)
+	}
+	if exitCode != 0 {
+		return nil, fmt.Errorf("read .git/config: exit code=%d\n%s", exitCode, stderr)
+	}
+	return &repoGit{cli: cli, image: image, volName: volName, s: s, config: []byte(stdout)}, nil
+}
+
+// run runs git with args in /repo and returns its combined output and
+// exit code.
+func (g *repoGit) run(ctx context.Context, args ...string) (string, int, error) {
+	if err := copyBytesToVolume(ctx, g.cli, g.volName, ".git/config", g.config); err != nil {
+		return "", 0, fmt.Errorf("restore .git/config: %w", err)
+	}
+	cmd := append(append(append([]string{}, gitHardening...), "-C", "/repo"), args...)
+	stdout, stderr, exitCode, err := runSandboxed(ctx, g.cli, g.s, g.image, g.volName, cmd, false, nil)
+	return stdout + stderr, exitCode, err
+}
+
+// reset restores the checkout to HEAD, keeping patch.diff.
+func (g *repoGit) reset(ctx context.Context) error {
+	for _, c := range [][]string{{"reset", "-q", "--hard"}, {"clean", "-fdq", "-e", "patch.diff"}} {
+		out, exitCode, err := g.run(ctx, c...)
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return fmt.Errorf("git %s exit code=%d\n%s", strings.Join(c, " "), exitCode, out)
+		}
+	}
+	return nil
+}
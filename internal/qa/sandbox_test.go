@@ -0,0 +1,52 @@
+package qa
+
+import "testing"
+
+func TestSandboxPolicyFromEnv(t *testing.T) {
+	for _, tc := range []struct {
+		name, repoMB string
+		want         int64
+		wantErr      bool
+	}{
+		{"default", "", 4096, false},
+		{"set", "1024", 1024, false},
+		{"disk-backed", "0", 0, false},
+		{"bad", "-1", 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("QA_SANDBOX_REPO_MB", tc.repoMB)
+			p, err := SandboxPolicyFromEnv()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("SandboxPolicyFromEnv() = %v, want error %v", err, tc.wantErr)
+			}
+			if err == nil && p.Default.RepoMB != tc.want {
+				t.Errorf("RepoMB = %d, want %d", p.Default.RepoMB, tc.want)
+			}
+		})
+	}
+}
+
+func TestProfileBounds(t *testing.T) {
+	p := &SandboxPolicy{Default: SandboxProfile{User: "1000:1000", ReadOnlyRootfs: true, Pids: 512}, MaxPids: 1024}
+	over, under, root := int64(2048), int64(256), true
+	for _, tc := range []struct {
+		name    string
+		spec    *SandboxSpec
+		pids    int64
+		wantErr bool
+	}{
+		{"default", nil, 512, false},
+		{"tighten", &SandboxSpec{Pids: &under}, 256, false},
+		{"beyond max", &SandboxSpec{Pids: &over}, 0, true},
+		{"root not allowed", &SandboxSpec{Root: &root}, 0, true},
+	} {
+		prof, err := p.Profile(tc.spec)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: Profile() = %v, want error %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if err == nil && prof.Pids != tc.pids {
+			t.Errorf("%s: pids = %d, want %d", tc.name, prof.Pids, tc.pids)
+		}
+	}
+}
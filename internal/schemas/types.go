@@ -86,7 +86,9 @@ type EditMade struct {
 	BaseEvent
 	FilePath     string `json:"file_path"`
 	Op           string `json:"op"`
+	NewPath      string `json:"new_path,omitempty"` // rename target
 	Range        *Range `json:"range,omitempty"`
+	Text         string `json:"text,omitempty"` // replacement text for range edits without a patch
 	PatchUnified string `json:"patch_unified"`
 	BeforeHash   string `json:"before_hash,omitempty"`
 	AfterHash    string `json:"after_hash,omitempty"`
@@ -140,11 +142,61 @@ type CreateTraceRequest struct {
 	Environment map[string]any `json:"environment"`
 }
 
+type CreateTraceResponse struct {
+	TraceID     string `json:"trace_id"`
+	UploadToken string `json:"upload_token"`
+}
+
+type AppendEventsResponse struct {
+	Accepted int   `json:"accepted"`
+	NextSeq  int64 `json:"next_seq"`
+}
+
 type QaRequest struct {
 	TestCommand string `json:"test_command,omitempty"`
 	DockerImage string `json:"docker_image,omitempty"`
 }
 
+// SnapshotOut describes an uploaded repository snapshot. Tasks reference it
+// with {"snapshot": {"ref": Ref, "sha256": SHA256}}.
+type SnapshotOut struct {
+	SnapshotID string `json:"snapshot_id"`
+	Ref        string `json:"ref"`
+	SHA256     string `json:"sha256"`
+	Size       int    `json:"size"`
+	Files      int    `json:"files"`
+}
+
+// ProjectCredential is a project's git credential: kind "https_token" (with
+// an optional username) or "ssh_key" (a private key, with optional
+// known_hosts lines). It is only used for repositories under URLPrefix.
+type ProjectCredential struct {
+	Kind       string `json:"kind"`
+	Username   string `json:"username,omitempty"`
+	Secret     string `json:"secret"`
+	KnownHosts string `json:"known_hosts,omitempty"`
+	URLPrefix  string `json:"url_prefix"`
+}
+
+// CredentialInfo describes a stored credential; secrets are never returned.
+type CredentialInfo struct {
+	Project   string    `json:"project"`
+	Kind      string    `json:"kind"`
+	Username  string    `json:"username,omitempty"`
+	URLPrefix string    `json:"url_prefix"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PrefetchRequest warms the worker's git mirror of a repository before
+// traces against it are QA'd.
+type PrefetchRequest struct {
+	Repository string `json:"repository"`
+	// Project selects the stored credential to fetch with.
+	Project string `json:"project,omitempty"`
+	// Commits must be present after the fetch; branches and tags work too.
+	Commits []string `json:"commits,omitempty"`
+}
+
 type TraceOut struct {
 	TraceID     string         `json:"trace_id"`
 	CreatedAt   time.Time      `json:"created_at"`
@@ -153,5 +205,148 @@ type TraceOut struct {
 	Environment map[string]any `json:"environment"`
 	Artifacts   map[string]any `json:"artifacts,omitempty"`
 	QA          map[string]any `json:"qa,omitempty"`
+	Status      string         `json:"status"`
 	Version     string         `json:"version"`
+	// Integrity is the edit hash-chain report, set once QA has run.
+	Integrity       map[string]any `json:"integrity,omitempty"`
+	IntegrityStatus string         `json:"integrity_status,omitempty"`
+}
+
+type TraceSummary struct {
+	TraceID     string    `json:"trace_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Status      string    `json:"status"`
+	Version     string    `json:"version"`
+	Repository  string    `json:"repository,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Developer   string    `json:"developer,omitempty"`
+	EventCount  int64     `json:"event_count"`
+	QAOK        *bool     `json:"qa_ok,omitempty"`
+}
+
+type ListTracesResponse struct {
+	Traces []TraceSummary `json:"traces"`
+}
+
+type EventsResponse struct {
+	Events []map[string]any `json:"events"`
+}
+
+// FileSnapshot is a file as it was at some point of a session: the task
+// commit plus every edit up to that point.
+type FileSnapshot struct {
+	TraceID string `json:"trace_id"`
+	Path    string `json:"path"`
+	Commit  string `json:"commit"`
+	// Snapshot is the snapshot ID for tasks that use one instead of a commit.
+	Snapshot string `json:"snapshot,omitempty"`
+	// At echoes the at parameter; empty means after the last edit.
+	At           string `json:"at,omitempty"`
+	EditsApplied int    `json:"edits_applied"`
+	Exists       bool   `json:"exists"`
+	Content      string `json:"content"`
+	// Hash is the git blob id of Content.
+	Hash string `json:"hash,omitempty"`
+	// Conflicts lists edits to this file that could not be replayed.
+	Conflicts []EditConflict `json:"conflicts,omitempty"`
+}
+
+type EditConflict struct {
+	Event  int    `json:"event"`
+	Op     string `json:"op"`
+	Hunk   string `json:"hunk,omitempty"`
+	Reason string `json:"reason"`
+}
+
+type TouchedFile struct {
+	Path        string     `json:"path"`
+	FirstOpened *time.Time `json:"first_opened,omitempty"`
+	EditCount   int        `json:"edit_count"`
+	// Status is added, modified, deleted or renamed, or empty when the
+	// file ends the session unchanged.
+	Status  string `json:"status,omitempty"`
+	OldPath string `json:"old_path,omitempty"`
+	Diff    string `json:"diff"`
+}
+
+type TouchedFilesResponse struct {
+	TraceID  string        `json:"trace_id"`
+	Commit   string        `json:"commit"`
+	Snapshot string        `json:"snapshot,omitempty"`
+	Files    []TouchedFile `json:"files"`
+}
+
+// TraceExport is the self-contained trace document described in the README,
+// plus an export block identifying the schema and sealing the content.
+type TraceExport struct {
+	TraceID     string           `json:"trace_id"`
+	CreatedAt   time.Time        `json:"created_at"`
+	Version     string           `json:"version"`
+	Developer   map[string]any   `json:"developer"`
+	Task        map[string]any   `json:"task"`
+	Environment map[string]any   `json:"environment"`
+	Events      []map[string]any `json:"events"`
+	Artifacts   map[string]any   `json:"artifacts,omitempty"`
+	QA          map[string]any   `json:"qa,omitempty"`
+	// Integrity is the edit hash-chain report, as stored by QA.
+	Integrity       map[string]any `json:"integrity,omitempty"`
+	IntegrityStatus string         `json:"integrity_status,omitempty"`
+	Export          ExportMeta     `json:"export"`
+}
+
+type ExportMeta struct {
+	SchemaVersion string    `json:"schema_version"`
+	ExportedAt    time.Time `json:"exported_at"`
+	EventCount    int       `json:"event_count"`
+	// Checksum is "sha256:<hex>" over the document encoded with Checksum empty.
+	Checksum string `json:"checksum"`
+}
+
+type ImportTraceResponse struct {
+	TraceID    string `json:"trace_id"`
+	EventCount int    `json:"event_count"`
+}
+
+// DatasetQuery selects the traces included in a dataset export. Zero
+// values do not filter.
+type DatasetQuery struct {
+	Status          string     `json:"status,omitempty"`
+	Repository      string     `json:"repository,omitempty"`
+	QAOK            *bool      `json:"qa_ok,omitempty"`
+	MinJudgeOverall *float64   `json:"min_judge_overall,omitempty"`
+	CreatedAfter    *time.Time `json:"created_after,omitempty"`
+	CreatedBefore   *time.Time `json:"created_before,omitempty"`
+	// IntegrityStatus is "ok", "broken" or "unverified".
+	IntegrityStatus string `json:"integrity_status,omitempty"`
+	// VerifiedFix selects on the baseline comparison of the QA run.
+	VerifiedFix *bool `json:"verified_fix,omitempty"`
+	// MinDiffCoverage is the lowest percentage of patched lines the tests
+	// must execute; traces without a coverage result are excluded.
+	MinDiffCoverage *float64 `json:"min_diff_coverage,omitempty"`
+}
+
+type CreateDatasetExportRequest struct {
+	Query DatasetQuery `json:"query"`
+	// Formats is any of "jsonl" and "parquet"; both when empty.
+	Formats []string `json:"formats,omitempty"`
+	// ShardSize is the number of traces per shard.
+	ShardSize int `json:"shard_size,omitempty"`
+	// Prefix is the object storage prefix, a path under datasets/;
+	// datasets/<export id> when empty.
+	Prefix string `json:"prefix,omitempty"`
+}
+
+type DatasetExportOut struct {
+	ID            string       `json:"id"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+	Status        string       `json:"status"`
+	Query         DatasetQuery `json:"query"`
+	Formats       []string     `json:"formats"`
+	ShardSize     int          `json:"shard_size"`
+	Prefix        string       `json:"prefix"`
+	RowsWritten   int64        `json:"rows_written"`
+	ShardsWritten int          `json:"shards_written"`
+	ManifestRef   string       `json:"manifest_ref,omitempty"`
+	Error         string       `json:"error,omitempty"`
 }
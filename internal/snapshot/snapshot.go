@@ -0,0 +1,259 @@
+// Package snapshot reads uploaded repository snapshots: tarballs in object
+// storage that tasks use instead of repository plus commit when the code
+// can't be cloned from the QA environment.
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"datacurve-takehome/internal/gitmirror"
+	"datacurve-takehome/internal/patch"
+	"datacurve-takehome/internal/storage"
+	"datacurve-takehome/internal/vault"
+)
+
+// Spec is a task's snapshot field: {"ref": "s3://...", "sha256": "..."}.
+type Spec struct {
+	Ref    string `json:"ref"`
+	SHA256 string `json:"sha256"`
+}
+
+// FromTask reads task.snapshot; it returns nil when the task has none.
+func FromTask(task map[string]any) (*Spec, error) {
+	raw, ok := task["snapshot"].(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+	sp := &Spec{}
+	sp.Ref, _ = raw["ref"].(string)
+	sp.SHA256, _ = raw["sha256"].(string)
+	sp.SHA256 = strings.ToLower(strings.TrimPrefix(sp.SHA256, "sha256:"))
+	if sp.Ref == "" || sp.SHA256 == "" {
+		return nil, errors.New("task snapshot needs ref and sha256")
+	}
+	return sp, nil
+}
+
+// Snapshot is a verified snapshot with its files loaded.
+type Snapshot struct {
+	// ID is the archive's sha256, so equal uploads share an ID.
+	ID      string
+	Ref     string
+	Archive []byte
+	files   map[string]string
+	// size is the total size of the files.
+	size int64
+}
+
+// ID returns the snapshot ID of an archive.
+func ID(archive []byte) string {
+	sum := sha256.Sum256(archive)
+	return hex.EncodeToString(sum[:])
+}
+
+// Fetch downloads the snapshot and checks it against sp.SHA256.
+func Fetch(ctx context.Context, s3c *storage.Client, sp Spec) (*Snapshot, error) {
+	archive, err := s3c.GetBytes(ctx, sp.Ref)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", sp.Ref, err)
+	}
+	if id := ID(archive); id != sp.SHA256 {
+		return nil, fmt.Errorf("snapshot %s: checksum mismatch (got sha256 %s, want %s)", sp.Ref, id, sp.SHA256)
+	}
+	return Load(sp.Ref, archive)
+}
+
+// MaxExpandedSize bounds the total size of a snapshot's files, which are
+// held in memory once loaded.
+const MaxExpandedSize = 1 << 30
+
+// Load reads a plain or gzip-compressed tar whose paths are relative to the
+// repository root. Archives with a .git entry anywhere are rejected: the
+// runners extract snapshots as is, and a .git directory's config and hooks
+// would run in the containers that run git as root.
+func Load(ref string, archive []byte) (*Snapshot, error) {
+	var r io.Reader = bytes.NewReader(archive)
+	s := &Snapshot{ID: ID(archive), Ref: ref, Archive: archive, files: map[string]string{}}
+	if s.Gzipped() {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot: %w", err)
+		}
+		r = zr
+	}
+	tr := tar.NewReader(r)
+	var size int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("snapshot: %w", err)
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("snapshot: path %q is outside the repository", hdr.Name)
+		}
+		if slices.Contains(strings.Split(name, "/"), ".git") {
+			return nil, fmt.Errorf("snapshot: %q is git metadata; archive the working tree without .git", hdr.Name)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		b, err := io.ReadAll(io.LimitReader(tr, MaxExpandedSize-size+1))
+		if err != nil {
+			return nil, fmt.Errorf("snapshot: %w", err)
+		}
+		if size += int64(len(b)); size > MaxExpandedSize {
+			return nil, fmt.Errorf("snapshot: files larger than %d MiB uncompressed", MaxExpandedSize>>20)
+		}
+		s.files[name] = string(b)
+	}
+	if len(s.files) == 0 {
+		return nil, errors.New("snapshot: no files")
+	}
+	s.size = size
+	return s, nil
+}
+
+// Gzipped reports whether the archive is gzip-compressed.
+func (s *Snapshot) Gzipped() bool {
+	return len(s.Archive) > 2 && s.Archive[0] == 0x1f && s.Archive[1] == 0x8b
+}
+
+// NumFiles is the number of regular files.
+func (s *Snapshot) NumFiles() int { return len(s.files) }
+
+// ReadFile implements patch.Source.
+func (s *Snapshot) ReadFile(_ context.Context, p string) (string, bool, error) {
+	content, ok := s.files[path.Clean(p)]
+	return content, ok, nil
+}
+
+// Cache keeps recently used snapshots in memory, by ID, up to a total size
+// of archives and files, evicting the least recently used.
+type Cache struct {
+	MaxBytes int64
+
+	mu    sync.Mutex
+	size  int64
+	order []string // least recently used first
+	snaps map[string]*Snapshot
+}
+
+// Fetch is Fetch through the cache.
+func (c *Cache) Fetch(ctx context.Context, s3c *storage.Client, sp Spec) (*Snapshot, error) {
+	c.mu.Lock()
+	if s, ok := c.snaps[sp.SHA256]; ok {
+		c.touch(sp.SHA256)
+		c.mu.Unlock()
+		return s, nil
+	}
+	c.mu.Unlock()
+	s, err := Fetch(ctx, s3c, sp)
+	if err != nil {
+		return nil, err
+	}
+	c.add(s)
+	return s, nil
+}
+
+func (c *Cache) touch(id string) {
+	c.order = append(slices.DeleteFunc(c.order, func(o string) bool { return o == id }), id)
+}
+
+func (c *Cache) add(s *Snapshot) {
+	n := int64(len(s.Archive)) + s.size
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.snaps[s.ID]; ok || n > c.MaxBytes {
+		return
+	}
+	if c.snaps == nil {
+		c.snaps = map[string]*Snapshot{}
+	}
+	for c.size+n > c.MaxBytes && len(c.order) > 0 {
+		old := c.snaps[c.order[0]]
+		c.size -= int64(len(old.Archive)) + old.size
+		delete(c.snaps, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.snaps[s.ID] = s
+	c.order = append(c.order, s.ID)
+	c.size += n
+}
+
+// ErrNotMirrored is returned by CachedBase when the task's commit isn't in
+// the git mirror yet.
+var ErrNotMirrored = errors.New("the task commit isn't in the git mirror yet")
+
+// CachedBase is TaskBase for request handlers: snapshots come through c,
+// and a commit cred's mirror doesn't have yet is ErrNotMirrored instead of
+// a clone or fetch.
+func CachedBase(ctx context.Context, m *gitmirror.Mirror, c *Cache, s3c *storage.Client, cred *vault.Credential, task map[string]any) (*Base, error) {
+	sp, err := FromTask(task)
+	if err != nil {
+		return nil, err
+	}
+	if sp != nil {
+		s, err := c.Fetch(ctx, s3c, *sp)
+		if err != nil {
+			return nil, err
+		}
+		return &Base{Source: s, Snapshot: s}, nil
+	}
+	repo, _ := task["repository"].(string)
+	commit, _ := task["commit"].(string)
+	tree, ok := m.Cached(ctx, repo, commit, cred)
+	if !ok {
+		return nil, ErrNotMirrored
+	}
+	return &Base{Source: tree, Commit: tree.Commit, Mirror: tree.Dir}, nil
+}
+
+// Base is the tree a task's edits are replayed on: its snapshot, or its
+// commit in the git mirror.
+type Base struct {
+	patch.Source
+	// Commit and Mirror are set for repository tasks, Snapshot for
+	// snapshot tasks.
+	Commit   string
+	Mirror   string
+	Snapshot *Snapshot
+}
+
+// TaskBase resolves the base of a task, fetching the snapshot or syncing
+// the mirror with cred as needed.
+func TaskBase(ctx context.Context, m *gitmirror.Mirror, s3c *storage.Client, cred *vault.Credential, task map[string]any) (*Base, error) {
+	sp, err := FromTask(task)
+	if err != nil {
+		return nil, err
+	}
+	if sp != nil {
+		s, err := Fetch(ctx, s3c, *sp)
+		if err != nil {
+			return nil, err
+		}
+		return &Base{Source: s, Snapshot: s}, nil
+	}
+	repo, _ := task["repository"].(string)
+	commit, _ := task["commit"].(string)
+	tree, err := m.Tree(ctx, repo, commit, cred)
+	if err != nil {
+		return nil, fmt.Errorf("git mirror: %w", err)
+	}
+	return &Base{Source: tree, Commit: tree.Commit, Mirror: tree.Dir}, nil
+}
@@ -0,0 +1,80 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func tarOf(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLoad(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{"working tree", map[string]string{"main.go": "package main\n", "./pkg/.gitignore": "bin/\n"}, ""},
+		{"git dir", map[string]string{"main.go": "package main\n", ".git/config": "[core]\n"}, "git metadata"},
+		{"nested git dir", map[string]string{"vendor/lib/.git/hooks/post-checkout": "#!/bin/sh\n"}, "git metadata"},
+		{"gitfile", map[string]string{"sub/.git": "gitdir: /etc\n"}, "git metadata"},
+		{"outside", map[string]string{"../evil": "x"}, "outside the repository"},
+		{"empty", map[string]string{}, "no files"},
+	} {
+		_, err := Load("", tarOf(t, tc.files))
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("%s: Load() = %v", tc.name, err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("%s: Load() = %v, want an error containing %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	load := func(content string) *Snapshot {
+		s, err := Load("", tarOf(t, map[string]string{"f": content}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	a, b, c := load("a"), load("b"), load("c")
+	size := int64(len(a.Archive)) + a.size
+	cache := &Cache{MaxBytes: 2 * size}
+	cache.add(a)
+	cache.add(b)
+	cache.mu.Lock()
+	cache.touch(a.ID)
+	cache.mu.Unlock()
+	cache.add(c)
+	for _, tc := range []struct {
+		name string
+		s    *Snapshot
+		want bool
+	}{{"a", a, true}, {"b", b, false}, {"c", c, true}} {
+		if _, ok := cache.snaps[tc.s.ID]; ok != tc.want {
+			t.Errorf("%s cached = %v, want %v", tc.name, ok, tc.want)
+		}
+	}
+	if cache.size != 2*size {
+		t.Errorf("size = %d, want %d", cache.size, 2*size)
+	}
+}
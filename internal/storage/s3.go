@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -62,6 +63,20 @@ func (c *Client) PutJSON(ctx context.Context, v any) (string, error) {
 	return fmt.Sprintf("s3://%s/%s", c.bucket, key), nil
 }
 
+// PutBytes stores data under key and returns its s3:// reference.
+func (c *Client) PutBytes(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	_, err := c.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &c.bucket,
+		Key:         &key,
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("s3://%s/%s", c.bucket, key), nil
+}
+
 func parseS3Ref(ref string) (string, string, error) {
 	const p = "s3://"
 	if !strings.HasPrefix(ref, p) {
@@ -97,3 +112,20 @@ func (c *Client) GetJSON(ctx context.Context, ref string) (map[string]any, error
 	log.Println("fetched s3 object", ref)
 	return v, nil
 }
+
+// GetBytes reads the object behind an s3:// reference.
+func (c *Client) GetBytes(ctx context.Context, ref string) ([]byte, error) {
+	_, key, err := parseS3Ref(ref)
+	if err != nil {
+		return nil, err
+	}
+	out, err := c.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &c.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
@@ -0,0 +1,47 @@
+package traces
+
+import (
+	"context"
+	"log"
+	"maps"
+
+	"github.com/jmoiron/sqlx"
+
+	"datacurve-takehome/internal/storage"
+)
+
+// LoadEvents fetches every event batch of a trace from object storage and
+// returns the events merged in upload (seq) order.
+func LoadEvents(ctx context.Context, dbx *sqlx.DB, s3c *storage.Client, id string) ([]map[string]any, error) {
+	var objectRefs = make([]string, 0)
+	err := dbx.SelectContext(ctx, &objectRefs, `select object_ref from event_batches where trace_id=$1 order by seq`, id)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("found %d objects for trace %s", len(objectRefs), id)
+
+	events := make([]map[string]any, 0)
+	for _, ref := range objectRefs {
+		doc, err := s3c.GetJSON(ctx, ref) // already decoded JSON -> map[string]any
+		if err != nil {
+			log.Printf("failed to get S3 object %s: %v", ref, err)
+			return nil, err
+		}
+
+		// Expect {"events": [...]}
+		evsAny, ok := doc["events"].([]any)
+		if !ok {
+			log.Printf("no 'events' array in %s (got keys: %v)", ref, maps.Keys(doc))
+			continue
+		}
+
+		for _, e := range evsAny {
+			if em, ok := e.(map[string]any); ok {
+				events = append(events, em)
+			} else {
+				log.Printf("skip non-object event in %s: %#v", ref, e)
+			}
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,195 @@
+package traces
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"datacurve-takehome/internal/auth"
+	"datacurve-takehome/internal/db"
+	"datacurve-takehome/internal/schemas"
+	"datacurve-takehome/internal/storage"
+)
+
+// SchemaVersion is the trace document format produced by Export.
+const SchemaVersion = "trace-1.0.0"
+
+var (
+	ErrNotFound         = errors.New("trace not found")
+	ErrExists           = errors.New("trace already exists")
+	ErrInvalidDocument  = errors.New("invalid trace document")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// Export assembles the combined trace document from the traces row and
+// its merged event batches. With redact set the task goes through
+// RedactTask, so the document can leave the admins' hands; an unredacted
+// document round-trips through Import without loss.
+func Export(ctx context.Context, dbx *sqlx.DB, s3c *storage.Client, id string, redact bool) (*schemas.TraceExport, error) {
+	var t db.Trace
+	if err := dbx.GetContext(ctx, &t, `select * from traces where id=$1`, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	events, err := LoadEvents(ctx, dbx, s3c, id)
+	if err != nil {
+		return nil, err
+	}
+	doc := &schemas.TraceExport{
+		TraceID:   t.ID,
+		CreatedAt: t.CreatedAt.UTC(),
+		Version:   t.Version,
+		Events:    events,
+	}
+	_ = json.Unmarshal(t.Developer, &doc.Developer)
+	_ = json.Unmarshal(t.Task, &doc.Task)
+	if redact {
+		RedactTask(doc.Task)
+	}
+	_ = json.Unmarshal(t.Environment, &doc.Environment)
+	if len(t.Artifacts) > 0 {
+		_ = json.Unmarshal(t.Artifacts, &doc.Artifacts)
+	}
+	if len(t.QA) > 0 {
+		_ = json.Unmarshal(t.QA, &doc.QA)
+	}
+	if len(t.Integrity) > 0 {
+		_ = json.Unmarshal(t.Integrity, &doc.Integrity)
+	}
+	doc.IntegrityStatus = t.IntegrityStatus.String
+	doc.Export = schemas.ExportMeta{
+		SchemaVersion: SchemaVersion,
+		ExportedAt:    time.Now().UTC(),
+		EventCount:    len(events),
+	}
+	sum, err := Checksum(doc)
+	if err != nil {
+		return nil, err
+	}
+	doc.Export.Checksum = sum
+	return doc, nil
+}
+
+// Checksum hashes the document's JSON encoding with the checksum field
+// blanked. encoding/json sorts map keys, so the encoding is stable across a
+// decode/encode round trip.
+func Checksum(doc *schemas.TraceExport) (string, error) {
+	cp := *doc
+	cp.Export.Checksum = ""
+	b, err := json.Marshal(&cp)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// Import recreates a sealed trace from an exported document, keeping its id
+// and creation time. All events are stored as a single batch.
+func Import(ctx context.Context, dbx *sqlx.DB, s3c *storage.Client, doc *schemas.TraceExport) error {
+	if err := validate(doc); err != nil {
+		return err
+	}
+
+	var cnt int
+	if err := dbx.GetContext(ctx, &cnt, `select count(1) from traces where id=$1`, doc.TraceID); err != nil {
+		return err
+	}
+	if cnt > 0 {
+		return ErrExists
+	}
+
+	events := doc.Events
+	if events == nil {
+		events = []map[string]any{}
+	}
+	ref, err := s3c.PutJSON(ctx, map[string]any{"events": events})
+	if err != nil {
+		return err
+	}
+
+	dev, _ := json.Marshal(doc.Developer)
+	task, _ := json.Marshal(doc.Task)
+	env, _ := json.Marshal(doc.Environment)
+	version := doc.Version
+	if version == "" {
+		version = SchemaVersion
+	}
+	createdAt := doc.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now().UTC()
+	}
+	// Imported traces are sealed, so the upload token is never handed out.
+	upload := uuid.NewString()
+
+	return db.WithTx(ctx, dbx, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, `insert into traces(id, created_at, developer, task, environment, status, upload_token_hash, artifacts, qa, version, integrity, integrity_status)
+			values($1,$2,$3,$4,$5,'sealed',$6,$7,$8,$9,$10,nullif($11,''))`,
+			doc.TraceID, createdAt, dev, task, env, auth.HashToken(upload), jsonOrNull(doc.Artifacts), jsonOrNull(doc.QA), version,
+			jsonOrNull(doc.Integrity), doc.IntegrityStatus)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `insert into event_batches(id, trace_id, seq, object_ref, event_count) values($1,$2,0,$3,$4)`,
+			uuid.NewString(), doc.TraceID, ref, len(events))
+		return err
+	})
+}
+
+// validate checks what Import needs before touching storage: a trace-1.x
+// document with an id, an intact checksum, and a task whose hidden tests
+// weren't redacted (the copy could never run them).
+func validate(doc *schemas.TraceExport) error {
+	if !strings.HasPrefix(doc.Export.SchemaVersion, "trace-1.") {
+		return fmt.Errorf("%w: unsupported schema version %q", ErrInvalidDocument, doc.Export.SchemaVersion)
+	}
+	if doc.TraceID == "" {
+		return fmt.Errorf("%w: missing trace_id", ErrInvalidDocument)
+	}
+	if doc.Export.Checksum != "" {
+		sum, err := Checksum(doc)
+		if err != nil {
+			return err
+		}
+		if sum != doc.Export.Checksum {
+			return fmt.Errorf("%w: document %s, computed %s", ErrChecksumMismatch, doc.Export.Checksum, sum)
+		}
+	}
+	if isRedacted(doc.Task) {
+		return fmt.Errorf("%w: task.hidden_tests is redacted; import an unredacted export (?unredacted=true, operator only)", ErrInvalidDocument)
+	}
+	return nil
+}
+
+func jsonOrNull(m map[string]any) []byte {
+	if len(m) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(m)
+	return b
+}
+
+// RedactTask removes what a task keeps private from readers of the trace:
+// the location of its hidden tests.
+func RedactTask(task map[string]any) {
+	if _, ok := task["hidden_tests"]; ok {
+		task["hidden_tests"] = map[string]any{"redacted": true}
+	}
+}
+
+// isRedacted reports whether RedactTask has been applied to task.
+func isRedacted(task map[string]any) bool {
+	h, _ := task["hidden_tests"].(map[string]any)
+	return h["redacted"] == true
+}
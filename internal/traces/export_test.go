@@ -0,0 +1,107 @@
+package traces
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"datacurve-takehome/internal/schemas"
+)
+
+// sealedDoc returns an exported document with its checksum set.
+func sealedDoc(t *testing.T) *schemas.TraceExport {
+	t.Helper()
+	doc := &schemas.TraceExport{
+		TraceID:     "3f1c",
+		CreatedAt:   time.Date(2024, 1, 15, 10, 30, 0, 123456789, time.UTC),
+		Version:     SchemaVersion,
+		Developer:   map[string]any{"id": "dev-1"},
+		Task:        map[string]any{"repo": "https://example.com/calc.git", "hidden_tests": map[string]any{"ref": "s3://traces/hidden/calc.tar.gz"}},
+		Environment: map[string]any{"os": "linux"},
+		Events: []map[string]any{
+			{"t": "2024-01-15T10:30:01Z", "type": "edit", "path": "calc.go", "n": 1.5, "unicode": "héllo <&>"},
+			{"t": "2024-01-15T10:30:02Z", "type": "cmd", "cmd": "go test ./...", "exit": 0.0},
+		},
+		QA:              map[string]any{"ok": true},
+		IntegrityStatus: "ok",
+		Export:          schemas.ExportMeta{SchemaVersion: SchemaVersion, ExportedAt: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), EventCount: 2},
+	}
+	sum, err := Checksum(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Export.Checksum = sum
+	return doc
+}
+
+func TestChecksumRoundTrip(t *testing.T) {
+	doc := sealedDoc(t)
+	if !strings.HasPrefix(doc.Export.Checksum, "sha256:") || len(doc.Export.Checksum) != len("sha256:")+64 {
+		t.Fatalf("checksum = %q", doc.Export.Checksum)
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back schemas.TraceExport
+	if err := json.Unmarshal(b, &back); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := Checksum(&back)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != doc.Export.Checksum {
+		t.Errorf("checksum after a JSON round trip = %s, want %s", sum, doc.Export.Checksum)
+	}
+	if err := validate(&back); err != nil {
+		t.Errorf("validate: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(*schemas.TraceExport)
+		want   error
+	}{
+		{"intact", func(*schemas.TraceExport) {}, nil},
+		{"no checksum", func(d *schemas.TraceExport) { d.Export.Checksum = ""; d.Events[0]["path"] = "x.go" }, nil},
+		{"event edited", func(d *schemas.TraceExport) { d.Events[0]["path"] = "other.go" }, ErrChecksumMismatch},
+		{"event dropped", func(d *schemas.TraceExport) { d.Events = d.Events[:1] }, ErrChecksumMismatch},
+		{"qa forged", func(d *schemas.TraceExport) { d.QA["ok"] = false }, ErrChecksumMismatch},
+		{"created_at moved", func(d *schemas.TraceExport) { d.CreatedAt = d.CreatedAt.Add(time.Nanosecond) }, ErrChecksumMismatch},
+		{"checksum altered", func(d *schemas.TraceExport) { d.Export.Checksum = "sha256:" + strings.Repeat("0", 64) }, ErrChecksumMismatch},
+		{"schema version", func(d *schemas.TraceExport) { d.Export.SchemaVersion = "trace-2.0.0" }, ErrInvalidDocument},
+		{"no trace id", func(d *schemas.TraceExport) { d.TraceID = "" }, ErrInvalidDocument},
+		{"redacted", func(d *schemas.TraceExport) {
+			RedactTask(d.Task)
+			d.Export.Checksum = ""
+			d.Export.Checksum, _ = Checksum(d)
+		}, ErrInvalidDocument},
+	} {
+		doc := sealedDoc(t)
+		tc.change(doc)
+		if err := validate(doc); !errors.Is(err, tc.want) || (tc.want == nil) != (err == nil) {
+			t.Errorf("%s: validate = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestImportRejectsBeforeStoring(t *testing.T) {
+	doc := sealedDoc(t)
+	RedactTask(doc.Task)
+	// nil database and storage: rejection must come before either is used
+	err := Import(context.Background(), nil, nil, doc)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Import of a redacted copy with a stale checksum = %v, want ErrChecksumMismatch", err)
+	}
+	doc.Export.Checksum = ""
+	err = Import(context.Background(), nil, nil, doc)
+	if !errors.Is(err, ErrInvalidDocument) || !strings.Contains(err.Error(), "redacted") {
+		t.Errorf("Import of a redacted document = %v, want ErrInvalidDocument naming the redaction", err)
+	}
+}
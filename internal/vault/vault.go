@@ -0,0 +1,302 @@
+// Package vault stores per-project git credentials, encrypted, in Postgres
+// and hands them to git through the environment only, so they never appear
+// in URLs, command lines or the test containers.
+package vault
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Credential kinds.
+const (
+	HTTPSToken = "https_token"
+	SSHKey     = "ssh_key"
+)
+
+// Credential is what a project clones its repositories with.
+type Credential struct {
+	Kind string `json:"kind"`
+	// Username for HTTPS tokens; default x-access-token, which GitHub and
+	// Gitea accept for any token.
+	Username string `json:"username,omitempty"`
+	// Secret is the token or the PEM private key.
+	Secret string `json:"secret"`
+	// KnownHosts pins the SSH host keys; without it the first key seen is
+	// accepted.
+	KnownHosts string `json:"known_hosts,omitempty"`
+	// URLPrefix scopes the credential: only repositories under it are
+	// cloned with it, e.g. https://github.com/acme/ or git@github.com:acme/.
+	URLPrefix string `json:"url_prefix"`
+	// Project is the project the credential was loaded for; Get sets it.
+	// Mirrors cloned with the credential are kept apart per project.
+	Project string `json:"-"`
+}
+
+func (c *Credential) Validate() error {
+	switch c.Kind {
+	case HTTPSToken, SSHKey:
+	default:
+		return fmt.Errorf("unknown credential kind %q", c.Kind)
+	}
+	if strings.TrimSpace(c.Secret) == "" {
+		return errors.New("secret is required")
+	}
+	if c.URLPrefix == "" {
+		return errors.New("url_prefix is required")
+	}
+	if c.Kind == HTTPSToken {
+		if _, err := helperScope(c.URLPrefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helperScope is the scheme://host part of an HTTPS prefix, which git
+// matches credential.<url>.helper against.
+func helperScope(prefix string) (string, error) {
+	u, err := url.Parse(prefix)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User != nil {
+		return "", fmt.Errorf("url_prefix %q must be an http(s) URL without credentials", prefix)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// Allows reports whether repo is under c's URL prefix. The prefix must end
+// at a path boundary, so https://github.com/acme doesn't cover
+// https://github.com/acme-evil/repo.
+func (c *Credential) Allows(repo string) bool {
+	p := c.URLPrefix
+	if p == "" || !strings.HasPrefix(repo, p) {
+		return false
+	}
+	rest := repo[len(p):]
+	return rest == "" || rest == ".git" || strings.HasSuffix(p, "/") || strings.HasSuffix(p, ":") || strings.HasPrefix(rest, "/")
+}
+
+// Helpers git runs through the shell. They read the secret from the
+// environment when git calls them, so it is never part of any argument.
+const (
+	credentialHelper = `!f() { test "$1" = get || return 0; printf 'username=%s\npassword=%s\n' "${GIT_AUTH_USER:-x-access-token}" "$GIT_AUTH_TOKEN"; }; f`
+	sshCommand       = `f() { d=$(mktemp -d) || return 1; printf '%s\n' "$GIT_AUTH_SSH_KEY" > "$d/key"; chmod 600 "$d/key"; ` +
+		`if [ -n "$GIT_AUTH_KNOWN_HOSTS" ]; then printf '%s\n' "$GIT_AUTH_KNOWN_HOSTS" > "$d/known_hosts"; c=yes; else c=accept-new; fi; ` +
+		`ssh -i "$d/key" -o IdentitiesOnly=yes -o BatchMode=yes -o UserKnownHostsFile="$d/known_hosts" -o StrictHostKeyChecking=$c "$@"; ` +
+		`r=$?; rm -rf "$d"; return $r; }; f`
+)
+
+// Env returns the environment variables that make git use c. It is nil for
+// a nil credential.
+func (c *Credential) Env() []string {
+	if c == nil {
+		return nil
+	}
+	switch c.Kind {
+	case HTTPSToken:
+		// the helper only answers for the prefix's host; Allows checks the
+		// rest of the prefix before a repository is cloned
+		scope, _ := helperScope(c.URLPrefix)
+		return []string{
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=credential." + scope + ".helper",
+			"GIT_CONFIG_VALUE_0=" + credentialHelper,
+			"GIT_AUTH_USER=" + c.Username,
+			"GIT_AUTH_TOKEN=" + c.Secret,
+		}
+	case SSHKey:
+		return []string{
+			"GIT_SSH_COMMAND=" + sshCommand,
+			"GIT_AUTH_SSH_KEY=" + c.Secret,
+			"GIT_AUTH_KNOWN_HOSTS=" + c.KnownHosts,
+		}
+	}
+	return nil
+}
+
+var urlUserinfo = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/@\s]+@`)
+
+// Scrub removes c's secret (and each of its lines, for keys) and the
+// userinfo of any URL from s, so clone errors can be logged and stored.
+func Scrub(s string, c *Credential) string {
+	s = urlUserinfo.ReplaceAllString(s, "${1}***@")
+	if c == nil {
+		return s
+	}
+	secrets := []string{c.Secret}
+	if c.Kind == SSHKey {
+		for _, line := range strings.Split(c.Secret, "\n") {
+			if line = strings.TrimSpace(line); len(line) >= 8 {
+				secrets = append(secrets, line)
+			}
+		}
+	}
+	for _, sec := range secrets {
+		if sec = strings.TrimSpace(sec); sec != "" {
+			s = strings.ReplaceAll(s, sec, "***")
+		}
+	}
+	return s
+}
+
+// Vault reads and writes the project_credentials table.
+type Vault struct {
+	DB  *sqlx.DB
+	key []byte
+}
+
+// FromEnv uses CREDENTIALS_KEY, a base64-encoded 32-byte AES key. Without
+// it the vault can't store or read credentials, but tasks without a
+// credential still run.
+func FromEnv(db *sqlx.DB) (*Vault, error) {
+	v := &Vault{DB: db}
+	if k := os.Getenv("CREDENTIALS_KEY"); k != "" {
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil || len(key) != 32 {
+			return nil, errors.New("CREDENTIALS_KEY must be 32 bytes, base64-encoded")
+		}
+		v.key = key
+	}
+	return v, nil
+}
+
+// Info is a stored credential without its secret.
+type Info struct {
+	Project   string    `db:"project"`
+	Kind      string    `db:"kind"`
+	Username  string    `db:"username"`
+	URLPrefix string    `db:"url_prefix"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+func (v *Vault) aead() (cipher.AEAD, error) {
+	if v.key == nil {
+		return nil, errors.New("credentials vault is disabled (CREDENTIALS_KEY not set)")
+	}
+	block, err := aes.NewCipher(v.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Put stores c for project, replacing any earlier credential. The project
+// name is bound to the ciphertext, so rows can't be swapped between
+// projects.
+func (v *Vault) Put(ctx context.Context, project string, c *Credential) (*Info, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	aead, err := v.aead()
+	if err != nil {
+		return nil, err
+	}
+	plain, _ := json.Marshal(c)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, plain, []byte(project))
+	var info Info
+	err = v.DB.GetContext(ctx, &info, `insert into project_credentials(project, kind, username, url_prefix, ciphertext)
+		values($1,$2,$3,$4,$5)
+		on conflict (project) do update set kind=excluded.kind, username=excluded.username,
+			url_prefix=excluded.url_prefix, ciphertext=excluded.ciphertext, updated_at=now()
+		returning project, kind, username, url_prefix, updated_at`, project, c.Kind, c.Username, c.URLPrefix, sealed)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Info describes project's credential; sql.ErrNoRows if it has none.
+func (v *Vault) Info(ctx context.Context, project string) (*Info, error) {
+	var info Info
+	err := v.DB.GetContext(ctx, &info, `select project, kind, username, url_prefix, updated_at from project_credentials where project=$1`, project)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Delete removes project's credential and reports whether there was one.
+func (v *Vault) Delete(ctx context.Context, project string) (bool, error) {
+	res, err := v.DB.ExecContext(ctx, `delete from project_credentials where project=$1`, project)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// Get decrypts project's credential. It returns nil, nil when the project
+// has none.
+func (v *Vault) Get(ctx context.Context, project string) (*Credential, error) {
+	var sealed []byte
+	err := v.DB.GetContext(ctx, &sealed, `select ciphertext from project_credentials where project=$1`, project)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	aead, err := v.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("credential for %s is corrupt", project)
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(project))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt credential for %s: %w", project, err)
+	}
+	var c Credential
+	if err := json.Unmarshal(plain, &c); err != nil {
+		return nil, err
+	}
+	c.Project = project
+	return &c, nil
+}
+
+// ForTask returns the credential of task.project for task.repository, or
+// nil when the task names no project or repository (snapshot tasks don't
+// clone) or its project has no credential.
+func (v *Vault) ForTask(ctx context.Context, task map[string]any) (*Credential, error) {
+	project, _ := task["project"].(string)
+	repo, _ := task["repository"].(string)
+	if project == "" || repo == "" || v == nil {
+		return nil, nil
+	}
+	return v.ForRepository(ctx, project, repo)
+}
+
+// ForRepository returns project's credential for cloning repo. A
+// repository outside the credential's URL prefix is an error, so a task
+// can't send a project's secret to a host of its choosing.
+func (v *Vault) ForRepository(ctx context.Context, project, repo string) (*Credential, error) {
+	c, err := v.Get(ctx, project)
+	if err != nil || c == nil {
+		return c, err
+	}
+	if !c.Allows(repo) {
+		if c.URLPrefix == "" {
+			return nil, fmt.Errorf("credential of project %s has no url_prefix; store it again with one", project)
+		}
+		return nil, fmt.Errorf("repository %s is outside the url_prefix %s of project %s's credential", Scrub(repo, nil), c.URLPrefix, project)
+	}
+	return c, nil
+}
@@ -0,0 +1,54 @@
+package vault
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAllows(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, repo string
+		want         bool
+	}{
+		{"https://github.com/acme/", "https://github.com/acme/repo.git", true},
+		{"https://github.com/acme", "https://github.com/acme/repo", true},
+		{"https://github.com/acme/repo", "https://github.com/acme/repo.git", true},
+		{"https://github.com/acme", "https://github.com/acme-evil/repo", false},
+		{"https://github.com/acme/repo", "https://github.com/acme/repo-fork", false},
+		{"https://github.com", "https://github.com@evil.example/acme/repo", false},
+		{"https://github.com/acme/", "https://gitlab.com/acme/repo", false},
+		{"git@github.com:acme/", "git@github.com:acme/repo.git", true},
+		{"git@github.com:", "git@github.com:acme/repo.git", true},
+		{"", "https://github.com/acme/repo", false},
+	} {
+		c := &Credential{URLPrefix: tc.prefix}
+		if got := c.Allows(tc.repo); got != tc.want {
+			t.Errorf("Allows(%q) under %q = %v, want %v", tc.repo, tc.prefix, got, tc.want)
+		}
+	}
+}
+
+func TestValidateURLPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		kind, prefix string
+		ok           bool
+	}{
+		{HTTPSToken, "https://github.com/acme/", true},
+		{HTTPSToken, "", false},
+		{HTTPSToken, "git@github.com:acme/", false},
+		{HTTPSToken, "https://user:pw@github.com/acme/", false},
+		{SSHKey, "git@github.com:acme/", true},
+	} {
+		c := &Credential{Kind: tc.kind, Secret: "s3cret", URLPrefix: tc.prefix}
+		if err := c.Validate(); (err == nil) != tc.ok {
+			t.Errorf("%s %q: Validate() = %v, want ok=%v", tc.kind, tc.prefix, err, tc.ok)
+		}
+	}
+}
+
+func TestEnvScopesHelper(t *testing.T) {
+	c := &Credential{Kind: HTTPSToken, Secret: "s3cret", URLPrefix: "https://github.com/acme/"}
+	if env := c.Env(); !slices.Contains(env, "GIT_CONFIG_KEY_0=credential.https://github.com.helper") {
+		t.Errorf("helper isn't scoped to the host: %q", env)
+	}
+}
@@ -3,79 +3,70 @@ package worker
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"maps"
 	"os"
+	"time"
 
 	"github.com/hibiken/asynq"
 	"github.com/jmoiron/sqlx"
 
+	"datacurve-takehome/internal/dataset"
+	"datacurve-takehome/internal/gitmirror"
+	"datacurve-takehome/internal/integrity"
+	"datacurve-takehome/internal/patch"
+	"datacurve-takehome/internal/policy"
 	"datacurve-takehome/internal/qa"
+	"datacurve-takehome/internal/schemas"
+	"datacurve-takehome/internal/snapshot"
 	"datacurve-takehome/internal/storage"
+	"datacurve-takehome/internal/traces"
+	"datacurve-takehome/internal/vault"
 )
 
 type Server struct {
-	DB    *sqlx.DB
-	S3    *storage.Client
-	Asynq *asynq.Client
+	DB     *sqlx.DB
+	S3     *storage.Client
+	Asynq  *asynq.Client
+	Mirror *gitmirror.Mirror
+	Vault  *vault.Vault
+	Runner qa.Runner
 }
 
 func (s *Server) mux() *asynq.ServeMux {
 	mux := asynq.NewServeMux()
 	mux.HandleFunc("run_full_qa", s.handleQA)
+	mux.HandleFunc(dataset.TaskName, s.handleDatasetExport)
+	mux.HandleFunc(gitmirror.PrefetchTask, s.handlePrefetch)
 	return mux
 }
 
-func (s *Server) handleQA(ctx context.Context, t *asynq.Task) error {
-	id := string(t.Payload())
-	log.Printf("Starting QA for trace %s", id)
-
-	// get patch from obj storage
-	// get obj references from event_batches table
-	var object_refs = make([]string, 0)
-	err := s.DB.SelectContext(ctx, &object_refs, `select object_ref from event_batches where trace_id=$1`, id)
-	if err != nil {
-		return err
+func (s *Server) handlePrefetch(ctx context.Context, t *asynq.Task) error {
+	var req schemas.PrefetchRequest
+	if err := json.Unmarshal(t.Payload(), &req); err != nil {
+		return fmt.Errorf("prefetch payload: %w", err)
 	}
-	log.Printf("found %d objects for trace %s", len(object_refs), id)
-
-	// get from s3
-	events := make([]map[string]any, 0)
-	for _, ref := range object_refs {
-		log.Println("Fetching S3 object:", ref)
-
-		doc, err := s.S3.GetJSON(ctx, ref) // already decoded JSON -> map[string]any
-		if err != nil {
-			log.Printf("failed to get S3 object %s: %v", ref, err)
+	log.Printf("Prefetching %s (%d commits)", vault.Scrub(req.Repository, nil), len(req.Commits))
+	var cred *vault.Credential
+	if req.Project != "" {
+		var err error
+		if cred, err = s.Vault.ForRepository(ctx, req.Project, req.Repository); err != nil {
 			return err
 		}
+	}
+	return s.Mirror.Prefetch(ctx, req.Repository, req.Commits, cred)
+}
 
-		// Expect {"events": [...]}
-		evsAny, ok := doc["events"].([]any)
-		if !ok {
-			log.Printf("no 'events' array in %s (got keys: %v)", ref, maps.Keys(doc)) // or fmt for Go<1.21
-			continue
-		}
+func (s *Server) handleDatasetExport(ctx context.Context, t *asynq.Task) error {
+	id := string(t.Payload())
+	log.Printf("Starting dataset export %s", id)
+	return dataset.Run(ctx, s.DB, s.S3, id)
+}
 
-		for _, e := range evsAny {
-			if em, ok := e.(map[string]any); ok {
-				events = append(events, em)
-			} else {
-				log.Printf("skip non-object event in %s: %#v", ref, e)
-			}
-		}
-	}
-	// get the patch from events of op "replace", "patch_unified"
-	var patch string
-	for _, e := range events {
-		if e["op"] == "replace" {
-			if v, ok := e["patch_unified"].(string); ok {
-				patch = v
-				break
-			}
-		}
-	}
-	log.Println("Using patch:", patch)
+func (s *Server) handleQA(ctx context.Context, t *asynq.Task) error {
+	id := string(t.Payload())
+	log.Printf("Starting QA for trace %s", id)
 
 	// get the start commit from traces table, commit field in json task column
 	var startCommit string
@@ -83,7 +74,7 @@ func (s *Server) handleQA(ctx context.Context, t *asynq.Task) error {
 	var testImage string
 	var testCommand string
 	var taskJSON []byte
-	err = s.DB.GetContext(ctx, &taskJSON, `select task from traces where id=$1`, id)
+	err := s.DB.GetContext(ctx, &taskJSON, `select task from traces where id=$1`, id)
 	if err != nil {
 		return err
 	}
@@ -105,39 +96,345 @@ func (s *Server) handleQA(ctx context.Context, t *asynq.Task) error {
 	if cmd, ok := task["test_command"].(string); ok {
 		testCommand = cmd
 	} else {
-		testCommand = "go test ./..."
+		// -json so the default run reports per-test cases for verification
+		testCommand = "go test -json ./..."
+	}
+	// baseline runs the tests on the start commit too, unless the task opts out
+	baseline := true
+	if b, ok := task["baseline"].(bool); ok {
+		baseline = b
+	}
+	var targets []string
+	if ts, ok := task["fail_to_pass"].([]any); ok {
+		for _, t := range ts {
+			if s, ok := t.(string); ok {
+				targets = append(targets, s)
+			}
+		}
+	}
+	// report files the test command writes, e.g. ["reports/*.xml"], and an
+	// optional parser name to skip format detection
+	var reports []string
+	if rs, ok := task["test_reports"].([]any); ok {
+		for _, r := range rs {
+			if s, ok := r.(string); ok {
+				reports = append(reports, s)
+			}
+		}
+	}
+	testFormat, _ := task["test_format"].(string)
+	// held-out tests: {"ref": "s3://bucket/key.tar.gz", "command": "..."}
+	var hidden *qa.HiddenTests
+	if h, ok := task["hidden_tests"].(map[string]any); ok {
+		if h["redacted"] == true {
+			return s.failQA(ctx, id, errors.New("hidden tests: task.hidden_tests was redacted by a trace export; import an unredacted export to run them"))
+		}
+		ref, _ := h["ref"].(string)
+		archive, err := s.S3.GetBytes(ctx, ref)
+		if err != nil {
+			return s.failQA(ctx, id, fmt.Errorf("hidden tests: %w", err))
+		}
+		hidden = &qa.HiddenTests{Archive: archive}
+		hidden.Command, _ = h["command"].(string)
+	}
+	// static analysis: true or {"analyzers": ["go-vet", "staticcheck", "gofmt", "ruff", "eslint"]}
+	var lint *qa.LintOptions
+	switch l := task["lint"].(type) {
+	case bool:
+		if l {
+			lint = &qa.LintOptions{}
+		}
+	case map[string]any:
+		lint = &qa.LintOptions{}
+		if as, ok := l["analyzers"].([]any); ok {
+			for _, a := range as {
+				if s, ok := a.(string); ok {
+					lint.Analyzers = append(lint.Analyzers, s)
+				}
+			}
+		}
+	}
+	// diff coverage: true or {"format": "go|coverage.py", "command": "...", "profile": "..."}
+	var coverage *qa.CoverageOptions
+	switch c := task["coverage"].(type) {
+	case bool:
+		if c {
+			coverage = &qa.CoverageOptions{}
+		}
+	case map[string]any:
+		coverage = &qa.CoverageOptions{}
+		coverage.Format, _ = c["format"].(string)
+		coverage.Command, _ = c["command"].(string)
+		coverage.Profile, _ = c["profile"].(string)
+	}
+	// mutation testing: true or {"max_mutants": 25, "timeout_seconds": 300, "budget_seconds": 1200}
+	var mutation *qa.MutationOptions
+	switch m := task["mutation"].(type) {
+	case bool:
+		if m {
+			mutation = &qa.MutationOptions{}
+		}
+	case map[string]any:
+		mutation = &qa.MutationOptions{}
+		if n, ok := m["max_mutants"].(float64); ok {
+			mutation.MaxMutants = int(n)
+		}
+		if n, ok := m["timeout_seconds"].(float64); ok {
+			mutation.Timeout = time.Duration(n) * time.Second
+		}
+		if n, ok := m["budget_seconds"].(float64); ok {
+			mutation.Budget = time.Duration(n) * time.Second
+		}
 	}
 	log.Println("Using start commit:", startCommit)
-	log.Println("Using repository URL:", repositoryURL)
+	log.Println("Using repository URL:", vault.Scrub(repositoryURL, nil))
+
+	events, err := traces.LoadEvents(ctx, s.DB, s.S3, id)
+	if err != nil {
+		return err
+	}
+	// git credentials of task.project, for the mirror and the clone only
+	cred, err := s.Vault.ForTask(ctx, task)
+	if err != nil {
+		return s.failQA(ctx, id, fmt.Errorf("credentials: %w", err))
+	}
+	// the start tree: an uploaded snapshot when the task has one, else the
+	// commit in the git mirror
+	base, err := snapshot.TaskBase(ctx, s.Mirror, s.S3, cred, task)
+	if err != nil {
+		return s.failQA(ctx, id, err)
+	}
+	var snapshotArchive []byte
+	if base.Snapshot != nil {
+		snapshotArchive = base.Snapshot.Archive
+	}
+	// pipeline spec: task.pipeline (YAML text or an object), else .qa.yml at
+	// the start commit, which the patch can't change
+	var pipeline *qa.Pipeline
+	pipelineSource := ""
+	if raw, ok := task["pipeline"]; ok {
+		spec, _ := raw.(string)
+		if spec == "" {
+			b, _ := json.Marshal(raw)
+			spec = string(b)
+		}
+		pipeline, err = qa.ParsePipeline([]byte(spec))
+		pipelineSource = "task"
+	} else if spec, ok, rerr := base.ReadFile(ctx, ".qa.yml"); rerr != nil {
+		err = rerr
+	} else if ok {
+		pipeline, err = qa.ParsePipeline([]byte(spec))
+		pipelineSource = ".qa.yml"
+	}
+	if err != nil {
+		return s.failQA(ctx, id, err)
+	}
+	if pipeline != nil {
+		for _, st := range pipeline.Stages {
+			if st.Tests {
+				testCommand = st.Command
+			}
+		}
+	}
+	// replay every edit on top of the start commit to get the final patch
+	assembled, err := patch.Assemble(ctx, patch.EditsFromEvents(events), base)
+	if err != nil {
+		return s.failQA(ctx, id, fmt.Errorf("assemble patch: %w", err))
+	}
+	finalPatch := assembled.Patch
+	log.Printf("Assembled patch from %d edits (%d files, %d conflicts)", assembled.Edits, len(assembled.Files), len(assembled.Conflicts))
 
-	res, err := qa.RunTests(ctx, repositoryURL, startCommit, patch, testImage, testCommand)
+	// check the reported file hashes before spending time on tests
+	report, err := integrity.Verify(ctx, events, base)
 	if err != nil {
-		log.Printf("QA runner error: %v", err)
-		// persist QA failure detail on the trace instead of panicking
-		_, _ = s.DB.ExecContext(ctx,
-			`UPDATE traces SET qa = jsonb_set(
-            COALESCE(qa, '{}'::jsonb),
-            '{error}', to_jsonb($2::text)
-         ) WHERE id = $1`,
-			id, err.Error(),
-		)
-		return nil // tell Asynq "done" so it doesn't keep retrying
+		return s.failQA(ctx, id, fmt.Errorf("verify integrity: %w", err))
+	}
+	rb, _ := json.Marshal(report)
+	if _, err := s.DB.ExecContext(ctx, `update traces set integrity=$2, integrity_status=$3 where id=$1`, id, rb, report.Status); err != nil {
+		return err
+	}
+	log.Printf("Integrity: %s (%d checks, %d issues)", report.Status, report.Checks, len(report.Issues))
+
+	// patch policy: {"forbidden": [...], "allow": [...], "enforce": true}
+	var pol policy.Policy
+	if raw, ok := task["policy"]; ok {
+		pb, _ := json.Marshal(raw)
+		if err := json.Unmarshal(pb, &pol); err != nil {
+			return s.failQA(ctx, id, fmt.Errorf("task policy: %w", err))
+		}
+	}
+	findings, err := policy.Check(finalPatch, pol)
+	if err != nil {
+		return s.failQA(ctx, id, fmt.Errorf("patch policy: %w", err))
+	}
+	log.Printf("Patch policy: %d findings (enforced=%t)", len(findings.Findings), findings.Enforced)
+	patchOut := map[string]any{
+		"edits": assembled.Edits, "files": assembled.Files, "conflicts": assembled.Conflicts,
+	}
+	if findings.Violated {
+		// don't run tests the patch may have tampered with
+		b, _ := json.Marshal(map[string]any{
+			"error":  fmt.Sprintf("patch policy violated: %d findings", len(findings.Findings)),
+			"policy": findings,
+			"patch":  patchOut,
+		})
+		_, err = s.DB.ExecContext(ctx, `update traces set qa=$1,
+			artifacts = coalesce(artifacts, '{}'::jsonb) || jsonb_build_object('final_patch', $3::text)
+			where id=$2`, b, id, finalPatch)
+		return err
+	}
+
+	// matrix: {"image": [...], "command": [...], "include": [...], "max_parallel": 2}
+	var matrixSpec qa.MatrixSpec
+	if raw, ok := task["matrix"]; ok {
+		mb, _ := json.Marshal(raw)
+		if err := json.Unmarshal(mb, &matrixSpec); err != nil {
+			return s.failQA(ctx, id, fmt.Errorf("task matrix: %w", err))
+		}
+	}
+	// sandbox: {"pids": 1024, "tmp_mb": 2048, "read_only_rootfs": false, ...}
+	var sandbox *qa.SandboxSpec
+	if raw, ok := task["sandbox"]; ok {
+		sb, _ := json.Marshal(raw)
+		if err := json.Unmarshal(sb, &sandbox); err != nil {
+			return s.failQA(ctx, id, fmt.Errorf("task sandbox: %w", err))
+		}
+	}
+	if pipeline != nil && matrixSpec.HasCommands() {
+		// the stages carry their own commands; only the images can vary
+		return s.failQA(ctx, id, errors.New("task matrix: command cells can't be combined with a pipeline, only images"))
+	}
+	opts := qa.RunOptions{
+		RepoURL:     repositoryURL,
+		StartCommit: base.Commit,
+		Mirror:      base.Mirror,
+		Snapshot:    snapshotArchive,
+		Credential:  cred,
+		Patch:       finalPatch,
+		Image:       testImage,
+		Command:     testCommand,
+		Baseline:    baseline,
+		Reports:     reports,
+		Format:      testFormat,
+		Hidden:      hidden,
+		Pipeline:    pipeline,
+		Lint:        lint,
+		Coverage:    coverage,
+		Mutation:    mutation,
+		Sandbox:     sandbox,
+	}
+	// what the backend can't run is left out and recorded under qa.skipped,
+	// so the task isn't failed for the backend it landed on
+	opts, skipped := qa.Degrade(s.Runner, opts)
+	pipeline = opts.Pipeline
+	cells := matrixSpec.Cells(opts.Image, opts.Command)
+	matrix := qa.RunMatrix(ctx, s.Runner, opts, cells, matrixSpec.MaxParallel, targets)
+	// the first cell that ran is the primary run reported under qa.tests;
+	// the task only fails when every cell errored
+	primary := matrix.Primary()
+	if primary == nil {
+		if len(cells) > 1 {
+			b, _ := json.Marshal(map[string]any{"matrix": matrix})
+			_, _ = s.DB.ExecContext(ctx, `update traces set qa = coalesce(qa, '{}'::jsonb) || $2::jsonb where id = $1`, id, b)
+		}
+		return s.failQA(ctx, id, fmt.Errorf("QA runner: %w", matrix.Cells[0].Err))
 	}
+	run, testImage, testCommand := primary.Run, primary.Image, primary.Command
+	res := run.Patched
 	qaOut := map[string]any{
 		"tests": map[string]any{
-			"runner": "docker", "image": testImage, "command": testCommand,
-			"ok": res.OK, "stdout": res.Stdout, "stderr": res.Stderr, "exit_code": res.ExitCode,
+			"runner": s.Runner.Name(), "image": testImage, "command": testCommand,
+			// the aggregate verdict: the tests passed in every cell
+			"ok": matrix.OK, "stdout": res.Stdout, "stderr": res.Stderr, "exit_code": res.ExitCode,
+			"parser": res.Parser, "summary": res.Summary, "cases": res.Cases,
 		},
-		"judge": qa.Judge("(summary)", os.Getenv("LLM_MODEL")),
+		"judge":  qa.Judge("(summary)", os.Getenv("LLM_MODEL")),
+		"patch":  patchOut,
+		"policy": findings,
+	}
+	if run.Apply != nil {
+		qaOut["apply"] = run.Apply
+	}
+	if skipped = append(skipped, matrix.Skipped...); len(skipped) > 0 {
+		qaOut["skipped"] = skipped
+	}
+	if run.Sandbox != nil {
+		qaOut["sandbox"] = run.Sandbox
+	}
+	if run.Baseline != nil {
+		qaOut["baseline"] = run.Baseline
+		qaOut["verification"] = qa.Verify(run.Baseline.Cases, res.Cases, targets)
+	}
+	if base.Snapshot != nil {
+		qaOut["snapshot"] = map[string]string{"id": base.Snapshot.ID, "ref": base.Snapshot.Ref}
+	}
+	if len(cells) > 1 {
+		qaOut["matrix"] = matrix
+	}
+	if pipeline != nil {
+		for i, st := range run.Stages {
+			for name, b := range st.Files {
+				ref, err := s.S3.PutBytes(ctx, fmt.Sprintf("qa/%s/%s/%s", id, st.Name, name), "application/octet-stream", b)
+				if err != nil {
+					log.Printf("store artifact %s of stage %s: %v", name, st.Name, err)
+					continue
+				}
+				if run.Stages[i].ArtifactRefs == nil {
+					run.Stages[i].ArtifactRefs = map[string]string{}
+				}
+				run.Stages[i].ArtifactRefs[name] = ref
+			}
+		}
+		qaOut["pipeline"] = map[string]any{"source": pipelineSource, "stages": run.Stages}
+		if run.BaselineStages != nil {
+			qaOut["pipeline"].(map[string]any)["baseline_stages"] = run.BaselineStages
+		}
+	}
+	if run.Lint != nil {
+		qaOut["lint"] = run.Lint
+	}
+	if run.Coverage != nil {
+		qaOut["coverage"] = run.Coverage
+	}
+	if run.Mutation != nil {
+		qaOut["mutation"] = run.Mutation
+	}
+	if run.Hidden != nil {
+		qaOut["hidden_tests"] = run.Hidden
+	}
+	if res.ParseError != "" {
+		qaOut["tests"].(map[string]any)["parse_error"] = res.ParseError
 	}
 	log.Println("QA result:", qaOut)
 	b, _ := json.Marshal(qaOut)
-	_, err = s.DB.ExecContext(ctx, `update traces set qa=$1 where id=$2`, b, id)
+	chain, _ := json.Marshal(assembled.Steps)
+	summary, _ := json.Marshal(res.Summary)
+	_, err = s.DB.ExecContext(ctx, `update traces set qa=$1,
+		artifacts = coalesce(artifacts, '{}'::jsonb) || jsonb_build_object('final_patch', $3::text, 'edit_chain', $4::jsonb, 'test_results', $5::jsonb)
+		where id=$2`, b, id, finalPatch, chain, summary)
 	return err
 }
 
-func Run(addr string, db *sqlx.DB, s3c *storage.Client) error {
+// failQA persists a QA failure on the trace instead of returning it, which
+// tells Asynq the task is done so it doesn't keep retrying.
+func (s *Server) failQA(ctx context.Context, id string, err error) error {
+	log.Printf("QA for trace %s failed: %v", id, err)
+	fields := map[string]any{"error": err.Error()}
+	// a rejected patch keeps its per-hunk report next to the error
+	var applyErr *qa.ApplyError
+	if errors.As(err, &applyErr) {
+		fields["apply"] = applyErr.Report
+	}
+	b, _ := json.Marshal(fields)
+	_, _ = s.DB.ExecContext(ctx,
+		`UPDATE traces SET qa = COALESCE(qa, '{}'::jsonb) || $2::jsonb WHERE id = $1`,
+		id, b,
+	)
+	return nil
+}
+
+func Run(addr string, db *sqlx.DB, s3c *storage.Client, mirror *gitmirror.Mirror, v *vault.Vault, runner qa.Runner) error {
 	srv := asynq.NewServer(asynq.RedisClientOpt{Addr: addr}, asynq.Config{Concurrency: 5})
-	w := &Server{DB: db, S3: s3c}
+	w := &Server{DB: db, S3: s3c, Mirror: mirror, Vault: v, Runner: runner}
 	return srv.Run(w.mux())
 }
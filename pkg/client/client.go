@@ -0,0 +1,476 @@
+// Package client is a Go client for the trace API. It wraps every endpoint
+// with the request and response types from internal/schemas, retries
+// transient failures with backoff, and offers an Uploader that batches
+// events and spools them to disk while the API is unreachable.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+
+	"datacurve-takehome/internal/schemas"
+)
+
+type (
+	CreateTraceRequest   = schemas.CreateTraceRequest
+	CreateTraceResponse  = schemas.CreateTraceResponse
+	AppendEventsRequest  = schemas.AppendEventsRequest
+	AppendEventsResponse = schemas.AppendEventsResponse
+	TraceOut             = schemas.TraceOut
+	TraceSummary         = schemas.TraceSummary
+	ListTracesResponse   = schemas.ListTracesResponse
+	EventsResponse       = schemas.EventsResponse
+	TraceExport          = schemas.TraceExport
+	ImportTraceResponse  = schemas.ImportTraceResponse
+	FileSnapshot         = schemas.FileSnapshot
+	TouchedFilesResponse = schemas.TouchedFilesResponse
+
+	PrefetchRequest            = schemas.PrefetchRequest
+	SnapshotOut                = schemas.SnapshotOut
+	ProjectCredential          = schemas.ProjectCredential
+	CredentialInfo             = schemas.CredentialInfo
+	DatasetQuery               = schemas.DatasetQuery
+	CreateDatasetExportRequest = schemas.CreateDatasetExportRequest
+	DatasetExportOut           = schemas.DatasetExportOut
+)
+
+// RetryPolicy controls how failed requests are retried. Delays grow
+// exponentially from BaseDelay up to MaxDelay, with full jitter.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 5, BaseDelay: 250 * time.Millisecond, MaxDelay: 10 * time.Second}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << attempt
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+type Client struct {
+	BaseURL string
+	// Token is the API token used for admin endpoints.
+	Token string
+	// OperatorToken is sent as X-Operator-Token on operator-only requests
+	// (ExportTraceUnredacted).
+	OperatorToken string
+	HTTP          *http.Client
+	Retry         RetryPolicy
+}
+
+type Option func(*Client)
+
+func WithHTTPClient(h *http.Client) Option { return func(c *Client) { c.HTTP = h } }
+
+func WithRetryPolicy(p RetryPolicy) Option { return func(c *Client) { c.Retry = p } }
+
+func WithOperatorToken(token string) Option { return func(c *Client) { c.OperatorToken = token } }
+
+func New(baseURL, token string, opts ...Option) *Client {
+	c := &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+		Retry:   DefaultRetryPolicy,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// APIError is returned when the API answers with a non-2xx status.
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s -> %d: %s", e.Method, e.URL, e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+// IsNotFound reports whether err is an API 404.
+func IsNotFound(err error) bool {
+	var ae *APIError
+	return errors.As(err, &ae) && ae.StatusCode == http.StatusNotFound
+}
+
+// IsConflict reports whether err is an API 409.
+func IsConflict(err error) bool {
+	var ae *APIError
+	return errors.As(err, &ae) && ae.StatusCode == http.StatusConflict
+}
+
+type request struct {
+	method string
+	path   string
+	bearer string
+	body   any
+	// raw is sent as-is instead of JSON-encoding body; set its
+	// Content-Type in header.
+	raw    []byte
+	header http.Header
+	// retry marks the request as safe to repeat after an ambiguous failure.
+	retry bool
+}
+
+func (c *Client) do(ctx context.Context, req request, out any) error {
+	var payload []byte
+	if req.body != nil {
+		b, err := json.Marshal(req.body)
+		if err != nil {
+			return err
+		}
+		payload = b
+	}
+	if req.raw != nil {
+		payload = req.raw
+	}
+	attempts := 1
+	if req.retry && c.Retry.MaxAttempts > 1 {
+		attempts = c.Retry.MaxAttempts
+	}
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.Retry.backoff(i - 1)):
+			}
+		}
+		res, err := c.send(ctx, req, payload)
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil || !isTransient(err) {
+				return err
+			}
+			continue
+		}
+		if res.StatusCode/100 != 2 {
+			b, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			lastErr = &APIError{Method: req.method, URL: c.BaseURL + req.path, StatusCode: res.StatusCode, Body: string(b)}
+			if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+				continue
+			}
+			return lastErr
+		}
+		defer res.Body.Close()
+		if out == nil {
+			_, _ = io.Copy(io.Discard, res.Body)
+			return nil
+		}
+		return json.NewDecoder(res.Body).Decode(out)
+	}
+	return lastErr
+}
+
+func (c *Client) send(ctx context.Context, req request, payload []byte) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	hr, err := http.NewRequestWithContext(ctx, req.method, c.BaseURL+req.path, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range req.header {
+		for _, v := range vs {
+			hr.Header.Add(k, v)
+		}
+	}
+	if payload != nil && hr.Header.Get("Content-Type") == "" {
+		hr.Header.Set("Content-Type", "application/json")
+	}
+	if req.bearer != "" {
+		hr.Header.Set("Authorization", "Bearer "+req.bearer)
+	}
+	return c.HTTP.Do(hr)
+}
+
+// isTransient reports whether a failed send is worth repeating: timeouts,
+// refused or reset connections and responses cut short. DNS, TLS and URL
+// errors would fail the same way again.
+func isTransient(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// --- endpoints ---
+
+func (c *Client) Healthz(ctx context.Context) error {
+	return c.do(ctx, request{method: http.MethodGet, path: "/healthz", retry: true}, nil)
+}
+
+// CreateTrace is not retried: the API has no way to deduplicate it, and a
+// second attempt after an ambiguous failure could leave an orphaned trace.
+func (c *Client) CreateTrace(ctx context.Context, req CreateTraceRequest) (*CreateTraceResponse, error) {
+	var out CreateTraceResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/traces", bearer: c.Token, body: req}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AppendEvents uploads one batch under a fresh idempotency key.
+func (c *Client) AppendEvents(ctx context.Context, traceID, uploadToken string, events []map[string]any) (*AppendEventsResponse, error) {
+	return c.AppendEventsWithKey(ctx, traceID, uploadToken, uuid.NewString(), events)
+}
+
+// AppendEventsWithKey uploads one batch under the given idempotency key.
+// Repeating the call with the same key never stores the batch twice.
+func (c *Client) AppendEventsWithKey(ctx context.Context, traceID, uploadToken, key string, events []map[string]any) (*AppendEventsResponse, error) {
+	if uploadToken == "" {
+		return nil, errors.New("upload token required")
+	}
+	var out AppendEventsResponse
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/traces/" + traceID + "/events",
+		bearer: uploadToken,
+		body:   AppendEventsRequest{Events: events},
+		header: http.Header{"Idempotency-Key": {key}},
+		retry:  true,
+	}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) Finalize(ctx context.Context, traceID string) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/traces/" + traceID + "/finalize", bearer: c.Token, retry: true}, nil)
+}
+
+func (c *Client) EnqueueQA(ctx context.Context, traceID string) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/traces/" + traceID + "/qa", bearer: c.Token}, nil)
+}
+
+// PrefetchRepo asks the worker to fetch repo into its mirror cache, with
+// project's credential if project is set, and check that commits are
+// there, so later QA runs don't depend on the upstream host.
+func (c *Client) PrefetchRepo(ctx context.Context, repo, project string, commits ...string) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/repos/prefetch", bearer: c.Token,
+		body: PrefetchRequest{Repository: repo, Project: project, Commits: commits}}, nil)
+}
+
+func (c *Client) GetTrace(ctx context.Context, traceID string) (*TraceOut, error) {
+	var out TraceOut
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/traces/" + traceID, bearer: c.Token, retry: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListOptions filters ListTraces. Zero values are left out of the query.
+type ListOptions struct {
+	Status     string
+	Query      string
+	Repository string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+	Offset     int
+}
+
+func (o ListOptions) values() url.Values {
+	v := url.Values{}
+	if o.Status != "" {
+		v.Set("status", o.Status)
+	}
+	if o.Query != "" {
+		v.Set("q", o.Query)
+	}
+	if o.Repository != "" {
+		v.Set("repository", o.Repository)
+	}
+	if !o.Since.IsZero() {
+		v.Set("since", o.Since.Format(time.RFC3339))
+	}
+	if !o.Until.IsZero() {
+		v.Set("until", o.Until.Format(time.RFC3339))
+	}
+	if o.Limit > 0 {
+		v.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		v.Set("offset", strconv.Itoa(o.Offset))
+	}
+	return v
+}
+
+func (c *Client) ListTraces(ctx context.Context, opts ListOptions) ([]TraceSummary, error) {
+	path := "/traces"
+	if q := opts.values().Encode(); q != "" {
+		path += "?" + q
+	}
+	var out ListTracesResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: path, bearer: c.Token, retry: true}, &out); err != nil {
+		return nil, err
+	}
+	return out.Traces, nil
+}
+
+// GetEvents returns every event of the trace, merged in upload order.
+func (c *Client) GetEvents(ctx context.Context, traceID string) ([]map[string]any, error) {
+	var out EventsResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/traces/" + traceID + "/events", bearer: c.Token, retry: true}, &out); err != nil {
+		return nil, err
+	}
+	return out.Events, nil
+}
+
+// ExportTrace fetches the self-contained trace-1.0.0 document, with the
+// location of the task's hidden tests redacted.
+func (c *Client) ExportTrace(ctx context.Context, traceID string) (*TraceExport, error) {
+	return c.exportTrace(ctx, "/traces/"+traceID+"/export", nil)
+}
+
+// ExportTraceUnredacted fetches the document with the task as stored, so
+// importing it elsewhere loses nothing. It needs OperatorToken.
+func (c *Client) ExportTraceUnredacted(ctx context.Context, traceID string) (*TraceExport, error) {
+	return c.exportTrace(ctx, "/traces/"+traceID+"/export?unredacted=true", http.Header{"X-Operator-Token": {c.OperatorToken}})
+}
+
+func (c *Client) exportTrace(ctx context.Context, path string, header http.Header) (*TraceExport, error) {
+	var out TraceExport
+	if err := c.do(ctx, request{method: http.MethodGet, path: path, bearer: c.Token, header: header, retry: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ImportTrace recreates a trace from an exported document. Retrying never
+// duplicates the trace, but a retry after an attempt that went through
+// unacknowledged fails with 409; IsConflict reports that, and the trace
+// then exists under doc.TraceID.
+func (c *Client) ImportTrace(ctx context.Context, doc *TraceExport) (*ImportTraceResponse, error) {
+	var out ImportTraceResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/traces/import", bearer: c.Token, body: doc, retry: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UploadSnapshot stores a plain or gzip-compressed tar of a repository for
+// tasks that can't clone it. Uploads are keyed by checksum, so retrying is
+// safe.
+func (c *Client) UploadSnapshot(ctx context.Context, archive []byte) (*SnapshotOut, error) {
+	var out SnapshotOut
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/snapshots", bearer: c.Token, raw: archive,
+		header: http.Header{"Content-Type": {"application/octet-stream"}}, retry: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PutCredential stores the git credential QA clones of project's tasks use,
+// replacing any earlier one.
+func (c *Client) PutCredential(ctx context.Context, project string, cred ProjectCredential) (*CredentialInfo, error) {
+	var out CredentialInfo
+	if err := c.do(ctx, request{method: http.MethodPut, path: "/projects/" + url.PathEscape(project) + "/credentials", bearer: c.Token, body: cred, retry: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetCredential describes project's credential without its secret.
+func (c *Client) GetCredential(ctx context.Context, project string) (*CredentialInfo, error) {
+	var out CredentialInfo
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/projects/" + url.PathEscape(project) + "/credentials", bearer: c.Token, retry: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) DeleteCredential(ctx context.Context, project string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: "/projects/" + url.PathEscape(project) + "/credentials", bearer: c.Token, retry: true}, nil)
+}
+
+// GetFile reconstructs path as of at, an RFC 3339 timestamp or an event
+// index; an empty at means after the last edit.
+func (c *Client) GetFile(ctx context.Context, traceID, path, at string) (*FileSnapshot, error) {
+	q := url.Values{"path": {path}}
+	if at != "" {
+		q.Set("at", at)
+	}
+	var out FileSnapshot
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/traces/" + traceID + "/files?" + q.Encode(), bearer: c.Token, retry: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// TouchedFiles lists the files a session opened or edited.
+func (c *Client) TouchedFiles(ctx context.Context, traceID string) (*TouchedFilesResponse, error) {
+	var out TouchedFilesResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/traces/" + traceID + "/files/touched", bearer: c.Token, retry: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateDatasetExport queues a bulk export of the traces matching req.Query.
+func (c *Client) CreateDatasetExport(ctx context.Context, req CreateDatasetExportRequest) (*DatasetExportOut, error) {
+	var out DatasetExportOut
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/datasets/exports", bearer: c.Token, body: req}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) GetDatasetExport(ctx context.Context, id string) (*DatasetExportOut, error) {
+	var out DatasetExportOut
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/datasets/exports/" + id, bearer: c.Token, retry: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// WaitForQA polls the trace until QA results are present. progress, if not
+// nil, is called after every poll that came back without results.
+func (c *Client) WaitForQA(ctx context.Context, traceID string, every time.Duration, progress func(elapsed time.Duration)) (*TraceOut, error) {
+	start := time.Now()
+	for {
+		tr, err := c.GetTrace(ctx, traceID)
+		if err != nil {
+			return nil, err
+		}
+		if len(tr.QA) > 0 {
+			return tr, nil
+		}
+		if progress != nil {
+			progress(time.Since(start))
+		}
+		select {
+		case <-ctx.Done():
+			return tr, ctx.Err()
+		case <-time.After(every):
+		}
+	}
+}
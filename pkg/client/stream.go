@@ -0,0 +1,70 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Batch is one event batch delivered by StreamEvents.
+type Batch struct {
+	Seq    int64            `json:"seq"`
+	Events []map[string]any `json:"events"`
+}
+
+// StreamEvents tails GET /traces/{id}/stream, calling fn for every batch
+// after afterSeq (-1 for all). It returns when ctx is done, the server closes
+// the stream, or fn returns an error.
+func (c *Client) StreamEvents(ctx context.Context, traceID string, afterSeq int64, fn func(Batch) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/traces/"+traceID+"/stream", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if afterSeq >= 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatInt(afterSeq, 10))
+	}
+	// The shared client's timeout would cut a long-lived stream short.
+	hc := *c.HTTP
+	hc.Timeout = 0
+	res, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return &APIError{Method: http.MethodGet, URL: req.URL.String(), StatusCode: res.StatusCode}
+	}
+
+	sc := bufio.NewScanner(res.Body)
+	sc.Buffer(make([]byte, 64*1024), 32<<20)
+	var data strings.Builder
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if data.Len() == 0 {
+				continue
+			}
+			var b Batch
+			if err := json.Unmarshal([]byte(data.String()), &b); err != nil {
+				return fmt.Errorf("decode stream event: %w", err)
+			}
+			data.Reset()
+			if err := fn(b); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return sc.Err()
+}
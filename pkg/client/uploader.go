@@ -0,0 +1,334 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploaderOptions tunes when an Uploader cuts a batch and where it keeps
+// batches that have not been acknowledged yet.
+type UploaderOptions struct {
+	// MaxEvents and MaxBytes cut a batch as soon as either is reached.
+	MaxEvents int
+	MaxBytes  int
+	// FlushInterval cuts and uploads whatever is buffered at least this often.
+	FlushInterval time.Duration
+	// SpoolDir, when set, persists every cut batch until the API accepts it,
+	// so events survive restarts and long stretches offline.
+	SpoolDir string
+	// OnError receives background upload failures. Failed batches stay
+	// queued and are retried on the next flush, except batches the API
+	// rejects outright (a 4xx other than 408 and 429): those are dropped so
+	// later batches aren't blocked behind them, and their spool file is
+	// kept aside with a .rejected suffix.
+	OnError func(error)
+}
+
+func (o *UploaderOptions) defaults() {
+	if o.MaxEvents <= 0 {
+		o.MaxEvents = 200
+	}
+	if o.MaxBytes <= 0 {
+		o.MaxBytes = 512 << 10
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = 2 * time.Second
+	}
+}
+
+// spooledBatch is a cut batch waiting for upload. Its idempotency key is
+// fixed when it is cut, so every retry of it is deduplicated server-side.
+type spooledBatch struct {
+	Key         string           `json:"key"`
+	TraceID     string           `json:"trace_id"`
+	UploadToken string           `json:"upload_token"`
+	Events      []map[string]any `json:"events"`
+
+	path string
+}
+
+// Uploader buffers events for one trace and uploads them in order.
+type Uploader struct {
+	c       *Client
+	traceID string
+	token   string
+	opts    UploaderOptions
+
+	mu       sync.Mutex
+	buf      []map[string]any
+	bufBytes int
+	pending  []*spooledBatch
+
+	// sendMu serialises uploads so batches land in the order they were cut.
+	sendMu sync.Mutex
+	kick   chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewUploader starts an Uploader for the trace. Batches left in the spool by
+// an earlier process for the same trace are queued ahead of new events.
+func (c *Client) NewUploader(traceID, uploadToken string, opts UploaderOptions) (*Uploader, error) {
+	opts.defaults()
+	u := &Uploader{
+		c:       c,
+		traceID: traceID,
+		token:   uploadToken,
+		opts:    opts,
+		kick:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if opts.SpoolDir != "" {
+		dir := filepath.Join(opts.SpoolDir, traceID)
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("spool dir: %w", err)
+		}
+		left, err := loadSpool(dir)
+		if err != nil {
+			return nil, err
+		}
+		u.pending = left
+	}
+	go u.loop()
+	return u, nil
+}
+
+// Add buffers one event. Anything that is not already a map is converted
+// through its JSON encoding, so the schemas event structs can be passed as is.
+func (u *Uploader) Add(ev any) error {
+	m, ok := ev.(map[string]any)
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := json.Unmarshal(b, &m); err != nil {
+			return err
+		}
+	}
+	u.mu.Lock()
+	u.buf = append(u.buf, m)
+	u.bufBytes += len(b)
+	full := len(u.buf) >= u.opts.MaxEvents || u.bufBytes >= u.opts.MaxBytes
+	var cutErr error
+	if full {
+		cutErr = u.cutLocked()
+	}
+	u.mu.Unlock()
+	if full {
+		select {
+		case u.kick <- struct{}{}:
+		default:
+		}
+	}
+	return cutErr
+}
+
+// Flush cuts the buffer and uploads every queued batch. On error the
+// remaining batches stay queued (and spooled, if enabled).
+func (u *Uploader) Flush(ctx context.Context) error {
+	u.mu.Lock()
+	err := u.cutLocked()
+	u.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return u.drain(ctx)
+}
+
+// Close stops the background flusher and makes a final Flush.
+func (u *Uploader) Close(ctx context.Context) error {
+	select {
+	case <-u.stop:
+	default:
+		close(u.stop)
+	}
+	<-u.done
+	return u.Flush(ctx)
+}
+
+// Pending reports how many events are not yet acknowledged by the API.
+func (u *Uploader) Pending() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	n := len(u.buf)
+	for _, b := range u.pending {
+		n += len(b.Events)
+	}
+	return n
+}
+
+func (u *Uploader) loop() {
+	defer close(u.done)
+	t := time.NewTicker(u.opts.FlushInterval)
+	defer t.Stop()
+	for {
+		var err error
+		select {
+		case <-u.stop:
+			return
+		case <-t.C:
+			err = u.Flush(context.Background())
+		case <-u.kick:
+			err = u.drain(context.Background())
+		}
+		if err != nil && u.opts.OnError != nil {
+			u.opts.OnError(err)
+		}
+	}
+}
+
+func (u *Uploader) cutLocked() error {
+	if len(u.buf) == 0 {
+		return nil
+	}
+	b := &spooledBatch{Key: uuid.NewString(), TraceID: u.traceID, UploadToken: u.token, Events: u.buf}
+	u.buf, u.bufBytes = nil, 0
+	u.pending = append(u.pending, b)
+	if u.opts.SpoolDir == "" {
+		return nil
+	}
+	name := fmt.Sprintf("%020d-%s.json", time.Now().UnixNano(), b.Key)
+	return writeSpool(filepath.Join(u.opts.SpoolDir, u.traceID, name), b)
+}
+
+func (u *Uploader) drain(ctx context.Context) error {
+	u.sendMu.Lock()
+	defer u.sendMu.Unlock()
+	var rejectErr error
+	for {
+		u.mu.Lock()
+		if len(u.pending) == 0 {
+			u.mu.Unlock()
+			return rejectErr
+		}
+		b := u.pending[0]
+		u.mu.Unlock()
+
+		if _, err := u.c.AppendEventsWithKey(ctx, b.TraceID, b.UploadToken, b.Key, b.Events); err != nil {
+			if !rejected(err) {
+				return errors.Join(err, rejectErr)
+			}
+			rejectErr = errors.Join(rejectErr, quarantine(b, err))
+		} else if b.path != "" {
+			_ = os.Remove(b.path)
+		}
+		u.mu.Lock()
+		u.pending = u.pending[1:]
+		u.mu.Unlock()
+	}
+}
+
+// rejected reports whether the API refused a batch for good, so sending it
+// again would fail the same way.
+func rejected(err error) bool {
+	var ae *APIError
+	return errors.As(err, &ae) && ae.StatusCode/100 == 4 &&
+		ae.StatusCode != http.StatusRequestTimeout && ae.StatusCode != http.StatusTooManyRequests
+}
+
+// quarantine moves a rejected batch's spool file aside, where loadSpool
+// doesn't pick it up, and returns the error to report.
+func quarantine(b *spooledBatch, err error) error {
+	if b.path == "" {
+		return fmt.Errorf("batch %s of trace %s rejected and dropped: %w", b.Key, b.TraceID, err)
+	}
+	if rerr := os.Rename(b.path, b.path+".rejected"); rerr != nil {
+		return fmt.Errorf("batch %s of trace %s rejected: %w (quarantine: %v)", b.Key, b.TraceID, err, rerr)
+	}
+	return fmt.Errorf("batch %s of trace %s rejected, kept at %s.rejected: %w", b.Key, b.TraceID, b.path, err)
+}
+
+// ResumeSpool uploads every batch left under spoolDir by earlier processes,
+// trace by trace, and removes them once accepted. Batches the API rejects
+// are quarantined like the Uploader does, and reported once the rest are
+// uploaded.
+func (c *Client) ResumeSpool(ctx context.Context, spoolDir string) error {
+	entries, err := os.ReadDir(spoolDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var rejectErr error
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		left, err := loadSpool(filepath.Join(spoolDir, e.Name()))
+		if err != nil {
+			return err
+		}
+		for _, b := range left {
+			if _, err := c.AppendEventsWithKey(ctx, b.TraceID, b.UploadToken, b.Key, b.Events); err != nil {
+				if !rejected(err) {
+					return errors.Join(fmt.Errorf("trace %s: %w", b.TraceID, err), rejectErr)
+				}
+				rejectErr = errors.Join(rejectErr, quarantine(b, err))
+				continue
+			}
+			_ = os.Remove(b.path)
+		}
+	}
+	return rejectErr
+}
+
+func writeSpool(path string, b *spooledBatch) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("spool write: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("spool write: %w", err)
+	}
+	b.path = path
+	return nil
+}
+
+// loadSpool reads a trace's spooled batches, oldest first. File names start
+// with a zero-padded timestamp, so lexical order is cut order.
+func loadSpool(dir string) ([]*spooledBatch, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	out := make([]*spooledBatch, 0, len(names))
+	for _, n := range names {
+		p := filepath.Join(dir, n)
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var b spooledBatch
+		if err := json.Unmarshal(data, &b); err != nil {
+			return nil, fmt.Errorf("spool %s: %w", p, err)
+		}
+		b.path = p
+		out = append(out, &b)
+	}
+	return out, nil
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestUploaderQuarantinesRejectedBatch(t *testing.T) {
+	var mu sync.Mutex
+	var accepted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req AppendEventsRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Events[0]["type"] == "bad" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"error":"invalid event"}`))
+			return
+		}
+		mu.Lock()
+		accepted = append(accepted, req.Events[0]["type"].(string))
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"accepted":1}`))
+	}))
+	defer srv.Close()
+
+	spool := t.TempDir()
+	var errs []error
+	c := New(srv.URL, "", WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	u, err := c.NewUploader("t1", "upload", UploaderOptions{
+		MaxEvents: 1, FlushInterval: time.Hour, SpoolDir: spool,
+		OnError: func(err error) { mu.Lock(); errs = append(errs, err); mu.Unlock() },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range []string{"bad", "good"} {
+		if err := u.Add(map[string]any{"type": typ}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := u.Close(context.Background()); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || !rejected(errs[0]) {
+		t.Fatalf("errors %v, want the rejected batch reported once", errs)
+	}
+	if len(accepted) != 1 || accepted[0] != "good" {
+		t.Errorf("accepted %v, want the batch after the rejected one", accepted)
+	}
+	if n := u.Pending(); n != 0 {
+		t.Errorf("Pending() = %d, want 0", n)
+	}
+	left, _ := filepath.Glob(filepath.Join(spool, "t1", "*.json"))
+	kept, _ := filepath.Glob(filepath.Join(spool, "t1", "*.json.rejected"))
+	if len(left) != 0 || len(kept) != 1 {
+		t.Errorf("spool has %d batches and %d rejected, want 0 and 1", len(left), len(kept))
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	// what http.Client returns for a failed request: *url.Error around a
+	// *net.OpError around the cause
+	wrap := func(op string, cause error) error {
+		return &url.Error{Op: "Post", URL: "http://api/traces", Err: &net.OpError{Op: op, Net: "tcp", Err: cause}}
+	}
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"refused", wrap("dial", os.NewSyscallError("connect", syscall.ECONNREFUSED)), true},
+		{"reset", wrap("read", os.NewSyscallError("read", syscall.ECONNRESET)), true},
+		{"dial timeout", wrap("dial", &net.DNSError{Err: "i/o timeout", Name: "api", IsTimeout: true}), true},
+		{"truncated response", &url.Error{Op: "Post", URL: "http://api/traces", Err: io.ErrUnexpectedEOF}, true},
+		{"bad host", wrap("dial", &net.DNSError{Err: "no such host", Name: "nonexistent.invalid", IsNotFound: true}), false},
+		{"tls", &url.Error{Op: "Post", URL: "https://api/traces", Err: x509.UnknownAuthorityError{}}, false},
+		{"canceled", &url.Error{Op: "Post", URL: "http://api/traces", Err: context.Canceled}, false},
+	} {
+		if got := isTransient(tc.err); got != tc.want {
+			t.Errorf("%s: isTransient(%v) = %v, want %v", tc.name, tc.err, got, tc.want)
+		}
+	}
+}